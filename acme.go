@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/acme"
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+// acmeOptions are the flags of "dnsup acme".
+type acmeOptions struct {
+	ttl  int
+	raw  bool
+	wait bool
+}
+
+func acmeFlags(fs *flag.FlagSet, c *config) {
+	fs.IntVar(&c.acme.ttl, "ttl", acme.DefaultTTL, "TTL of the challenge record")
+	fs.BoolVar(&c.acme.raw, "raw", false, "take the domain, token and key authorization rather than the record's name and value")
+	fs.BoolVar(&c.acme.wait, "wait", true, "after present, wait for the record to be visible on the zone's servers or -verify-resolver")
+}
+
+// runAcme implements "dnsup acme present|cleanup fqdn value [zonefile...]",
+// the interface of lego's exec DNS provider, and with -raw "dnsup acme
+// present|cleanup domain token keyauth [zonefile...]".
+func runAcme(args []string) {
+	cfg, err := parseConfig("acme", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	n := 3
+	if cfg.acme.raw {
+		n = 4
+	}
+	if len(cfg.Args) < n || cfg.Args[0] != "present" && cfg.Args[0] != "cleanup" {
+		logging.Fatal("usage: dnsup acme [flags] present|cleanup fqdn value [zonefile...]\n       dnsup acme -raw [flags] present|cleanup domain token keyauth [zonefile...]")
+	}
+	cfg.zoneArgs(cfg.Args[n:])
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	if cfg.acme.ttl < 0 {
+		logging.Fatalf("invalid -ttl %d", cfg.acme.ttl)
+	}
+
+	recs := &zoneRecords{cfg: cfg, wait: cfg.acme.wait}
+	var fqdn, value string
+	if cfg.acme.raw {
+		fqdn, value = acme.Challenge(cfg.Args[1], cfg.Args[3])
+	} else {
+		fqdn, value = acme.ChallengeName(cfg.Args[1]), cfg.Args[2]
+	}
+	if cfg.Args[0] == "present" {
+		err = recs.AddTXT(fqdn, value, uint32(cfg.acme.ttl))
+	} else {
+		err = recs.RemoveTXT(fqdn, value)
+	}
+	if err != nil {
+		logging.Fatal(err)
+	}
+}
+
+// zoneRecords is an acme.Records adding and removing challenge records in
+// the configured master files, announced as other changes are, and with
+// wait waiting for each added record to propagate.
+type zoneRecords struct {
+	cfg  *config
+	wait bool
+}
+
+func (z *zoneRecords) AddTXT(fqdn, value string, ttl uint32) error {
+	db, err := z.cfg.newDB()
+	if err != nil {
+		return err
+	}
+	if _, err := loadAll(db, z.cfg.zoneFiles()); err != nil {
+		return err
+	}
+	rr := &dns.TXT{Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl}, Txt: []string{value}}
+	old := values(db.Lookup(fqdn, dns.TypeTXT))
+	present := false
+	for _, v := range old {
+		present = present || v == rdataOf(rr)
+	}
+	if !present {
+		if err := db.AddRecord(rr); err != nil {
+			return err
+		}
+		if err := commit(z.cfg, db, nil); err != nil {
+			return err
+		}
+		if !z.cfg.DryRun {
+			recordChanged(z.cfg, db, cliActor(), fqdn, dns.TypeTXT, old, rdataOf(rr))
+			flushEvents()
+		}
+	}
+	if !z.wait || z.cfg.DryRun {
+		return nil
+	}
+	return verify(z.cfg, []expectation{{name: fqdn, rrtype: dns.TypeTXT, value: strconv.Quote(value)}})
+}
+
+func (z *zoneRecords) RemoveTXT(fqdn, value string) error {
+	db, err := z.cfg.newDB()
+	if err != nil {
+		return err
+	}
+	if _, err := loadAll(db, z.cfg.zoneFiles()); err != nil {
+		return err
+	}
+	n, err := db.DeleteRecords(fqdn, dns.TypeTXT, strconv.Quote(value))
+	if err != nil || n == 0 {
+		return err // nothing to clean up
+	}
+	if err := commit(z.cfg, db, nil); err != nil {
+		return err
+	}
+	if !z.cfg.DryRun {
+		recordChanged(z.cfg, db, cliActor(), fqdn, dns.TypeTXT, []string{strconv.Quote(value)}, "")
+		flushEvents()
+	}
+	return nil
+}
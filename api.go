@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// apiZone and apiRecord are the JSON forms of zones and records.
+type apiZone struct {
+	Zone   string `json:"zone"`
+	File   string `json:"file"`
+	Serial uint32 `json:"serial"`
+}
+
+type apiRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	TTL   uint32 `json:"ttl"`
+	Value string `json:"value"`
+}
+
+// checkSecret compares got with want, which is plain text or "sha256:"
+// followed by the hex digest, in constant time.
+func checkSecret(want, got string) bool {
+	if strings.HasPrefix(want, "sha256:") {
+		sum := sha256.Sum256([]byte(got))
+		got, want = hex.EncodeToString(sum[:]), strings.ToLower(strings.TrimPrefix(want, "sha256:"))
+	}
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// authorizeKey reports whether key is one of the configured API keys.
+func (s *server) authorizeKey(key string) bool {
+	for _, want := range s.config().APIKeys {
+		if checkSecret(want, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiHandler serves the JSON REST API:
+//
+//	GET /zones                  the loaded zones
+//	GET /zones/{zone}/records   the records of a zone
+//	PUT /records/{name}         set the records of a name and type
+//
+// Requests carry one of the configured API keys as a bearer token or in
+// an X-API-Key header, or are signed with a keyring key as described by
+// tsig.SignRequest.
+func (s *server) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/zones", s.apiZones)
+	mux.HandleFunc("/zones/", s.apiZoneRecords)
+	mux.HandleFunc("/records/", s.apiPutRecord)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "TSIG ") {
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+			if err != nil {
+				apiError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			key, err := s.keyring().VerifyRequest(r, body, time.Now())
+			if err != nil {
+				apiError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			mux.ServeHTTP(w, withActor(r, "api:"+key.Name))
+			return
+		}
+		key := r.Header.Get("X-API-Key")
+		if strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if s.authorizeKey(key) {
+			mux.ServeHTTP(w, withActor(r, "api"))
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dnsup"`)
+		apiError(w, http.StatusUnauthorized, "missing or invalid API key")
+	})
+}
+
+// actorKey is the request context key of the authenticated client's name
+// for the audit log.
+type actorKey struct{}
+
+func withActor(r *http.Request, actor string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), actorKey{}, actor))
+}
+
+func (s *server) apiZones(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.load()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	zones := []apiZone{}
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			z := apiZone{Zone: auth.Domain(), File: mf.Name()}
+			if soa := auth.SOA(); soa != nil {
+				z.Serial = soa.Serial
+			}
+			zones = append(zones, z)
+		}
+	}
+	writeJSON(w, http.StatusOK, zones)
+}
+
+func (s *server) apiZoneRecords(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/zones/")
+	if !strings.HasSuffix(rest, "/records") {
+		apiError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != "GET" {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	zone := dns.Fqdn(strings.ToLower(strings.TrimSuffix(rest, "/records")))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.load()
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	auth := db.Zone(zone)
+	if auth == nil || !strings.EqualFold(auth.Domain(), zone) {
+		apiError(w, http.StatusNotFound, fmt.Sprintf("zone %q is not loaded", zone))
+		return
+	}
+	writeJSON(w, http.StatusOK, apiRecords(auth.Records()))
+}
+
+// apiPutRecord makes value the only data of the name's records of the
+// given type, adding a record if there are none.
+func (s *server) apiPutRecord(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := dns.Fqdn(strings.ToLower(strings.TrimPrefix(r.URL.Path, "/records/")))
+	var req struct {
+		Type    string     `json:"type"`
+		Value   string     `json:"value"`
+		TTL     *uint32    `json:"ttl"`
+		Expires *time.Time `json:"expires"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rrtype, ok := dns.StringToType[strings.ToUpper(req.Type)]
+	if !ok {
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("invalid record type %q", req.Type))
+		return
+	}
+	var expires time.Time
+	if req.Expires != nil {
+		expires = *req.Expires
+	}
+	actor, _ := r.Context().Value(actorKey{}).(string)
+	rrs, err := s.setRecord(name, rrtype, req.Value, req.TTL, expires, actor)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if e, ok := err.(*requestError); ok {
+			status = e.status
+		}
+		apiError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, apiRecords(rrs))
+}
+
+// requestError is an error in a request rather than on the server, with
+// the HTTP status to report it with.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+// updateError is the request error for err, as returned by an update to
+// a DB: a conflict for a record dnsup does not manage or an alias, else
+// a bad request.
+func updateError(err error) error {
+	switch err.(type) {
+	case *zonedb.UnmanagedError, *zonedb.AliasError:
+		return &requestError{http.StatusConflict, err.Error()}
+	}
+	return &requestError{http.StatusBadRequest, err.Error()}
+}
+
+// setRecord makes value the only data of the rrtype records of name. If
+// there are none a record is added with ttl, or the TTL of the zone's SOA
+// if ttl is nil. With a non-zero expires the records are instead replaced
+// by one expiring then, with ttl or the TTL of those replaced. The change
+// is written, audited as made by actor and announced, and the resulting
+// records returned.
+func (s *server) setRecord(name string, rrtype uint16, value string, ttl *uint32, expires time.Time, actor string) ([]dns.RR, error) {
+	if rrtype == dns.TypeSOA {
+		return nil, &requestError{http.StatusBadRequest, "cannot set SOA records"}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rrs []dns.RR
+	err := reapply(func() (err error) {
+		rrs, err = s.setRecordLocked(name, rrtype, value, ttl, expires, actor)
+		return err
+	})
+	return rrs, err
+}
+
+// setRecordLocked is setRecord for a caller holding the server's lock.
+func (s *server) setRecordLocked(name string, rrtype uint16, value string, ttl *uint32, expires time.Time, actor string) ([]dns.RR, error) {
+	db, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	auth := db.Zone(name)
+	if auth == nil {
+		return nil, &requestError{http.StatusNotFound, fmt.Sprintf("no loaded zone contains %q", name)}
+	}
+	if ttl != nil {
+		if err := s.cfg.zoneConfig(auth.Domain()).checkTTL(*ttl); err != nil {
+			return nil, &requestError{http.StatusBadRequest, err.Error()}
+		}
+	}
+	old := values(db.Lookup(name, rrtype))
+	if len(old) == 0 || !expires.IsZero() {
+		t := auth.SOA().Hdr.Ttl
+		if len(old) > 0 {
+			t = db.Lookup(name, rrtype)[0].Header().Ttl
+			if _, err := db.DeleteRecords(name, rrtype, ""); err != nil {
+				return nil, updateError(err)
+			}
+		}
+		if ttl != nil {
+			t = *ttl
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, t, dns.TypeToString[rrtype], value))
+		if err == nil && rr == nil {
+			err = fmt.Errorf("empty %s value", dns.TypeToString[rrtype])
+		}
+		switch {
+		case err != nil:
+		case expires.IsZero():
+			err = db.AddRecord(rr)
+		default:
+			err = db.AddExpiringRecord(rr, expires)
+		}
+		if err != nil {
+			return nil, &requestError{http.StatusBadRequest, err.Error()}
+		}
+	} else if err := db.UpdateRecord(name, rrtype, value); err != nil {
+		return nil, updateError(err)
+	}
+	if err := clampTTL(s.cfg, db, name, rrtype); err != nil {
+		return nil, updateError(err)
+	}
+
+	if db.Dirty() {
+		if s.cfg.DryRun {
+			return db.Lookup(name, rrtype), db.Diff(os.Stdout)
+		}
+		if err := db.Write(); err != nil {
+			return nil, err
+		}
+		s.health.wrote()
+		recordChanged(s.cfg, db, actor, name, rrtype, old, value)
+		s.setLive(db)
+		s.publish(db, name, rrtype)
+		var updates []update
+		if rrtype == dns.TypeA || rrtype == dns.TypeAAAA {
+			updates = append(updates, update{domain: name, ip: value})
+		}
+		if err := announce(s.cfg, db, updates); err != nil {
+			logging.Error(err)
+		}
+	}
+	return db.Lookup(name, rrtype), nil
+}
+
+func (s *server) load() (*zonedb.DB, error) {
+	db, err := s.cfg.newDB()
+	if err != nil {
+		return nil, err
+	}
+	return db, db.Load(s.cfg.zoneFiles()...)
+}
+
+func apiRecords(rrs []dns.RR) []apiRecord {
+	recs := []apiRecord{}
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		recs = append(recs, apiRecord{
+			Name:  hdr.Name,
+			Type:  dns.TypeToString[hdr.Rrtype],
+			TTL:   hdr.Ttl,
+			Value: strings.TrimPrefix(rr.String(), hdr.String()),
+		})
+	}
+	return recs
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Error(err)
+	}
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// change is one entry of a batch for "dnsup apply".
+type change struct {
+	Name  string  `json:"name"`
+	Type  string  `json:"type"`
+	Value string  `json:"value"`
+	TTL   *uint32 `json:"ttl"`
+}
+
+// rrset is the changes to one name and type, which together give it its
+// new records.
+type rrset struct {
+	name   string
+	rrtype uint16
+	values []string
+	ttl    *uint32
+}
+
+// applyOptions are the flags of "dnsup apply".
+type applyOptions struct {
+	file   string
+	format string
+}
+
+func applyFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.apply.file, "f", "-", "file of changes to apply, or - for standard input")
+	fs.StringVar(&c.apply.format, "format", "", "format of the changes, json or csv (default from the file name, else json)")
+}
+
+// runApply implements "dnsup apply [-f file] [-format json|csv]
+// [zonefile...]", setting the records listed in a JSON array of {"name",
+// "type", "value", "ttl"} objects, or CSV rows of name,type,value[,ttl],
+// read from a file or standard input. Several entries for the same name
+// and type give it that many records. Either every change is made, with
+// one serial bump per zone, or none is.
+func runApply(args []string) {
+	cfg, err := parseConfig("apply", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	cfg.zoneArgs(cfg.Args)
+
+	in := io.Reader(os.Stdin)
+	if cfg.apply.file != "-" {
+		f, err := os.Open(cfg.apply.file)
+		if err != nil {
+			logging.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	if cfg.apply.format == "" {
+		cfg.apply.format = "json"
+		if strings.EqualFold(filepath.Ext(cfg.apply.file), ".csv") {
+			cfg.apply.format = "csv"
+		}
+	}
+	changes, err := readChanges(in, cfg.apply.format)
+	if err != nil {
+		logging.Fatalf("%s: %v", cfg.apply.file, err)
+	}
+	sets, err := groupChanges(changes)
+	if err != nil {
+		logging.Fatalf("%s: %v", cfg.apply.file, err)
+	}
+	if len(sets) == 0 {
+		logging.Fatal("no changes to apply")
+	}
+
+	b, err := newBackend(cfg)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if b != nil {
+		var rus []recordUpdate
+		for _, set := range sets {
+			if len(set.values) > 1 {
+				logging.Fatalf("%s %s: several records need master files", set.name, dns.TypeToString[set.rrtype])
+			}
+			if set.ttl != nil {
+				logging.Fatalf("%s %s: ttl needs master files", set.name, dns.TypeToString[set.rrtype])
+			}
+			rus = append(rus, recordUpdate{name: set.name, rrtype: set.rrtype, value: set.values[0]})
+		}
+		ctx, cancel := cfg.timeoutContext()
+		err := applyBackend(ctx, cfg, b, nil, rus)
+		cancel()
+		flushEvents()
+		if err != nil {
+			logging.Fatal(err)
+		}
+		return
+	}
+
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	db := loadZones(cfg)
+	olds := make([][]dns.RR, len(sets))
+	for i, set := range sets {
+		for _, rr := range db.Lookup(set.name, set.rrtype) {
+			olds[i] = append(olds[i], dns.Copy(rr))
+		}
+	}
+	if err := applyRRsets(cfg, db, sets); err != nil {
+		logging.Fatalf("%v; nothing written", err)
+	}
+	if !db.Dirty() {
+		logging.Infof("no records changed")
+		return
+	}
+	if err := commit(cfg, db, nil); err != nil {
+		logging.Fatal(err)
+	}
+	if !cfg.DryRun {
+		for i, set := range sets {
+			if !sameRecords(olds[i], db.Lookup(set.name, set.rrtype)) {
+				recordChanged(cfg, db, cliActor(), set.name, set.rrtype, values(olds[i]), strings.Join(set.values, ","))
+			}
+		}
+		flushEvents()
+	}
+}
+
+// readChanges reads a batch of changes in the given format.
+func readChanges(r io.Reader, format string) ([]change, error) {
+	switch format {
+	case "json":
+		var changes []change
+		if err := json.NewDecoder(r).Decode(&changes); err != nil {
+			return nil, err
+		}
+		return changes, nil
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.Comment = '#'
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		var changes []change
+		for n := 1; ; n++ {
+			row, err := cr.Read()
+			if err == io.EOF {
+				return changes, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if n == 1 && strings.EqualFold(row[0], "name") {
+				continue // header
+			}
+			if len(row) < 3 || len(row) > 4 {
+				return nil, fmt.Errorf("line %d: want name,type,value[,ttl]", n)
+			}
+			c := change{Name: row[0], Type: row[1], Value: row[2]}
+			if len(row) == 4 && row[3] != "" {
+				ttl, err := strconv.ParseUint(row[3], 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid TTL %q", n, row[3])
+				}
+				t := uint32(ttl)
+				c.TTL = &t
+			}
+			changes = append(changes, c)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q: want json or csv", format)
+	}
+}
+
+// groupChanges collects the changes by name and type, in the order each
+// first appears.
+func groupChanges(changes []change) ([]*rrset, error) {
+	var sets []*rrset
+	byKey := map[string]*rrset{}
+	for i, c := range changes {
+		rrtype, ok := dns.StringToType[strings.ToUpper(c.Type)]
+		if !ok || rrtype == dns.TypeSOA {
+			return nil, fmt.Errorf("change %d: invalid record type %q", i+1, c.Type)
+		}
+		if c.Name == "" || strings.TrimSpace(c.Value) == "" {
+			return nil, fmt.Errorf("change %d: missing name or value", i+1)
+		}
+		name := dns.Fqdn(strings.ToLower(c.Name))
+		key := name + " " + dns.TypeToString[rrtype]
+		set := byKey[key]
+		if set == nil {
+			set = &rrset{name: name, rrtype: rrtype}
+			byKey[key] = set
+			sets = append(sets, set)
+		}
+		set.values = append(set.values, strings.TrimSpace(c.Value))
+		if c.TTL != nil {
+			set.ttl = c.TTL
+		}
+	}
+	return sets, nil
+}
+
+// applyRRsets gives each name and type of sets its values, keeping their
+// TTLs within the min_ttl and max_ttl of their zone: a TTL given in the
+// batch outside them is an error, and any other is raised or lowered into
+// them.
+func applyRRsets(cfg *config, db *zonedb.DB, sets []*rrset) error {
+	for _, set := range sets {
+		err := setRRset(db, set)
+		switch {
+		case err != nil:
+		case set.ttl != nil:
+			err = cfg.nameConfig(db, set.name).checkTTL(*set.ttl)
+		default:
+			err = clampTTL(cfg, db, set.name, set.rrtype)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %v", set.name, dns.TypeToString[set.rrtype], err)
+		}
+	}
+	return nil
+}
+
+// setRRset gives the name and type of set exactly its values. A single
+// value replacing a single record updates it in place, keeping its
+// position in the file; otherwise the records are replaced. New records
+// take the set's TTL, else that of the records replaced, else that of the
+// zone's SOA.
+func setRRset(db *zonedb.DB, set *rrset) error {
+	auth := db.Zone(set.name)
+	if auth == nil {
+		return fmt.Errorf("no loaded zone contains %q", set.name)
+	}
+	existing := db.Lookup(set.name, set.rrtype)
+	if len(set.values) == 1 && len(existing) == 1 {
+		if err := db.UpdateRecord(set.name, set.rrtype, set.values[0]); err != nil {
+			return err
+		}
+		if set.ttl != nil {
+			return db.SetTTL(set.name, set.rrtype, *set.ttl)
+		}
+		return nil
+	}
+
+	ttl := auth.SOA().Hdr.Ttl
+	if len(existing) > 0 {
+		ttl = existing[0].Header().Ttl
+	}
+	if set.ttl != nil {
+		ttl = *set.ttl
+	}
+	var rrs []dns.RR
+	for _, v := range set.values {
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", set.name, ttl, dns.TypeToString[set.rrtype], v))
+		if err == nil && rr == nil {
+			err = fmt.Errorf("empty value")
+		}
+		if err != nil {
+			return err
+		}
+		rrs = append(rrs, rr)
+	}
+	if sameRecords(existing, rrs) {
+		return nil
+	}
+	if len(existing) > 0 {
+		if _, err := db.DeleteRecords(set.name, set.rrtype, ""); err != nil {
+			return err
+		}
+	}
+	for _, rr := range rrs {
+		if err := db.AddRecord(rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameRecords reports whether a and b hold the same data and TTLs, in any
+// order.
+func sameRecords(a, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := map[string]int{}
+	for _, rr := range a {
+		count[fmt.Sprintf("%d %s", rr.Header().Ttl, rdataOf(rr))]++
+	}
+	for _, rr := range b {
+		key := fmt.Sprintf("%d %s", rr.Header().Ttl, rdataOf(rr))
+		if count[key] == 0 {
+			return false
+		}
+		count[key]--
+	}
+	return true
+}
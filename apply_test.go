@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+const applyZone = `$ORIGIN example.com.
+$TTL 30
+@	IN	SOA	ns1 hostmaster 2024010101 7200 3600 1209600 300
+	IN	NS	ns1
+ns1	IN	A	192.0.2.1
+www	300	IN	A	192.0.2.10
+`
+
+// loadApplyZone returns a DB holding applyZone and a function removing
+// its file.
+func loadApplyZone(t *testing.T) (*zonedb.DB, func()) {
+	dir, err := ioutil.TempDir("", "dnsup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "example.com.zone")
+	if err := ioutil.WriteFile(file, []byte(applyZone), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	db := zonedb.New()
+	if err := db.Load(file); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() { os.RemoveAll(dir) }
+}
+
+func TestApplyRRsetsTTLLimits(t *testing.T) {
+	cfg := &config{MinTTL: 60}
+	low := uint32(5)
+
+	db, done := loadApplyZone(t)
+	defer done()
+	err := applyRRsets(cfg, db, []*rrset{{name: "www.example.com.", rrtype: dns.TypeA, values: []string{"192.0.2.11"}, ttl: &low}})
+	if err == nil || !strings.Contains(err.Error(), "below min_ttl") {
+		t.Errorf("TTL below min_ttl: %v", err)
+	}
+
+	db, done = loadApplyZone(t)
+	defer done()
+	sets := []*rrset{{name: "mail.example.com.", rrtype: dns.TypeA, values: []string{"192.0.2.20", "192.0.2.21"}}}
+	if err := applyRRsets(cfg, db, sets); err != nil {
+		t.Fatal(err)
+	}
+	rrs := db.Lookup("mail.example.com.", dns.TypeA)
+	if len(rrs) != 2 {
+		t.Fatalf("%d records added, want 2", len(rrs))
+	}
+	for _, rr := range rrs {
+		if rr.Header().Ttl != 60 {
+			t.Errorf("%s not raised to min_ttl", rr)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/dyndns"
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/provider"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// backend applies updates somewhere other than local master files: an
+// authoritative server via RFC 2136 or its own control channel, a hosted
+// DNS provider's API, a dyndns2 service, or a hosts file for dnsmasq or
+// the local machine.
+type backend interface {
+	// UpdateRecord makes value the only rrtype record of name.
+	UpdateRecord(ctx context.Context, name string, rrtype uint16, value string) error
+	// Plan describes the change UpdateRecord would make, for -dry-run.
+	Plan(ctx context.Context, name string, rrtype uint16, value string) (string, error)
+}
+
+// backends returns how many backends are configured; more than one is an
+// error.
+func (c *config) backends() int {
+	n := 0
+	for _, s := range []string{c.Server, c.Provider, c.DynDNSService, c.Knot, c.Dnsmasq, c.HostsFile} {
+		if s != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// newBackend returns the configured backend, or nil if updates should be
+// applied to master files.
+func newBackend(cfg *config) (backend, error) {
+	n := cfg.backends()
+	switch {
+	case n > 1:
+		return nil, fmt.Errorf("-server, -provider, -dyndns-service, -knot, -dnsmasq and -hosts-file are mutually exclusive")
+	case cfg.DynDNSService != "":
+		password := cfg.DynDNSPassword
+		if password == "" {
+			password = os.Getenv("DYNDNS_PASSWORD")
+		}
+		b := &dyndnsBackend{service: strings.ToLower(cfg.DynDNSService)}
+		var err error
+		switch b.service {
+		case "duckdns":
+			b.u, err = dyndns.NewDuckDNS(password)
+		case "freedns":
+			b.u, err = dyndns.NewFreeDNS(password, cfg.DynDNSTokens)
+		default:
+			var c *dyndns.Client
+			if c, err = dyndns.NewClient(cfg.DynDNSService, cfg.DynDNSUser, password); err == nil {
+				b.u, b.service = c, c.URL
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	case cfg.Server != "":
+		key, err := cfg.tsigKey()
+		if err != nil {
+			return nil, err
+		}
+		return newDynamicUpdater(cfg.Server, cfg.Zone, key)
+	case cfg.Provider != "":
+		p, err := provider.New(cfg.Provider, cfg.ProviderOptions)
+		if err != nil {
+			return nil, err
+		}
+		return &providerBackend{p: p}, nil
+	case cfg.Knot != "":
+		return newKnotBackend(cfg.Knot, cfg.Zone)
+	case cfg.Dnsmasq != "":
+		return newDnsmasqBackend(cfg.Dnsmasq, cfg.DnsmasqReload), nil
+	case cfg.HostsFile != "":
+		return newHostsBackend(cfg.HostsFile), nil
+	}
+	return nil, nil
+}
+
+// applyBackend applies the address and record updates through b, or
+// prints the planned changes with -dry-run.
+func applyBackend(ctx context.Context, cfg *config, b backend, updates []update, sets []recordUpdate) error {
+	var all []recordUpdate
+	for _, up := range updates {
+		if zonedb.IsPattern(up.domain) {
+			return fmt.Errorf("%s: patterns need master files, not -server, -provider or -dyndns-service", up.domain)
+		}
+		rrtype, err := addressType(up.ip)
+		if err != nil {
+			return err
+		}
+		all = append(all, recordUpdate{name: up.domain, rrtype: rrtype, value: up.ip})
+	}
+	batch, ok := b.(provider.Batcher)
+	if ok && !cfg.DryRun {
+		batch.Begin()
+	}
+	var applied []recordUpdate
+	for _, set := range append(all, sets...) {
+		if cfg.DryRun {
+			plan, err := b.Plan(ctx, set.name, set.rrtype, set.value)
+			if err != nil {
+				return err
+			}
+			if !cfg.Quiet {
+				fmt.Println(plan)
+			}
+			continue
+		}
+		if err := b.UpdateRecord(ctx, set.name, set.rrtype, set.value); err != nil {
+			return err
+		}
+		if !ok {
+			noteChange(set.name, set.rrtype, nil, set.value)
+			recordChanged(cfg, nil, cliActor(), set.name, set.rrtype, nil, set.value)
+			continue
+		}
+		applied = append(applied, set)
+	}
+	if !ok || cfg.DryRun {
+		return nil
+	}
+	// A batch only takes effect on Commit, so its changes are recorded
+	// once it has succeeded.
+	if err := batch.Commit(ctx); err != nil {
+		return err
+	}
+	for _, set := range applied {
+		noteChange(set.name, set.rrtype, nil, set.value)
+		recordChanged(cfg, nil, cliActor(), set.name, set.rrtype, nil, set.value)
+	}
+	return nil
+}
+
+// addressType returns the record type holding ip.
+func addressType(ip string) (uint16, error) {
+	ipa := net.ParseIP(ip)
+	switch {
+	case ipa == nil:
+		return 0, fmt.Errorf("invalid IP address %q", ip)
+	case ipa.To4() != nil:
+		return dns.TypeA, nil
+	default:
+		return dns.TypeAAAA, nil
+	}
+}
+
+// providerBackend adapts a provider.Provider to a backend.
+type providerBackend struct {
+	p provider.Provider
+}
+
+func (b *providerBackend) UpdateRecord(ctx context.Context, name string, rrtype uint16, value string) error {
+	return b.p.UpsertRecord(ctx, provider.Record{Name: name, Type: dns.TypeToString[rrtype], Value: value})
+}
+
+// Begin and Commit batch updates if the provider supports it.
+func (b *providerBackend) Begin() {
+	if batch, ok := b.p.(provider.Batcher); ok {
+		batch.Begin()
+	}
+}
+
+func (b *providerBackend) Commit(ctx context.Context) error {
+	if batch, ok := b.p.(provider.Batcher); ok {
+		return batch.Commit(ctx)
+	}
+	return nil
+}
+
+func (b *providerBackend) Plan(ctx context.Context, name string, rrtype uint16, value string) (string, error) {
+	typ := dns.TypeToString[rrtype]
+	recs, err := b.p.GetRecords(ctx, name, typ)
+	if err != nil {
+		return "", err
+	}
+	var old []string
+	for _, rec := range recs {
+		old = append(old, rec.Value)
+	}
+	if len(old) == 1 && old[0] == value {
+		return fmt.Sprintf("%s %s: unchanged %s", name, typ, value), nil
+	}
+	return fmt.Sprintf("%s %s: [%s] -> %s", name, typ, strings.Join(old, ", "), value), nil
+}
+
+// dyndnsBackend pushes address updates to a dynamic DNS service, which
+// cannot hold other record types or report current values.
+type dyndnsBackend struct {
+	u       dyndns.Updater
+	service string
+}
+
+func (b *dyndnsBackend) UpdateRecord(ctx context.Context, name string, rrtype uint16, value string) error {
+	if rrtype != dns.TypeA && rrtype != dns.TypeAAAA {
+		return fmt.Errorf("%s %s: %s only updates A and AAAA records", name, dns.TypeToString[rrtype], b.service)
+	}
+	changed, err := b.u.Update(ctx, name, value)
+	if err == nil && !changed {
+		logging.Infof("%s: already %s", name, value)
+	}
+	return err
+}
+
+func (b *dyndnsBackend) Plan(ctx context.Context, name string, rrtype uint16, value string) (string, error) {
+	return fmt.Sprintf("%s %s: -> %s via %s", name, dns.TypeToString[rrtype], value, b.service), nil
+}
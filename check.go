@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// runCheck implements "dnsup check [-strict] [zonefile...]", validating
+// the zones without writing them. It exits non-zero if any zone has
+// errors, or warnings with -strict.
+func runCheck(args []string) {
+	cfg, err := parseConfig("check", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	cfg.zoneArgs(cfg.Args)
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	db := loadZones(cfg)
+
+	failed := 0
+	for _, p := range db.Check() {
+		fmt.Println(p)
+		if !p.Warning || cfg.Strict {
+			failed++
+		}
+	}
+	zones := 0
+	for _, mf := range db.Files() {
+		zones += len(mf.Authorities())
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) in %d zone(s)\n", failed, zones)
+		os.Exit(1)
+	}
+	fmt.Printf("%d zone(s) OK\n", zones)
+}
+
+// setValidation sets how db validates the master files it writes: by
+// parsing and checking them again ("builtin"), by that and BIND's
+// named-checkzone ("named-checkzone"), by named-checkzone if it is
+// installed ("auto"), or not at all ("off").
+func (c *config) setValidation(db *zonedb.DB) error {
+	switch c.Validate {
+	case "builtin", "":
+	case "named-checkzone":
+		db.SetValidator(namedCheckzone)
+	case "auto":
+		if _, err := exec.LookPath("named-checkzone"); err == nil {
+			db.SetValidator(namedCheckzone)
+		}
+	case "off":
+		db.EnableValidation(false)
+		return nil
+	default:
+		return fmt.Errorf("unknown validate setting %q: want builtin, named-checkzone, auto or off", c.Validate)
+	}
+	db.EnableValidation(true)
+	return nil
+}
+
+// namedCheckzone runs named-checkzone on file, the new text of zone.
+func namedCheckzone(zone, file string) error {
+	cmd := exec.Command("named-checkzone", zone, file)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("named-checkzone: %v: %s", err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+// command is a subcommand, selected by the first argument. Besides -config
+// and the common flags, it takes those of its groups and any its flags
+// function registers, which run reads from the config parseConfig returns.
+type command struct {
+	name    string
+	args    string // what follows the flags, for help and the man page
+	summary string
+	// complete says what each positional argument is, for completion:
+	// "name" for a record name, "zone" for a zone, "type" for a record
+	// type, "command" for a command and "shell" for a shell. Other
+	// arguments are completed as file names by the shell.
+	complete []string
+	groups   []*flagGroup
+	flags    func(fs *flag.FlagSet, c *config)
+	noFlags  bool // not even the common ones, as it does not parse them
+	hidden   bool
+	run      func(args []string)
+}
+
+// commands are the subcommands, in the order help lists them; set in
+// init, as help refers to them.
+var commands []command
+
+func init() {
+	// edit are the groups of the commands that change master files.
+	edit := []*flagGroup{loadGroup, writeGroup, announceGroup, tsigGroup}
+	updates := append(edit, backendGroup, pluginGroup, addressGroup, updateGroup, verifyGroup)
+	updateCommand = &command{name: "dnsup", args: "[zonefile...]", groups: updates, flags: updateFlags, run: runUpdate}
+	commands = []command{
+		{name: "add", args: "name TYPE value [zonefile...]", summary: "add a record", complete: []string{"name", "type"}, groups: edit, flags: addFlags, run: runAdd},
+		{name: "delete", args: "name TYPE [zonefile...]", summary: "delete the records of a name and type", complete: []string{"name", "type"}, groups: edit, flags: deleteFlags, run: runDelete},
+		{name: "set-ttl", args: "name ttl [zonefile...]", summary: "set the TTL of the address records of a name, or a pattern", complete: []string{"name"}, groups: edit, flags: setTTLFlags, run: runSetTTL},
+		{name: "apply", args: "[zonefile...]", summary: "set the records listed in a JSON or CSV file, all or none", groups: append(edit, backendGroup, pluginGroup), flags: applyFlags, run: runApply},
+		{name: "check", args: "[zonefile...]", summary: "validate the zones without writing them", groups: []*flagGroup{loadGroup}, run: runCheck},
+		{name: "diff", args: "old.zone new.zone|@server", summary: "compare two master files, or a master file and a server", groups: []*flagGroup{loadGroup, tsigGroup}, flags: diffFlags, run: runDiff},
+		{name: "fmt", args: "[zonefile...]", summary: "print or rewrite master files in a canonical style", groups: []*flagGroup{loadGroup}, flags: fmtFlags, run: runFmt},
+		{name: "export", args: "[zonefile...]", summary: "print the parsed zones as JSON or YAML", groups: []*flagGroup{loadGroup}, flags: exportFlags, run: runExport},
+		{name: "import", args: "zone", summary: "write a master file from a zone transfer or a zone definition", groups: []*flagGroup{loadGroup, writeGroup, tsigGroup}, flags: importFlags, run: runImport},
+		{name: "tui", args: "[zonefile...]", summary: "browse and edit the zones in a terminal UI", groups: edit, run: runTUI},
+		{name: "daemon", args: "[zonefile...]", summary: "keep the records pointed at the current addresses", groups: updates, flags: daemonFlags, run: runDaemon},
+		{name: "serve", args: "[zonefile...]", summary: "accept dyndns2, REST, gRPC and DNS UPDATE requests", groups: append(edit, updateGroup), flags: serveFlags, run: runServe},
+		{name: "secondary", args: "[zone=file...]", summary: "keep master files in step with a primary server", groups: edit, flags: secondaryFlags, run: runSecondary},
+		{name: "kube", args: "[zonefile...]", summary: "give the hosts of Kubernetes Ingresses and Services records", groups: edit, flags: kubeFlags, run: runKube},
+		{name: "docker", args: "[zonefile...]", summary: "give the hosts of Docker containers records", groups: append(edit, pluginGroup, addressGroup), flags: dockerFlags, run: runDocker},
+		{name: "acme", args: "present|cleanup fqdn value [zonefile...]", summary: "publish ACME DNS-01 challenges, for lego's exec provider", groups: append(edit, verifyGroup), flags: acmeFlags, run: runAcme},
+		{name: "journal", args: "[zonefile...]", summary: "print or undo the journaled changes of the zones", groups: edit, flags: journalFlags, run: runJournal},
+		{name: "history", args: "[name]", summary: "print the audit log entries of a name", complete: []string{"name"}, flags: historyFlags, run: runHistory},
+		{name: "rollback", args: "[zonefile...]", summary: "write a zone as it was at a serial or a time", groups: edit, flags: rollbackFlags, run: runRollback},
+		{name: "restore", args: "[zonefile...]", summary: "put back a backup of each master file", groups: edit, flags: restoreFlags, run: runRestore},
+		{name: "soa", args: "zone [zonefile...]", summary: "print or set the fields of a zone's SOA", complete: []string{"zone"}, groups: edit, flags: soaFlags, run: runSOA},
+		{name: "sign", args: "[zonefile...]", summary: "re-sign the zones under new serials", groups: edit, run: runSign},
+		{name: "ds", args: "[keyfile...]", summary: "print the DS records of the key signing keys", flags: dsFlags, run: runDS},
+		{name: "cds", args: "[zonefile...]", summary: "publish CDS and CDNSKEY records for the parent", groups: edit, flags: cdsFlags, run: runCDS},
+		{name: "help", args: "[command]", summary: "describe the commands, or the flags of one", complete: []string{"command"}, noFlags: true, run: runHelp},
+		{name: "completion", args: "bash|zsh|fish", summary: "print a shell completion script", complete: []string{"shell"}, noFlags: true, run: runCompletion},
+		{name: "man", summary: "print the man page, in roff", noFlags: true, run: runMan},
+		{name: "__complete", noFlags: true, hidden: true, run: runComplete},
+	}
+}
+
+// findCommand returns the subcommand named name, or nil.
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// updateCommand is dnsup run without a subcommand, for parseConfig,
+// help, completion and the man page; set in init.
+var updateCommand *command
+
+// flagSet returns a FlagSet of the flags cmd takes, setting the fields of c
+// and, for -config, path.
+func (cmd *command) flagSet(c *config, path *string, handling flag.ErrorHandling) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, handling)
+	if cmd.noFlags {
+		return fs
+	}
+	fs.StringVar(path, "config", "", "read settings from this TOML file")
+	c.registerCommon(fs)
+	for _, g := range cmd.groups {
+		g.register(c, fs)
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs, c)
+	}
+	return fs
+}
+
+// flagSetOf returns the flags of cmd, for describing and completing them.
+func flagSetOf(cmd *command) *flag.FlagSet {
+	return cmd.flagSet(defaultConfig(), new(string), flag.ContinueOnError)
+}
+
+// takes reports whether cmd takes the flags of g.
+func (cmd *command) takes(g *flagGroup) bool {
+	for _, cg := range cmd.groups {
+		if cg == g {
+			return true
+		}
+	}
+	return false
+}
+
+// runHelp implements "dnsup help [command]", listing the commands or
+// printing the usage and flags of one.
+func runHelp(args []string) {
+	if len(args) > 0 {
+		cmd := findCommand(args[0])
+		if cmd == nil || cmd.hidden {
+			logging.Fatalf("unknown command %q", args[0])
+		}
+		if cmd.noFlags {
+			fmt.Printf("usage: dnsup %s %s\n\n%s.\n", cmd.name, cmd.args, capitalize(cmd.summary))
+			return
+		}
+		fmt.Printf("usage: dnsup %s [flags] %s\n\n%s.\n\nflags:\n", cmd.name, cmd.args, capitalize(cmd.summary))
+		fs := flagSetOf(cmd)
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+		return
+	}
+	fmt.Println("usage: dnsup [flags] [zonefile...]")
+	fmt.Println("       dnsup command [flags] [args...]")
+	fmt.Println()
+	fmt.Println("Without a command, dnsup points the records of the domains at the given")
+	fmt.Println("or discovered addresses. The commands are:")
+	fmt.Println()
+	for _, cmd := range commands {
+		if !cmd.hidden {
+			fmt.Printf("  %-10s %s\n", cmd.name, cmd.summary)
+		}
+	}
+	fmt.Println()
+	fmt.Println(`Run "dnsup help command" for the flags of a command, or "dnsup -h" for`)
+	fmt.Println("those of updates.")
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// runCompletion implements "dnsup completion bash|zsh|fish", printing a
+// script that completes commands, flags, zones and record names by
+// calling "dnsup __complete".
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		logging.Fatal("usage: dnsup completion bash|zsh|fish")
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		logging.Fatalf("unknown shell %q: want bash, zsh or fish", args[0])
+	}
+}
+
+// The completion scripts pass the words of the command line after
+// "dnsup", up to the one being completed, to "dnsup __complete" and offer
+// what it prints, or file names if it prints nothing.
+const (
+	bashCompletion = `# bash completion for dnsup: source this, or install it as
+# /etc/bash_completion.d/dnsup.
+_dnsup() {
+	local IFS=$'\n'
+	COMPREPLY=($(dnsup __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _dnsup dnsup
+`
+	zshCompletion = `#compdef dnsup
+# zsh completion for dnsup: install this as _dnsup in a directory of
+# $fpath, or source it after compinit.
+_dnsup() {
+	local -a candidates
+	candidates=("${(@f)$(dnsup __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ -n ${candidates[1]} ]]; then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+if [[ $funcstack[1] == _dnsup ]]; then
+	_dnsup "$@"
+else
+	compdef _dnsup dnsup
+fi
+`
+	fishCompletion = `# fish completion for dnsup: install this as
+# ~/.config/fish/completions/dnsup.fish.
+function __dnsup_complete
+	set -l args (commandline -opc)
+	set -e args[1]
+	set -l cur (commandline -ct)
+	dnsup __complete $args "$cur" 2>/dev/null
+end
+complete -c dnsup -a '(__dnsup_complete)'
+`
+)
+
+// runComplete implements "dnsup __complete word...", printing the
+// completions of the last word given the ones before it, as the
+// completion scripts call it.
+func runComplete(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	logging.SetDefault(logging.New(ioutil.Discard, logging.LevelError, false))
+	for _, c := range completions(args[:len(args)-1], args[len(args)-1]) {
+		fmt.Println(c)
+	}
+}
+
+// completions returns the completions of cur following the words before
+// it on the command line.
+func completions(words []string, cur string) []string {
+	cmd := updateCommand
+	if len(words) > 0 {
+		if c := findCommand(words[0]); c != nil && !c.hidden {
+			cmd, words = c, words[1:]
+		}
+	} else if !strings.HasPrefix(cur, "-") {
+		return withPrefix(commandNames(), cur)
+	}
+
+	fs := flagSetOf(cmd)
+	var configFile string
+	var args []string
+	var pending *flag.Flag // a flag waiting for its value
+	for _, w := range words {
+		if pending != nil {
+			if pending.Name == "config" {
+				configFile = w
+			}
+			pending = nil
+			continue
+		}
+		if len(args) > 0 || !strings.HasPrefix(w, "-") || w == "-" {
+			args = append(args, w)
+			continue
+		}
+		name := strings.TrimLeft(w, "-")
+		if i := strings.Index(name, "="); i >= 0 {
+			if name[:i] == "config" {
+				configFile = name[i+1:]
+			}
+			continue
+		}
+		if f := fs.Lookup(name); f != nil && !isBoolFlag(f) {
+			pending = f
+		}
+	}
+
+	switch {
+	case pending != nil:
+		switch pending.Name {
+		case "zone":
+			return withPrefix(zoneNames(configFile, args, true), cur)
+		case "domain":
+			return withPrefix(zoneNames(configFile, args, false), cur)
+		case "type":
+			return withPrefix(recordTypes, strings.ToUpper(cur))
+		}
+		return nil
+	case strings.HasPrefix(cur, "-") && len(args) == 0:
+		var flags []string
+		fs.VisitAll(func(f *flag.Flag) { flags = append(flags, "-"+f.Name) })
+		return withPrefix(flags, cur)
+	case len(args) < len(cmd.complete):
+		switch cmd.complete[len(args)] {
+		case "name":
+			return withPrefix(zoneNames(configFile, args, false), cur)
+		case "type":
+			return withPrefix(recordTypes, strings.ToUpper(cur))
+		case "zone":
+			return withPrefix(zoneNames(configFile, args, true), cur)
+		case "command":
+			return withPrefix(commandNames(), cur)
+		case "shell":
+			return withPrefix([]string{"bash", "fish", "zsh"}, cur)
+		}
+	}
+	return nil
+}
+
+// commandNames returns the names of the commands help lists.
+func commandNames() []string {
+	var names []string
+	for _, c := range commands {
+		if !c.hidden {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// recordTypes are the record types completed.
+var recordTypes = []string{"A", "AAAA", "CAA", "CNAME", "DS", "HTTPS", "MX", "NS", "PTR", "SRV", "SSHFP", "SVCB", "TLSA", "TXT"}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func withPrefix(words []string, prefix string) []string {
+	var out []string
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// zoneNames returns the zones, or the record names, of the master files
+// among args, or else of those of the configuration file, or of none.
+func zoneNames(configFile string, args []string, zones bool) []string {
+	cfg := defaultConfig()
+	if configFile != "" {
+		cfg.load(configFile)
+	}
+	var files []string
+	for _, a := range args {
+		if st, err := os.Stat(a); err == nil && st.Mode().IsRegular() {
+			files = append(files, a)
+		}
+	}
+	cfg.zoneArgs(files)
+	cfg.KeepGoing = true
+	db, err := cfg.newDB()
+	if err != nil {
+		return nil
+	}
+	db.Load(cfg.zoneFiles()...)
+	seen := map[string]bool{}
+	var names []string
+	add := func(name string) {
+		name = strings.TrimSuffix(name, ".")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			if zones {
+				add(auth.Domain())
+				continue
+			}
+			for _, rr := range auth.Records() {
+				add(rr.Header().Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runMan implements "dnsup man", printing a man page for dnsup(1)
+// describing the commands and their flags.
+func runMan(args []string) {
+	writeMan(os.Stdout)
+}
+
+func writeMan(w io.Writer) {
+	fmt.Fprint(w, ".TH DNSUP 1 \"\" dnsup \"User Commands\"\n")
+	fmt.Fprint(w, ".SH NAME\ndnsup \\- keep DNS records pointed at changing addresses\n")
+	fmt.Fprint(w, ".SH SYNOPSIS\n.B dnsup\n[\\fIflags\\fR] [\\fIzonefile\\fR...]\n.br\n.B dnsup\n\\fIcommand\\fR [\\fIflags\\fR] [\\fIargs\\fR...]\n")
+	fmt.Fprint(w, ".SH DESCRIPTION\n"+
+		"Without a command, dnsup points the A and AAAA records of the configured domains "+
+		"at the given or discovered addresses, rewriting the master files with only those "+
+		"records changed and the serials advanced, or through a DNS provider or server. "+
+		"The commands edit, check, serve and follow the zones in other ways.\n")
+	fmt.Fprint(w, ".SH OPTIONS\nThe flags override the settings of the\n.B \\-config\nfile. Every command but help, completion and man takes these:\n")
+	manFlags(w, (&command{}).flagSet(defaultConfig(), new(string), flag.ContinueOnError))
+	for _, g := range flagGroups {
+		var takers []string
+		if updateCommand.takes(g) {
+			takers = append(takers, "updates")
+		}
+		for i := range commands {
+			if commands[i].takes(g) {
+				takers = append(takers, commands[i].name)
+			}
+		}
+		fmt.Fprintf(w, ".SS %s\nFlags for %s, taken by %s:\n", roff(g.name), roff(g.summary), roff(list(takers)))
+		fs := flag.NewFlagSet(g.name, flag.ContinueOnError)
+		g.register(defaultConfig(), fs)
+		manFlags(w, fs)
+	}
+	fmt.Fprint(w, ".SH COMMANDS\n")
+	for i := range commands {
+		cmd := &commands[i]
+		if cmd.hidden {
+			continue
+		}
+		flags := "[\\fIflags\\fR] "
+		if cmd.noFlags {
+			flags = ""
+		}
+		fmt.Fprintf(w, ".SS %s\n.B dnsup %s\n%s%s\n.PP\n%s.\n", cmd.name, cmd.name, flags, roff(cmd.args), roff(capitalize(cmd.summary)))
+		manCommandFlags(w, cmd)
+	}
+	fmt.Fprint(w, ".SS updates\nFlags of dnsup run without a command:\n")
+	manCommandFlags(w, updateCommand)
+	fmt.Fprint(w, ".SH EXIT STATUS\nAn update without a command exits with\n")
+	for _, st := range []struct {
+		code int
+		text string
+	}{
+		{exitChanged, "records were changed, or would be with -dry-run"},
+		{exitError, "the update failed"},
+		{exitUnchanged, "the records already held the addresses"},
+		{exitVerifyFailed, "the changes were made but did not appear in time with -verify"},
+		{exitPartial, "master files failed to load with keep_going; the others were updated"},
+	} {
+		fmt.Fprintf(w, ".TP\n.B %d\n%s (%s)\n", st.code, roff(capitalize(st.text)), statuses[st.code])
+	}
+	fmt.Fprint(w, ".PP\nand, with\n.BR \\-quiet ,\nprints the status, changes and error as a JSON line. The commands exit with 1 on failure.\n")
+	fmt.Fprint(w, ".SH FILES\n.TP\n.I dnsup.example.toml\nAn example configuration, describing every setting.\n")
+	fmt.Fprint(w, ".SH SEE ALSO\n.BR named (8),\n.BR rndc (8),\n.BR nsupdate (1)\n")
+}
+
+// manCommandFlags writes the flags of cmd's own, and names its groups.
+func manCommandFlags(w io.Writer, cmd *command) {
+	if cmd.flags != nil {
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		cmd.flags(fs, defaultConfig())
+		manFlags(w, fs)
+	}
+	if len(cmd.groups) > 0 {
+		var names []string
+		for _, g := range cmd.groups {
+			names = append(names, g.name)
+		}
+		fmt.Fprintf(w, ".PP\nIt also takes the flags for %s.\n", roff(list(names)))
+	}
+}
+
+// list joins words as "a, b and c".
+func list(words []string) string {
+	if len(words) < 2 {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " and " + words[len(words)-1]
+}
+
+// manFlags writes the flags of fs as a list of tagged paragraphs.
+func manFlags(w io.Writer, fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		name, usage := flag.UnquoteUsage(f)
+		fmt.Fprintf(w, ".TP\n.B \\-%s", roff(f.Name))
+		if name != "" {
+			fmt.Fprintf(w, " \\fI%s\\fR", roff(name))
+		}
+		fmt.Fprintf(w, "\n%s", roff(usage))
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" && f.DefValue != "[]" {
+			fmt.Fprintf(w, " (default %s)", roff(f.DefValue))
+		}
+		fmt.Fprintln(w)
+	})
+}
+
+// roff escapes text for the man page.
+func roff(text string) string {
+	text = strings.Replace(text, `\`, `\e`, -1)
+	text = strings.Replace(text, "-", `\-`, -1)
+	if strings.HasPrefix(text, ".") || strings.HasPrefix(text, "'") {
+		text = `\&` + text
+	}
+	return text
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// addOptions are the flags of "dnsup add".
+type addOptions struct {
+	ttl     int
+	expires string
+}
+
+func addFlags(fs *flag.FlagSet, c *config) {
+	fs.IntVar(&c.add.ttl, "ttl", -1, "TTL of the new record (default the TTL of the zone's SOA)")
+	fs.StringVar(&c.add.expires, "expires", "", "remove the record, with \"dnsup daemon\" running, after this duration or at this RFC 3339 time")
+}
+
+// runAdd implements "dnsup add name TYPE value [zonefile...]".
+func runAdd(args []string) {
+	cfg, err := parseConfig("add", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if len(cfg.Args) < 3 {
+		logging.Fatal("usage: dnsup add [flags] name TYPE value [zonefile...]")
+	}
+	name, typ, value := dns.Fqdn(cfg.Args[0]), strings.ToUpper(cfg.Args[1]), cfg.Args[2]
+	cfg.zoneArgs(cfg.Args[3:])
+
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	db := loadZones(cfg)
+	if cfg.add.ttl < 0 {
+		auth := db.Zone(name)
+		if auth == nil {
+			logging.Fatalf("no loaded zone contains %q", name)
+		}
+		cfg.add.ttl = int(auth.SOA().Hdr.Ttl)
+	}
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, cfg.add.ttl, typ, value))
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if rr == nil {
+		logging.Fatalf("empty %s value for %q", typ, name)
+	}
+	old := values(db.Lookup(name, rr.Header().Rrtype))
+	if cfg.add.expires == "" {
+		err = db.AddRecord(rr)
+	} else {
+		var at time.Time
+		if at, err = parseExpiry(cfg.add.expires, time.Now()); err != nil {
+			logging.Fatalf("invalid -expires: %v", err)
+		}
+		err = db.AddExpiringRecord(rr, at)
+	}
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if err := commit(cfg, db, nil); err != nil {
+		logging.Fatal(err)
+	}
+	if !cfg.DryRun {
+		recordChanged(cfg, db, cliActor(), name, rr.Header().Rrtype, old, rdataOf(rr))
+		flushEvents()
+	}
+}
+
+// deleteOptions are the flags of "dnsup delete".
+type deleteOptions struct {
+	value string
+}
+
+func deleteFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.delete.value, "value", "", "only delete records with this data")
+}
+
+// runDelete implements "dnsup delete [-value data] name TYPE [zonefile...]".
+func runDelete(args []string) {
+	cfg, err := parseConfig("delete", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if len(cfg.Args) < 2 {
+		logging.Fatal("usage: dnsup delete [flags] name TYPE [zonefile...]")
+	}
+	name, typ := dns.Fqdn(cfg.Args[0]), strings.ToUpper(cfg.Args[1])
+	cfg.zoneArgs(cfg.Args[2:])
+	rrtype, ok := dns.StringToType[typ]
+	if !ok {
+		logging.Fatalf("unknown record type %q", typ)
+	}
+
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	db := loadZones(cfg)
+	old := values(db.Lookup(name, rrtype))
+	n, err := db.DeleteRecords(name, rrtype, cfg.delete.value)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if n == 0 {
+		logging.Fatalf("no %s records found for %q", typ, name)
+	}
+	if err := commit(cfg, db, nil); err != nil {
+		logging.Fatal(err)
+	}
+	if !cfg.DryRun {
+		if cfg.delete.value != "" {
+			old = []string{cfg.delete.value}
+		}
+		recordChanged(cfg, db, cliActor(), name, rrtype, old, "")
+		flushEvents()
+	}
+}
+
+func loadZones(cfg *config) *zonedb.DB {
+	db, err := cfg.newDB()
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if _, err := loadAll(db, cfg.zoneFiles()); err != nil {
+		logging.Fatal(err)
+	}
+	return db
+}
+
+// loadAll loads files into db. With keep_going, each file that fails to
+// load is logged with its error and left out, and the *zonedb.LoadError
+// for them is returned with a nil error.
+func loadAll(db *zonedb.DB, files []string) (*zonedb.LoadError, error) {
+	err := db.Load(files...)
+	le, ok := err.(*zonedb.LoadError)
+	if !ok {
+		return nil, err
+	}
+	for i, file := range le.Files {
+		fields := logging.Fields{"file": file, "error": le.Errs[i].Error()}
+		if pe, ok := le.Errs[i].(*zonedb.ParseError); ok {
+			fields["file"], fields["error"] = pe.File, pe.Err.Error()
+			if pe.Line > 0 {
+				fields["line"] = pe.Line
+			}
+		}
+		logging.Event(logging.LevelError, "master file failed to load", fields)
+	}
+	return le, nil
+}
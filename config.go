@@ -0,0 +1,786 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/alert"
+	"github.com/johnweldon/dnsup/pkg/catalog"
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/plugin"
+	"github.com/johnweldon/dnsup/pkg/provider"
+	"github.com/johnweldon/dnsup/pkg/retry"
+	"github.com/johnweldon/dnsup/pkg/sqlstore"
+	"github.com/johnweldon/dnsup/pkg/tsig"
+	"github.com/johnweldon/dnsup/pkg/webhook"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// config holds every setting; it is populated from an optional TOML file
+// and then overridden by flags given on the command line.
+type config struct {
+	Zones           []string          `toml:"zones"`
+	Domains         []string          `toml:"domains"`
+	IP              string            `toml:"ip"`
+	IPv4            string            `toml:"ipv4"`
+	IPv6            string            `toml:"ipv6"`
+	Set             []string          `toml:"set"`
+	AutoIP          bool              `toml:"auto_ip"`
+	IPFamily        int               `toml:"ip_family"`
+	IPSources       []string          `toml:"ip_sources"`
+	Iface           string            `toml:"iface"`
+	PreferGlobal    bool              `toml:"prefer_global"`
+	IPConsensus     string            `toml:"ip_consensus"`
+	Provider        string            `toml:"provider"`
+	ProviderOptions map[string]string `toml:"provider_options"`
+	PluginDirs      []string          `toml:"plugin_dirs"`
+
+	Interval          duration               `toml:"interval"`
+	Settle            duration               `toml:"settle"`
+	MinUpdateInterval duration               `toml:"min_update_interval"`
+	Timeout           duration               `toml:"timeout"`
+	Retry             map[string]retryConfig `toml:"retry"`
+	StateFile         string                 `toml:"state_file"`
+
+	DynDNSService  string            `toml:"dyndns_service"`
+	DynDNSUser     string            `toml:"dyndns_user"`
+	DynDNSPassword string            `toml:"dyndns_password"`
+	DynDNSTokens   map[string]string `toml:"dyndns_tokens"`
+
+	Dnsmasq       string `toml:"dnsmasq"`
+	DnsmasqReload string `toml:"dnsmasq_reload"`
+	HostsFile     string `toml:"hosts_file"`
+
+	Server  string   `toml:"server"`
+	Zone    string   `toml:"zone"`
+	TSIG    string   `toml:"tsig"`
+	Keyring string   `toml:"keyring"`
+	Knot    string   `toml:"knot"`
+	Rndc    string   `toml:"rndc"`
+	DryRun  bool     `toml:"dry_run"`
+	Stdin   bool     `toml:"-"`
+	Quiet   bool     `toml:"-"`
+	Stream  bool     `toml:"stream"`
+	Args    []string `toml:"-"`
+
+	Notify   []string `toml:"notify"`
+	NotifyNS bool     `toml:"notify_ns"`
+
+	Verify          bool     `toml:"verify"`
+	VerifyResolvers []string `toml:"verify_resolvers"`
+	VerifyTimeout   duration `toml:"verify_timeout"`
+
+	Hooks     []string            `toml:"hooks"`
+	ZoneHooks map[string][]string `toml:"zone_hooks"`
+
+	Listen      string               `toml:"listen"`
+	TLSCert     string               `toml:"tls_cert"`
+	TLSKey      string               `toml:"tls_key"`
+	ServeDynDNS bool                 `toml:"serve_dyndns"`
+	ServeAPI    bool                 `toml:"serve_api"`
+	APIKeys     []string             `toml:"api_keys"`
+	GRPCListen  string               `toml:"grpc_listen"`
+	DNSListen   string               `toml:"dns_listen"`
+	Users       map[string]serveUser `toml:"users"`
+
+	Serial      string            `toml:"serial"`
+	SerialZones map[string]string `toml:"serial_zones"`
+	LiveSerial  bool              `toml:"live_serial"`
+	Journal     bool              `toml:"journal"`
+	RecordStore string            `toml:"record_store"`
+	ZoneWorkers int               `toml:"zone_workers"`
+	KeepGoing   bool              `toml:"keep_going"`
+	Strict      bool              `toml:"strict"`
+	Tidy        bool              `toml:"tidy"`
+	Origin      string            `toml:"origin"`
+	Origins     map[string]string `toml:"origins"`
+	FollowCNAME bool              `toml:"follow_cname"`
+	Templates   map[string]string `toml:"templates"`
+	tmplData    *templateData     // set by runs that render the templates
+
+	CoreDNS       bool   `toml:"coredns"`
+	CoreDNSReload string `toml:"coredns_reload"`
+
+	Validate string `toml:"validate"`
+
+	AddressPolicy string   `toml:"address_policy"`
+	OldIP         string   `toml:"-"`
+	IPSet         []string `toml:"-"`
+	BackupDir     string   `toml:"backup_dir"`
+	BackupKeep    int      `toml:"backup_keep"`
+	MinTTL        int      `toml:"min_ttl"`
+	MaxTTL        int      `toml:"max_ttl"`
+	ManagedOnly   bool     `toml:"managed_only"`
+
+	ReverseZones []string              `toml:"reverse_zones"`
+	Views        map[string]viewConfig `toml:"views"`
+
+	ZoneSettings map[string]zoneSettings `toml:"zone_settings"`
+
+	DNSSECKeys     []string `toml:"dnssec_keys"`
+	DNSSECNSEC3    bool     `toml:"dnssec_nsec3"`
+	DNSSECValidity duration `toml:"dnssec_validity"`
+	DNSSECSigner   string   `toml:"dnssec_signer"`
+
+	TransferAllow []string `toml:"transfer_allow"`
+	MetricsListen string   `toml:"metrics_listen"`
+
+	KubeServer    string `toml:"kube_server"`
+	KubeToken     string `toml:"kube_token"`
+	KubeCA        string `toml:"kube_ca"`
+	KubeNamespace string `toml:"kube_namespace"`
+	KubeOwner     string `toml:"kube_owner"`
+
+	DockerHost  string `toml:"docker_host"`
+	DockerOwner string `toml:"docker_owner"`
+
+	Primary          string            `toml:"primary"`
+	SecondaryZones   map[string]string `toml:"secondary_zones"`
+	SecondaryRefresh duration          `toml:"secondary_refresh"`
+
+	LogLevel  string `toml:"log_level"`
+	LogFormat string `toml:"log_format"`
+
+	AuditLog string          `toml:"audit_log"`
+	Webhooks []webhookConfig `toml:"webhooks"`
+	Alerts   []alertConfig   `toml:"alerts"`
+	Push     []pushConfig    `toml:"push"`
+	Catalogs []catalogConfig `toml:"catalogs"`
+
+	webhooks []*webhook.Hook
+	alerts   []*alert.Filter
+	catalogs []catalog.Store
+
+	// The flags of single commands, which have no settings.
+	acme      acmeOptions
+	add       addOptions
+	apply     applyOptions
+	cds       cdsOptions
+	delete    deleteOptions
+	diff      diffOptions
+	ds        dsOptions
+	export    exportOptions
+	fmt       fmtOptions
+	history   historyOptions
+	importing importOptions
+	journal   journalOptions
+	restore   restoreOptions
+	rollback  rollbackOptions
+	secondary secondaryOptions
+	setTTL    setTTLOptions
+	soa       soaOptions
+}
+
+// webhookConfig is an endpoint that record changes are posted to, with
+// the body rendered from the Body template if set and signed with Secret.
+type webhookConfig struct {
+	URL    string `toml:"url"`
+	Secret string `toml:"secret"`
+	Body   string `toml:"body"`
+}
+
+// catalogConfig is a Consul agent or etcd server that the addresses of
+// changed names are published into. Which of the other settings apply
+// depends on the kind.
+type catalogConfig struct {
+	Kind     string `toml:"kind"`
+	URL      string `toml:"url"`
+	Prefix   string `toml:"prefix"`
+	Token    string `toml:"token"`
+	Service  bool   `toml:"service"`
+	User     string `toml:"user"`
+	Password string `toml:"password"`
+}
+
+// store returns the catalog.Store for cc.
+func (cc catalogConfig) store() (catalog.Store, error) {
+	if !strings.HasPrefix(cc.URL, "http://") && !strings.HasPrefix(cc.URL, "https://") {
+		return nil, fmt.Errorf("%s catalog needs an http or https url", cc.Kind)
+	}
+	url := strings.TrimSuffix(cc.URL, "/")
+	switch cc.Kind {
+	case "consul":
+		if cc.Prefix == "" && !cc.Service {
+			return nil, fmt.Errorf("consul catalog needs a prefix or service")
+		}
+		return &catalog.Consul{URL: url, Token: cc.Token, Prefix: cc.Prefix, Service: cc.Service}, nil
+	case "etcd":
+		return &catalog.Etcd{URL: url, User: cc.User, Password: cc.Password, Prefix: cc.Prefix}, nil
+	default:
+		return nil, fmt.Errorf("unknown catalog kind %q: want consul or etcd", cc.Kind)
+	}
+}
+
+// retryConfig overrides the default retry policy of one of retryOps. Zero
+// settings keep the default, except an explicit jitter.
+type retryConfig struct {
+	Attempts   int      `toml:"attempts"`
+	Backoff    duration `toml:"backoff"`
+	MaxBackoff duration `toml:"max_backoff"`
+	Jitter     *float64 `toml:"jitter"`
+}
+
+// retryOps are the network operations with a retry policy.
+var retryOps = []string{"discovery", "provider", "notify", "verify", "push", "catalog"}
+
+// alertConfig is an email, Telegram or ntfy destination for alerts about
+// the listed events, or about every event if there are none. Which of
+// the other settings apply depends on the kind.
+type alertConfig struct {
+	Kind   string   `toml:"kind"`
+	Events []string `toml:"events"`
+
+	SMTP     string   `toml:"smtp"`
+	User     string   `toml:"user"`
+	Password string   `toml:"password"`
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+
+	Token  string `toml:"token"`
+	ChatID string `toml:"chat_id"`
+
+	URL      string `toml:"url"`
+	Priority string `toml:"priority"`
+}
+
+// sender returns the alert.Sender for a.
+func (a alertConfig) sender() (alert.Sender, error) {
+	switch a.Kind {
+	case "email":
+		if a.SMTP == "" || a.From == "" || len(a.To) == 0 {
+			return nil, fmt.Errorf("email alert needs smtp, from and to")
+		}
+		return &alert.Email{Addr: a.SMTP, User: a.User, Password: a.Password, From: a.From, To: a.To}, nil
+	case "telegram":
+		if a.Token == "" || a.ChatID == "" {
+			return nil, fmt.Errorf("telegram alert needs token and chat_id")
+		}
+		return &alert.Telegram{Token: a.Token, ChatID: a.ChatID, API: a.URL}, nil
+	case "ntfy":
+		if a.URL == "" {
+			return nil, fmt.Errorf("ntfy alert needs url")
+		}
+		return &alert.Ntfy{URL: a.URL, Token: a.Token, Priority: a.Priority}, nil
+	default:
+		return nil, fmt.Errorf("unknown alert kind %q: want email, telegram or ntfy", a.Kind)
+	}
+}
+
+func defaultConfig() *config {
+	return &config{
+		IPFamily:       4,
+		Serial:         "increment",
+		AddressPolicy:  "overwrite",
+		Validate:       "builtin",
+		Interval:       duration{5 * time.Minute},
+		Timeout:        duration{2 * time.Minute},
+		Settle:         duration{30 * time.Second},
+		Listen:         ":8053",
+		DNSSECValidity: duration{30 * 24 * time.Hour},
+		VerifyTimeout:  duration{2 * time.Minute},
+		BackupKeep:     10,
+		LogLevel:       "info",
+		LogFormat:      "text",
+	}
+}
+
+// flagGroup is a set of flags that several commands take, described once
+// in the man page.
+type flagGroup struct {
+	name     string
+	summary  string
+	register func(c *config, fs *flag.FlagSet)
+}
+
+// The flag groups commands take besides the common flags.
+var (
+	loadGroup     = &flagGroup{"loading", "how master files are loaded", (*config).registerLoad}
+	writeGroup    = &flagGroup{"writing", "how changed zones are written, and what else records their changes", (*config).registerWrite}
+	announceGroup = &flagGroup{"announcing", "how changed zones are signed, reloaded, announced and copied", (*config).registerAnnounce}
+	tsigGroup     = &flagGroup{"TSIG", "the TSIG keys of zone transfers, dynamic updates and NOTIFY", (*config).registerTSIG}
+	backendGroup  = &flagGroup{"backends", "where records are kept instead of master files", (*config).registerBackends}
+	pluginGroup   = &flagGroup{"plugins", "where provider and address source plugins are found", (*config).registerPlugins}
+	addressGroup  = &flagGroup{"addresses", "the addresses to apply, or how they are discovered", (*config).registerAddresses}
+	updateGroup   = &flagGroup{"address updates", "how a new address changes the records of a name", (*config).registerUpdates}
+	verifyGroup   = &flagGroup{"verification", "how changes are checked to have reached the name servers", (*config).registerVerify}
+)
+
+// flagGroups are the flag groups, for the man page.
+var flagGroups = []*flagGroup{loadGroup, writeGroup, announceGroup, tsigGroup, backendGroup, pluginGroup, addressGroup, updateGroup, verifyGroup}
+
+// registerDomains registers -domain, for the commands that update the
+// addresses of domains.
+func (c *config) registerDomains(fs *flag.FlagSet) {
+	fs.Var((*stringList)(&c.Domains), "domain", "domain name to update, or a pattern such as @ or *.home.example.com (repeatable)")
+}
+
+// registerCommon registers the flags every command that parses flags
+// takes, besides -config.
+func (c *config) registerCommon(fs *flag.FlagSet) {
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "least severe messages to log: debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log as text or as one JSON object per line")
+}
+
+func (c *config) registerLoad(fs *flag.FlagSet) {
+	fs.StringVar(&c.Origin, "origin", c.Origin, "default $ORIGIN of the master files, and the zone of those without a SOA record, loaded as fragments of it")
+	fs.Var((*optionMap)(&c.Templates), "template", "render the master file from a Go template, as zonefile=template (repeatable)")
+	fs.IntVar(&c.ZoneWorkers, "zone-workers", c.ZoneWorkers, "how many master files to parse or write at once, or 0 for one per CPU")
+	fs.BoolVar(&c.KeepGoing, "keep-going", c.KeepGoing, "report every master file that fails to load and go on with the others")
+	fs.BoolVar(&c.Strict, "strict", c.Strict, "fail to load master files with record types or directives dnsup skips, rather than warning")
+	fs.StringVar(&c.Serial, "serial", c.Serial, "SOA serial policy: increment, date (YYYYMMDDnn) or unix")
+}
+
+func (c *config) registerWrite(fs *flag.FlagSet) {
+	fs.BoolVar(&c.LiveSerial, "live-serial", c.LiveSerial, "advance serials from the one the zone's name servers serve, when that is ahead of the master file's")
+	fs.StringVar(&c.Validate, "validate", c.Validate, "validate master files before writing them: builtin, named-checkzone, auto (named-checkzone if installed, else builtin) or off")
+	fs.BoolVar(&c.Tidy, "tidy", c.Tidy, "remove duplicate records from the master files written, and group their records by name and type")
+	fs.BoolVar(&c.CoreDNS, "coredns", c.CoreDNS, "write master files in the form CoreDNS's file plugin expects: SOA first, explicit and consistent TTLs, includes inlined")
+	fs.BoolVar(&c.ManagedOnly, "managed-only", c.ManagedOnly, "only change records marked with a \"; dnsup:managed\" comment, stamping those changed")
+	fs.IntVar(&c.MinTTL, "min-ttl", c.MinTTL, "raise the TTL of the records dnsup manages to at least this")
+	fs.IntVar(&c.MaxTTL, "max-ttl", c.MaxTTL, "lower the TTL of the records dnsup manages to at most this, or 0 for no limit")
+	fs.Var((*stringList)(&c.ReverseZones), "reverse-zone", "reverse zone master file whose PTR records follow address changes (repeatable)")
+	fs.StringVar(&c.BackupDir, "backup-dir", c.BackupDir, "copy each master file into this directory before rewriting it")
+	fs.IntVar(&c.BackupKeep, "backup-keep", c.BackupKeep, "how many backups of each master file to keep in -backup-dir, or 0 for all")
+	fs.BoolVar(&c.Journal, "journal", c.Journal, "record the changes to each zone in a journal beside its master file, for IXFR")
+	fs.StringVar(&c.RecordStore, "record-store", c.RecordStore, "also keep the zones' records in this SQLite database, for querying")
+	fs.StringVar(&c.AuditLog, "audit-log", c.AuditLog, "append every record change, with who made it, to this file")
+	fs.Var((*webhookList)(&c.Webhooks), "webhook", "post record changes as JSON to this URL (repeatable)")
+	fs.BoolVar(&c.DryRun, "dry-run", c.DryRun, "print a unified diff of the updated zones instead of writing them")
+}
+
+func (c *config) registerAnnounce(fs *flag.FlagSet) {
+	fs.Var((*stringList)(&c.DNSSECKeys), "dnssec-key", "DNSSEC key pair, K<zone>+<alg>+<tag>, to re-sign changed zones with (repeatable)")
+	fs.BoolVar(&c.DNSSECNSEC3, "dnssec-nsec3", c.DNSSECNSEC3, "sign with NSEC3 rather than NSEC records")
+	fs.StringVar(&c.DNSSECSigner, "dnssec-signer", c.DNSSECSigner, "shell command that signs a changed zone, instead of signing it with -dnssec-key")
+	fs.StringVar(&c.CoreDNSReload, "coredns-reload", c.CoreDNSReload, "shell command run once zones have changed, such as \"pkill -USR1 -x coredns\"")
+	fs.StringVar(&c.Rndc, "rndc", c.Rndc, "have BIND add, repoint or reload changed zones with this rndc command, such as \"rndc -k /etc/bind/rndc.key\"")
+	fs.Var((*stringList)(&c.Notify), "notify", "send NOTIFY for changed zones to this secondary (repeatable)")
+	fs.BoolVar(&c.NotifyNS, "notify-ns", c.NotifyNS, "send NOTIFY to the zone's NS records other than the primary")
+	fs.Var((*stringList)(&c.Hooks), "hook", "shell command to run for each changed zone (repeatable)")
+	fs.Var((*pushList)(&c.Push), "push", "upload the master files of changed zones to [user@]host:directory with scp (repeatable)")
+}
+
+func (c *config) registerTSIG(fs *flag.FlagSet) {
+	fs.StringVar(&c.TSIG, "tsig", c.TSIG, "TSIG key for -server and NOTIFY: [algorithm:]name:secret, or the name of a -keyring key")
+	fs.StringVar(&c.Keyring, "keyring", c.Keyring, "file of TSIG keys, as BIND key statements or YAML")
+}
+
+func (c *config) registerBackends(fs *flag.FlagSet) {
+	fs.StringVar(&c.Provider, "provider", c.Provider, "update records through this DNS provider's API instead of master files")
+	fs.Var((*optionMap)(&c.ProviderOptions), "provider-opt", "provider option as key=value, such as token=... (repeatable)")
+	fs.StringVar(&c.DynDNSService, "dyndns-service", c.DynDNSService, "push address updates to this dyndns2 server URL, or to duckdns or freedns")
+	fs.StringVar(&c.DynDNSUser, "dyndns-user", c.DynDNSUser, "user name for -dyndns-service")
+	fs.StringVar(&c.DynDNSPassword, "dyndns-password", c.DynDNSPassword, "password, or token for duckdns and freedns, for -dyndns-service (default $DYNDNS_PASSWORD)")
+	fs.Var((*optionMap)(&c.DynDNSTokens), "dyndns-token", "per host freedns token as host=token (repeatable)")
+	fs.StringVar(&c.Dnsmasq, "dnsmasq", c.Dnsmasq, "keep the addresses in this dnsmasq hosts file, such as Pi-hole's custom.list, or address= .conf file, instead of master files")
+	fs.StringVar(&c.DnsmasqReload, "dnsmasq-reload", c.DnsmasqReload, "shell command run after changing -dnsmasq (default \""+defaultDnsmasqReload+"\")")
+	fs.StringVar(&c.HostsFile, "hosts-file", c.HostsFile, "keep the addresses in a block of this hosts file, such as /etc/hosts, instead of master files")
+	fs.StringVar(&c.Server, "server", c.Server, "send RFC 2136 dynamic updates to this server instead of rewriting master files")
+	fs.StringVar(&c.Zone, "zone", c.Zone, "zone to update with -server or -knot (discovered if empty)")
+	fs.StringVar(&c.Knot, "knot", c.Knot, "update a running Knot DNS server through knotc zone transactions, with this knotc command, instead of master files")
+	fs.DurationVar(&c.Timeout.Duration, "timeout", c.Timeout.Duration, "give up on the address discovery and updates of a run or daemon check after this long, or 0 for no limit")
+}
+
+func (c *config) registerPlugins(fs *flag.FlagSet) {
+	fs.Var((*stringList)(&c.PluginDirs), "plugin-dir", "look for dnsup-provider-NAME and dnsup-ipsource-NAME plugins here instead of in $PATH (repeatable)")
+}
+
+func (c *config) registerAddresses(fs *flag.FlagSet) {
+	fs.StringVar(&c.IP, "ip", c.IP, "IP address to apply to each -domain, to its A or AAAA records by family")
+	fs.StringVar(&c.IPv4, "ipv4", c.IPv4, "IPv4 address for the A records of each -domain, or auto to discover it")
+	fs.StringVar(&c.IPv6, "ipv6", c.IPv6, "IPv6 address for the AAAA records of each -domain, or auto to discover it")
+	fs.BoolVar(&c.AutoIP, "auto-ip", c.AutoIP, "discover the public IP address instead of using a fixed one")
+	fs.IntVar(&c.IPFamily, "ip-family", c.IPFamily, "address family to discover with -auto-ip (4 or 6)")
+	fs.Var((*stringList)(&c.IPSources), "ip-source", "URL, dns:opendns, stun:HOST[:PORT], natpmp[:GATEWAY], upnp, iface:NAME or exec:COMMAND to query with -auto-ip (repeatable)")
+	fs.StringVar(&c.IPConsensus, "ip-consensus", c.IPConsensus, "query every -ip-source and require this many, or a majority, to agree on the address")
+	fs.StringVar(&c.Iface, "iface", c.Iface, "discover addresses from this network interface instead of -ip-source")
+	fs.BoolVar(&c.PreferGlobal, "prefer-global", c.PreferGlobal, "with -iface, prefer public addresses over private and ULA ones")
+}
+
+func (c *config) registerUpdates(fs *flag.FlagSet) {
+	fs.StringVar(&c.AddressPolicy, "address-policy", c.AddressPolicy, "for a name with several A or AAAA records: overwrite them all, replace-all with one, or replace-one, the one holding the old address")
+	fs.BoolVar(&c.FollowCNAME, "follow-cname", c.FollowCNAME, "update the addresses of the canonical name of a domain that is a CNAME, rather than failing")
+}
+
+func (c *config) registerVerify(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Verify, "verify", c.Verify, "after updating, query the zone's name servers until the new records are visible")
+	fs.Var((*stringList)(&c.VerifyResolvers), "verify-resolver", "with -verify, query this resolver instead of the name servers (repeatable)")
+	fs.DurationVar(&c.VerifyTimeout.Duration, "verify-timeout", c.VerifyTimeout.Duration, "how long -verify waits for the records to appear")
+}
+
+// parseConfig parses args with the flags of the command named name, or
+// of updates for "dnsup". When -config is given the file is loaded first
+// and the flags are applied on top of it. Positional arguments are left in
+// Args.
+func parseConfig(name string, args []string) (*config, error) {
+	cmd := updateCommand
+	if name != cmd.name {
+		if cmd = findCommand(name); cmd == nil {
+			return nil, fmt.Errorf("unknown command %q", name)
+		}
+	}
+	parse := func(c *config) (string, []string, error) {
+		var path string
+		fs := cmd.flagSet(c, &path, flag.ExitOnError)
+		err := fs.Parse(args)
+		return path, fs.Args(), err
+	}
+
+	cfg := defaultConfig()
+	path, zones, err := parse(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		cfg = defaultConfig()
+		if err := cfg.load(path); err != nil {
+			return nil, err
+		}
+		if _, _, err := parse(cfg); err != nil {
+			return nil, err
+		}
+	}
+	cfg.Args = zones
+	cfg.normalize()
+	if cfg.MinTTL < 0 || cfg.MaxTTL < 0 || cfg.MaxTTL > 0 && cfg.MinTTL > cfg.MaxTTL {
+		return nil, fmt.Errorf("invalid TTL limits: min_ttl %d, max_ttl %d", cfg.MinTTL, cfg.MaxTTL)
+	}
+	for zone, z := range cfg.ZoneSettings {
+		if zc := cfg.zoneConfig(zone); zc.MinTTL < 0 || zc.MaxTTL < 0 || zc.MaxTTL > 0 && zc.MinTTL > zc.MaxTTL {
+			return nil, fmt.Errorf("invalid TTL limits for zone %s: min_ttl %d, max_ttl %d", zone, zc.MinTTL, zc.MaxTTL)
+		}
+		if _, err := z.soa(); err != nil {
+			return nil, fmt.Errorf("zone %s: %v", zone, err)
+		}
+	}
+	if err := cfg.setupLogging(); err != nil {
+		return nil, err
+	}
+	if err := cfg.setupEvents(); err != nil {
+		return nil, err
+	}
+	if err := cfg.setupRetries(); err != nil {
+		return nil, err
+	}
+	if _, err := plugin.Discover(cfg.PluginDirs); err != nil {
+		return nil, fmt.Errorf("plugins: %v", err)
+	}
+	for _, pc := range cfg.Push {
+		if pc.Host == "" || pc.Method != "" && pc.Method != "scp" && pc.Method != "rsync" {
+			return nil, fmt.Errorf("invalid push to %q: want a host and method scp or rsync", pc.Host)
+		}
+	}
+	return cfg, nil
+}
+
+// setupEvents prepares the webhooks, alerts and catalogs that changes and
+// failures are sent to.
+func (c *config) setupEvents() error {
+	for _, wc := range c.Webhooks {
+		h, err := webhook.New(wc.URL, wc.Secret, wc.Body)
+		if err != nil {
+			return err
+		}
+		c.webhooks = append(c.webhooks, h)
+	}
+	for _, ac := range c.Alerts {
+		s, err := ac.sender()
+		if err != nil {
+			return err
+		}
+		for _, e := range ac.Events {
+			if !alert.ValidEvent(e) {
+				return fmt.Errorf("unknown alert event %q: want %s", e, strings.Join(alert.Events, ", "))
+			}
+		}
+		c.alerts = append(c.alerts, &alert.Filter{Sender: s, Events: ac.Events})
+	}
+	for _, cc := range c.Catalogs {
+		s, err := cc.store()
+		if err != nil {
+			return err
+		}
+		c.catalogs = append(c.catalogs, s)
+	}
+	return nil
+}
+
+// setupRetries checks the retry settings and applies those of provider
+// API requests, which the provider package retries itself.
+func (c *config) setupRetries() error {
+	for op, rc := range c.Retry {
+		known := false
+		for _, o := range retryOps {
+			known = known || o == op
+		}
+		if !known {
+			return fmt.Errorf("unknown retry operation %q: want %s", op, strings.Join(retryOps, ", "))
+		}
+		if rc.Attempts < 0 || rc.Jitter != nil && (*rc.Jitter < 0 || *rc.Jitter > 1) {
+			return fmt.Errorf("invalid retry.%s: want attempts of at least 0 and jitter from 0 to 1", op)
+		}
+	}
+	provider.Retry = c.retryPolicy("provider")
+	return nil
+}
+
+// retryPolicy returns the retry policy of op: the default, overridden by
+// any [retry.<op>] settings.
+func (c *config) retryPolicy(op string) retry.Policy {
+	p := retry.Default
+	rc, ok := c.Retry[op]
+	if !ok {
+		return p
+	}
+	if rc.Attempts > 0 {
+		p.Attempts = rc.Attempts
+	}
+	if rc.Backoff.Duration > 0 {
+		p.Backoff = rc.Backoff.Duration
+	}
+	if rc.MaxBackoff.Duration > 0 {
+		p.MaxBackoff = rc.MaxBackoff.Duration
+	}
+	if rc.Jitter != nil {
+		p.Jitter = *rc.Jitter
+	}
+	return p
+}
+
+// setupLogging replaces the default logger with one at the configured
+// level and format.
+func (c *config) setupLogging() error {
+	level, err := logging.ParseLevel(c.LogLevel)
+	if err != nil {
+		return err
+	}
+	var asJSON bool
+	switch c.LogFormat {
+	case "", "text":
+	case "json":
+		asJSON = true
+	default:
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	}
+	logging.SetDefault(logging.New(os.Stderr, level, asJSON))
+	return nil
+}
+
+// ipSources returns the sources to discover addresses from: the -iface
+// interface if set, or else the configured ip_sources.
+func (c *config) ipSources() []string {
+	if c.Iface == "" {
+		return c.IPSources
+	}
+	src := "iface:" + c.Iface
+	if c.PreferGlobal {
+		src += "/global"
+	}
+	return []string{src}
+}
+
+// timeoutContext returns the context bounding the network operations of
+// a run or daemon check by -timeout.
+func (c *config) timeoutContext() (context.Context, context.CancelFunc) {
+	if c.Timeout.Duration <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), c.Timeout.Duration)
+}
+
+// zoneFiles returns the master files to load: the zones followed by any
+// reverse zones not among them.
+func (c *config) zoneFiles() []string {
+	files := append([]string(nil), c.Zones...)
+	for _, rev := range c.ReverseZones {
+		dup := false
+		for _, f := range c.Zones {
+			dup = dup || f == rev
+		}
+		if !dup {
+			files = append(files, rev)
+		}
+	}
+	return files
+}
+
+// zoneArgs replaces the configured zones with files named on the command
+// line, if any.
+func (c *config) zoneArgs(files []string) {
+	if len(files) > 0 {
+		c.Zones = files
+	}
+}
+
+// newDB returns a zone database configured with the serial policies,
+// journaling, ownership, backups, PTR synchronization, workers, error
+// collection, strictness, CoreDNS output, origin, CNAME chasing, address
+// policy and templates.
+func (c *config) newDB() (*zonedb.DB, error) {
+	db := zonedb.New()
+	db.SetWorkers(c.ZoneWorkers)
+	db.CollectErrors(c.KeepGoing)
+	db.EnableStrict(c.Strict)
+	db.EnableCoreDNS(c.CoreDNS)
+	db.EnableTidy(c.Tidy)
+	if err := c.setValidation(db); err != nil {
+		return nil, err
+	}
+	db.SetOrigin("", c.Origin)
+	db.EnableCNAMEChase(c.FollowCNAME)
+	ap, err := zonedb.ParseAddressPolicy(c.AddressPolicy)
+	if err != nil {
+		return nil, err
+	}
+	db.SetAddressPolicy(ap)
+	for file, origin := range c.Origins {
+		db.SetOrigin(file, origin)
+	}
+	if err := c.setTemplates(db); err != nil {
+		return nil, err
+	}
+	db.SetWarnings(func(err error) { logging.Warnf("%v", err) })
+	p, err := zonedb.ParseSerialPolicy(c.Serial)
+	if err != nil {
+		return nil, err
+	}
+	db.SetSerialPolicy("", p)
+	for zone, name := range c.SerialZones {
+		p, err := zonedb.ParseSerialPolicy(name)
+		if err != nil {
+			return nil, fmt.Errorf("zone %s: %v", zone, err)
+		}
+		db.SetSerialPolicy(dns.Fqdn(zone), p)
+	}
+	if c.LiveSerial {
+		db.SetLiveSerial(liveSerial)
+	}
+	for zone, z := range c.ZoneSettings {
+		if z.Serial != "" {
+			p, err := zonedb.ParseSerialPolicy(z.Serial)
+			if err != nil {
+				return nil, fmt.Errorf("zone %s: %v", zone, err)
+			}
+			db.SetSerialPolicy(zone, p)
+		}
+		if z.ManagedOnly != nil {
+			db.SetOwnership(zone, *z.ManagedOnly)
+		}
+		if f, err := z.soa(); err != nil {
+			return nil, fmt.Errorf("zone %s: %v", zone, err)
+		} else if f != (zonedb.SOAFields{}) {
+			db.SetSOAFields(zone, f)
+		}
+	}
+	db.EnableJournal(c.Journal)
+	db.EnableOwnership(c.ManagedOnly)
+	if c.BackupDir != "" {
+		db.EnableBackups(c.BackupDir, c.BackupKeep)
+	}
+	db.EnablePTRSync(len(c.ReverseZones) > 0)
+	if c.RecordStore != "" {
+		s, err := openStore(c.RecordStore)
+		if err != nil {
+			return nil, fmt.Errorf("record store %s: %v", c.RecordStore, err)
+		}
+		db.SetStore(s)
+	}
+	return db, nil
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = map[string]*sqlstore.Store{}
+)
+
+// openStore returns the record store in file, opening it on first use so
+// that every DB of a long-running command shares one connection.
+func openStore(file string) (*sqlstore.Store, error) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if s, ok := stores[file]; ok {
+		return s, nil
+	}
+	s, err := sqlstore.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	stores[file] = s
+	return s, nil
+}
+
+// keyring loads the configured keyring, or returns nil if there is none.
+func (c *config) keyring() (*tsig.Keyring, error) {
+	if c.Keyring == "" {
+		return nil, nil
+	}
+	return tsig.Load(c.Keyring)
+}
+
+// tsigKey resolves the tsig setting, an inline key or the name of a key
+// in the keyring. It returns nil if the setting is empty.
+func (c *config) tsigKey() (*tsig.Key, error) {
+	if c.TSIG == "" {
+		return nil, nil
+	}
+	if strings.Contains(c.TSIG, ":") {
+		return tsig.ParseKey(c.TSIG)
+	}
+	kr, err := c.keyring()
+	if err != nil {
+		return nil, err
+	}
+	if k := kr.Get(c.TSIG); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("TSIG key %q not found (set -keyring or give [algorithm:]name:secret)", c.TSIG)
+}
+
+// normalize makes the zone names used as keys fully qualified, and those
+// of zone_settings lowercase too; see zoneConfig.
+func (c *config) normalize() {
+	hooks := map[string][]string{}
+	for zone, cmds := range c.ZoneHooks {
+		hooks[dns.Fqdn(zone)] = cmds
+	}
+	c.ZoneHooks = hooks
+	settings := map[string]zoneSettings{}
+	for zone, z := range c.ZoneSettings {
+		settings[strings.ToLower(dns.Fqdn(zone))] = z
+	}
+	c.ZoneSettings = settings
+}
+
+func (c *config) load(path string) error {
+	md, err := toml.DecodeFile(path, c)
+	if err != nil {
+		return err
+	}
+	if keys := md.Undecoded(); len(keys) > 0 {
+		var names []string
+		for _, k := range keys {
+			names = append(names, k.String())
+		}
+		return fmt.Errorf("%s: unknown settings: %s", path, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// duration is a time.Duration read from strings such as "5m".
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
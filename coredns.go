@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// corednsChanged runs the coredns_reload command, such as
+// "pkill -USR1 -x coredns", once if any zone in db was modified, for a
+// CoreDNS that should not wait for its file plugin to notice the new
+// serials at its next reload check.
+func corednsChanged(cfg *config, db *zonedb.DB) error {
+	if cfg.CoreDNSReload == "" {
+		return nil
+	}
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			if !auth.Dirty() {
+				continue
+			}
+			if out, err := runHook(cfg.CoreDNSReload, os.Environ()); err != nil {
+				return fmt.Errorf("reloading CoreDNS with %q: %v: %s", cfg.CoreDNSReload, err, bytes.TrimSpace(out))
+			}
+			return nil
+		}
+	}
+	return nil
+}
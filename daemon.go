@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/alert"
+	"github.com/johnweldon/dnsup/pkg/grpcapi"
+	"github.com/johnweldon/dnsup/pkg/ipsource"
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// daemon periodically discovers the public addresses and rewrites the
+// master files whenever one changes, tracking each family on its own.
+type daemon struct {
+	cfg      *config
+	backend  backend
+	notifier *notifier
+	srv      *server
+	lastIP   map[uint16]string
+	names    map[uint16][]string // the domains last updated, patterns expanded
+	pending  map[uint16]pendingIP
+	applied  map[uint16]time.Time // when each family's records were last updated
+	expiry   time.Time            // when the next record expires, if any does
+	state    *ipState
+	metrics  *daemonMetrics
+	failing  bool
+}
+
+func daemonFlags(fs *flag.FlagSet, c *config) {
+	c.registerDomains(fs)
+	fs.DurationVar(&c.Interval.Duration, "interval", c.Interval.Duration, "how often to check the public IP address")
+	fs.StringVar(&c.StateFile, "state-file", c.StateFile, "remember the addresses applied in this file, not to apply them again after a restart (default in $STATE_DIRECTORY if set)")
+	fs.DurationVar(&c.Settle.Duration, "settle", c.Settle.Duration, "apply a changed address only once it has been current this long, so that flaps coalesce")
+	fs.DurationVar(&c.MinUpdateInterval.Duration, "min-update-interval", c.MinUpdateInterval.Duration, "least time between updates of each family's records (default 5m with -dyndns-service)")
+	fs.StringVar(&c.GRPCListen, "grpc", c.GRPCListen, "serve the gRPC API on this address, streaming the daemon's changes")
+	fs.Var((*stringList)(&c.APIKeys), "api-key", "key accepted by the gRPC API (repeatable)")
+	fs.StringVar(&c.DNSListen, "dns", c.DNSListen, "answer DNS queries for the zones on this address, such as :53")
+	fs.Var((*stringList)(&c.TransferAllow), "transfer-allow", "address or CIDR prefix allowed AXFR and IXFR with -dns without TSIG (repeatable)")
+	fs.StringVar(&c.MetricsListen, "metrics", c.MetricsListen, "serve Prometheus metrics on /metrics, and /healthz and /readyz, at this address, such as :9153")
+}
+
+// runDaemon implements "dnsup daemon [flags] [zonefile...]". SIGHUP
+// reloads the configuration and the zones, to apply from an immediate
+// check; SIGTERM and SIGINT stop it between checks.
+func runDaemon(args []string) {
+	cfg, b, err := daemonConfig(args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+
+	n, err := newNotifier(cfg)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	keys, err := cfg.keyring()
+	if err != nil {
+		logging.Fatal(err)
+	}
+	state, err := loadState(cfg.stateFile())
+	if err != nil {
+		logging.Fatal(err)
+	}
+	srv := &server{cfg: cfg, keys: keys, health: newHealth(cfg, cfg.Interval.Duration)}
+	d := &daemon{
+		cfg: cfg, backend: b, notifier: n, srv: srv,
+		lastIP: map[uint16]string{}, names: map[uint16][]string{},
+		pending: map[uint16]pendingIP{}, applied: map[uint16]time.Time{},
+		state: state,
+	}
+	if cfg.GRPCListen != "" {
+		d.srv.hub = grpcapi.NewHub()
+		startGRPC(d.srv)
+	}
+	if cfg.DNSListen != "" {
+		d.srv.startDNS()
+	}
+	if cfg.MetricsListen != "" {
+		mux := http.NewServeMux()
+		d.metrics = newDaemonMetrics()
+		d.metrics.register(mux)
+		srv.health.register(mux)
+		l, err := listenTCP("metrics", cfg.MetricsListen)
+		if err != nil {
+			logging.Fatal(err)
+		}
+		go func() {
+			logging.Fatal(http.Serve(l, mux))
+		}()
+		logging.Infof("serving metrics and health checks on %s", cfg.MetricsListen)
+	}
+	warnUnusedSockets()
+	srv.health.watchdog()
+	d.restore()
+
+	// With -iface, address changes are also picked up from netlink as
+	// they happen, rather than only at the next poll.
+	var events <-chan struct{}
+	if cfg.Iface != "" {
+		if events, err = ipsource.Watch(); err != nil {
+			logging.Warnf("watching %s: %v; polling every %s", cfg.Iface, err, cfg.Interval.Duration)
+		}
+	}
+
+	hup, term := notifySignals()
+	tick := time.NewTicker(cfg.Interval.Duration)
+	defer tick.Stop()
+	for {
+		err := d.check()
+		d.metrics.checked(err)
+		srv.health.checked(err)
+		if err != nil {
+			logging.Error(err)
+			if !d.failing {
+				sendAlert(d.cfg, alert.UpdateFailed, "update failed", err.Error())
+			}
+		}
+		if err := d.expire(); err != nil {
+			logging.Errorf("removing expired records: %v", err)
+		}
+		d.failing = err != nil
+		d.reportStatus(err)
+		var due <-chan time.Time
+		if at, ok := d.due(); ok {
+			due = time.After(time.Until(at))
+		}
+		select {
+		case <-tick.C:
+		case <-due:
+		case _, ok := <-events:
+			if !ok {
+				logging.Warnf("netlink watch of %s ended; polling every %s", d.cfg.Iface, d.cfg.Interval.Duration)
+				events = nil
+				continue
+			}
+			time.Sleep(settleDelay)
+		case <-hup:
+			srv.reloading(func() error { return d.reload(args) })
+			tick.Reset(d.cfg.Interval.Duration)
+		case sig := <-term:
+			srv.shutdown(sig, nil)
+		}
+	}
+}
+
+// reload replaces the daemon's configuration, backend and notifier with
+// those of a fresh parse of args, and forgets the addresses last applied
+// so that the next check applies them to the domains now configured.
+func (d *daemon) reload(args []string) error {
+	cfg, b, err := daemonConfig(args)
+	if err != nil {
+		return err
+	}
+	n, err := newNotifier(cfg)
+	if err != nil {
+		return err
+	}
+	state, err := loadState(cfg.stateFile())
+	if err != nil {
+		return err
+	}
+	if err := d.srv.reload(cfg); err != nil {
+		return err
+	}
+	d.srv.health.setConfig(cfg, cfg.Interval.Duration)
+	d.cfg, d.backend, d.notifier, d.state = cfg, b, n, state
+	d.lastIP = map[uint16]string{}
+	d.pending = map[uint16]pendingIP{}
+	return nil
+}
+
+// daemonConfig parses and checks the configuration of "dnsup daemon",
+// at startup and on reload, returning the backend it selects, if any.
+func daemonConfig(args []string) (*config, backend, error) {
+	cfg, err := parseConfig("daemon", args)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.zoneArgs(cfg.Args)
+
+	b, err := newBackend(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b == nil && len(cfg.Zones) < 1 {
+		return nil, nil, fmt.Errorf("missing master file name")
+	}
+	if len(cfg.Domains) < 1 {
+		return nil, nil, fmt.Errorf("no domains to update")
+	}
+	for i, domain := range cfg.Domains {
+		cfg.Domains[i] = dns.Fqdn(domain)
+		if b != nil && zonedb.IsPattern(domain) {
+			return nil, nil, fmt.Errorf("%s: patterns need master files, not -server, -provider or -dyndns-service", domain)
+		}
+	}
+	if b != nil && (cfg.GRPCListen != "" || cfg.DNSListen != "") {
+		return nil, nil, fmt.Errorf("-grpc and -dns need master files, not -server, -provider or -dyndns-service")
+	}
+	if cfg.IP == "" && cfg.IPv4 == "" && cfg.IPv6 == "" {
+		cfg.AutoIP = true // the daemon discovers the address unless given one
+	}
+	if _, ok := b.(*dyndnsBackend); ok && cfg.MinUpdateInterval.Duration == 0 {
+		cfg.MinUpdateInterval.Duration = dyndnsUpdateInterval
+	}
+	return cfg, b, nil
+}
+
+// restore takes the addresses last applied to all the domains from the
+// state file, so that the first check after a restart leaves them be if
+// they have not changed.
+func (d *daemon) restore() {
+	for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		if ip := d.state.current(d.cfg.Domains, rrtype); ip != "" {
+			d.lastIP[rrtype] = ip
+			d.metrics.current(ip, rrtype)
+			logging.Infof("last applied %s %s, from %s", dns.TypeToString[rrtype], ip, d.state.path)
+		}
+	}
+}
+
+// reportStatus shows the outcome of the last check in systemctl status.
+func (d *daemon) reportStatus(err error) {
+	if err != nil {
+		sdStatus("check failed: %v", err)
+		return
+	}
+	var ips []string
+	for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		if ip := d.lastIP[rrtype]; ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	sdStatus("%d domains at %s, checked %s", len(d.cfg.Domains), strings.Join(ips, " and "), time.Now().Format("15:04:05"))
+}
+
+// settleDelay is how long the daemon waits after an address change event
+// before checking, for duplicate address detection to finish and for any
+// burst of further events.
+const settleDelay = 2 * time.Second
+
+// check discovers the addresses and applies those that changed, within
+// the configured timeout.
+func (d *daemon) check() error {
+	ctx, cancel := d.cfg.timeoutContext()
+	defer cancel()
+	ips, err := addresses(ctx, d.cfg)
+	if len(ips) == 0 {
+		if err == nil {
+			err = fmt.Errorf("missing -ip, -ipv4, -ipv6 or -auto-ip")
+		}
+		d.metrics.counted("failed", len(d.cfg.Domains))
+		return err
+	}
+	if err != nil {
+		logging.Error(err)
+	}
+	for _, ip := range ips {
+		rrtype, err := addressType(ip)
+		if err != nil {
+			return err
+		}
+		if ip == d.lastIP[rrtype] {
+			delete(d.pending, rrtype) // a flap that settled back
+			d.metrics.counted("skipped", len(d.cfg.Domains))
+			continue
+		}
+		if !d.ready(ip, rrtype) {
+			d.metrics.counted("deferred", len(d.cfg.Domains))
+			continue
+		}
+		if err := d.apply(ctx, ip, rrtype); err != nil {
+			delete(d.pending, rrtype) // to settle again rather than retry at once
+			d.metrics.counted("failed", len(d.cfg.Domains))
+			return err
+		}
+		delete(d.pending, rrtype)
+		d.applied[rrtype] = time.Now()
+		d.lastIP[rrtype] = ip
+		if err := d.state.applied(d.cfg.Domains, rrtype, ip); err != nil {
+			logging.Errorf("saving state: %v", err)
+		}
+		d.metrics.current(ip, rrtype)
+		if d.cfg.Verify {
+			go verifyDaemon(d.cfg, ip, d.names[rrtype])
+		}
+	}
+	return nil
+}
+
+// dyndnsUpdateInterval is the least time between the daemon's pushes to a
+// dyndns service unless configured otherwise, since services flag clients
+// that update too often as abusive.
+const dyndnsUpdateInterval = 5 * time.Minute
+
+// pendingIP is an address change the daemon has seen but not yet applied,
+// waiting for it to settle or for the rate limit.
+type pendingIP struct {
+	ip    string
+	since time.Time // when ip was first seen
+	due   time.Time // when it may be applied
+}
+
+// ready reports whether the change of rrtype's address to ip may be
+// applied now: on the first check at once, and otherwise once ip has been
+// current for the settle period and min_update_interval has passed since
+// the family was last updated. Until then the change is kept pending,
+// coalescing with any that follow it.
+func (d *daemon) ready(ip string, rrtype uint16) bool {
+	now := time.Now()
+	p, seen := d.pending[rrtype]
+	if !seen || p.ip != ip {
+		p, seen = pendingIP{ip: ip, since: now}, false
+	}
+	p.due = p.since
+	if d.lastIP[rrtype] != "" {
+		p.due = p.due.Add(d.cfg.Settle.Duration)
+	}
+	if last, ok := d.applied[rrtype]; ok {
+		if limit := last.Add(d.cfg.MinUpdateInterval.Duration); limit.After(p.due) {
+			p.due = limit
+		}
+	}
+	if !now.Before(p.due) {
+		return true
+	}
+	if !seen {
+		logging.Infof("address changed to %s; applying it at %s if still current", ip, p.due.Format("15:04:05"))
+	}
+	d.pending[rrtype] = p
+	return false
+}
+
+// due returns when the earliest pending change may be applied or the
+// next record expires, if either is pending.
+func (d *daemon) due() (time.Time, bool) {
+	at := d.expiry
+	for _, p := range d.pending {
+		if at.IsZero() || p.due.Before(at) {
+			at = p.due
+		}
+	}
+	return at, !at.IsZero()
+}
+
+// verifyDaemon logs whether the domains' records for ip become visible.
+// It takes the configuration of the check, which a reload may go on to
+// replace.
+func verifyDaemon(cfg *config, ip string, domains []string) {
+	var updates []update
+	for _, domain := range domains {
+		updates = append(updates, update{domain: domain, ip: ip})
+	}
+	exps, err := expectations(updates, nil)
+	if err == nil {
+		err = verify(cfg, exps)
+	}
+	if err != nil {
+		logging.Error(err)
+		sendAlert(cfg, alert.VerifyFailed, "verification of "+ip+" failed", err.Error())
+	}
+}
+
+// apply points the records of the domains of ip's family at ip.
+func (d *daemon) apply(ctx context.Context, ip string, rrtype uint16) error {
+	if d.backend != nil {
+		d.names[rrtype] = d.cfg.Domains
+		for _, domain := range d.cfg.Domains {
+			if err := d.backend.UpdateRecord(ctx, domain, rrtype, ip); err != nil {
+				return err
+			}
+			recordChanged(d.cfg, nil, "daemon", domain, rrtype, nil, ip)
+			d.metrics.changed(domain)
+		}
+		d.srv.health.wrote()
+		sendAlert(d.cfg, alert.IPChanged, "address changed to "+ip, strings.Join(d.cfg.Domains, "\n"))
+		d.metrics.counted("applied", len(d.cfg.Domains))
+		return nil
+	}
+
+	d.srv.mu.Lock()
+	defer d.srv.mu.Unlock()
+	return reapply(func() error { return d.applyZones(ip, rrtype) })
+}
+
+// applyZones points the records of the domains in the master files at
+// ip. The caller holds the server's lock.
+func (d *daemon) applyZones(ip string, rrtype uint16) error {
+	db, err := d.cfg.newDB()
+	if err != nil {
+		return err
+	}
+	if len(d.cfg.Templates) > 0 {
+		ips := []string{ip}
+		for t, last := range d.lastIP {
+			if t != rrtype && last != "" {
+				ips = append(ips, last)
+			}
+		}
+		db.SetTemplateData(newTemplateData(ips...))
+	}
+	if err := db.Load(d.cfg.zoneFiles()...); err != nil {
+		return err
+	}
+	domains := expandDomains(db, d.cfg.Domains, rrtype)
+	d.names[rrtype] = domains
+	olds := map[string][]string{}
+	apply := db.UpdateIP
+	if last := d.lastIP[rrtype]; last != "" && d.cfg.AddressPolicy == "replace-one" {
+		// the record to replace is the one the daemon last pointed here
+		apply = func(domain, ip string) error { return db.ReplaceIP(domain, last, ip) }
+	}
+	for _, domain := range domains {
+		olds[domain] = values(db.Lookup(domain, rrtype))
+		if err := apply(domain, ip); err != nil {
+			return err
+		}
+		if err := clampTTL(d.cfg, db, domain, rrtype); err != nil {
+			return err
+		}
+	}
+	if !db.Dirty() {
+		d.metrics.counted("skipped", len(domains))
+	} else {
+		start := time.Now()
+		if err := db.Write(); err != nil {
+			return err
+		}
+		d.metrics.wrote(time.Since(start))
+		d.srv.health.wrote()
+		var lines []string
+		for _, domain := range domains {
+			if old := olds[domain]; !sameValues(old, values(db.Lookup(domain, rrtype))) {
+				recordChanged(d.cfg, db, "daemon", domain, rrtype, old, ip)
+				d.metrics.changed(domain)
+				d.metrics.counted("applied", 1)
+				lines = append(lines, changeLine(domain, rrtype, old, ip))
+			} else {
+				d.metrics.counted("skipped", 1)
+			}
+		}
+		sendAlert(d.cfg, alert.IPChanged, "address changed to "+ip, lines...)
+		d.srv.setLive(db)
+		for _, domain := range domains {
+			d.srv.publish(db, domain, rrtype)
+		}
+		if err := signChanged(d.cfg, db); err != nil {
+			logging.Error(err)
+		}
+		if err := pushChanged(d.cfg, db); err != nil {
+			logging.Error(err)
+		}
+		if err := rndcChanged(d.cfg, db); err != nil {
+			logging.Error(err)
+		}
+		if err := corednsChanged(d.cfg, db); err != nil {
+			logging.Error(err)
+		}
+		if d.notifier.enabled() {
+			d.notifier.notifyChanged(db)
+		}
+		var updates []update
+		for _, domain := range domains {
+			updates = append(updates, update{domain: domain, ip: ip})
+		}
+		if err := runHooks(d.cfg, db, updates); err != nil {
+			logging.Error(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/dnssec"
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+// rrsetKey identifies the records of one name and type.
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+}
+
+// diffOptions are the flags of "dnsup diff".
+type diffOptions struct {
+	ignoreSerial bool
+	query        bool
+}
+
+func diffFlags(fs *flag.FlagSet, c *config) {
+	fs.BoolVar(&c.diff.ignoreSerial, "ignore-serial", false, "do not report SOA serial changes")
+	fs.BoolVar(&c.diff.query, "query", false, "query each name instead of transferring the zone from @server")
+}
+
+// runDiff implements "dnsup diff [-ignore-serial] [-query] old.zone
+// new.zone" and "dnsup diff [flags] zonefile @server", reporting the
+// records added, removed and changed between two master files, or
+// between the zones of a master file and what a name server serves for
+// them. The live zones are fetched by AXFR, or by querying each name and
+// type of the file if the transfer is refused or with -query. DNSSEC
+// signatures and NSEC records are not compared. It exits 1 if there are
+// differences.
+func runDiff(args []string) {
+	cfg, err := parseConfig("diff", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if len(cfg.Args) != 2 || strings.HasPrefix(cfg.Args[0], "@") && strings.HasPrefix(cfg.Args[1], "@") {
+		logging.Fatal("usage: dnsup diff [flags] old.zone new.zone|@server")
+	}
+
+	var old, cur []dns.RR
+	switch {
+	case strings.HasPrefix(cfg.Args[1], "@"):
+		old = loadRecords(cfg, cfg.Args[0])
+		cur = liveRecords(cfg, cfg.Args[0], cfg.Args[1][1:], cfg.diff.query)
+	case strings.HasPrefix(cfg.Args[0], "@"):
+		cur = loadRecords(cfg, cfg.Args[1])
+		old = liveRecords(cfg, cfg.Args[1], cfg.Args[0][1:], cfg.diff.query)
+	default:
+		old = loadRecords(cfg, cfg.Args[0])
+		cur = loadRecords(cfg, cfg.Args[1])
+	}
+
+	fmt.Printf("--- %s\n+++ %s\n", cfg.Args[0], cfg.Args[1])
+	added, removed, changed := diffRecords(os.Stdout, old, cur, cfg.diff.ignoreSerial)
+	fmt.Printf("%d added, %d removed, %d changed\n", added, removed, changed)
+	if added+removed+changed > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadRecords returns the records of the master file named file.
+func loadRecords(cfg *config, file string) []dns.RR {
+	db, err := cfg.newDB()
+	if err == nil {
+		err = db.Load(file)
+	}
+	if err != nil {
+		logging.Fatal(err)
+	}
+	return db.Records()
+}
+
+// liveRecords returns the records server serves for the zones in the
+// master file named file.
+func liveRecords(cfg *config, file, server string, query bool) []dns.RR {
+	db, err := cfg.newDB()
+	if err == nil {
+		err = db.Load(file)
+	}
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	key, err := cfg.tsigKey()
+	if err != nil {
+		logging.Fatal(err)
+	}
+	var rrs []dns.RR
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			if !query {
+				live, err := transferZone(server, auth.Domain(), key)
+				if err == nil {
+					rrs = append(rrs, live...)
+					continue
+				}
+				logging.Warnf("%v; querying each name instead", err)
+			}
+			live, err := queryRecords(server, auth.Records())
+			if err != nil {
+				logging.Fatal(err)
+			}
+			rrs = append(rrs, live...)
+		}
+	}
+	return rrs
+}
+
+// queryRecords asks server for each name and type among rrs, returning
+// the records of that name and type in its answers.
+func queryRecords(server string, rrs []dns.RR) ([]dns.RR, error) {
+	c := &dns.Client{Timeout: 5 * time.Second}
+	seen := map[rrsetKey]bool{}
+	var live []dns.RR
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		k := rrsetKey{strings.ToLower(hdr.Name), hdr.Rrtype}
+		if seen[k] || skipDiff(hdr.Rrtype) {
+			continue
+		}
+		seen[k] = true
+		m := new(dns.Msg)
+		m.SetQuestion(hdr.Name, hdr.Rrtype)
+		m.RecursionDesired = false
+		r, _, err := c.Exchange(m, server)
+		if err == nil && r.Truncated {
+			tc := &dns.Client{Net: "tcp", Timeout: c.Timeout}
+			r, _, err = tc.Exchange(m, server)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("query %s %s at %s: %v", hdr.Name, dns.TypeToString[hdr.Rrtype], server, err)
+		}
+		for _, a := range r.Answer {
+			if a.Header().Rrtype == hdr.Rrtype && strings.EqualFold(a.Header().Name, hdr.Name) {
+				live = append(live, a)
+			}
+		}
+	}
+	return live, nil
+}
+
+// skipDiff reports whether records of rrtype are left out of diffs: the
+// ones a signer generates.
+func skipDiff(rrtype uint16) bool {
+	switch rrtype {
+	case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+		return true
+	}
+	return false
+}
+
+// diffRecords writes the differences between old and cur to w, one line
+// per record added ("+") or removed ("-") and per name and type whose
+// records changed ("~"), in name order, and returns their counts.
+func diffRecords(w io.Writer, old, cur []dns.RR, ignoreSerial bool) (added, removed, changed int) {
+	group := func(rrs []dns.RR) map[rrsetKey][]dns.RR {
+		sets := map[rrsetKey][]dns.RR{}
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			if skipDiff(hdr.Rrtype) {
+				continue
+			}
+			if soa, ok := rr.(*dns.SOA); ok && ignoreSerial {
+				c := *soa
+				c.Serial = 0
+				rr = &c
+			}
+			k := rrsetKey{strings.ToLower(hdr.Name), hdr.Rrtype}
+			sets[k] = append(sets[k], rr)
+		}
+		return sets
+	}
+	before, after := group(old), group(cur)
+	var keys []rrsetKey
+	for k := range before {
+		keys = append(keys, k)
+	}
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return dnssec.CanonicalLess(keys[i].name, keys[j].name)
+		}
+		return keys[i].rrtype < keys[j].rrtype
+	})
+
+	for _, k := range keys {
+		a, b := before[k], after[k]
+		switch {
+		case len(a) == 0:
+			for _, rr := range b {
+				fmt.Fprintf(w, "+ %s\n", rr)
+				added++
+			}
+		case len(b) == 0:
+			for _, rr := range a {
+				fmt.Fprintf(w, "- %s\n", rr)
+				removed++
+			}
+		case !sameRecords(a, b):
+			fmt.Fprintf(w, "~ %s %s: %s -> %s\n", a[0].Header().Name, dns.TypeToString[k.rrtype], describeSet(a), describeSet(b))
+			changed++
+		}
+	}
+	return added, removed, changed
+}
+
+// describeSet returns the TTL and data of rrs, as "300 192.0.2.1,
+// 192.0.2.2" or, if their TTLs differ, each with its own.
+func describeSet(rrs []dns.RR) string {
+	var parts []string
+	ttl := rrs[0].Header().Ttl
+	same := true
+	for _, rr := range rrs {
+		same = same && rr.Header().Ttl == ttl
+	}
+	for _, rr := range rrs {
+		if same {
+			parts = append(parts, rdataOf(rr))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d %s", rr.Header().Ttl, rdataOf(rr)))
+		}
+	}
+	sort.Strings(parts)
+	if same {
+		return fmt.Sprintf("%d %s", ttl, strings.Join(parts, ", "))
+	}
+	return strings.Join(parts, ", ")
+}
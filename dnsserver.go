@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// startDNS answers queries for the live zones on the configured address,
+// or the sockets systemd passed for it, over UDP and TCP, exiting if
+// either listener fails.
+func (s *server) startDNS() {
+	db, err := s.load()
+	if err != nil {
+		logging.Fatal(err)
+	}
+	s.setLive(db)
+	if s.transferNets, err = parseNets(s.cfg.TransferAllow); err != nil {
+		logging.Fatal(err)
+	}
+	pc, err := listenUDP("dns", s.cfg.DNSListen)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	l, err := listenTCP("dns", s.cfg.DNSListen)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	for _, srv := range []*dns.Server{{PacketConn: pc}, {Listener: l}} {
+		srv.Handler, srv.TsigSecret = s, s.keys.Secrets()
+		go func() {
+			logging.Fatal(srv.ActivateAndServe())
+		}()
+	}
+	logging.Infof("serving DNS on %s", s.cfg.DNSListen)
+}
+
+// setLive makes db, just written, the zones answered from.
+func (s *server) setLive(db *zonedb.DB) {
+	s.liveMu.Lock()
+	s.live = db
+	s.liveMu.Unlock()
+}
+
+func (s *server) snapshot() *zonedb.DB {
+	s.liveMu.RLock()
+	defer s.liveMu.RUnlock()
+	return s.live
+}
+
+func (s *server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if req.Opcode == dns.OpcodeQuery && len(req.Question) == 1 {
+		if t := req.Question[0].Qtype; t == dns.TypeAXFR || t == dns.TypeIXFR {
+			s.transfer(w, req)
+			return
+		}
+	}
+	m := s.answer(req)
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := req.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+			size = int(opt.UDPSize())
+		}
+		m.Truncate(size)
+	}
+	if err := w.WriteMsg(m); err != nil {
+		logging.Error(err)
+	}
+}
+
+// answer builds the authoritative response to req from the live zones:
+// the records asked for, a CNAME in their place, a referral below a zone
+// cut, or a negative answer with the zone's SOA.
+func (s *server) answer(req *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	switch {
+	case req.Opcode != dns.OpcodeQuery:
+		return m.SetRcode(req, dns.RcodeNotImplemented)
+	case len(req.Question) != 1:
+		return m.SetRcode(req, dns.RcodeFormatError)
+	}
+	m.SetReply(req)
+	q := req.Question[0]
+	db := s.snapshot()
+	name := strings.ToLower(q.Name)
+	var auth *zonedb.Authority
+	if db != nil && (q.Qclass == dns.ClassINET || q.Qclass == dns.ClassANY) {
+		auth = db.Zone(name)
+	}
+	if auth == nil {
+		m.Rcode = dns.RcodeRefused
+		return m
+	}
+
+	for n := name; n != "." && !strings.EqualFold(n, auth.Domain()); n = parentName(n) {
+		if n == name && q.Qtype == dns.TypeDS {
+			continue // answered by the parent side of the cut
+		}
+		if ns := db.Lookup(n, dns.TypeNS); len(ns) > 0 {
+			m.Ns = ns
+			m.Extra = glue(db, ns)
+			return m
+		}
+	}
+
+	m.Authoritative = true
+	rrs := db.Lookup(name, q.Qtype)
+	if len(rrs) == 0 && q.Qtype != dns.TypeCNAME {
+		rrs = db.Lookup(name, dns.TypeCNAME)
+	}
+	if len(rrs) > 0 {
+		m.Answer = rrs
+		if q.Qtype == dns.TypeNS {
+			m.Extra = glue(db, rrs)
+		}
+		return m
+	}
+	if len(db.Lookup(name, dns.TypeANY)) == 0 && !hasDescendant(auth, name) {
+		m.Rcode = dns.RcodeNameError
+	}
+	if soa := auth.SOA(); soa != nil {
+		m.Ns = []dns.RR{soa}
+	}
+	return m
+}
+
+// transferChunk is the number of records sent in each transfer message.
+const transferChunk = 100
+
+// transfer answers an AXFR or IXFR request for a live zone from a client
+// signed with a keyring key or in transfer_allow. IXFR is answered from
+// the zone's journal, falling back to the whole zone when the journal
+// does not reach back to the client's serial.
+func (s *server) transfer(w dns.ResponseWriter, req *dns.Msg) {
+	q := req.Question[0]
+	m := new(dns.Msg)
+	var auth *zonedb.Authority
+	db := s.snapshot()
+	if db != nil {
+		auth = db.Zone(strings.ToLower(q.Name))
+	}
+	switch {
+	case auth == nil || !strings.EqualFold(auth.Domain(), q.Name) || auth.SOA() == nil:
+		m.SetRcode(req, dns.RcodeNotAuth)
+	case !s.transferAllowed(w, req):
+		m.SetRcode(req, dns.RcodeRefused)
+	}
+	if m.Rcode != dns.RcodeSuccess {
+		if err := w.WriteMsg(m); err != nil {
+			logging.Error(err)
+		}
+		return
+	}
+
+	soa := auth.SOA()
+	rrs := transferRecords(db, auth, req)
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		// Only an up to date IXFR fits; anything else is retried over TCP.
+		m.SetReply(req)
+		if len(rrs) == 1 {
+			m.Answer = rrs
+		} else {
+			m.Truncated = true
+		}
+		if err := w.WriteMsg(m); err != nil {
+			logging.Error(err)
+		}
+		return
+	}
+
+	ch := make(chan *dns.Envelope)
+	tr := &dns.Transfer{TsigSecret: s.keyring().Secrets()}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := tr.Out(w, req, ch); err != nil {
+			logging.Errorf("transfer %s to %s: %v", q.Name, w.RemoteAddr(), err)
+		}
+	}()
+	for len(rrs) > 0 {
+		n := len(rrs)
+		if n > transferChunk {
+			n = transferChunk
+		}
+		ch <- &dns.Envelope{RR: rrs[:n]}
+		rrs = rrs[n:]
+	}
+	close(ch)
+	wg.Wait()
+	w.Hijack()
+	logging.Infof("%s of %s serial %d to %s", dns.TypeToString[q.Qtype], auth.Domain(), soa.Serial, w.RemoteAddr())
+}
+
+// transferRecords returns the records answering a transfer request: the
+// journaled changes since the serial of an IXFR request, just the SOA if
+// the client is current, or else the whole zone between two SOAs.
+func transferRecords(db *zonedb.DB, auth *zonedb.Authority, req *dns.Msg) []dns.RR {
+	soa := auth.SOA()
+	if req.Question[0].Qtype == dns.TypeIXFR && len(req.Ns) > 0 {
+		if have, ok := req.Ns[0].(*dns.SOA); ok {
+			if have.Serial == soa.Serial {
+				return []dns.RR{soa}
+			}
+			journal, err := db.Journal(auth.Domain())
+			if err != nil {
+				logging.Error(err)
+			}
+			if chain, ok := zonedb.Since(journal, have.Serial); ok && chain[len(chain)-1].To == soa.Serial {
+				rrs := []dns.RR{soa}
+				for _, d := range chain {
+					rrs = append(rrs, d.Deleted...)
+					rrs = append(rrs, d.Added...)
+				}
+				return append(rrs, soa)
+			}
+		}
+	}
+	rrs := auth.Records()
+	return append(rrs, soa)
+}
+
+// transferAllowed reports whether req carries a valid keyring signature
+// or comes from an address in transfer_allow.
+func (s *server) transferAllowed(w dns.ResponseWriter, req *dns.Msg) bool {
+	if req.IsTsig() != nil && w.TsigStatus() == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(w.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	for _, n := range s.transferNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNets parses a list of CIDR prefixes or single addresses.
+func parseNets(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range list {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// parentName returns name without its first label.
+func parentName(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 && i < len(name)-1 {
+		return name[i+1:]
+	}
+	return "."
+}
+
+// glue returns the loaded addresses of the targets of the NS records.
+func glue(db *zonedb.DB, ns []dns.RR) []dns.RR {
+	var extra []dns.RR
+	for _, rr := range ns {
+		if n, ok := rr.(*dns.NS); ok {
+			extra = append(extra, db.Lookup(n.Ns, dns.TypeA)...)
+			extra = append(extra, db.Lookup(n.Ns, dns.TypeAAAA)...)
+		}
+	}
+	return extra
+}
+
+// hasDescendant reports whether auth holds records below name, which
+// makes name an empty non-terminal rather than nonexistent.
+func hasDescendant(auth *zonedb.Authority, name string) bool {
+	suffix := "." + name
+	for _, rr := range auth.Records() {
+		if strings.HasSuffix(strings.ToLower(rr.Header().Name), suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnweldon/dnsup/pkg/docker"
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+func dockerFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.DockerHost, "docker-host", c.DockerHost, "Docker engine to follow (default $DOCKER_HOST or "+docker.DefaultHost+")")
+	fs.StringVar(&c.DockerOwner, "docker-owner", c.DockerOwner, "owner ID recorded in the TXT markers, to share zones between hosts (default the host name)")
+	fs.DurationVar(&c.Interval.Duration, "interval", c.Interval.Duration, "how often to resynchronize in full")
+	fs.DurationVar(&c.Timeout.Duration, "timeout", c.Timeout.Duration, "give up on the address discovery of a resynchronization after this long, or 0 for no limit")
+}
+
+// runDocker implements "dnsup docker [flags] [zonefile...]", which
+// follows the local Docker engine and gives the hosts labeled on running
+// containers A and AAAA records, removing them when the containers stop.
+func runDocker(args []string) {
+	cfg, err := parseConfig("docker", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	cfg.zoneArgs(cfg.Args)
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	if cfg.IP == "" && cfg.IPv4 == "" && cfg.IPv6 == "" {
+		cfg.AutoIP = true // containers without a network label get the host's address
+	}
+
+	client, err := docker.NewClient(cfg.DockerHost)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	owner := cfg.DockerOwner
+	if owner == "" {
+		if owner, err = os.Hostname(); err != nil {
+			logging.Fatal(err)
+		}
+	}
+	p := newPublisher(cfg, "docker", owner)
+
+	events := client.Events()
+	tick := time.NewTicker(cfg.Interval.Duration)
+	defer tick.Stop()
+	for {
+		cs, err := client.Containers()
+		if err == nil {
+			var eps []endpoint
+			ctx, cancel := cfg.timeoutContext()
+			if eps, err = dockerEndpoints(ctx, cfg, cs); err == nil {
+				err = p.reconcile(eps)
+			}
+			cancel()
+		}
+		if err != nil {
+			logging.Error(err)
+		}
+		flushEvents()
+		select {
+		case <-tick.C:
+		case <-events:
+			time.Sleep(settleDelay)
+		}
+	}
+}
+
+// dockerEndpoints pairs the hosts labeled on each container with its
+// targets: those of its dnsup.target label, its addresses on the network
+// of its dnsup.network label, or else the host's addresses, looked up
+// only if some container needs them. Failing to find any of the host's
+// addresses is an error, so that a lookup failure does not unpublish
+// every container.
+func dockerEndpoints(ctx context.Context, cfg *config, cs []docker.Container) ([]endpoint, error) {
+	var host []string
+	var looked bool
+	var eps []endpoint
+	for _, c := range cs {
+		hosts := splitLabel(c.Labels[docker.LabelHostname])
+		if len(hosts) == 0 {
+			continue
+		}
+		targets := splitLabel(c.Labels[docker.LabelTarget])
+		if name := c.Labels[docker.LabelNetwork]; len(targets) == 0 && name != "" {
+			n, ok := c.Networks[name]
+			if !ok {
+				logging.Warnf("container %s: not attached to network %q", c.Name, name)
+				continue
+			}
+			for _, ip := range []string{n.IPAddress, n.GlobalIPv6Address} {
+				if net.ParseIP(ip) != nil {
+					targets = append(targets, ip)
+				}
+			}
+			if len(targets) == 0 {
+				continue // no address assigned yet
+			}
+		}
+		if len(targets) == 0 {
+			if !looked {
+				ips, err := addresses(ctx, cfg)
+				if len(ips) == 0 {
+					return nil, err
+				}
+				if err != nil {
+					logging.Error(err)
+				}
+				host, looked = ips, true
+			}
+			targets = host
+		}
+		var ttl uint32
+		if s := c.Labels[docker.LabelTTL]; s != "" {
+			if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+				ttl = uint32(n)
+			}
+		}
+		for _, h := range hosts {
+			eps = append(eps, endpoint{host: strings.ToLower(strings.TrimSuffix(h, ".")) + ".", targets: targets, ttl: ttl, resource: "container/" + c.Name})
+		}
+	}
+	return eps, nil
+}
+
+func splitLabel(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
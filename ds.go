@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/dnssec"
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+// dsOptions are the flags of "dnsup ds".
+type dsOptions struct {
+	digest string
+	all    bool
+}
+
+func dsFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.ds.digest, "digest", "sha256", "DS digest type: sha256 or sha384")
+	fs.BoolVar(&c.ds.all, "all", false, "include zone signing keys as well")
+	fs.Var((*stringList)(&c.DNSSECKeys), "dnssec-key", "DNSSEC key pair, K<zone>+<alg>+<tag>, to print the DS records of (repeatable)")
+}
+
+// runDS implements "dnsup ds [-digest sha256] [-all] [keyfile...]",
+// printing the DS records to give the parent zone for the key signing
+// keys among the named or configured DNSSEC keys.
+func runDS(args []string) {
+	cfg, err := parseConfig("ds", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	d, err := dnssec.ParseDigest(cfg.ds.digest)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	paths := cfg.DNSSECKeys
+	if len(cfg.Args) > 0 {
+		paths = cfg.Args
+	}
+	keys, err := dnssec.LoadKeys(paths)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if len(keys) == 0 {
+		logging.Fatal("no -dnssec-key or key files given")
+	}
+	for _, k := range keys {
+		if !cfg.ds.all && !k.KSK() {
+			continue
+		}
+		ds, err := dnssec.DS(k, d)
+		if err != nil {
+			logging.Fatal(err)
+		}
+		fmt.Println(ds)
+	}
+}
+
+// cdsOptions are the flags of "dnsup cds".
+type cdsOptions struct {
+	digest string
+	tags   []string
+	remove bool
+}
+
+func cdsFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.cds.digest, "digest", "sha256", "CDS digest type: sha256 or sha384")
+	fs.Var((*stringList)(&c.cds.tags), "tag", "publish only the key with this key tag (repeatable)")
+	fs.BoolVar(&c.cds.remove, "delete", false, "ask the parent to remove the zone's DS records (RFC 8078)")
+}
+
+// runCDS implements "dnsup cds [-digest sha256] [-tag keytag] [-delete]
+// [zonefile...]", replacing the CDS and CDNSKEY records at the apex of
+// each loaded zone with those of its key signing keys, so that a parent
+// polling for them (RFC 7344) can follow a key rollover.
+func runCDS(args []string) {
+	cfg, err := parseConfig("cds", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	d, err := dnssec.ParseDigest(cfg.cds.digest)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	cfg.zoneArgs(cfg.Args)
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	keys, err := dnssec.LoadKeys(cfg.DNSSECKeys)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	only := map[uint16]bool{}
+	for _, t := range cfg.cds.tags {
+		n, err := strconv.ParseUint(t, 10, 16)
+		if err != nil {
+			logging.Fatalf("invalid key tag %q", t)
+		}
+		only[uint16(n)] = true
+	}
+
+	db := loadZones(cfg)
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			var rrs []dns.RR
+			ttl := auth.SOA().Hdr.Ttl
+			if cfg.cds.remove {
+				rrs = dnssec.DeleteRecords(auth.Domain(), ttl)
+			}
+			for _, k := range dnssec.ForZone(keys, auth.Domain()) {
+				if cfg.cds.remove || !k.KSK() || len(only) > 0 && !only[k.Tag()] {
+					continue
+				}
+				cds, err := dnssec.CDS(k, d)
+				if err != nil {
+					logging.Fatal(err)
+				}
+				cdnskey := dnssec.CDNSKEY(k)
+				cds.Hdr.Ttl, cdnskey.Hdr.Ttl = ttl, ttl
+				rrs = append(rrs, cds, cdnskey)
+			}
+			if len(rrs) == 0 {
+				continue
+			}
+			for _, t := range []uint16{dns.TypeCDS, dns.TypeCDNSKEY} {
+				if _, err := db.DeleteRecords(auth.Domain(), t, ""); err != nil {
+					logging.Fatal(err)
+				}
+			}
+			for _, rr := range rrs {
+				if err := db.AddRecord(rr); err != nil {
+					logging.Fatal(err)
+				}
+			}
+			logging.Infof("%s: published %d CDS and CDNSKEY records", auth.Domain(), len(rrs))
+		}
+	}
+	if !db.Dirty() {
+		logging.Fatal("no zone has a key signing key among the -dnssec-key keys")
+	}
+	if err := commit(cfg, db, nil); err != nil {
+		logging.Fatal(err)
+	}
+}
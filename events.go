@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/alert"
+	"github.com/johnweldon/dnsup/pkg/audit"
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/webhook"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// values returns the data of rrs in presentation format, to be captured
+// before an update changes them.
+func values(rrs []dns.RR) []string {
+	var vs []string
+	for _, rr := range rrs {
+		vs = append(vs, rdataOf(rr))
+	}
+	return vs
+}
+
+// sameValues reports whether old and cur, the data of an RRset before and
+// after an update, are the same, so that the update changed nothing.
+func sameValues(old, cur []string) bool {
+	if len(old) != len(cur) {
+		return false
+	}
+	for i := range old {
+		if old[i] != cur[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// recordChanged logs a record change by actor, such as "cli:alice" or
+// "dyndns:router", as an event with the zone, name, type, old and new data
+// (old_ip and new_ip for addresses) and, when db holds the zone, its new
+// serial. An empty value means the records were deleted. The change is
+// appended to the audit log and posted to the configured webhooks, and
+// address changes are published into the catalogs, in the background; see
+// flushEvents.
+func recordChanged(cfg *config, db *zonedb.DB, actor, name string, rrtype uint16, old []string, value string) {
+	fields := logging.Fields{"actor": actor, "name": name, "type": dns.TypeToString[rrtype]}
+	oldKey, newKey := "old_value", "new_value"
+	if rrtype == dns.TypeA || rrtype == dns.TypeAAAA {
+		oldKey, newKey = "old_ip", "new_ip"
+	}
+	if old != nil {
+		fields[oldKey] = strings.Join(old, ",")
+	}
+	fields[newKey] = value
+	ev := &webhook.Event{Event: "record_changed", Name: name, Type: dns.TypeToString[rrtype], Old: old, New: value, Time: time.Now()}
+	if ev.Old == nil {
+		ev.Old = []string{}
+	}
+	if db != nil {
+		if auth := db.Zone(name); auth != nil {
+			fields["zone"] = auth.Domain()
+			fields["serial"] = auth.SOA().Serial
+			ev.Zone, ev.Serial = auth.Domain(), auth.SOA().Serial
+		}
+	}
+	logging.Event(logging.LevelInfo, "record updated", fields)
+
+	if cfg.AuditLog != "" {
+		e := &audit.Entry{Time: ev.Time, Actor: actor, Zone: ev.Zone, Name: name, Type: ev.Type, Old: ev.Old, New: value, Serial: ev.Serial}
+		if err := audit.Append(cfg.AuditLog, e); err != nil {
+			logging.Errorf("audit log: %v", err)
+		}
+	}
+
+	for _, h := range cfg.webhooks {
+		pending.Add(1)
+		go func(h *webhook.Hook) {
+			defer pending.Done()
+			if err := h.Send(ev); err != nil {
+				logging.Error(err)
+			}
+		}(h)
+	}
+
+	if len(cfg.catalogs) > 0 && (rrtype == dns.TypeA || rrtype == dns.TypeAAAA) {
+		var addrs []string
+		if db != nil {
+			addrs = values(db.Lookup(name, rrtype))
+		} else if value != "" {
+			addrs = strings.Split(value, ",")
+		}
+		catalogChanged(cfg, name, ev.Type, addrs)
+	}
+}
+
+// catalogDone is closed once the last change queued by catalogChanged
+// has been published.
+var (
+	catalogMu   sync.Mutex
+	catalogDone chan struct{}
+)
+
+// catalogChanged sets the typ addresses of name to addrs in each catalog,
+// in the background but after the changes published before it, so that an
+// older set of addresses never overwrites a newer one.
+func catalogChanged(cfg *config, name, typ string, addrs []string) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	prev, done := catalogDone, make(chan struct{})
+	catalogDone = done
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		policy := cfg.retryPolicy("catalog")
+		for _, s := range cfg.catalogs {
+			err := policy.Do(context.Background(), "catalog", func() error {
+				return s.Publish(context.Background(), name, typ, addrs)
+			})
+			if err != nil {
+				logging.Errorf("publishing %s %s: %v", name, typ, err)
+			}
+		}
+	}()
+}
+
+// sendAlert sends a message for event to the alerts configured for it, in
+// the background; see flushEvents.
+func sendAlert(cfg *config, event, title string, lines ...string) {
+	m := &alert.Message{Event: event, Title: "dnsup: " + title, Body: strings.Join(lines, "\n")}
+	for _, f := range cfg.alerts {
+		if !f.Wants(event) {
+			continue
+		}
+		pending.Add(1)
+		go func(f *alert.Filter) {
+			defer pending.Done()
+			if err := f.Send(m); err != nil {
+				logging.Error(err)
+			}
+		}(f)
+	}
+}
+
+// maxReapply bounds how often reapply repeats an edit.
+const maxReapply = 3
+
+// reapply runs edit, which loads the zones, changes and writes them, and
+// runs it again on freshly loaded zones if a master file was modified by
+// someone else in the meantime, so the change is made on top of theirs
+// rather than clobbering it or being lost.
+func reapply(edit func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := edit()
+		if _, ok := err.(*zonedb.ChangedError); !ok || attempt == maxReapply {
+			return err
+		}
+		logging.Warnf("%v; reloading and applying the change again", err)
+	}
+}
+
+// cliActor names the user running a command, for the audit log.
+func cliActor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli:" + os.Getenv("USER")
+}
+
+// changeLine describes a record change for an alert.
+func changeLine(name string, rrtype uint16, old []string, value string) string {
+	from := "(none)"
+	if len(old) > 0 {
+		from = strings.Join(old, ", ")
+	}
+	return fmt.Sprintf("%s %s: %s -> %s", name, dns.TypeToString[rrtype], from, value)
+}
+
+// pending counts the webhook, alert and catalog requests still in flight.
+var pending sync.WaitGroup
+
+// flushEvents waits for the webhook, alert and catalog requests in flight,
+// for commands that exit once their updates are applied.
+func flushEvents() {
+	pending.Wait()
+}
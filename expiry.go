@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+// parseExpiry returns the expiry given as a duration from now, such as
+// "2h", or as an RFC 3339 time.
+func parseExpiry(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// expire removes the records of the master files whose expiry has
+// passed, writing and announcing the zones they were removed from, and
+// notes when the next record expires. After a failure the records are
+// removed at the next check instead.
+func (d *daemon) expire() error {
+	if d.backend != nil {
+		return nil
+	}
+	d.srv.mu.Lock()
+	defer d.srv.mu.Unlock()
+	return reapply(d.expireZones)
+}
+
+// expireZones is expire for a caller holding the server's lock.
+func (d *daemon) expireZones() error {
+	db, err := d.srv.load()
+	if err != nil {
+		return err
+	}
+	d.expiry = time.Time{}
+	rrs := db.DeleteExpired(time.Now())
+	next, _ := db.NextExpiry()
+	if len(rrs) == 0 {
+		d.expiry = next
+		return nil
+	}
+	if d.cfg.DryRun {
+		return db.Diff(os.Stdout)
+	}
+	if err := db.Write(); err != nil {
+		return err
+	}
+	d.srv.health.wrote()
+	d.srv.setLive(db)
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		logging.Infof("%s %s %s expired", hdr.Name, dns.TypeToString[hdr.Rrtype], rdataOf(rr))
+		recordChanged(d.cfg, db, "expiry", hdr.Name, hdr.Rrtype, []string{rdataOf(rr)}, "")
+		d.srv.publish(db, hdr.Name, hdr.Rrtype)
+	}
+	d.expiry = next
+	return announce(d.cfg, db, nil)
+}
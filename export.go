@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+// exportFile is a master file as written by "dnsup export".
+type exportFile struct {
+	File  string       `json:"file"`
+	Zones []exportZone `json:"zones"`
+}
+
+// exportZone is one authority of a master file, with its records in file
+// order.
+type exportZone struct {
+	Zone    string      `json:"zone"`
+	Serial  uint32      `json:"serial"`
+	NS      []string    `json:"ns"`
+	Records []apiRecord `json:"records"`
+}
+
+// exportOptions are the flags of "dnsup export".
+type exportOptions struct {
+	format string
+}
+
+func exportFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.export.format, "format", "json", "output format, json or yaml")
+}
+
+// runExport implements "dnsup export [-format json|yaml] [zonefile...]",
+// printing the parsed zones, each record as its name, type, TTL and data,
+// for scripts that would rather not parse master files.
+func runExport(args []string) {
+	cfg, err := parseConfig("export", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	cfg.zoneArgs(cfg.Args)
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	db := loadZones(cfg)
+
+	files := []exportFile{}
+	for _, mf := range db.Files() {
+		f := exportFile{File: mf.Name(), Zones: []exportZone{}}
+		for _, auth := range mf.Authorities() {
+			ns := auth.NS()
+			if ns == nil {
+				ns = []string{}
+			}
+			f.Zones = append(f.Zones, exportZone{
+				Zone:    auth.Domain(),
+				Serial:  auth.SOA().Serial,
+				NS:      ns,
+				Records: apiRecords(auth.Records()),
+			})
+		}
+		files = append(files, f)
+	}
+
+	switch cfg.export.format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(files)
+	case "yaml":
+		err = writeYAML(os.Stdout, files)
+	default:
+		logging.Fatalf("unknown format %q: want json or yaml", cfg.export.format)
+	}
+	if err != nil {
+		logging.Fatal(err)
+	}
+}
+
+// writeYAML writes files as YAML of the same shape as the JSON export.
+// Strings are double-quoted, which YAML reads as JSON strings.
+func writeYAML(w io.Writer, files []exportFile) error {
+	bw := bufio.NewWriter(w)
+	if len(files) == 0 {
+		fmt.Fprintln(bw, "[]")
+	}
+	q := strconv.Quote
+	for _, f := range files {
+		fmt.Fprintf(bw, "- file: %s\n", q(f.File))
+		if len(f.Zones) == 0 {
+			fmt.Fprintln(bw, "  zones: []")
+			continue
+		}
+		fmt.Fprintln(bw, "  zones:")
+		for _, z := range f.Zones {
+			fmt.Fprintf(bw, "    - zone: %s\n", q(z.Zone))
+			fmt.Fprintf(bw, "      serial: %d\n", z.Serial)
+			if len(z.NS) == 0 {
+				fmt.Fprintln(bw, "      ns: []")
+			} else {
+				fmt.Fprintln(bw, "      ns:")
+				for _, ns := range z.NS {
+					fmt.Fprintf(bw, "        - %s\n", q(ns))
+				}
+			}
+			if len(z.Records) == 0 {
+				fmt.Fprintln(bw, "      records: []")
+				continue
+			}
+			fmt.Fprintln(bw, "      records:")
+			for _, r := range z.Records {
+				fmt.Fprintf(bw, "        - name: %s\n", q(r.Name))
+				fmt.Fprintf(bw, "          type: %s\n", q(r.Type))
+				fmt.Fprintf(bw, "          ttl: %d\n", r.TTL)
+				fmt.Fprintf(bw, "          value: %s\n", q(r.Value))
+			}
+		}
+	}
+	return bw.Flush()
+}
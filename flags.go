@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// stringList is a flag.Value collecting every occurrence of a flag.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// optionMap is a flag.Value collecting key=value pairs.
+type optionMap map[string]string
+
+func (m *optionMap) String() string {
+	var kv []string
+	for k, v := range *m {
+		kv = append(kv, k+"="+v)
+	}
+	sort.Strings(kv)
+	return strings.Join(kv, ",")
+}
+
+func (m *optionMap) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i < 1 {
+		return fmt.Errorf("want key=value, got %q", v)
+	}
+	if *m == nil {
+		*m = map[string]string{}
+	}
+	(*m)[v[:i]] = v[i+1:]
+	return nil
+}
+
+// webhookList is a flag.Value adding a webhook by URL.
+type webhookList []webhookConfig
+
+func (l *webhookList) String() string {
+	var urls []string
+	for _, wc := range *l {
+		urls = append(urls, wc.URL)
+	}
+	return strings.Join(urls, ",")
+}
+
+func (l *webhookList) Set(v string) error {
+	*l = append(*l, webhookConfig{URL: v})
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+// fmtOptions are the flags of "dnsup fmt".
+type fmtOptions struct {
+	list  bool
+	diff  bool
+	write bool
+}
+
+func fmtFlags(fs *flag.FlagSet, c *config) {
+	fs.BoolVar(&c.fmt.list, "l", false, "list the master files whose formatting differs from the canonical style")
+	fs.BoolVar(&c.fmt.diff, "d", false, "print the changes formatting makes as a diff")
+	fs.BoolVar(&c.fmt.write, "w", false, "rewrite the master files in place")
+	fs.BoolVar(&c.DryRun, "dry-run", c.DryRun, "with -w, print the diff instead of rewriting the master files")
+}
+
+// runFmt implements "dnsup fmt [-l] [-d] [-w] [zonefile...]", printing
+// each master file in the canonical style, like gofmt: with -l only the
+// names of those not already in it, with -d the changes as a diff, and
+// with -w rewriting them in place, or with -dry-run printing the diff
+// instead.
+func runFmt(args []string) {
+	cfg, err := parseConfig("fmt", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if cfg.fmt.write && cfg.DryRun {
+		cfg.fmt.write, cfg.fmt.diff = false, true
+	}
+	cfg.zoneArgs(cfg.Args)
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	db := loadZones(cfg)
+	for _, mf := range db.Files() {
+		var buf bytes.Buffer
+		if err := mf.Format(&buf); err != nil {
+			logging.Fatal(err)
+		}
+		orig, err := ioutil.ReadFile(mf.Name())
+		if err != nil {
+			logging.Fatal(err)
+		}
+		if !cfg.fmt.list && !cfg.fmt.diff && !cfg.fmt.write {
+			os.Stdout.Write(buf.Bytes())
+			continue
+		}
+		if bytes.Equal(orig, buf.Bytes()) {
+			continue
+		}
+		if cfg.fmt.list {
+			fmt.Println(mf.Name())
+		}
+		if cfg.fmt.diff {
+			if err := mf.FormatDiff(os.Stdout); err != nil {
+				logging.Fatal(err)
+			}
+		}
+		if cfg.fmt.write {
+			if err := mf.WriteFormatted(); err != nil {
+				logging.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/johnweldon/dnsup/pkg/grpcapi"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// rpcService implements the gRPC API on top of a server.
+type rpcService struct {
+	s *server
+}
+
+// serveGRPC accepts gRPC calls on lis, authorized by the API keys and
+// over TLS if a certificate is configured.
+func (s *server) serveGRPC(lis net.Listener) error {
+	var opts []grpc.ServerOption
+	if s.cfg.TLSCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(s.cfg.TLSCert, s.cfg.TLSKey)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	return grpcapi.NewServer(rpcService{s}, s.authorizeKey, opts...).Serve(lis)
+}
+
+// publish sends the current rrtype records of name in db to the
+// WatchChanges subscribers.
+func (s *server) publish(db *zonedb.DB, name string, rrtype uint16) {
+	if s.hub == nil {
+		return
+	}
+	auth := db.Zone(name)
+	if auth == nil {
+		return
+	}
+	now := time.Now().Unix()
+	for _, rr := range db.Lookup(name, rrtype) {
+		hdr := rr.Header()
+		s.hub.Publish(&grpcapi.Change{
+			Zone:   auth.Domain(),
+			Name:   hdr.Name,
+			Type:   dns.TypeToString[hdr.Rrtype],
+			Value:  strings.TrimPrefix(rr.String(), hdr.String()),
+			Serial: auth.SOA().Serial,
+			Time:   now,
+		})
+	}
+}
+
+func (r rpcService) UpdateRecord(ctx context.Context, req *grpcapi.UpdateRecordRequest) (*grpcapi.UpdateRecordResponse, error) {
+	rrtype, ok := dns.StringToType[strings.ToUpper(req.Type)]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid record type %q", req.Type)
+	}
+	var ttl *uint32
+	if req.TTL != 0 {
+		ttl = &req.TTL
+	}
+	rrs, err := r.s.setRecord(dns.Fqdn(strings.ToLower(req.Name)), rrtype, req.Value, ttl, time.Time{}, "grpc")
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return &grpcapi.UpdateRecordResponse{Records: rpcRecords(rrs)}, nil
+}
+
+func (r rpcService) ListRecords(ctx context.Context, req *grpcapi.ListRecordsRequest) (*grpcapi.ListRecordsResponse, error) {
+	zone := dns.Fqdn(strings.ToLower(req.Zone))
+	var rrtype uint16
+	if req.Type != "" {
+		t, ok := dns.StringToType[strings.ToUpper(req.Type)]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid record type %q", req.Type)
+		}
+		rrtype = t
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	db, err := r.s.load()
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	auth := db.Zone(zone)
+	if auth == nil || !strings.EqualFold(auth.Domain(), zone) {
+		return nil, status.Errorf(codes.NotFound, "zone %q is not loaded", zone)
+	}
+	var rrs []dns.RR
+	for _, rr := range auth.Records() {
+		hdr := rr.Header()
+		if req.Name != "" && !strings.EqualFold(hdr.Name, dns.Fqdn(req.Name)) {
+			continue
+		}
+		if rrtype != 0 && hdr.Rrtype != rrtype {
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	return &grpcapi.ListRecordsResponse{Records: rpcRecords(rrs)}, nil
+}
+
+func (r rpcService) WatchChanges(req *grpcapi.WatchChangesRequest, stream grpcapi.ChangeStream) error {
+	if r.s.hub == nil {
+		return status.Error(codes.Unavailable, "changes are not being published")
+	}
+	zone := ""
+	if req.Zone != "" {
+		zone = dns.Fqdn(req.Zone)
+	}
+	ch, cancel := r.s.hub.Subscribe()
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case c := <-ch:
+			if zone != "" && !strings.EqualFold(c.Zone, zone) {
+				continue
+			}
+			if err := stream.Send(c); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// rpcError converts a setRecord or load error to a gRPC status.
+func rpcError(err error) error {
+	if e, ok := err.(*requestError); ok {
+		code := codes.InvalidArgument
+		switch e.status {
+		case 404:
+			code = codes.NotFound
+		case 409:
+			code = codes.FailedPrecondition
+		}
+		return status.Error(code, e.msg)
+	}
+	return status.Error(codes.Internal, fmt.Sprint(err))
+}
+
+func rpcRecords(rrs []dns.RR) []*grpcapi.Record {
+	var recs []*grpcapi.Record
+	for _, rec := range apiRecords(rrs) {
+		recs = append(recs, &grpcapi.Record{Name: rec.Name, Type: rec.Type, TTL: rec.TTL, Value: rec.Value})
+	}
+	return recs
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// health tracks what the /healthz and /readyz probes and the systemd
+// watchdog report: whether every zone parses and, in the daemon, how
+// recently an address check and a write last succeeded.
+type health struct {
+	started time.Time
+
+	mu  sync.Mutex
+	cfg *config
+	// interval is how often the daemon checks the address; zero when
+	// serving, where there are no checks to wait for.
+	interval  time.Duration
+	lastCheck time.Time
+	lastWrite time.Time
+	checkErr  error
+}
+
+func newHealth(cfg *config, interval time.Duration) *health {
+	return &health{cfg: cfg, interval: interval, started: time.Now()}
+}
+
+// setConfig replaces the configuration and check interval on reload.
+func (h *health) setConfig(cfg *config, interval time.Duration) {
+	h.mu.Lock()
+	h.cfg, h.interval = cfg, interval
+	h.mu.Unlock()
+}
+
+// checked records the result of an address check.
+func (h *health) checked(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkErr = err
+	if err == nil {
+		h.lastCheck = time.Now()
+	}
+}
+
+// wrote records a successful write of the master files.
+func (h *health) wrote() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.lastWrite = time.Now()
+	h.mu.Unlock()
+}
+
+// healthReport is the body of both probes.
+type healthReport struct {
+	Status    string            `json:"status"`
+	Zones     map[string]string `json:"zones"`
+	LastCheck *time.Time        `json:"last_check,omitempty"`
+	CheckErr  string            `json:"check_error,omitempty"`
+	LastWrite *time.Time        `json:"last_write,omitempty"`
+}
+
+// report parses every zone file and reports whether the process is live
+// and whether it is ready. It is live while the zones parse and checks
+// keep succeeding, and ready once one has.
+func (h *health) report() (r healthReport, live, ready bool) {
+	r.Zones = map[string]string{}
+	live = true
+	h.mu.Lock()
+	cfg := h.cfg
+	h.mu.Unlock()
+	for _, file := range cfg.zoneFiles() {
+		r.Zones[file] = "ok"
+		if err := zonedb.New().Load(file); err != nil {
+			r.Zones[file] = err.Error()
+			live = false
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.lastCheck.IsZero() {
+		t := h.lastCheck
+		r.LastCheck = &t
+	}
+	if h.checkErr != nil {
+		r.CheckErr = h.checkErr.Error()
+	}
+	if !h.lastWrite.IsZero() {
+		t := h.lastWrite
+		r.LastWrite = &t
+	}
+	ready = live
+	if h.interval > 0 {
+		// Allow a few missed checks, counting from startup until the
+		// first one succeeds.
+		since := h.lastCheck
+		if since.IsZero() {
+			since = h.started
+			ready = false
+		}
+		if time.Since(since) > 3*h.interval+time.Minute {
+			live = false
+		}
+	}
+	return r, live, live && ready
+}
+
+// register adds the probes to mux.
+func (h *health) register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		r, live, _ := h.report()
+		h.respond(w, r, live)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		r, _, ready := h.report()
+		h.respond(w, r, ready)
+	})
+}
+
+func (h *health) respond(w http.ResponseWriter, r healthReport, ok bool) {
+	r.Status = "ok"
+	code := http.StatusOK
+	if !ok {
+		r.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(r)
+}
+
+// watchdog tells systemd, when run as a Type=notify service, that dnsup
+// is ready once the probes say so and, if WatchdogSec is set, keeps
+// pinging the watchdog for as long as it stays live.
+func (h *health) watchdog() {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	var every time.Duration
+	if usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC")); err == nil && usec > 0 {
+		every = time.Duration(usec) * time.Microsecond / 2
+	}
+	go func() {
+		notified := false
+		for {
+			_, live, ready := h.report()
+			if ready && !notified {
+				if err := sdNotify("READY=1"); err != nil {
+					logging.Warnf("systemd notify: %v", err)
+					return
+				}
+				notified = true
+			}
+			if live && every > 0 {
+				sdNotify("WATCHDOG=1")
+			}
+			if notified && every == 0 {
+				return
+			}
+			wait := every
+			if !notified && (wait == 0 || wait > time.Second) {
+				wait = time.Second
+			}
+			time.Sleep(wait)
+		}
+	}()
+}
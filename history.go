@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/audit"
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+// historyOptions are the flags of "dnsup history".
+type historyOptions struct {
+	typ   string
+	since time.Duration
+}
+
+func historyFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.history.typ, "type", "", "only changes to records of this type")
+	fs.DurationVar(&c.history.since, "since", 0, "only changes made in this long before now")
+	fs.StringVar(&c.Zone, "zone", c.Zone, "only changes to records in this zone")
+	fs.StringVar(&c.AuditLog, "audit-log", c.AuditLog, "audit log to read")
+}
+
+// runHistory implements "dnsup history [-type TYPE] [-zone zone] [-since
+// duration] [name]", printing the audit log entries for a name, or for
+// every name, oldest first.
+func runHistory(args []string) {
+	cfg, err := parseConfig("history", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if cfg.AuditLog == "" {
+		logging.Fatal("no audit log: set audit_log or -audit-log")
+	}
+	if len(cfg.Args) > 1 {
+		logging.Fatal("usage: dnsup history [flags] [name]")
+	}
+	var name string
+	if len(cfg.Args) == 1 {
+		name = dns.Fqdn(cfg.Args[0])
+	}
+	zone := cfg.Zone
+	if zone != "" {
+		zone = dns.Fqdn(zone)
+	}
+	var after time.Time
+	if cfg.history.since > 0 {
+		after = time.Now().Add(-cfg.history.since)
+	}
+
+	entries, err := audit.Read(cfg.AuditLog, func(e *audit.Entry) bool {
+		return (name == "" || strings.EqualFold(e.Name, name)) &&
+			(cfg.history.typ == "" || strings.EqualFold(e.Type, cfg.history.typ)) &&
+			(zone == "" || strings.EqualFold(e.Zone, zone) || dns.IsSubDomain(strings.ToLower(zone), strings.ToLower(e.Name))) &&
+			!e.Time.Before(after)
+	})
+	if err != nil {
+		logging.Fatal(err)
+	}
+	for _, e := range entries {
+		fmt.Println(e)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// runHooks runs the global hooks, or those of the zone's zone_settings,
+// followed by the zone's zone_hooks once for every modified zone in db.
+// Each command is run by the shell with the zone, master file, new serial
+// and the addresses applied within the zone in DNSUP_ZONE, DNSUP_FILE,
+// DNSUP_SERIAL and DNSUP_IP. Failures are logged with the command's output
+// and counted in the returned error.
+func runHooks(cfg *config, db *zonedb.DB, updates []update) error {
+	failed := 0
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			if !auth.Dirty() {
+				continue
+			}
+			cmds := append(append([]string(nil), cfg.zoneConfig(auth.Domain()).Hooks...), cfg.ZoneHooks[auth.Domain()]...)
+			if len(cmds) == 0 {
+				continue
+			}
+			env := hookEnv(mf, auth, updates)
+			for _, cmd := range cmds {
+				if out, err := runHook(cmd, env); err != nil {
+					logging.Errorf("hook %q for %s failed: %v\n%s", cmd, auth.Domain(), err, out)
+					failed++
+				}
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d hook(s) failed", failed)
+	}
+	return nil
+}
+
+func hookEnv(mf *zonedb.MasterFile, auth *zonedb.Authority, updates []update) []string {
+	var ips []string
+	seen := map[string]bool{}
+	for _, up := range updates {
+		if dns.IsSubDomain(auth.Domain(), up.domain) && !seen[up.ip] {
+			seen[up.ip] = true
+			ips = append(ips, up.ip)
+		}
+	}
+	return append(os.Environ(),
+		"DNSUP_ZONE="+auth.Domain(),
+		"DNSUP_FILE="+mf.Name(),
+		"DNSUP_SERIAL="+strconv.FormatUint(uint64(auth.SOA().Serial), 10),
+		"DNSUP_IP="+strings.Join(ips, " "),
+	)
+}
+
+func runHook(command string, env []string) ([]byte, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	cmd.Env = env
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.Bytes(), err
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// defaultDnsmasqReload has dnsmasq, or Pi-hole's FTL, re-read its hosts
+// files.
+const defaultDnsmasqReload = "pkill -HUP -x dnsmasq || pkill -HUP -x pihole-FTL"
+
+// The lines of a hosts file that dnsup manages among others' entries.
+const (
+	hostsBegin = "# BEGIN dnsup\n"
+	hostsEnd   = "# END dnsup\n"
+)
+
+// hostsBackend keeps the addresses of names in a file of host entries:
+// a hosts file with "address name" lines or, if the file name ends in
+// .conf, a dnsmasq config file with address=/name/address lines. After a
+// change the reload command, if any, is run by the shell. A batch of
+// updates writes the file and reloads once.
+//
+// For dnsmasq the file may be one given to addn-hosts, such as Pi-hole's
+// custom.list, which SIGHUP has it re-read; config files need dnsmasq
+// restarted. For /etc/hosts only the entries between the hostsBegin and
+// hostsEnd lines are managed, added at the end of the file if missing.
+type hostsBackend struct {
+	file    string
+	reload  string
+	conf    bool
+	guarded bool
+
+	lines         []string // the managed lines while they are being changed
+	before, after []string // the lines around them, when guarded
+	changed       bool
+	batch         bool
+}
+
+func newDnsmasqBackend(file, reload string) *hostsBackend {
+	if reload == "" {
+		reload = defaultDnsmasqReload
+	}
+	return &hostsBackend{file: file, reload: reload, conf: strings.HasSuffix(file, ".conf")}
+}
+
+func newHostsBackend(file string) *hostsBackend {
+	return &hostsBackend{file: file, guarded: true}
+}
+
+// read returns the managed lines of the file, which need not exist yet,
+// keeping those around them.
+func (b *hostsBackend) read() ([]string, error) {
+	data, err := ioutil.ReadFile(b.file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if !b.guarded {
+		return lines, nil
+	}
+	b.before, b.after = lines, nil
+	for i, line := range lines {
+		if strings.TrimSpace(line) != strings.TrimSpace(hostsBegin) {
+			continue
+		}
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == strings.TrimSpace(hostsEnd) {
+				b.before, b.after = lines[:i], lines[j+1:]
+				return lines[i+1 : j], nil
+			}
+		}
+		return nil, fmt.Errorf("%s: %q without %q", b.file, strings.TrimSpace(hostsBegin), strings.TrimSpace(hostsEnd))
+	}
+	return nil, nil
+}
+
+// entry returns the address and names of a line, or "" if it holds none.
+func (b *hostsBackend) entry(line string) (string, []string) {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	if b.conf {
+		// address=/name[/name...]/address
+		if !strings.HasPrefix(line, "address=/") {
+			return "", nil
+		}
+		parts := strings.Split(strings.TrimPrefix(line, "address="), "/")
+		if len(parts) < 3 || parts[len(parts)-1] == "" {
+			return "", nil
+		}
+		return parts[len(parts)-1], parts[1 : len(parts)-1]
+	}
+	fs := strings.Fields(line)
+	if len(fs) < 2 {
+		return "", nil
+	}
+	return fs[0], fs[1:]
+}
+
+// current returns the addresses of rrtype the file holds for name.
+func (b *hostsBackend) current(lines []string, name string, rrtype uint16) []string {
+	var ips []string
+	for _, line := range lines {
+		ip, names := b.entry(line)
+		if ip != "" && hasName(names, name) && ipType(ip) == rrtype {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// UpdateRecord makes value the only address of its family for name,
+// dropping name from other lines of that family and from lines left with
+// no name.
+func (b *hostsBackend) UpdateRecord(ctx context.Context, name string, rrtype uint16, value string) error {
+	if rrtype != dns.TypeA && rrtype != dns.TypeAAAA {
+		return fmt.Errorf("%s %s: %s only holds A and AAAA records", name, dns.TypeToString[rrtype], b.file)
+	}
+	if b.lines == nil {
+		lines, err := b.read()
+		if err != nil {
+			return err
+		}
+		b.lines = lines
+	}
+	if old := b.current(b.lines, name, rrtype); len(old) == 1 && old[0] == value {
+		logging.Infof("%s: already %s", name, value)
+	} else {
+		b.lines, b.changed = b.replace(b.lines, name, rrtype, value), true
+	}
+	if b.batch {
+		return nil
+	}
+	return b.Commit(ctx)
+}
+
+func (b *hostsBackend) replace(lines []string, name string, rrtype uint16, value string) []string {
+	host := strings.TrimSuffix(name, ".")
+	var out []string
+	for _, line := range lines {
+		ip, names := b.entry(line)
+		if ip == "" || ipType(ip) != rrtype || !hasName(names, name) {
+			out = append(out, line)
+			continue
+		}
+		var kept []string
+		for _, n := range names {
+			if !strings.EqualFold(strings.TrimSuffix(n, "."), host) {
+				kept = append(kept, n)
+			}
+		}
+		if len(kept) > 0 {
+			out = append(out, b.format(ip, kept))
+		}
+	}
+	if n := len(out); n > 0 && !strings.HasSuffix(out[n-1], "\n") {
+		out[n-1] += "\n"
+	}
+	return append(out, b.format(value, []string{host}))
+}
+
+func (b *hostsBackend) format(ip string, names []string) string {
+	if b.conf {
+		return "address=/" + strings.Join(names, "/") + "/" + ip + "\n"
+	}
+	return ip + "\t" + strings.Join(names, " ") + "\n"
+}
+
+func (b *hostsBackend) Begin() {
+	b.batch = true
+}
+
+// Commit writes the file, if changed, and runs the reload command.
+func (b *hostsBackend) Commit(ctx context.Context) error {
+	lines, changed := b.lines, b.changed
+	b.lines, b.changed, b.batch = nil, false, false
+	if !changed {
+		return nil
+	}
+	if b.guarded {
+		all := append([]string(nil), b.before...)
+		if n := len(all); n > 0 && !strings.HasSuffix(all[n-1], "\n") {
+			all[n-1] += "\n"
+		}
+		all = append(all, hostsBegin)
+		all = append(all, lines...)
+		all = append(all, hostsEnd)
+		lines = append(all, b.after...)
+	}
+	data := strings.Join(lines, "")
+	err := zonedb.WriteFile(b.file, func(w io.Writer) error {
+		_, err := io.WriteString(w, data)
+		return err
+	})
+	if err != nil && b.guarded {
+		// /etc/hosts is often a bind mount, as in containers, that cannot
+		// be replaced by renaming; rewrite it in place instead.
+		err = ioutil.WriteFile(b.file, []byte(data), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if b.reload == "" {
+		return nil
+	}
+	if out, err := runHook(b.reload, os.Environ()); err != nil {
+		return fmt.Errorf("reloading dnsmasq with %q: %v: %s", b.reload, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (b *hostsBackend) Plan(ctx context.Context, name string, rrtype uint16, value string) (string, error) {
+	lines, err := b.read()
+	if err != nil {
+		return "", err
+	}
+	typ := dns.TypeToString[rrtype]
+	old := b.current(lines, name, rrtype)
+	if len(old) == 1 && old[0] == value {
+		return fmt.Sprintf("%s %s: unchanged %s", name, typ, value), nil
+	}
+	return fmt.Sprintf("%s %s: [%s] -> %s in %s", name, typ, strings.Join(old, ", "), value, b.file), nil
+}
+
+// hasName reports whether names, as written in a dnsmasq file, include
+// name.
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(strings.TrimSuffix(n, "."), strings.TrimSuffix(name, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipType returns the record type of the address ip, or 0 if it is not
+// one.
+func ipType(ip string) uint16 {
+	rrtype, err := addressType(ip)
+	if err != nil {
+		return 0
+	}
+	return rrtype
+}
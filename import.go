@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/tsig"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+	"github.com/johnweldon/dnsup/pkg/zonedef"
+)
+
+// importOptions are the flags of "dnsup import".
+type importOptions struct {
+	server string
+	from   string
+	format string
+	out    string
+	force  bool
+}
+
+func importFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.importing.server, "axfr", "", "transfer the zone from this server")
+	fs.StringVar(&c.importing.from, "from", "", "compile the zone from this JSON or YAML definition")
+	fs.StringVar(&c.importing.format, "format", "", "format of -from, json or yaml (default from the file name)")
+	fs.StringVar(&c.importing.out, "o", "", "master file to write (default <zone>.zone)")
+	fs.BoolVar(&c.importing.force, "force", false, "overwrite an existing master file")
+}
+
+// runImport implements "dnsup import -axfr server [-o file] zone",
+// writing the transferred zone as a new master file, and "dnsup import
+// -from definition [-o file]", compiling a JSON or YAML zone definition
+// into a master file. A master file compiled before is replaced only if
+// the definition's records differ from it, under a serial above its own.
+func runImport(args []string) {
+	cfg, err := parseConfig("import", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if cfg.importing.from != "" {
+		if cfg.importing.server != "" || len(cfg.Args) != 0 {
+			logging.Fatal("usage: dnsup import -from definition [-o file]")
+		}
+		compileZone(cfg, cfg.importing.from, cfg.importing.format, cfg.importing.out)
+		return
+	}
+	if cfg.importing.server == "" || len(cfg.Args) != 1 {
+		logging.Fatal("usage: dnsup import -axfr server [-o file] zone")
+	}
+	zone := dns.Fqdn(strings.ToLower(cfg.Args[0]))
+	if cfg.importing.out == "" {
+		cfg.importing.out = strings.TrimSuffix(zone, ".") + ".zone"
+	}
+
+	key, err := cfg.tsigKey()
+	if err != nil {
+		logging.Fatal(err)
+	}
+	rrs, err := transferZone(cfg.importing.server, zone, key)
+	if err != nil {
+		logging.Fatal(err)
+	}
+
+	db, err := cfg.newDB()
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if _, err := db.Import(cfg.importing.out, zone, rrs); err != nil {
+		logging.Fatalf("%s from %s: %v", zone, cfg.importing.server, err)
+	}
+	if cfg.DryRun {
+		printImported(db)
+		return
+	}
+	if _, err := os.Stat(cfg.importing.out); err == nil && !cfg.importing.force {
+		logging.Fatalf("%s exists; use -force to overwrite it", cfg.importing.out)
+	}
+	if err := db.Write(); err != nil {
+		logging.Fatal(err)
+	}
+	logging.Infof("wrote %d records of %s to %s", len(rrs), zone, cfg.importing.out)
+}
+
+// compileZone writes the zone defined in the file from as the master
+// file out. Unless the definition gives a later serial, the serial is
+// advanced by the serial policy from that of the zone out already holds,
+// or from zero for a new file.
+func compileZone(cfg *config, from, format, out string) {
+	data, err := ioutil.ReadFile(from)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if format == "" {
+		format = "yaml"
+		if strings.EqualFold(filepath.Ext(from), ".json") {
+			format = "json"
+		}
+	}
+	def, err := zonedef.Parse(data, format)
+	if err != nil {
+		logging.Fatalf("%s: %v", from, err)
+	}
+	rrs, err := def.RRs()
+	if err != nil {
+		logging.Fatalf("%s: %v", from, err)
+	}
+	zone := def.Origin
+	if out == "" {
+		out = strings.TrimSuffix(zone, ".") + ".zone"
+	}
+
+	var cur []dns.RR
+	if _, err := os.Stat(out); err == nil {
+		old, err := cfg.newDB()
+		if err == nil {
+			err = old.Load(out)
+		}
+		if err != nil {
+			logging.Fatal(err)
+		}
+		auth := old.Zone(zone)
+		if auth == nil || auth.Domain() != zone {
+			logging.Fatalf("%s does not hold %s", out, zone)
+		}
+		cur = auth.Records()
+		if sameZone(cur, rrs) {
+			logging.Infof("%s is up to date", out)
+			return
+		}
+	}
+
+	db, err := cfg.newDB()
+	if err != nil {
+		logging.Fatal(err)
+	}
+	mf, err := db.Import(out, zone, rrs)
+	if err != nil {
+		logging.Fatalf("%s: %v", from, err)
+	}
+	auth := mf.Authorities()[0]
+	soa := auth.SOA()
+	switch {
+	case cur != nil && int32(soa.Serial-cur[0].(*dns.SOA).Serial) > 0:
+		// The definition's own serial is already ahead.
+	case cur != nil:
+		soa.Serial = cur[0].(*dns.SOA).Serial
+		auth.Touch()
+	case soa.Serial == 0:
+		auth.Touch()
+	}
+	if cfg.DryRun {
+		printImported(db)
+		return
+	}
+	if err := db.Write(); err != nil {
+		logging.Fatal(err)
+	}
+	logging.Infof("wrote %d records of %s to %s, serial %d", len(rrs), zone, out, soa.Serial)
+}
+
+// sameZone reports whether a and b hold the same records, ignoring the
+// SOA serial and the order of the records.
+func sameZone(a, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	key := func(rr dns.RR) string {
+		if soa, ok := rr.(*dns.SOA); ok {
+			c := *soa
+			c.Serial = 0
+			rr = &c
+		}
+		return strings.ToLower(rr.String())
+	}
+	count := map[string]int{}
+	for _, rr := range a {
+		count[key(rr)]++
+	}
+	for _, rr := range b {
+		k := key(rr)
+		if count[k] == 0 {
+			return false
+		}
+		count[k]--
+	}
+	return true
+}
+
+func printImported(db *zonedb.DB) {
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			fmt.Printf("%s: %d records\n", auth.Domain(), len(auth.Records()))
+		}
+	}
+}
+
+// transferZone fetches zone from server by AXFR, signed with key if it is
+// not nil, and returns its records without the closing SOA.
+func transferZone(server, zone string, key *tsig.Key) ([]dns.RR, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	t := &dns.Transfer{}
+	m := new(dns.Msg)
+	m.SetAxfr(zone)
+	if key != nil {
+		t.TsigSecret = map[string]string{key.Name: key.Secret}
+		m.SetTsig(key.Name, key.Algorithm, tsigFudge, time.Now().Unix())
+	}
+	ch, err := t.In(m, server)
+	if err != nil {
+		return nil, fmt.Errorf("axfr %s from %s: %v", zone, server, err)
+	}
+	var rrs []dns.RR
+	for env := range ch {
+		if env.Error != nil {
+			return nil, fmt.Errorf("axfr %s from %s: %v", zone, server, env.Error)
+		}
+		rrs = append(rrs, env.RR...)
+	}
+	if len(rrs) == 0 || rrs[0].Header().Rrtype != dns.TypeSOA {
+		return nil, fmt.Errorf("axfr %s from %s: transfer did not start with a SOA", zone, server)
+	}
+	if last := len(rrs) - 1; last > 0 && rrs[last].Header().Rrtype == dns.TypeSOA {
+		rrs = rrs[:last]
+	}
+	return rrs, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// ipSet returns the addresses of -ip-set, each flag a single address or a
+// comma-separated list, by record type.
+func ipSet(list []string) (map[uint16][]string, error) {
+	set := map[uint16][]string{}
+	for _, arg := range list {
+		for _, ip := range strings.Split(arg, ",") {
+			ip = strings.TrimSpace(ip)
+			rrtype, err := addressType(ip)
+			if err != nil {
+				return nil, fmt.Errorf("-ip-set: %v", err)
+			}
+			set[rrtype] = append(set[rrtype], ip)
+		}
+	}
+	return set, nil
+}
+
+// applyIPSet makes the A and AAAA records of the configured domains in db
+// exactly the -ip-set addresses of each family given, and returns the
+// records changed, their value the addresses joined by commas, and their
+// old values.
+func applyIPSet(cfg *config, db *zonedb.DB) ([]recordUpdate, [][]string, error) {
+	set, err := ipSet(cfg.IPSet)
+	if err != nil {
+		return nil, nil, err
+	}
+	var domains []string
+	for _, domain := range cfg.Domains {
+		domains = append(domains, dns.Fqdn(domain))
+	}
+	var changes []recordUpdate
+	var olds [][]string
+	for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		ips := set[rrtype]
+		if len(ips) == 0 {
+			continue
+		}
+		for _, name := range expandDomains(db, domains, rrtype) {
+			old := values(db.Lookup(name, rrtype))
+			if sameAddresses(old, ips) {
+				continue
+			}
+			if err := db.SetIPs(name, rrtype, ips); err != nil {
+				return nil, nil, err
+			}
+			if err := clampTTL(cfg, db, name, rrtype); err != nil {
+				return nil, nil, err
+			}
+			changes = append(changes, recordUpdate{name: name, rrtype: rrtype, value: strings.Join(ips, ",")})
+			olds = append(olds, old)
+		}
+	}
+	return changes, olds, nil
+}
+
+// sameAddresses reports whether old holds exactly the addresses of ips,
+// once each.
+func sameAddresses(old, ips []string) bool {
+	a := append([]string(nil), old...)
+	b := append([]string(nil), ips...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, " ") == strings.Join(b, " ")
+}
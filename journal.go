@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// journalOptions are the flags of "dnsup journal".
+type journalOptions struct {
+	since    int64
+	rollback int64
+}
+
+func journalFlags(fs *flag.FlagSet, c *config) {
+	fs.Int64Var(&c.journal.since, "since", -1, "print only the changes after this serial")
+	fs.Int64Var(&c.journal.rollback, "rollback", -1, "undo the changes made after this serial, as a new serial")
+}
+
+// runJournal implements "dnsup journal [-since serial] [-rollback serial]
+// [zonefile...]", printing the journaled changes of each loaded zone or
+// undoing those made after a serial.
+func runJournal(args []string) {
+	cfg, err := parseConfig("journal", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	cfg.zoneArgs(cfg.Args)
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	if cfg.journal.rollback >= 0 {
+		cfg.Journal = true // the rollback is itself a change to journal
+	}
+	db := loadZones(cfg)
+
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			journal, err := db.Journal(auth.Domain())
+			if err != nil {
+				logging.Fatal(err)
+			}
+			if cfg.journal.rollback >= 0 {
+				if err := rollbackZone(db, auth, journal, uint32(cfg.journal.rollback)); err != nil {
+					logging.Fatal(err)
+				}
+				continue
+			}
+			if cfg.journal.since >= 0 {
+				chain, ok := zonedb.Since(journal, uint32(cfg.journal.since))
+				if !ok && uint32(cfg.journal.since) != auth.SOA().Serial {
+					logging.Warnf("%s: journal does not reach back to serial %d", auth.Domain(), cfg.journal.since)
+				}
+				journal = chain
+			}
+			for _, d := range journal {
+				printDelta(d)
+			}
+		}
+	}
+	if cfg.journal.rollback >= 0 {
+		if err := commit(cfg, db, nil); err != nil {
+			logging.Fatal(err)
+		}
+	}
+}
+
+// rollbackZone reverses the journaled changes of auth made after serial,
+// newest first.
+func rollbackZone(db *zonedb.DB, auth *zonedb.Authority, journal []*zonedb.Delta, serial uint32) error {
+	if serial == auth.SOA().Serial {
+		return nil
+	}
+	chain, ok := zonedb.Since(journal, serial)
+	if !ok || chain[len(chain)-1].To != auth.SOA().Serial {
+		return fmt.Errorf("%s: journal does not lead from serial %d to %d", auth.Domain(), serial, auth.SOA().Serial)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if err := db.ApplyDelta(chain[i].Reverse()); err != nil {
+			return err
+		}
+	}
+	logging.Infof("%s: rolled back %d changes to serial %d", auth.Domain(), len(chain), serial)
+	return nil
+}
+
+func printDelta(d *zonedb.Delta) {
+	fmt.Printf("; %s serial %d -> %d at %s\n", d.Zone, d.From, d.To, d.Time.Format(time.RFC3339))
+	for _, rr := range d.Deleted {
+		if rr.Header().Rrtype != dns.TypeSOA {
+			fmt.Printf("-%s\n", rr)
+		}
+	}
+	for _, rr := range d.Added {
+		if rr.Header().Rrtype != dns.TypeSOA {
+			fmt.Printf("+%s\n", rr)
+		}
+	}
+}
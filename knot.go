@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// knotBackend applies updates to a running Knot DNS server through knotc
+// zone transactions: zone-begin, zone-unset and zone-set, then
+// zone-commit, which has Knot advance the serial and write the zone file
+// itself. A batch of updates shares one transaction per zone.
+type knotBackend struct {
+	knotc []string // the knotc command and its options, such as -s socket
+	zone  string   // the zone to update, or "" to find it among Knot's
+	zones []string
+
+	batching bool
+	open     map[string]bool // zones with a transaction begun
+}
+
+func newKnotBackend(command, zone string) (*knotBackend, error) {
+	b := &knotBackend{knotc: strings.Fields(command)}
+	if len(b.knotc) == 0 {
+		return nil, fmt.Errorf("empty knotc command")
+	}
+	if zone != "" {
+		b.zone = dns.Fqdn(zone)
+	}
+	return b, nil
+}
+
+// run runs knotc with args, failing with its output if it does.
+func (b *knotBackend) run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, b.knotc[0], append(append([]string(nil), b.knotc[1:]...), args...)...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("knotc %s: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(out.Bytes()))
+	}
+	return out.String(), nil
+}
+
+// zoneOf returns the zone of Knot's that holds name, the configured one
+// or the longest listed by zone-status.
+func (b *knotBackend) zoneOf(ctx context.Context, name string) (string, error) {
+	if b.zone != "" {
+		return b.zone, nil
+	}
+	if b.zones == nil {
+		out, err := b.run(ctx, "zone-status")
+		if err != nil {
+			return "", err
+		}
+		for _, line := range strings.Split(out, "\n") {
+			if strings.HasPrefix(line, "[") {
+				if i := strings.Index(line, "]"); i > 0 {
+					b.zones = append(b.zones, dns.Fqdn(line[1:i]))
+				}
+			}
+		}
+	}
+	best := ""
+	for _, z := range b.zones {
+		if dns.IsSubDomain(z, dns.Fqdn(name)) && len(z) > len(best) {
+			best = z
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("%s: not in any zone served by Knot", name)
+	}
+	return best, nil
+}
+
+// get returns the TTL and data of the rrtype records of name in zone, as
+// the transaction sees them if one is open.
+func (b *knotBackend) get(ctx context.Context, zone, name string, rrtype uint16) (uint32, []string, error) {
+	cmd := "zone-read"
+	if b.open[zone] {
+		cmd = "zone-get"
+	}
+	out, err := b.run(ctx, cmd, zone, dns.Fqdn(name), dns.TypeToString[rrtype])
+	if err != nil {
+		if strings.Contains(err.Error(), "no such") {
+			return 0, nil, nil
+		}
+		return 0, nil, err
+	}
+	var ttl uint32
+	var values []string
+	for _, line := range strings.Split(out, "\n") {
+		i := strings.Index(line, "] ")
+		if i < 0 {
+			continue
+		}
+		rr, err := dns.NewRR(line[i+2:])
+		if err != nil || rr == nil {
+			continue
+		}
+		ttl = rr.Header().Ttl
+		values = append(values, strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Header().String())))
+	}
+	return ttl, values, nil
+}
+
+// begin opens a transaction on zone for the batch, if not yet open.
+func (b *knotBackend) begin(ctx context.Context, zone string) error {
+	if b.open[zone] {
+		return nil
+	}
+	if _, err := b.run(ctx, "zone-begin", zone); err != nil {
+		return err
+	}
+	b.open[zone] = true
+	return nil
+}
+
+// UpdateRecord replaces the rrtype records of name with one holding
+// value, keeping their TTL. Outside a batch the change is committed at
+// once; within one, a failure aborts every transaction of the batch.
+func (b *knotBackend) UpdateRecord(ctx context.Context, name string, rrtype uint16, value string) error {
+	if !b.batching {
+		b.Begin()
+		if err := b.UpdateRecord(ctx, name, rrtype, value); err != nil {
+			return err
+		}
+		return b.Commit(ctx)
+	}
+	if err := b.update(ctx, name, rrtype, value); err != nil {
+		b.batching = false
+		return b.abort(ctx, err)
+	}
+	return nil
+}
+
+func (b *knotBackend) update(ctx context.Context, name string, rrtype uint16, value string) error {
+	zone, err := b.zoneOf(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := b.begin(ctx, zone); err != nil {
+		return err
+	}
+	ttl, old, err := b.get(ctx, zone, name, rrtype)
+	if err != nil {
+		return err
+	}
+	if len(old) == 1 && old[0] == value {
+		return nil
+	}
+	typ := dns.TypeToString[rrtype]
+	if len(old) > 0 {
+		if _, err := b.run(ctx, "zone-unset", zone, dns.Fqdn(name), typ); err != nil {
+			return err
+		}
+	} else {
+		ttl = defaultUpdateTTL
+	}
+	_, err = b.run(ctx, "zone-set", zone, dns.Fqdn(name), fmt.Sprint(ttl), typ, value)
+	return err
+}
+
+// abort rolls back the open transactions after err.
+func (b *knotBackend) abort(ctx context.Context, err error) error {
+	for _, zone := range b.opened() {
+		if _, aerr := b.run(ctx, "zone-abort", zone); aerr != nil {
+			err = fmt.Errorf("%v; %v", err, aerr)
+		}
+	}
+	b.open = nil
+	return err
+}
+
+// opened returns the zones with a transaction open, sorted.
+func (b *knotBackend) opened() []string {
+	var zones []string
+	for zone := range b.open {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+func (b *knotBackend) Begin() {
+	b.batching = true
+	b.open = map[string]bool{}
+}
+
+// Commit commits the open transactions, aborting those left after a
+// failure.
+func (b *knotBackend) Commit(ctx context.Context) error {
+	b.batching = false
+	for _, zone := range b.opened() {
+		if _, err := b.run(ctx, "zone-commit", zone); err != nil {
+			return b.abort(ctx, err)
+		}
+		delete(b.open, zone)
+	}
+	b.open = nil
+	return nil
+}
+
+func (b *knotBackend) Plan(ctx context.Context, name string, rrtype uint16, value string) (string, error) {
+	typ := dns.TypeToString[rrtype]
+	zone, err := b.zoneOf(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	_, old, err := b.get(ctx, zone, name, rrtype)
+	if err != nil {
+		return "", err
+	}
+	if len(old) == 1 && old[0] == value {
+		return fmt.Sprintf("%s %s: unchanged %s", name, typ, value), nil
+	}
+	return fmt.Sprintf("%s %s: [%s] -> %s via knotc", name, typ, strings.Join(old, ", "), value), nil
+}
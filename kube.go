@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/johnweldon/dnsup/pkg/kube"
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+func kubeFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.KubeServer, "kube-server", c.KubeServer, "Kubernetes API server URL (default the cluster the controller runs in)")
+	fs.StringVar(&c.KubeToken, "kube-token", c.KubeToken, "bearer token for -kube-server")
+	fs.StringVar(&c.KubeCA, "kube-ca", c.KubeCA, "file of certificate authorities to trust for -kube-server")
+	fs.StringVar(&c.KubeNamespace, "kube-namespace", c.KubeNamespace, "only publish resources in this namespace")
+	fs.StringVar(&c.KubeOwner, "kube-owner", c.KubeOwner, "owner ID recorded in the TXT markers, to share zones between clusters")
+	fs.DurationVar(&c.Interval.Duration, "interval", c.Interval.Duration, "how often to resynchronize in full")
+}
+
+// runKube implements "dnsup kube [flags] [zonefile...]", a controller
+// that watches the cluster's annotated Ingresses and Services and gives
+// their hosts A and AAAA records for the addresses of their load
+// balancers, or a CNAME for a load balancer known only by name.
+func runKube(args []string) {
+	cfg, err := parseConfig("kube", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	cfg.zoneArgs(cfg.Args)
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+
+	var client *kube.Client
+	if cfg.KubeServer != "" {
+		client, err = kube.NewClient(cfg.KubeServer, cfg.KubeToken, cfg.KubeCA)
+	} else {
+		client, err = kube.InCluster()
+	}
+	if err != nil {
+		logging.Fatal(err)
+	}
+	client.Namespace = cfg.KubeNamespace
+	owner := cfg.KubeOwner
+	if owner == "" {
+		owner = "default"
+	}
+	p := newPublisher(cfg, "kube", owner)
+
+	events := client.Watch()
+	tick := time.NewTicker(cfg.Interval.Duration)
+	defer tick.Stop()
+	for {
+		eps, err := client.Endpoints()
+		if err == nil {
+			err = p.reconcile(kubeEndpoints(eps))
+		}
+		if err != nil {
+			logging.Error(err)
+		}
+		flushEvents()
+		select {
+		case <-tick.C:
+		case <-events:
+			time.Sleep(settleDelay)
+		}
+	}
+}
+
+func kubeEndpoints(eps []kube.Endpoint) []endpoint {
+	var out []endpoint
+	for _, ep := range eps {
+		out = append(out, endpoint{host: ep.Host, targets: ep.Targets, ttl: ep.TTL, resource: ep.Resource})
+	}
+	return out
+}
@@ -1,23 +1,493 @@
 package main
 
 import (
-	"log"
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/alert"
+	"github.com/johnweldon/dnsup/pkg/ipsource"
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
 )
 
+// update is a single domain to address assignment.
+type update struct {
+	domain string
+	ip     string
+}
+
+// recordUpdate sets the records of a name and type to a value in
+// presentation format.
+type recordUpdate struct {
+	name   string
+	rrtype uint16
+	value  string
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatal("missing master file name")
+	if len(os.Args) > 1 {
+		if cmd := findCommand(os.Args[1]); cmd != nil {
+			cmd.run(os.Args[2:])
+			return
+		}
+	}
+	runUpdate(os.Args[1:])
+}
+
+func updateFlags(fs *flag.FlagSet, c *config) {
+	c.registerDomains(fs)
+	fs.Var((*stringList)(&c.Set), "set", "set the records of a name and type, as \"name TYPE value\" (repeatable)")
+	fs.BoolVar(&c.Stdin, "stdin", false, "also read \"domain ip\" pairs from standard input")
+	fs.BoolVar(&c.Quiet, "quiet", false, "log nothing, and print the result as a single JSON line")
+	fs.BoolVar(&c.Stream, "stream", c.Stream, "rewrite a single very large master file as a stream, through an index of its records, instead of loading it")
+	fs.StringVar(&c.OldIP, "old-ip", "", "replace only the A or AAAA record of each -domain holding this address")
+	fs.Var((*stringList)(&c.IPSet), "ip-set", "make the A and AAAA records of each -domain exactly these addresses, comma-separated (repeatable)")
+}
+
+// runUpdate implements "dnsup [flags] [zonefile...]", pointing the
+// records of the domains at their addresses.
+func runUpdate(args []string) {
+	cfg, err := parseConfig("dnsup", args)
+	if err != nil {
+		logging.Fatal(err)
 	}
+	if cfg.Quiet {
+		logging.SetDefault(logging.New(ioutil.Discard, logging.LevelError, false))
+		outcome.quiet = true
+	}
+	logging.Exit = func(msg string) { finish(exitError, msg) }
+	ctx, cancel := cfg.timeoutContext()
+	defer cancel()
+	if len(cfg.Views) > 0 {
+		updateViews(ctx, cfg)
+		succeed()
+	}
+	cfg.zoneArgs(cfg.Args)
 
-	db := newRRDB()
-	if err := db.Process(os.Args[1:]); err != nil {
-		log.Fatal(err)
+	updates, err := collectUpdates(ctx, cfg)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	sets, err := parseRecordUpdates(cfg.Set)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if len(updates) == 0 && len(sets) == 0 && len(cfg.IPSet) == 0 && len(cfg.Templates) == 0 {
+		logging.Fatal("no domains to update")
 	}
 
-	db.UpdateIP("w.jw4.us.", "10.10.11.11")
+	b, err := newBackend(cfg)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if len(cfg.IPSet) > 0 && (b != nil || cfg.Stream) {
+		logging.Fatal("-ip-set applies to master files only")
+	}
+	if len(cfg.Templates) > 0 && (b != nil || cfg.Stream) {
+		logging.Fatal("templates apply to master files only")
+	}
+	if b != nil {
+		err := applyBackend(ctx, cfg, b, updates, sets)
+		if err != nil {
+			sendAlert(cfg, alert.UpdateFailed, "update failed", err.Error())
+		}
+		flushEvents()
+		if err != nil {
+			logging.Fatal(err)
+		}
+		verifyUpdates(cfg, updates, sets)
+		succeed()
+	}
+
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	if cfg.Stream {
+		err := streamUpdates(cfg, updates, sets)
+		if err != nil {
+			sendAlert(cfg, alert.UpdateFailed, "update failed", err.Error())
+		}
+		flushEvents()
+		if err != nil {
+			logging.Fatal(err)
+		}
+		succeed()
+	}
+
+	if len(cfg.Templates) > 0 {
+		if cfg.tmplData, err = renderData(ctx, cfg, updates); err != nil {
+			logging.Fatal(err)
+		}
+	}
+	updateZones(cfg, updates, sets)
+	succeed()
+}
+
+// updateZones applies updates and sets to the master files, writes them
+// and announces the changes, exiting with exitError, exitVerifyFailed or
+// exitPartial on failure.
+func updateZones(cfg *config, updates []update, sets []recordUpdate) {
+	db, err := cfg.newDB()
+	if err != nil {
+		logging.Fatal(err)
+	}
+	failed, err := loadAll(db, cfg.zoneFiles())
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if updates, err = expandUpdates(db, updates); err != nil {
+		logging.Fatal(err)
+	}
+
+	var changes []recordUpdate
+	var olds [][]string
+	for _, up := range updates {
+		rrtype, err := addressType(up.ip)
+		if err != nil {
+			logging.Fatal(err)
+		}
+		changes = append(changes, recordUpdate{name: up.domain, rrtype: rrtype, value: up.ip})
+		olds = append(olds, values(db.Lookup(up.domain, rrtype)))
+		apply := db.UpdateIP
+		if cfg.OldIP != "" {
+			apply = func(domain, ip string) error { return db.ReplaceIP(domain, cfg.OldIP, ip) }
+		}
+		if err := apply(up.domain, up.ip); err != nil {
+			logging.Fatal(err)
+		}
+		if err := clampTTL(cfg, db, up.domain, rrtype); err != nil {
+			logging.Fatal(err)
+		}
+	}
+	if len(cfg.IPSet) > 0 {
+		c, o, err := applyIPSet(cfg, db)
+		if err != nil {
+			logging.Fatal(err)
+		}
+		changes, olds = append(changes, c...), append(olds, o...)
+	}
+	for _, set := range sets {
+		changes = append(changes, set)
+		olds = append(olds, values(db.Lookup(set.name, set.rrtype)))
+		if err := db.UpdateRecord(set.name, set.rrtype, set.value); err != nil {
+			logging.Fatal(err)
+		}
+		if err := clampTTL(cfg, db, set.name, set.rrtype); err != nil {
+			logging.Fatal(err)
+		}
+	}
+
+	if err := commit(cfg, db, updates); err != nil {
+		sendAlert(cfg, alert.UpdateFailed, "update failed", err.Error())
+		flushEvents()
+		logging.Fatal(err)
+	}
+	noteZones(db)
+	var lines []string
+	for i, c := range changes {
+		if sameValues(olds[i], values(db.Lookup(c.name, c.rrtype))) {
+			continue
+		}
+		noteChange(c.name, c.rrtype, olds[i], c.value)
+		if cfg.DryRun {
+			continue
+		}
+		recordChanged(cfg, db, cliActor(), c.name, c.rrtype, olds[i], c.value)
+		if c.rrtype == dns.TypeA || c.rrtype == dns.TypeAAAA {
+			lines = append(lines, changeLine(c.name, c.rrtype, olds[i], c.value))
+		}
+	}
+	if !cfg.DryRun {
+		if len(lines) > 0 {
+			sendAlert(cfg, alert.IPChanged, "address changed", lines...)
+		}
+		flushEvents()
+	}
+	verifyUpdates(cfg, updates, sets)
+	if failed != nil {
+		msg := fmt.Sprintf("%d master files failed to load", len(failed.Files))
+		logging.Error(msg)
+		finish(exitPartial, msg)
+	}
+}
+
+// verifyUpdates waits for the updates to be visible with -verify, exiting
+// with exitVerifyFailed if they do not appear in time.
+func verifyUpdates(cfg *config, updates []update, sets []recordUpdate) {
+	if !cfg.Verify || cfg.DryRun {
+		return
+	}
+	exps, err := expectations(updates, sets)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if err := verify(cfg, exps); err != nil {
+		sendAlert(cfg, alert.VerifyFailed, "verification failed", err.Error())
+		flushEvents()
+		logging.Error(err)
+		finish(exitVerifyFailed, err.Error())
+	}
+}
+
+// commit writes the modified zones in db, or prints their diff with
+// -dry-run, then re-signs them, notifies secondaries and runs the hooks.
+func commit(cfg *config, db *zonedb.DB, updates []update) error {
+	if cfg.DryRun {
+		if cfg.Quiet {
+			return nil
+		}
+		return db.Diff(os.Stdout)
+	}
 
 	if err := db.Write(); err != nil {
-		log.Fatal(err)
+		return err
+	}
+	return announce(cfg, db, updates)
+}
+
+// announce re-signs the zones changed in db, pushes them to the remote
+// servers, has BIND or CoreDNS load them, sends NOTIFY for them and runs
+// the hooks.
+func announce(cfg *config, db *zonedb.DB, updates []update) error {
+	if err := signChanged(cfg, db); err != nil {
+		return err
+	}
+	if err := pushChanged(cfg, db); err != nil {
+		return err
+	}
+	if err := rndcChanged(cfg, db); err != nil {
+		return err
+	}
+	if err := corednsChanged(cfg, db); err != nil {
+		return err
+	}
+	n, err := newNotifier(cfg)
+	if err != nil {
+		return err
+	}
+	if n.enabled() {
+		n.notifyChanged(db)
+	}
+
+	return runHooks(cfg, db, updates)
+}
+
+// collectUpdates pairs each configured domain with the configured or
+// discovered addresses, followed by any pairs read from standard input.
+func collectUpdates(ctx context.Context, cfg *config) ([]update, error) {
+	var updates []update
+	if len(cfg.Domains) > 0 && len(cfg.IPSet) == 0 {
+		ips, err := addresses(ctx, cfg)
+		switch {
+		case len(ips) == 0 && err != nil:
+			return nil, err
+		case len(ips) == 0:
+			return nil, fmt.Errorf("missing -ip, -ipv4, -ipv6 or -auto-ip for %v", cfg.Domains)
+		case err != nil:
+			logging.Error(err)
+		}
+		for _, domain := range cfg.Domains {
+			for _, ip := range ips {
+				updates = append(updates, update{domain: dns.Fqdn(domain), ip: ip})
+			}
+		}
+	}
+	if cfg.Stdin {
+		pairs, err := readUpdates(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, pairs...)
+	}
+	return updates, nil
+}
+
+// expandUpdates replaces each update of a pattern such as
+// "*.home.example.com." or "@" with updates of the names in db it matches
+// that have records of the address's family.
+func expandUpdates(db *zonedb.DB, updates []update) ([]update, error) {
+	var out []update
+	for _, up := range updates {
+		if !zonedb.IsPattern(up.domain) {
+			name, err := db.Canonical(up.domain)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, update{domain: name, ip: up.ip})
+			continue
+		}
+		rrtype, err := addressType(up.ip)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range expandDomains(db, []string{up.domain}, rrtype) {
+			out = append(out, update{domain: name, ip: up.ip})
+		}
+	}
+	return out, nil
+}
+
+// expandDomains replaces the patterns among domains with the names in db
+// they match that have records of rrtype, warning about those matching
+// none, and aliases with their canonical names when following CNAMEs.
+func expandDomains(db *zonedb.DB, domains []string, rrtype uint16) []string {
+	var names []string
+	for _, domain := range domains {
+		matched := db.Expand(domain, rrtype)
+		if len(matched) == 0 {
+			logging.Warnf("%s matches no %s records", domain, dns.TypeToString[rrtype])
+		}
+		for _, name := range matched {
+			canon, err := db.Canonical(name)
+			if err != nil {
+				logging.Warnf("%v", err)
+				canon = name // UpdateIP reports it
+			}
+			names = append(names, canon)
+		}
+	}
+	return names
+}
+
+// addresses returns the addresses to give the configured domains, at most
+// one of each family: -ip or the -auto-ip discovery, and -ipv4 and -ipv6,
+// each of which may be "auto" to discover it. The families are discovered
+// independently, so the addresses found are returned along with an error
+// for any family that could not be.
+func addresses(ctx context.Context, cfg *config) ([]string, error) {
+	type source struct {
+		flag   string
+		value  string
+		family ipsource.Family
+	}
+	sources := []source{{"ip", cfg.IP, 0}}
+	if cfg.AutoIP {
+		sources[0] = source{"auto-ip", "auto", ipsource.Family(cfg.IPFamily)}
+	}
+	sources = append(sources, source{"ipv4", cfg.IPv4, ipsource.IPv4}, source{"ipv6", cfg.IPv6, ipsource.IPv6})
+
+	var ips, failed []string
+	seen := map[uint16]string{}
+	for _, src := range sources {
+		ip := src.value
+		switch ip {
+		case "":
+			continue
+		case "auto":
+			addr, err := discover(ctx, cfg, src.family)
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("-%s: %v", src.flag, err))
+				continue
+			}
+			ip = addr.String()
+		}
+		rrtype, err := addressType(ip)
+		if err != nil {
+			return nil, fmt.Errorf("-%s: %v", src.flag, err)
+		}
+		if src.family == ipsource.IPv4 && rrtype != dns.TypeA || src.family == ipsource.IPv6 && rrtype != dns.TypeAAAA {
+			return nil, fmt.Errorf("-%s: %s is not an IPv%d address", src.flag, ip, src.family)
+		}
+		if prev, ok := seen[rrtype]; ok && prev != ip {
+			return nil, fmt.Errorf("-%s: conflicting %s addresses %s and %s", src.flag, dns.TypeToString[rrtype], prev, ip)
+		}
+		if _, ok := seen[rrtype]; !ok {
+			seen[rrtype] = ip
+			ips = append(ips, ip)
+		}
+	}
+	if len(failed) > 0 {
+		return ips, fmt.Errorf("address discovery failed: %s", strings.Join(failed, "; "))
+	}
+	return ips, nil
+}
+
+// discover finds the public address of the given family: the first one
+// reported by the IP sources or, with ip_consensus, the one enough of them
+// agree on. Sources that disagree are logged. Discovery is retried, from
+// the first source, if it fails.
+func discover(ctx context.Context, cfg *config, family ipsource.Family) (net.IP, error) {
+	sources := cfg.ipSources()
+	p := cfg.retryPolicy("discovery")
+	if cfg.IPConsensus == "" {
+		var ip net.IP
+		err := p.Do(ctx, "discovery", func() (err error) {
+			ip, err = ipsource.DiscoverContext(ctx, sources, family)
+			return err
+		})
+		return ip, err
+	}
+	if len(sources) == 0 {
+		sources = ipsource.DefaultSources
+	}
+	quorum := len(sources)/2 + 1
+	if cfg.IPConsensus != "majority" {
+		n, err := strconv.Atoi(cfg.IPConsensus)
+		if err != nil || n < 1 || n > len(sources) {
+			return nil, fmt.Errorf("invalid ip_consensus %q: want majority or 1 to %d", cfg.IPConsensus, len(sources))
+		}
+		quorum = n
+	}
+	var ip net.IP
+	var dissent []ipsource.Vote
+	err := p.Do(ctx, "discovery", func() (err error) {
+		ip, dissent, err = ipsource.Consensus(ipsource.PollContext(ctx, sources, family), quorum)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range dissent {
+		logging.Warnf("IP source disagrees with %s: %v", ip, v)
+	}
+	return ip, nil
+}
+
+// parseRecordUpdates parses "name TYPE value" settings.
+func parseRecordUpdates(sets []string) ([]recordUpdate, error) {
+	var rus []recordUpdate
+	for _, set := range sets {
+		fields := strings.Fields(set)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid record %q: want \"name TYPE value\"", set)
+		}
+		rrtype, ok := dns.StringToType[strings.ToUpper(fields[1])]
+		if !ok {
+			return nil, fmt.Errorf("invalid record %q: unknown type %q", set, fields[1])
+		}
+		value := strings.TrimSpace(set)
+		value = strings.TrimSpace(value[len(fields[0]):])
+		value = strings.TrimSpace(value[len(fields[1]):])
+		rus = append(rus, recordUpdate{name: dns.Fqdn(fields[0]), rrtype: rrtype, value: value})
+	}
+	return rus, nil
+}
+
+// readUpdates reads whitespace separated "domain ip" lines, ignoring blank
+// lines and lines starting with '#'.
+func readUpdates(r io.Reader) ([]update, error) {
+	var updates []update
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("stdin:%d: want \"domain ip\", got %q", n, line)
+		}
+		updates = append(updates, update{domain: dns.Fqdn(fields[0]), ip: fields[1]})
 	}
+	return updates, s.Err()
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/ipsource"
+	"github.com/johnweldon/dnsup/pkg/metrics"
+	"github.com/johnweldon/dnsup/pkg/retry"
+)
+
+// daemonMetrics are the daemon's Prometheus metrics. A nil *daemonMetrics
+// records nothing.
+type daemonMetrics struct {
+	reg          *metrics.Registry
+	updates      *metrics.Vec
+	lastChange   *metrics.Vec
+	lastCheck    *metrics.Vec
+	address      *metrics.Vec
+	sourceTime   *metrics.Vec
+	sourceErrors *metrics.Vec
+	writeTime    *metrics.Vec
+	retries      *metrics.Vec
+}
+
+func newDaemonMetrics() *daemonMetrics {
+	reg := metrics.NewRegistry()
+	return &daemonMetrics{
+		reg:          reg,
+		updates:      reg.Counter("dnsup_updates_total", "Domain address updates by result: applied, skipped when unchanged, deferred while settling or rate limited, or failed.", "result"),
+		lastChange:   reg.Gauge("dnsup_last_change_timestamp_seconds", "Unix time the domain's records were last changed.", "domain"),
+		lastCheck:    reg.Gauge("dnsup_last_check_timestamp_seconds", "Unix time of the last address check, by result: ok or error.", "result"),
+		address:      reg.Gauge("dnsup_address_info", "The current address of each family, as a label; always 1.", "family", "ip"),
+		sourceTime:   reg.Histogram("dnsup_ip_source_duration_seconds", "Time taken to query each IP source.", nil, "source"),
+		sourceErrors: reg.Counter("dnsup_ip_source_errors_total", "IP source queries that failed.", "source"),
+		writeTime:    reg.Histogram("dnsup_zone_write_duration_seconds", "Time taken to write the changed master files.", nil),
+		retries:      reg.Counter("dnsup_retries_total", "Network operations retried after failing, by operation: discovery, provider, notify or verify.", "operation"),
+	}
+}
+
+// register adds /metrics to mux and starts timing the IP sources and
+// counting retries.
+func (m *daemonMetrics) register(mux *http.ServeMux) {
+	ipsource.Observe = func(source string, d time.Duration, err error) {
+		m.sourceTime.Observe(d.Seconds(), source)
+		if err != nil {
+			m.sourceErrors.Inc(source)
+		}
+	}
+	retry.Observe = func(op string, attempt int, err error) {
+		m.retries.Inc(op)
+	}
+	mux.Handle("/metrics", m.reg)
+}
+
+// checked records the result of an address check.
+func (m *daemonMetrics) checked(err error) {
+	if m == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.lastCheck.Set(float64(time.Now().Unix()), result)
+}
+
+// counted adds n domain updates with the given result.
+func (m *daemonMetrics) counted(result string, n int) {
+	if m == nil {
+		return
+	}
+	m.updates.Add(float64(n), result)
+}
+
+// changed records that domain now points at ip.
+func (m *daemonMetrics) changed(domain string) {
+	if m == nil {
+		return
+	}
+	m.lastChange.Set(float64(time.Now().Unix()), domain)
+}
+
+// current records ip as the address of its family.
+func (m *daemonMetrics) current(ip string, rrtype uint16) {
+	if m == nil {
+		return
+	}
+	family := "ipv4"
+	if rrtype == dns.TypeAAAA {
+		family = "ipv6"
+	}
+	m.address.Delete(family)
+	m.address.Set(1, family, ip)
+}
+
+// wrote records how long writing the master files took.
+func (m *daemonMetrics) wrote(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.writeTime.Observe(d.Seconds())
+}
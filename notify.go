@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/retry"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// notifier sends DNS NOTIFY messages to secondaries of modified zones.
+type notifier struct {
+	servers []string
+	useNS   bool
+	keyName string
+	keyAlgo string
+	client  *dns.Client
+	retry   retry.Policy
+	zones   map[string]*notifier // of zones with their own notify settings
+}
+
+func newNotifier(cfg *config) (*notifier, error) {
+	n := &notifier{
+		servers: cfg.Notify,
+		useNS:   cfg.NotifyNS,
+		client:  &dns.Client{Timeout: 5 * time.Second},
+		retry:   cfg.retryPolicy("notify"),
+	}
+	key, err := cfg.tsigKey()
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		n.keyName, n.keyAlgo = key.Name, key.Algorithm
+		n.client.TsigSecret = map[string]string{key.Name: key.Secret}
+	}
+	for zone, z := range cfg.ZoneSettings {
+		if z.Notify == nil && z.NotifyNS == nil {
+			continue
+		}
+		if n.zones == nil {
+			n.zones = map[string]*notifier{}
+		}
+		zc := cfg.zoneConfig(zone)
+		zn := *n
+		zn.servers, zn.useNS, zn.zones = zc.Notify, zc.NotifyNS, nil
+		n.zones[zone] = &zn
+	}
+	return n, nil
+}
+
+func (n *notifier) enabled() bool {
+	if len(n.servers) > 0 || n.useNS {
+		return true
+	}
+	for _, zn := range n.zones {
+		if zn.enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyChanged notifies the secondaries of every modified zone in db,
+// logging any that fail.
+func (n *notifier) notifyChanged(db *zonedb.DB) {
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			if !auth.Dirty() {
+				continue
+			}
+			zn := n
+			if z, ok := n.zones[auth.Domain()]; ok {
+				zn = z
+			}
+			for _, err := range zn.notify(auth) {
+				logging.Error(err)
+			}
+		}
+	}
+}
+
+func (n *notifier) notify(auth *zonedb.Authority) []error {
+	soa := auth.SOA()
+	targets := append([]string(nil), n.servers...)
+	if n.useNS {
+		for _, ns := range auth.NS() {
+			if !strings.EqualFold(ns, soa.Ns) {
+				targets = append(targets, ns)
+			}
+		}
+	}
+
+	var errs []error
+	for _, target := range targets {
+		addrs, err := notifyAddrs(target)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("notify %s for %s: %v", target, auth.Domain(), err))
+			continue
+		}
+		for _, addr := range addrs {
+			err := n.retry.Do(context.Background(), "notify", func() error {
+				return n.send(auth.Domain(), soa, addr)
+			})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("notify %s for %s: %v", addr, auth.Domain(), err))
+			}
+		}
+	}
+	return errs
+}
+
+func (n *notifier) send(zone string, soa *dns.SOA, addr string) error {
+	m := new(dns.Msg)
+	m.SetNotify(zone)
+	m.Answer = []dns.RR{soa}
+	if n.keyName != "" {
+		m.SetTsig(n.keyName, n.keyAlgo, tsigFudge, time.Now().Unix())
+	}
+	r, _, err := n.client.Exchange(m, addr)
+	if err != nil {
+		return err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		err := fmt.Errorf("rejected: %s", dns.RcodeToString[r.Rcode])
+		if r.Rcode != dns.RcodeServerFailure {
+			err = retry.Permanent(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// notifyAddrs resolves a host, host:port or address to the addresses
+// NOTIFY should be sent to.
+func notifyAddrs(target string) ([]string, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host, port = strings.TrimSuffix(target, "."), "53"
+	}
+	if net.ParseIP(host) != nil {
+		return []string{net.JoinHostPort(host, port)}, nil
+	}
+	ips, err := net.LookupHost(host)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip, port))
+	}
+	return addrs, nil
+}
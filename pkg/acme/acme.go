@@ -0,0 +1,84 @@
+// Package acme answers ACME dns-01 challenges (RFC 8555 section 8.4) by
+// adding and removing the TXT records of the challenge, through a
+// Provider that satisfies lego's challenge.Provider and
+// challenge.ProviderTimeout interfaces.
+package acme
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultTTL is the TTL of challenge records unless configured otherwise:
+// short, so that resolvers do not keep a stale answer between attempts.
+const DefaultTTL = 60
+
+// Records adds and removes the TXT records of challenges, such as in a
+// master file, publishing each change before returning.
+type Records interface {
+	AddTXT(fqdn, value string, ttl uint32) error
+	RemoveTXT(fqdn, value string) error
+}
+
+// Provider presents and cleans up dns-01 challenges through Records.
+type Provider struct {
+	Records Records
+	TTL     uint32 // of the records; zero is DefaultTTL
+
+	// The time lego waits for a record to propagate, and between its
+	// checks; zero for two minutes and five seconds.
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+}
+
+// Present adds the challenge record for domain with the key authorization
+// keyAuth.
+func (p *Provider) Present(domain, token, keyAuth string) error {
+	fqdn, value := Challenge(domain, keyAuth)
+	ttl := p.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	return p.Records.AddTXT(fqdn, value, ttl)
+}
+
+// CleanUp removes the challenge record Present added.
+func (p *Provider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value := Challenge(domain, keyAuth)
+	return p.Records.RemoveTXT(fqdn, value)
+}
+
+// Timeout returns how long lego should wait for a record to propagate
+// and how often to check.
+func (p *Provider) Timeout() (timeout, interval time.Duration) {
+	timeout, interval = p.PropagationTimeout, p.PollingInterval
+	if timeout == 0 {
+		timeout = 2 * time.Minute
+	}
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	return timeout, interval
+}
+
+// Challenge returns the name and TXT value of the challenge record for
+// domain with the key authorization keyAuth: the unpadded base64url
+// SHA-256 digest of keyAuth at ChallengeName(domain).
+func Challenge(domain, keyAuth string) (fqdn, value string) {
+	sum := sha256.Sum256([]byte(keyAuth))
+	return ChallengeName(domain), base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ChallengeName returns the name of the challenge record of domain, which
+// may be a wildcard or already the challenge name.
+func ChallengeName(domain string) string {
+	domain = dns.Fqdn(strings.TrimPrefix(domain, "*."))
+	if strings.HasPrefix(strings.ToLower(domain), "_acme-challenge.") {
+		return domain
+	}
+	return "_acme-challenge." + domain
+}
@@ -0,0 +1,81 @@
+// Package alert sends short messages about what dnsup did, or failed to
+// do, by email, to a Telegram chat or to an ntfy topic.
+package alert
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The events an alert can be sent for.
+const (
+	IPChanged    = "ip_changed"
+	UpdateFailed = "update_failed"
+	VerifyFailed = "verify_failed"
+)
+
+// Events lists every event, for validating configurations.
+var Events = []string{IPChanged, UpdateFailed, VerifyFailed}
+
+// Message is an alert about one event.
+type Message struct {
+	Event string
+	Title string
+	Body  string
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(m *Message) error
+}
+
+// Filter passes on to Sender only the messages for the listed events, or
+// for every event if there are none.
+type Filter struct {
+	Sender
+	Events []string
+}
+
+// Wants reports whether the filter passes messages for event.
+func (f *Filter) Wants(event string) bool {
+	if len(f.Events) == 0 {
+		return true
+	}
+	for _, e := range f.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidEvent reports whether event is one of Events.
+func ValidEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// post sends req, failing unless it is answered with a 2xx status.
+func post(name string, req *http.Request) error {
+	req.Header.Set("User-Agent", "dnsup")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s: %s", name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
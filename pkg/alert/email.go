@@ -0,0 +1,92 @@
+package alert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Email sends messages through an SMTP server, with STARTTLS when the
+// server offers it or implicit TLS on port 465.
+type Email struct {
+	Addr     string // host:port
+	User     string
+	Password string
+	From     string
+	To       []string
+}
+
+func (e *Email) Send(m *Message) error {
+	if len(e.To) == 0 {
+		return fmt.Errorf("email: no recipients")
+	}
+	host, port, err := net.SplitHostPort(e.Addr)
+	if err != nil {
+		return fmt.Errorf("email: %v", err)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", m.Title)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+	msg.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if e.User != "" {
+		auth = smtp.PlainAuth("", e.User, e.Password, host)
+	}
+	if port != "465" {
+		if err := smtp.SendMail(e.Addr, auth, e.From, e.To, msg.Bytes()); err != nil {
+			return fmt.Errorf("email: %v", err)
+		}
+		return nil
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 15 * time.Second}, "tcp", e.Addr, &tls.Config{ServerName: host})
+	if err != nil {
+		return fmt.Errorf("email: %v", err)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("email: %v", err)
+	}
+	defer c.Close()
+	if err := deliver(c, auth, e.From, e.To, msg.Bytes()); err != nil {
+		return fmt.Errorf("email: %v", err)
+	}
+	return nil
+}
+
+func deliver(c *smtp.Client, auth smtp.Auth, from string, to []string, msg []byte) error {
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,39 @@
+package alert
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Ntfy publishes messages to an ntfy topic, such as
+// https://ntfy.sh/mytopic.
+type Ntfy struct {
+	URL      string
+	Token    string
+	Priority string
+}
+
+// tags are the ntfy emoji tags per event.
+var tags = map[string]string{
+	IPChanged:    "globe_with_meridians",
+	UpdateFailed: "warning",
+	VerifyFailed: "warning",
+}
+
+func (n *Ntfy) Send(m *Message) error {
+	req, err := http.NewRequest("POST", n.URL, strings.NewReader(m.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", m.Title)
+	if tag := tags[m.Event]; tag != "" {
+		req.Header.Set("Tags", tag)
+	}
+	if n.Priority != "" {
+		req.Header.Set("Priority", n.Priority)
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return post("ntfy", req)
+}
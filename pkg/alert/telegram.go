@@ -0,0 +1,32 @@
+package alert
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Telegram sends messages to a chat through a bot.
+type Telegram struct {
+	Token  string
+	ChatID string
+	// API is the Bot API base URL; empty means https://api.telegram.org.
+	API string
+}
+
+func (t *Telegram) Send(m *Message) error {
+	api := t.API
+	if api == "" {
+		api = "https://api.telegram.org"
+	}
+	form := url.Values{
+		"chat_id": {t.ChatID},
+		"text":    {m.Title + "\n\n" + m.Body},
+	}
+	req, err := http.NewRequest("POST", api+"/bot"+t.Token+"/sendMessage", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return post("telegram", req)
+}
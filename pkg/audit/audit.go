@@ -0,0 +1,95 @@
+// Package audit keeps an append-only log of record changes, one JSON
+// object per line, and reads it back for querying.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is one change to the records of a name and type. New is empty
+// when the records were deleted.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Zone   string    `json:"zone,omitempty"`
+	Name   string    `json:"name"`
+	Type   string    `json:"type"`
+	Old    []string  `json:"old"`
+	New    string    `json:"new"`
+	Serial uint32    `json:"serial,omitempty"`
+}
+
+func (e *Entry) String() string {
+	old := "(none)"
+	if len(e.Old) > 0 {
+		old = strings.Join(e.Old, ", ")
+	}
+	nw := e.New
+	if nw == "" {
+		nw = "(deleted)"
+	}
+	s := fmt.Sprintf("%s %s %s %s: %s -> %s", e.Time.UTC().Format(time.RFC3339), e.Actor, e.Name, e.Type, old, nw)
+	if e.Serial != 0 {
+		s += fmt.Sprintf(" (%s serial %d)", e.Zone, e.Serial)
+	}
+	return s
+}
+
+// mu serializes appends within the process; O_APPEND keeps the lines of
+// concurrent processes whole.
+var mu sync.Mutex
+
+// Append adds e to the log at path, creating it if need be.
+func Append(path string, e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read returns the entries in the log at path, oldest first, for which
+// match returns true, or all of them if match is nil.
+func Read(path string, match func(*Entry) bool) ([]*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []*Entry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		if len(strings.TrimSpace(s.Text())) == 0 {
+			continue
+		}
+		e := &Entry{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		if match == nil || match(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, s.Err()
+}
@@ -0,0 +1,81 @@
+// Package catalog publishes the addresses of names into service discovery
+// stores, Consul and etcd, so that services and resolvers using them see
+// the same changes as the zones dnsup manages.
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/johnweldon/dnsup/pkg/retry"
+)
+
+// Store holds the addresses of names.
+type Store interface {
+	// Publish sets the addresses of type typ, "A" or "AAAA", of name, a
+	// fully qualified domain name; none removes them.
+	Publish(ctx context.Context, name, typ string, addrs []string) error
+}
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// do sends a request to url with in as its JSON body, if not nil, and
+// decodes the JSON response into out, if not nil. Failures other than
+// throttled or unavailable servers and lost connections are permanent.
+func do(ctx context.Context, store, method, url string, header http.Header, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return send(store, req, header, out)
+}
+
+// send sends req with header added, decoding the JSON response into out
+// if not nil, for do.
+func send(store string, req *http.Request, header http.Header, out interface{}) error {
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("User-Agent", "dnsup")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %v", store, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return fmt.Errorf("%s: %v", store, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		err := fmt.Errorf("%s: %s %s: %s: %s", store, req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(data)))
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode/100 != 5 {
+			err = retry.Permanent(err)
+		}
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return retry.Permanent(fmt.Errorf("%s: %s %s: %v", store, req.Method, req.URL.Path, err))
+	}
+	return nil
+}
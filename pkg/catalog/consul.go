@@ -0,0 +1,122 @@
+package catalog
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/johnweldon/dnsup/pkg/retry"
+)
+
+// Consul publishes into the Consul agent at URL, such as
+// http://127.0.0.1:8500, with the ACL Token if set. The addresses are
+// stored comma-separated under the KV key Prefix/<name>/<type>, such as
+// dnsup/www.example.com/A, unless Prefix is empty. With Service, each
+// address is also registered with the agent as an instance of a service
+// named after the name with dashes for dots, which Consul's DNS interface
+// serves as www-example-com.service.consul.
+type Consul struct {
+	URL     string
+	Token   string
+	Prefix  string
+	Service bool
+}
+
+// consulService is a service registration, as registered and listed.
+type consulService struct {
+	ID      string            `json:"ID"`
+	Name    string            `json:"Service,omitempty"`
+	Address string            `json:"Address"`
+	Tags    []string          `json:"Tags"`
+	Meta    map[string]string `json:"Meta"`
+}
+
+func (c *Consul) Publish(ctx context.Context, name, typ string, addrs []string) error {
+	name = strings.TrimSuffix(name, ".")
+	if c.Prefix != "" {
+		key := c.URL + "/v1/kv/" + strings.Trim(c.Prefix, "/") + "/" + url.PathEscape(name) + "/" + typ
+		var err error
+		if len(addrs) == 0 {
+			err = c.do(ctx, "DELETE", key, nil, nil)
+		} else {
+			err = c.put(ctx, key, strings.Join(addrs, ","))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if c.Service {
+		return c.register(ctx, name, typ, addrs)
+	}
+	return nil
+}
+
+// register makes the registrations of name's typ addresses those of addrs,
+// deregistering the instances of removed addresses.
+func (c *Consul) register(ctx context.Context, name, typ string, addrs []string) error {
+	var services map[string]consulService
+	if err := c.do(ctx, "GET", c.URL+"/v1/agent/services", nil, &services); err != nil {
+		return err
+	}
+	want := map[string]bool{}
+	for _, a := range addrs {
+		want[a] = true
+	}
+	var ids []string
+	for id, s := range services {
+		if s.Meta["dnsup_name"] != name || s.Meta["dnsup_type"] != typ {
+			continue
+		}
+		if want[s.Address] {
+			delete(want, s.Address)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := c.do(ctx, "PUT", c.URL+"/v1/agent/service/deregister/"+url.PathEscape(id), nil, nil); err != nil {
+			return err
+		}
+	}
+	service := strings.Replace(name, ".", "-", -1)
+	for _, a := range addrs {
+		if !want[a] {
+			continue
+		}
+		s := consulService{
+			ID:      "dnsup-" + service + "-" + a,
+			Name:    service,
+			Address: a,
+			Tags:    []string{"dnsup"},
+			Meta:    map[string]string{"dnsup_name": name, "dnsup_type": typ},
+		}
+		if err := c.do(ctx, "PUT", c.URL+"/v1/agent/service/register", s, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put stores value under the KV key at url, as the raw body.
+func (c *Consul) put(ctx context.Context, url, value string) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, strings.NewReader(value))
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	return send("consul", req, c.header(), nil)
+}
+
+func (c *Consul) do(ctx context.Context, method, url string, in, out interface{}) error {
+	return do(ctx, "consul", method, url, c.header(), in, out)
+}
+
+func (c *Consul) header() http.Header {
+	h := http.Header{}
+	if c.Token != "" {
+		h.Set("X-Consul-Token", c.Token)
+	}
+	return h
+}
@@ -0,0 +1,79 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Etcd publishes into etcd through the JSON gateway of its v3 API at URL,
+// such as http://127.0.0.1:2379, authenticating as User with Password if
+// set. Each address is stored as a SkyDNS record, as the CoreDNS etcd
+// plugin reads them, under Prefix ("/skydns" by default) and the labels of
+// the name reversed: the first address of www.example.com is
+// {"host":"192.0.2.1"} at /skydns/com/example/www/dnsup-a-1, which CoreDNS
+// serves for www.example.com. Other keys under the name are left alone.
+type Etcd struct {
+	URL      string
+	User     string
+	Password string
+	Prefix   string
+}
+
+// skydns is the value of a SkyDNS record.
+type skydns struct {
+	Host string `json:"host"`
+}
+
+func (e *Etcd) Publish(ctx context.Context, name, typ string, addrs []string) error {
+	h := http.Header{}
+	if e.User != "" {
+		var resp struct {
+			Token string `json:"token"`
+		}
+		auth := map[string]string{"name": e.User, "password": e.Password}
+		if err := do(ctx, "etcd", "POST", e.URL+"/v3/auth/authenticate", nil, auth, &resp); err != nil {
+			return err
+		}
+		h.Set("Authorization", resp.Token)
+	}
+	prefix := e.key(name) + "/dnsup-" + strings.ToLower(typ) + "-"
+	del := map[string][]byte{"key": []byte(prefix), "range_end": rangeEnd(prefix)}
+	if err := do(ctx, "etcd", "POST", e.URL+"/v3/kv/deleterange", h, del, nil); err != nil {
+		return err
+	}
+	for i, a := range addrs {
+		value, err := json.Marshal(skydns{Host: a})
+		if err != nil {
+			return err
+		}
+		put := map[string][]byte{"key": []byte(fmt.Sprintf("%s%d", prefix, i+1)), "value": value}
+		if err := do(ctx, "etcd", "POST", e.URL+"/v3/kv/put", h, put, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// key returns the SkyDNS key of name, such as /skydns/com/example/www.
+func (e *Etcd) key(name string) string {
+	prefix := strings.TrimSuffix(e.Prefix, "/")
+	if prefix == "" {
+		prefix = "/skydns"
+	}
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return prefix + "/" + strings.Join(labels, "/")
+}
+
+// rangeEnd returns the end of the range of keys starting with prefix,
+// which must not end in 0xff.
+func rangeEnd(prefix string) []byte {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	return end
+}
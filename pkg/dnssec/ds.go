@@ -0,0 +1,65 @@
+package dnssec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// digests maps the supported DS digest names to their types.
+var digests = map[string]uint8{
+	"sha256": dns.SHA256,
+	"sha384": dns.SHA384,
+}
+
+// ParseDigest returns the DS digest type named sha256 or sha384.
+func ParseDigest(name string) (uint8, error) {
+	d, ok := digests[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported DS digest %q (want sha256 or sha384)", name)
+	}
+	return d, nil
+}
+
+// DS returns the DS record for key that the parent zone publishes.
+func DS(k *Key, digest uint8) (*dns.DS, error) {
+	ds := k.DNSKEY.ToDS(digest)
+	if ds == nil {
+		return nil, fmt.Errorf("key %s: cannot make a DS record with digest %d", k.Path, digest)
+	}
+	return ds, nil
+}
+
+// CDS returns the child DS record asking the parent to publish the DS of
+// key (RFC 7344).
+func CDS(k *Key, digest uint8) (*dns.CDS, error) {
+	ds, err := DS(k, digest)
+	if err != nil {
+		return nil, err
+	}
+	return ds.ToCDS(), nil
+}
+
+// CDNSKEY returns the child DNSKEY record asking the parent to publish a
+// DS for key (RFC 7344).
+func CDNSKEY(k *Key) *dns.CDNSKEY {
+	return k.DNSKEY.ToCDNSKEY()
+}
+
+// DeleteRecords returns the CDS and CDNSKEY records of zone asking the
+// parent to remove its DS records, turning DNSSEC off (RFC 8078).
+func DeleteRecords(zone string, ttl uint32) []dns.RR {
+	zone = dns.Fqdn(zone)
+	return []dns.RR{
+		&dns.CDS{DS: dns.DS{
+			Hdr:    dns.RR_Header{Name: zone, Rrtype: dns.TypeCDS, Class: dns.ClassINET, Ttl: ttl},
+			Digest: "00",
+		}},
+		&dns.CDNSKEY{DNSKEY: dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeCDNSKEY, Class: dns.ClassINET, Ttl: ttl},
+			Protocol:  3,
+			PublicKey: "AA==",
+		}},
+	}
+}
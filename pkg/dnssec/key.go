@@ -0,0 +1,107 @@
+// Package dnssec loads DNSSEC signing keys and signs zones with them,
+// generating the DNSKEY, RRSIG and NSEC or NSEC3 records.
+package dnssec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Key is a DNSSEC key pair as written by dnssec-keygen or ldns-keygen: a
+// K<zone>+<alg>+<tag>.key file holding the DNSKEY record and a .private
+// file beside it holding the private key.
+type Key struct {
+	Path   string
+	DNSKEY *dns.DNSKEY
+	Signer crypto.Signer
+}
+
+// LoadKey loads a key pair given the path of either file or their common
+// prefix.
+func LoadKey(path string) (*Key, error) {
+	base := strings.TrimSuffix(strings.TrimSuffix(path, ".key"), ".private")
+	pub, err := ioutil.ReadFile(base + ".key")
+	if err != nil {
+		return nil, err
+	}
+	k := &Key{Path: base}
+	s := bufio.NewScanner(bytes.NewReader(pub))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == ';' {
+			continue
+		}
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s.key: %v", base, err)
+		}
+		if key, ok := rr.(*dns.DNSKEY); ok {
+			k.DNSKEY = key
+			break
+		}
+	}
+	if k.DNSKEY == nil {
+		return nil, fmt.Errorf("%s.key: no DNSKEY record", base)
+	}
+	k.DNSKEY.Hdr.Name = strings.ToLower(dns.Fqdn(k.DNSKEY.Hdr.Name))
+
+	priv, err := ioutil.ReadFile(base + ".private")
+	if err != nil {
+		return nil, err
+	}
+	pk, err := k.DNSKEY.ReadPrivateKey(bytes.NewReader(priv), base+".private")
+	if err != nil {
+		return nil, fmt.Errorf("%s.private: %v", base, err)
+	}
+	signer, ok := pk.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s.private: %T cannot sign", base, pk)
+	}
+	k.Signer = signer
+	return k, nil
+}
+
+// LoadKeys loads the key pairs at each of paths.
+func LoadKeys(paths []string) ([]*Key, error) {
+	var keys []*Key
+	for _, p := range paths {
+		k, err := LoadKey(p)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// Zone returns the zone the key belongs to, the owner of its DNSKEY.
+func (k *Key) Zone() string {
+	return k.DNSKEY.Hdr.Name
+}
+
+// KSK reports whether the key is a key signing key, with the SEP flag.
+func (k *Key) KSK() bool {
+	return k.DNSKEY.Flags&dns.SEP != 0
+}
+
+// Tag returns the key tag of the key.
+func (k *Key) Tag() uint16 {
+	return k.DNSKEY.KeyTag()
+}
+
+// ForZone returns the keys belonging to zone.
+func ForZone(keys []*Key, zone string) []*Key {
+	var out []*Key
+	for _, k := range keys {
+		if strings.EqualFold(k.Zone(), dns.Fqdn(zone)) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
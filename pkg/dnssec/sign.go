@@ -0,0 +1,349 @@
+package dnssec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Options control how a zone is signed.
+type Options struct {
+	// Inception and Expiration bound the validity of the signatures.
+	Inception  time.Time
+	Expiration time.Time
+
+	// NSEC3 selects hashed denial of existence (RFC 5155) with the given
+	// iterations and hex encoded salt, instead of an NSEC chain.
+	NSEC3      bool
+	Iterations uint16
+	Salt       string
+}
+
+// Sign returns the records of the zone at origin signed with keys. Any
+// signatures and NSEC or NSEC3 records in rrs are dropped and generated
+// anew, and the DNSKEY of each key is added at the apex. Key signing keys
+// sign the DNSKEY RRset and zone signing keys everything else; a zone
+// with only one kind signs everything with it. Names below a delegation
+// are left unsigned as glue. The SOA comes first in the result.
+func Sign(origin string, rrs []dns.RR, keys []*Key, opt Options) ([]dns.RR, error) {
+	origin = strings.ToLower(dns.Fqdn(origin))
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no signing keys", origin)
+	}
+	z := &zone{origin: origin, sets: map[string]map[uint16][]dns.RR{}, seen: map[string]bool{}}
+	for _, rr := range rrs {
+		switch rr.Header().Rrtype {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeNSEC3PARAM:
+			continue
+		}
+		if !dns.IsSubDomain(origin, strings.ToLower(rr.Header().Name)) {
+			return nil, fmt.Errorf("%s: %s is outside the zone", origin, rr.Header().Name)
+		}
+		z.add(dns.Copy(rr))
+	}
+	soas := z.sets[origin][dns.TypeSOA]
+	if len(soas) != 1 {
+		return nil, fmt.Errorf("%s: want one SOA at the apex, have %d", origin, len(soas))
+	}
+	soa := soas[0].(*dns.SOA)
+	ttl := soa.Minttl
+	if soa.Hdr.Ttl < ttl {
+		ttl = soa.Hdr.Ttl
+	}
+
+	var zsks, ksks []*Key
+	for _, k := range keys {
+		if k.Zone() != origin {
+			return nil, fmt.Errorf("%s: key %s is for zone %s", origin, k.Path, k.Zone())
+		}
+		z.dropKey(k.DNSKEY)
+		dk := dns.Copy(k.DNSKEY).(*dns.DNSKEY)
+		if dk.Hdr.Ttl == 0 {
+			dk.Hdr.Ttl = soa.Hdr.Ttl
+		}
+		z.add(dk)
+		if k.KSK() {
+			ksks = append(ksks, k)
+		} else {
+			zsks = append(zsks, k)
+		}
+	}
+	if len(zsks) == 0 {
+		zsks = ksks
+	}
+	if len(ksks) == 0 {
+		ksks = zsks
+	}
+
+	z.findCuts()
+	if opt.NSEC3 {
+		z.nsec3Chain(ttl, opt.Iterations, opt.Salt)
+	} else {
+		z.nsecChain(ttl)
+	}
+
+	signed := &zone{origin: origin, sets: map[string]map[uint16][]dns.RR{}, seen: map[string]bool{}}
+	for _, name := range z.names() {
+		for _, t := range z.types(name) {
+			set := z.sets[name][t]
+			for _, rr := range set {
+				signed.add(rr)
+			}
+			if z.occluded(name) || z.cuts[name] && t != dns.TypeDS && t != dns.TypeNSEC {
+				continue
+			}
+			signers := zsks
+			if t == dns.TypeDNSKEY && name == origin {
+				signers = ksks
+			}
+			for _, k := range signers {
+				sig, err := signSet(k, origin, set, opt)
+				if err != nil {
+					return nil, err
+				}
+				signed.add(sig)
+			}
+		}
+	}
+	return signed.records(), nil
+}
+
+// signSet returns the signature of key over set.
+func signSet(k *Key, origin string, set []dns.RR, opt Options) (*dns.RRSIG, error) {
+	hdr := set[0].Header()
+	labels := dns.CountLabel(hdr.Name)
+	if strings.HasPrefix(hdr.Name, "*.") {
+		labels--
+	}
+	sig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: hdr.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: hdr.Ttl},
+		TypeCovered: hdr.Rrtype,
+		Algorithm:   k.DNSKEY.Algorithm,
+		Labels:      uint8(labels),
+		OrigTtl:     hdr.Ttl,
+		Inception:   uint32(opt.Inception.Unix()),
+		Expiration:  uint32(opt.Expiration.Unix()),
+		KeyTag:      k.Tag(),
+		SignerName:  origin,
+	}
+	if err := sig.Sign(k.Signer, set); err != nil {
+		return nil, fmt.Errorf("signing %s %s with key %d: %v", hdr.Name, dns.TypeToString[hdr.Rrtype], k.Tag(), err)
+	}
+	return sig, nil
+}
+
+// zone holds the RRsets of a zone by owner and type. Owner names are kept
+// in lower case, the canonical form signatures are made over.
+type zone struct {
+	origin string
+	sets   map[string]map[uint16][]dns.RR
+	seen   map[string]bool
+	cuts   map[string]bool
+}
+
+func (z *zone) add(rr dns.RR) {
+	hdr := rr.Header()
+	hdr.Name = strings.ToLower(hdr.Name)
+	s := rr.String()
+	if z.seen[s] {
+		return
+	}
+	z.seen[s] = true
+	if z.sets[hdr.Name] == nil {
+		z.sets[hdr.Name] = map[uint16][]dns.RR{}
+	}
+	z.sets[hdr.Name][hdr.Rrtype] = append(z.sets[hdr.Name][hdr.Rrtype], rr)
+}
+
+// dropKey removes a DNSKEY record for key already in the zone, which is
+// replaced by the key's own.
+func (z *zone) dropKey(key *dns.DNSKEY) {
+	set := z.sets[z.origin][dns.TypeDNSKEY]
+	out := set[:0]
+	for _, rr := range set {
+		if dk := rr.(*dns.DNSKEY); dk.PublicKey != key.PublicKey || dk.Algorithm != key.Algorithm {
+			out = append(out, rr)
+		} else {
+			delete(z.seen, rr.String())
+		}
+	}
+	z.sets[z.origin][dns.TypeDNSKEY] = out
+}
+
+// findCuts records the delegation points, names below the apex with NS
+// records.
+func (z *zone) findCuts() {
+	z.cuts = map[string]bool{}
+	for name, sets := range z.sets {
+		if _, ok := sets[dns.TypeNS]; ok && name != z.origin {
+			z.cuts[name] = true
+		}
+	}
+}
+
+// occluded reports whether name is below a delegation point, so that its
+// records are glue rather than authoritative data.
+func (z *zone) occluded(name string) bool {
+	for n := parent(name); n != z.origin && n != "."; n = parent(n) {
+		if z.cuts[n] {
+			return true
+		}
+	}
+	return false
+}
+
+// names returns the owner names in canonical order.
+func (z *zone) names() []string {
+	var names []string
+	for name := range z.sets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return CanonicalLess(names[i], names[j]) })
+	return names
+}
+
+// authoritative returns the owner names in canonical order that the zone
+// is authoritative for, including its delegation points.
+func (z *zone) authoritative() []string {
+	var names []string
+	for _, name := range z.names() {
+		if !z.occluded(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// types returns the types at name in ascending order.
+func (z *zone) types(name string) []uint16 {
+	var types []uint16
+	for t, set := range z.sets[name] {
+		if len(set) > 0 {
+			types = append(types, t)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// bitmap returns the types to list in the NSEC or NSEC3 record of name:
+// only NS and DS at a delegation point.
+func (z *zone) bitmap(name string) []uint16 {
+	var types []uint16
+	for _, t := range z.types(name) {
+		if !z.cuts[name] || t == dns.TypeNS || t == dns.TypeDS {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+func (z *zone) nsecChain(ttl uint32) {
+	names := z.authoritative()
+	for i, name := range names {
+		types := append(z.bitmap(name), dns.TypeRRSIG, dns.TypeNSEC)
+		sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+		z.add(&dns.NSEC{
+			Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl},
+			NextDomain: names[(i+1)%len(names)],
+			TypeBitMap: types,
+		})
+	}
+}
+
+func (z *zone) nsec3Chain(ttl uint32, iterations uint16, salt string) {
+	z.add(&dns.NSEC3PARAM{
+		Hdr:        dns.RR_Header{Name: z.origin, Rrtype: dns.TypeNSEC3PARAM, Class: dns.ClassINET},
+		Hash:       dns.SHA1,
+		Iterations: iterations,
+		SaltLength: uint8(len(salt) / 2),
+		Salt:       salt,
+	})
+
+	// Empty non-terminals get NSEC3 records of their own.
+	all := map[string]bool{}
+	for _, name := range z.authoritative() {
+		all[name] = true
+		for p := parent(name); p != z.origin && dns.IsSubDomain(z.origin, p); p = parent(p) {
+			all[p] = true
+		}
+	}
+	type hashed struct {
+		hash  string
+		types []uint16
+	}
+	var chain []hashed
+	for name := range all {
+		types := z.bitmap(name)
+		if len(types) > 0 && !(z.cuts[name] && len(z.sets[name][dns.TypeDS]) == 0) {
+			types = append(types, dns.TypeRRSIG)
+		}
+		sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+		chain = append(chain, hashed{dns.HashName(name, dns.SHA1, iterations, salt), types})
+	}
+	sort.Slice(chain, func(i, j int) bool { return chain[i].hash < chain[j].hash })
+	for i, h := range chain {
+		z.add(&dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: strings.ToLower(h.hash) + "." + z.origin, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: ttl},
+			Hash:       dns.SHA1,
+			Iterations: iterations,
+			SaltLength: uint8(len(salt) / 2),
+			Salt:       salt,
+			HashLength: 20,
+			NextDomain: chain[(i+1)%len(chain)].hash,
+			TypeBitMap: h.types,
+		})
+	}
+}
+
+// records returns the zone's records, the SOA first and the rest in
+// canonical order with each RRset followed by its signatures.
+func (z *zone) records() []dns.RR {
+	var rrs []dns.RR
+	rrs = append(rrs, z.sets[z.origin][dns.TypeSOA]...)
+	rrs = append(rrs, z.sigs(z.origin, dns.TypeSOA)...)
+	for _, name := range z.names() {
+		for _, t := range z.types(name) {
+			if t == dns.TypeRRSIG || name == z.origin && t == dns.TypeSOA {
+				continue
+			}
+			rrs = append(rrs, z.sets[name][t]...)
+			rrs = append(rrs, z.sigs(name, t)...)
+		}
+	}
+	return rrs
+}
+
+// sigs returns the signatures at name covering t.
+func (z *zone) sigs(name string, t uint16) []dns.RR {
+	var sigs []dns.RR
+	for _, rr := range z.sets[name][dns.TypeRRSIG] {
+		if rr.(*dns.RRSIG).TypeCovered == t {
+			sigs = append(sigs, rr)
+		}
+	}
+	return sigs
+}
+
+// parent returns name without its first label.
+func parent(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 && i < len(name)-1 {
+		return name[i+1:]
+	}
+	return "."
+}
+
+// CanonicalLess orders lower case names as RFC 4034 section 6.1 does,
+// comparing labels from the root down.
+func CanonicalLess(a, b string) bool {
+	la, lb := dns.SplitDomainName(a), dns.SplitDomainName(b)
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if la[i] != lb[j] {
+			return la[i] < lb[j]
+		}
+	}
+	return len(la) < len(lb)
+}
@@ -0,0 +1,162 @@
+// Package docker lists the running containers of a Docker engine that
+// carry dnsup labels, and follows the engine's events, through its HTTP
+// API.
+package docker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Labels recognized on containers. LabelHostname lists the hosts to
+// publish; the others are optional.
+const (
+	LabelHostname = "dnsup.hostname"
+	LabelNetwork  = "dnsup.network" // publish the container's address on this network
+	LabelTarget   = "dnsup.target"  // publish these addresses instead
+	LabelTTL      = "dnsup.ttl"
+)
+
+// DefaultHost is the engine's socket when neither the host nor
+// $DOCKER_HOST is given.
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// Client talks to a Docker engine.
+type Client struct {
+	base string
+	http *http.Client
+}
+
+// NewClient returns a client for the engine at host, a unix:// socket
+// path or a tcp:// or http:// address, defaulting to $DOCKER_HOST and then
+// DefaultHost.
+func NewClient(host string) (*Client, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = DefaultHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		tr := &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.DialTimeout("unix", path, 10*time.Second)
+			},
+		}
+		return &Client{base: "http://docker", http: &http.Client{Transport: tr}}, nil
+	case "tcp", "http":
+		return &Client{base: "http://" + u.Host, http: &http.Client{}}, nil
+	}
+	return nil, fmt.Errorf("unsupported docker host %q: want unix://, tcp:// or http://", host)
+}
+
+// Container is a running container with a LabelHostname label.
+type Container struct {
+	ID       string
+	Name     string
+	Labels   map[string]string
+	Networks map[string]Network
+}
+
+// Network is a container's attachment to a network.
+type Network struct {
+	IPAddress         string
+	GlobalIPv6Address string
+}
+
+func (c *Client) get(path string, timeout time.Duration) (*http.Response, error) {
+	hc := *c.http
+	hc.Timeout = timeout
+	resp, err := hc.Get(c.base + path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
+
+// Containers returns the running containers labeled LabelHostname.
+func (c *Client) Containers() ([]Container, error) {
+	filters, _ := json.Marshal(map[string][]string{"label": {LabelHostname}, "status": {"running"}})
+	resp, err := c.get("/containers/json?filters="+url.QueryEscape(string(filters)), 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var list []struct {
+		ID              string `json:"Id"`
+		Names           []string
+		Labels          map[string]string
+		NetworkSettings struct {
+			Networks map[string]Network
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("listing containers: %v", err)
+	}
+	var cs []Container
+	for _, l := range list {
+		name := l.ID
+		if len(l.Names) > 0 {
+			name = strings.TrimPrefix(l.Names[0], "/")
+		}
+		cs = append(cs, Container{ID: l.ID, Name: name, Labels: l.Labels, Networks: l.NetworkSettings.Networks})
+	}
+	return cs, nil
+}
+
+// Events follows the engine's container events, sending on the returned
+// channel, without blocking, whenever a container starts, stops or
+// changes networks. It starts over after errors for as long as the
+// process runs.
+func (c *Client) Events() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	go func() {
+		for {
+			if err := c.events(ch); err != nil {
+				time.Sleep(5 * time.Second)
+			}
+		}
+	}()
+	return ch
+}
+
+func (c *Client) events(ch chan<- struct{}) error {
+	filters, _ := json.Marshal(map[string][]string{
+		"type":  {"container", "network"},
+		"event": {"start", "die", "stop", "kill", "destroy", "connect", "disconnect"},
+	})
+	resp, err := c.get("/events?filters="+url.QueryEscape(string(filters)), 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	s := bufio.NewScanner(resp.Body)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,147 @@
+// Package dyndns updates addresses through dynamic DNS services: the
+// dyndns2 protocol used by No-IP, DynDNS and many routers, and the token
+// based update URLs of DuckDNS and FreeDNS.
+package dyndns
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// UserAgent identifies dnsup to update servers, which may block clients
+// that do not send one.
+const UserAgent = "dnsup/dyndns2"
+
+// Return codes of the dyndns2 protocol.
+const (
+	Good     = "good"     // the update succeeded
+	NoChange = "nochg"    // the address was already current
+	BadAuth  = "badauth"  // the credentials were rejected
+	Donator  = "!donator" // the request needs a paid account
+	NotFQDN  = "notfqdn"  // the host name is not fully qualified
+	NoHost   = "nohost"   // the host name does not exist for this user
+	NumHost  = "numhost"  // too many host names in the request
+	Abuse    = "abuse"    // the host name is blocked for abuse
+	BadAgent = "badagent" // the user agent or request was rejected
+	DNSErr   = "dnserr"   // a server side DNS error
+	Shutdown = "911"      // a server side problem or maintenance
+)
+
+// RetryAfter is how long to wait before contacting a server again after
+// it returned 911 or dnserr.
+const RetryAfter = 30 * time.Minute
+
+// Error is a dyndns2 return code other than good or nochg.
+type Error struct {
+	Host string
+	Code string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dyndns2: %s: %s", e.Host, e.Code)
+}
+
+// Fatal reports whether the code means that retrying cannot succeed until
+// the configuration is fixed; servers may block clients that keep trying.
+func (e *Error) Fatal() bool {
+	switch e.Code {
+	case BadAuth, Donator, NotFQDN, NoHost, NumHost, Abuse, BadAgent:
+		return true
+	}
+	return false
+}
+
+// Client sends updates to a dyndns2 server. After a fatal error it refuses
+// further updates, and after a server error it waits RetryAfter, as the
+// protocol requires of clients.
+type Client struct {
+	URL      string // update endpoint, such as https://members.dyndns.org/nic/update
+	User     string
+	Password string
+	HTTP     *http.Client
+
+	halted    error
+	holdUntil time.Time
+}
+
+// NewClient returns a client for the update endpoint u. A URL without a
+// path gets the usual /nic/update.
+func NewClient(u, user, password string) (*Client, error) {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("dyndns2: invalid server URL %q", u)
+	}
+	if parsed.Path == "" || parsed.Path == "/" {
+		parsed.Path = "/nic/update"
+	}
+	if parsed.User != nil {
+		if user == "" {
+			user = parsed.User.Username()
+		}
+		if p, ok := parsed.User.Password(); ok && password == "" {
+			password = p
+		}
+		parsed.User = nil
+	}
+	return &Client{
+		URL:      parsed.String(),
+		User:     user,
+		Password: password,
+		HTTP:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Update points host at ip. It reports whether the server changed the
+// record, false meaning nochg.
+func (c *Client) Update(ctx context.Context, host, ip string) (bool, error) {
+	if c.halted != nil {
+		return false, fmt.Errorf("not retrying after %v", c.halted)
+	}
+	if now := time.Now(); now.Before(c.holdUntil) {
+		return false, fmt.Errorf("dyndns2: server unavailable, retrying after %s", c.holdUntil.Format(time.Kitchen))
+	}
+
+	q := url.Values{"hostname": {strings.TrimSuffix(host, ".")}, "myip": {ip}}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.URL+"?"+q.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.SetBasicAuth(c.User, c.Password)
+	req.Header.Set("User-Agent", UserAgent)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(io.LimitReader(resp.Body, 4096)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	code := strings.TrimSpace(line)
+	if i := strings.IndexByte(code, ' '); i >= 0 {
+		code = code[:i]
+	}
+
+	switch code {
+	case Good:
+		return true, nil
+	case NoChange:
+		return false, nil
+	case "":
+		return false, fmt.Errorf("dyndns2: %s: %s with no return code", host, resp.Status)
+	}
+	e := &Error{Host: host, Code: code}
+	switch {
+	case e.Fatal():
+		c.halted = e
+	case code == Shutdown || code == DNSErr:
+		c.holdUntil = time.Now().Add(RetryAfter)
+	}
+	return false, e
+}
@@ -0,0 +1,62 @@
+package dyndns
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// MaxHosts is the most host names accepted in one update request.
+const MaxHosts = 20
+
+// Server is an http.Handler for the dyndns2 /nic/update endpoint.
+type Server struct {
+	// Authenticate reports whether password is valid for user.
+	Authenticate func(user, password string) bool
+	// Update points host at ip on behalf of user and returns Good,
+	// NoChange or another return code.
+	Update func(user, host, ip string) string
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	user, password, ok := r.BasicAuth()
+	if !ok || !s.Authenticate(user, password) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="dnsup"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, BadAuth)
+		return
+	}
+
+	q := r.URL.Query()
+	var hosts []string
+	for _, h := range strings.Split(q.Get("hostname"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	switch {
+	case len(hosts) == 0:
+		fmt.Fprintln(w, NotFQDN)
+		return
+	case len(hosts) > MaxHosts:
+		fmt.Fprintln(w, NumHost)
+		return
+	}
+
+	// As in the protocol, a missing or malformed myip means the address
+	// the request came from.
+	ip := q.Get("myip")
+	if net.ParseIP(ip) == nil {
+		ip, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	for _, host := range hosts {
+		switch code := s.Update(user, host, ip); code {
+		case Good, NoChange:
+			fmt.Fprintf(w, "%s %s\n", code, ip)
+		default:
+			fmt.Fprintln(w, code)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package dyndns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Updater points host names at addresses through a dynamic DNS service.
+// Update reports whether the service changed the record.
+type Updater interface {
+	Update(ctx context.Context, host, ip string) (bool, error)
+}
+
+// DuckDNS updates subdomains of duckdns.org with an account token.
+type DuckDNS struct {
+	Token string
+	HTTP  *http.Client
+}
+
+// NewDuckDNS returns an updater using the account token.
+func NewDuckDNS(token string) (*DuckDNS, error) {
+	if token == "" {
+		return nil, fmt.Errorf("duckdns: missing token")
+	}
+	return &DuckDNS{Token: token, HTTP: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Update points host, a duckdns.org name or just its first label, at ip.
+func (d *DuckDNS) Update(ctx context.Context, host, ip string) (bool, error) {
+	sub := strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(host), "."), ".duckdns.org")
+	q := url.Values{"domains": {sub}, "token": {d.Token}, "verbose": {"true"}}
+	if a := net.ParseIP(ip); a != nil && a.To4() == nil {
+		q.Set("ipv6", ip)
+	} else {
+		q.Set("ip", ip)
+	}
+	body, err := get(ctx, d.HTTP, "https://www.duckdns.org/update?"+q.Encode())
+	if err != nil {
+		return false, fmt.Errorf("duckdns: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if lines[0] != "OK" {
+		return false, fmt.Errorf("duckdns: %s: update rejected (%s)", host, lines[0])
+	}
+	return strings.TrimSpace(lines[len(lines)-1]) != "NOCHANGE", nil
+}
+
+// FreeDNS updates afraid.org hosts through their per host update tokens.
+// Tokens maps host names to tokens; Token is used for any host not
+// listed.
+type FreeDNS struct {
+	Token  string
+	Tokens map[string]string
+	HTTP   *http.Client
+}
+
+// NewFreeDNS returns an updater using the default token and the per host
+// tokens.
+func NewFreeDNS(token string, tokens map[string]string) (*FreeDNS, error) {
+	f := &FreeDNS{Token: token, Tokens: map[string]string{}, HTTP: &http.Client{Timeout: 30 * time.Second}}
+	for host, tok := range tokens {
+		f.Tokens[strings.ToLower(strings.TrimSuffix(host, "."))] = tok
+	}
+	if token == "" && len(f.Tokens) == 0 {
+		return nil, fmt.Errorf("freedns: missing token")
+	}
+	return f, nil
+}
+
+func (f *FreeDNS) Update(ctx context.Context, host, ip string) (bool, error) {
+	token := f.Tokens[strings.ToLower(strings.TrimSuffix(host, "."))]
+	if token == "" {
+		token = f.Token
+	}
+	if token == "" {
+		return false, fmt.Errorf("freedns: no token for %s", host)
+	}
+	body, err := get(ctx, f.HTTP, "https://freedns.afraid.org/dynamic/update.php?"+url.QueryEscape(token)+"&address="+url.QueryEscape(ip))
+	if err != nil {
+		return false, fmt.Errorf("freedns: %v", err)
+	}
+	body = strings.TrimSpace(body)
+	switch {
+	case strings.HasPrefix(body, "Updated"):
+		return true, nil
+	case strings.Contains(body, "has not changed"):
+		return false, nil
+	}
+	return false, fmt.Errorf("freedns: %s: %s", host, body)
+}
+
+func get(ctx context.Context, client *http.Client, u string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,26 @@
+package grpcapi
+
+import "fmt"
+
+// codec encodes the messages of this package. It is forced on the server
+// in place of the generated-code protobuf codec and is compatible with it
+// on the wire.
+type codec struct{}
+
+func (codec) Name() string { return "proto" }
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("grpcapi: cannot marshal %T", v)
+	}
+	return m.marshal(nil), nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(message)
+	if !ok {
+		return fmt.Errorf("grpcapi: cannot unmarshal into %T", v)
+	}
+	return unmarshal(data, m)
+}
@@ -0,0 +1,272 @@
+package grpcapi
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// message is implemented by each message type to encode itself in the
+// protobuf wire format described by dnsup.proto.
+type message interface {
+	marshal(b []byte) []byte
+	field(num protowire.Number, typ protowire.Type, b []byte) (int, error)
+}
+
+// Record is a resource record with its data in presentation format.
+type Record struct {
+	Name  string
+	Type  string
+	TTL   uint32
+	Value string
+}
+
+type UpdateRecordRequest struct {
+	Name  string
+	Type  string
+	Value string
+	TTL   uint32
+}
+
+type UpdateRecordResponse struct {
+	Records []*Record
+}
+
+type ListRecordsRequest struct {
+	Zone string
+	Name string
+	Type string
+}
+
+type ListRecordsResponse struct {
+	Records []*Record
+}
+
+type WatchChangesRequest struct {
+	Zone string
+}
+
+// Change is a record update, sent to WatchChanges streams once written.
+type Change struct {
+	Zone   string
+	Name   string
+	Type   string
+	Value  string
+	Serial uint32
+	Time   int64
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendMessage(b []byte, num protowire.Number, m message) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m.marshal(nil))
+}
+
+// unmarshal decodes b into m, skipping unknown fields.
+func unmarshal(b []byte, m message) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := m.field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// The decode helpers consume one field value of the expected wire type,
+// returning 0 to skip a value of another type.
+
+func decodeString(typ protowire.Type, b []byte, s *string) int {
+	if typ != protowire.BytesType {
+		return 0
+	}
+	v, n := protowire.ConsumeString(b)
+	if n > 0 {
+		*s = v
+	}
+	return n
+}
+
+func decodeVarint(typ protowire.Type, b []byte) (uint64, int) {
+	if typ != protowire.VarintType {
+		return 0, 0
+	}
+	return protowire.ConsumeVarint(b)
+}
+
+func decodeRecord(typ protowire.Type, b []byte, recs *[]*Record) (int, error) {
+	if typ != protowire.BytesType {
+		return 0, nil
+	}
+	v, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return n, nil
+	}
+	rec := &Record{}
+	if err := unmarshal(v, rec); err != nil {
+		return 0, fmt.Errorf("record: %v", err)
+	}
+	*recs = append(*recs, rec)
+	return n, nil
+}
+
+func (m *Record) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.Name)
+	b = appendString(b, 2, m.Type)
+	b = appendVarint(b, 3, uint64(m.TTL))
+	return appendString(b, 4, m.Value)
+}
+
+func (m *Record) field(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	switch num {
+	case 1:
+		return decodeString(typ, b, &m.Name), nil
+	case 2:
+		return decodeString(typ, b, &m.Type), nil
+	case 3:
+		v, n := decodeVarint(typ, b)
+		m.TTL = uint32(v)
+		return n, nil
+	case 4:
+		return decodeString(typ, b, &m.Value), nil
+	}
+	return 0, nil
+}
+
+func (m *UpdateRecordRequest) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.Name)
+	b = appendString(b, 2, m.Type)
+	b = appendString(b, 3, m.Value)
+	return appendVarint(b, 4, uint64(m.TTL))
+}
+
+func (m *UpdateRecordRequest) field(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	switch num {
+	case 1:
+		return decodeString(typ, b, &m.Name), nil
+	case 2:
+		return decodeString(typ, b, &m.Type), nil
+	case 3:
+		return decodeString(typ, b, &m.Value), nil
+	case 4:
+		v, n := decodeVarint(typ, b)
+		m.TTL = uint32(v)
+		return n, nil
+	}
+	return 0, nil
+}
+
+func (m *UpdateRecordResponse) marshal(b []byte) []byte {
+	for _, rec := range m.Records {
+		b = appendMessage(b, 1, rec)
+	}
+	return b
+}
+
+func (m *UpdateRecordResponse) field(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	if num == 1 {
+		return decodeRecord(typ, b, &m.Records)
+	}
+	return 0, nil
+}
+
+func (m *ListRecordsRequest) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.Zone)
+	b = appendString(b, 2, m.Name)
+	return appendString(b, 3, m.Type)
+}
+
+func (m *ListRecordsRequest) field(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	switch num {
+	case 1:
+		return decodeString(typ, b, &m.Zone), nil
+	case 2:
+		return decodeString(typ, b, &m.Name), nil
+	case 3:
+		return decodeString(typ, b, &m.Type), nil
+	}
+	return 0, nil
+}
+
+func (m *ListRecordsResponse) marshal(b []byte) []byte {
+	for _, rec := range m.Records {
+		b = appendMessage(b, 1, rec)
+	}
+	return b
+}
+
+func (m *ListRecordsResponse) field(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	if num == 1 {
+		return decodeRecord(typ, b, &m.Records)
+	}
+	return 0, nil
+}
+
+func (m *WatchChangesRequest) marshal(b []byte) []byte {
+	return appendString(b, 1, m.Zone)
+}
+
+func (m *WatchChangesRequest) field(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	if num == 1 {
+		return decodeString(typ, b, &m.Zone), nil
+	}
+	return 0, nil
+}
+
+func (m *Change) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.Zone)
+	b = appendString(b, 2, m.Name)
+	b = appendString(b, 3, m.Type)
+	b = appendString(b, 4, m.Value)
+	b = appendVarint(b, 5, uint64(m.Serial))
+	return appendVarint(b, 6, uint64(m.Time))
+}
+
+func (m *Change) field(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	switch num {
+	case 1:
+		return decodeString(typ, b, &m.Zone), nil
+	case 2:
+		return decodeString(typ, b, &m.Name), nil
+	case 3:
+		return decodeString(typ, b, &m.Type), nil
+	case 4:
+		return decodeString(typ, b, &m.Value), nil
+	case 5:
+		v, n := decodeVarint(typ, b)
+		m.Serial = uint32(v)
+		return n, nil
+	case 6:
+		v, n := decodeVarint(typ, b)
+		m.Time = int64(v)
+		return n, nil
+	}
+	return 0, nil
+}
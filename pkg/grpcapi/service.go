@@ -0,0 +1,166 @@
+// Package grpcapi implements the dnsup gRPC service described by
+// dnsup.proto: updating and listing records and streaming changes.
+package grpcapi
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Service is implemented by the server side of the API.
+type Service interface {
+	UpdateRecord(ctx context.Context, req *UpdateRecordRequest) (*UpdateRecordResponse, error)
+	ListRecords(ctx context.Context, req *ListRecordsRequest) (*ListRecordsResponse, error)
+	WatchChanges(req *WatchChangesRequest, stream ChangeStream) error
+}
+
+// ChangeStream sends the changes of a WatchChanges call.
+type ChangeStream interface {
+	Send(*Change) error
+	Context() context.Context
+}
+
+type changeStream struct {
+	grpc.ServerStream
+}
+
+func (s changeStream) Send(c *Change) error {
+	return s.ServerStream.SendMsg(c)
+}
+
+// NewServer returns a gRPC server for svc. Calls must carry one of the
+// authorized keys in their "authorization" metadata, as "Bearer <key>",
+// or in "x-api-key"; authorize checks them.
+func NewServer(svc Service, authorize func(key string) bool, opts ...grpc.ServerOption) *grpc.Server {
+	check := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		key := ""
+		if v := md.Get("x-api-key"); len(v) > 0 {
+			key = v[0]
+		}
+		if v := md.Get("authorization"); len(v) > 0 && strings.HasPrefix(v[0], "Bearer ") {
+			key = strings.TrimPrefix(v[0], "Bearer ")
+		}
+		if !authorize(key) {
+			return status.Error(codes.Unauthenticated, "missing or invalid API key")
+		}
+		return nil
+	}
+	opts = append(opts,
+		grpc.ForceServerCodec(codec{}),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := check(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := check(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	s := grpc.NewServer(opts...)
+	s.RegisterService(&serviceDesc, svc)
+	return s
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "dnsup.v1.Dnsup",
+	HandlerType: (*Service)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "UpdateRecord", Handler: updateRecordHandler},
+		{MethodName: "ListRecords", Handler: listRecordsHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "WatchChanges", Handler: watchChangesHandler, ServerStreams: true},
+	},
+	Metadata: "dnsup.proto",
+}
+
+func updateRecordHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRecordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Service).UpdateRecord(ctx, req.(*UpdateRecordRequest))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/dnsup.v1.Dnsup/UpdateRecord"}
+	return interceptor(ctx, in, info, handler)
+}
+
+func listRecordsHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRecordsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Service).ListRecords(ctx, req.(*ListRecordsRequest))
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/dnsup.v1.Dnsup/ListRecords"}
+	return interceptor(ctx, in, info, handler)
+}
+
+func watchChangesHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(WatchChangesRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(Service).WatchChanges(in, changeStream{stream})
+}
+
+// Hub fans changes out to the WatchChanges subscribers. A subscriber that
+// falls behind loses changes rather than delaying updates.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan *Change]bool
+}
+
+// NewHub returns a hub without subscribers.
+func NewHub() *Hub {
+	return &Hub{subs: map[chan *Change]bool{}}
+}
+
+// Subscribe returns a channel of the changes published from now on and a
+// function to cancel the subscription.
+func (h *Hub) Subscribe() (<-chan *Change, func()) {
+	ch := make(chan *Change, 64)
+	h.mu.Lock()
+	h.subs[ch] = true
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+// Publish sends c to every subscriber with room for it. A nil hub
+// discards changes.
+func (h *Hub) Publish(c *Change) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- c:
+		default:
+		}
+	}
+}
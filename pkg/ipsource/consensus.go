@@ -0,0 +1,81 @@
+package ipsource
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Vote is the address a source reported, or the error it failed with.
+type Vote struct {
+	Source string
+	IP     net.IP
+	Err    error
+}
+
+func (v Vote) String() string {
+	if v.Err != nil {
+		return v.Err.Error()
+	}
+	return v.Source + ": " + v.IP.String()
+}
+
+// Poll queries every source concurrently for an address of the given
+// family and returns their votes in the order of sources.
+func Poll(sources []string, family Family) []Vote {
+	return PollContext(context.Background(), sources, family)
+}
+
+// PollContext is Poll, giving up on the sources still to answer when ctx
+// is done.
+func PollContext(ctx context.Context, sources []string, family Family) []Vote {
+	if len(sources) == 0 {
+		sources = DefaultSources
+	}
+	votes := make([]Vote, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src string) {
+			defer wg.Done()
+			ip, err := LookupContext(ctx, src, family)
+			votes[i] = Vote{Source: src, IP: ip, Err: err}
+		}(i, src)
+	}
+	wg.Wait()
+	return votes
+}
+
+// Consensus returns the address reported by at least quorum of votes,
+// along with the votes that failed or reported another address, so that a
+// single wrong or hijacked source cannot decide the address on its own.
+func Consensus(votes []Vote, quorum int) (net.IP, []Vote, error) {
+	counts := map[string]int{}
+	best := ""
+	for _, v := range votes {
+		if v.Err != nil {
+			continue
+		}
+		s := v.IP.String()
+		counts[s]++
+		if counts[s] > counts[best] {
+			best = s
+		}
+	}
+	var dissent []Vote
+	for _, v := range votes {
+		if v.Err != nil || v.IP.String() != best {
+			dissent = append(dissent, v)
+		}
+	}
+	if best == "" || counts[best] < quorum {
+		var tally []string
+		for _, v := range votes {
+			tally = append(tally, v.String())
+		}
+		return nil, dissent, fmt.Errorf("no address reported by %d of %d sources: %s", quorum, len(votes), strings.Join(tally, "; "))
+	}
+	return net.ParseIP(best), dissent, nil
+}
@@ -0,0 +1,58 @@
+package ipsource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("exec", func(source string) (Source, error) {
+		command := strings.TrimSpace(strings.TrimPrefix(source, "exec:"))
+		if command == "" || !strings.HasPrefix(source, "exec:") {
+			return nil, fmt.Errorf("%s: want exec:COMMAND", source)
+		}
+		return SourceFunc(func(ctx context.Context, family Family) (net.IP, error) {
+			return lookupExec(ctx, source, command, family)
+		}), nil
+	})
+}
+
+// lookupExec runs command, of a source "exec:COMMAND", with the shell and
+// takes the first word of its output that is an address of family, or a
+// prefix of one, so that it may print a log line or a router's status. The
+// family is in DNSUP_FAMILY, 4 or 6, for commands that report both.
+func lookupExec(ctx context.Context, source, command string, family Family) (net.IP, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "DNSUP_FAMILY="+strconv.Itoa(int(family)))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %v: %s", source, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	for _, word := range strings.Fields(string(out)) {
+		word = strings.Trim(word, "[](),;")
+		if i := strings.IndexByte(word, '/'); i >= 0 {
+			word = word[:i]
+		}
+		if ip, err := parseIP(source, word, family); err == nil {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no IPv%d address in the output", source, family)
+}
@@ -0,0 +1,48 @@
+package ipsource
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// lookupInterface returns an address of the given family assigned to the
+// named interface, from a source "iface:NAME" or "iface:NAME/global".
+// Loopback and link-local addresses are never used, nor, where the system
+// reports them, temporary, deprecated or tentative IPv6 addresses. With
+// "/global" a public address is preferred over a private or ULA one.
+func lookupInterface(source string, family Family) (net.IP, error) {
+	name := strings.TrimPrefix(source, "iface:")
+	global := strings.HasSuffix(name, "/global")
+	name = strings.TrimSuffix(name, "/global")
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	skip := unusableAddrs(name)
+	var fallback net.IP
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := n.IP
+		if (ip.To4() != nil) != (family == IPv4) || !ip.IsGlobalUnicast() || skip[ip.String()] {
+			continue
+		}
+		if !global || !ip.IsPrivate() {
+			return ip, nil
+		}
+		if fallback == nil {
+			fallback = ip
+		}
+	}
+	if fallback != nil {
+		return fallback, nil
+	}
+	return nil, fmt.Errorf("%s: no usable IPv%d address", source, family)
+}
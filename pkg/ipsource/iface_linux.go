@@ -0,0 +1,132 @@
+//go:build linux
+// +build linux
+
+package ipsource
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// IPv6 address flags reported in /proc/net/if_inet6.
+const (
+	ifaTemporary  = 0x01
+	ifaDeprecated = 0x20
+	ifaTentative  = 0x40
+)
+
+// Netlink multicast groups for address changes, from linux/rtnetlink.h.
+const (
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// unusableAddrs returns the temporary, deprecated and tentative IPv6
+// addresses of the named interface, which should not be published.
+func unusableAddrs(name string) map[string]bool {
+	skip := map[string]bool{}
+	f, err := os.Open("/proc/net/if_inet6")
+	if err != nil {
+		return skip
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// address ifindex prefixlen scope flags name
+		fields := strings.Fields(s.Text())
+		if len(fields) != 6 || fields[5] != name || len(fields[0]) != 32 {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil || flags&(ifaTemporary|ifaDeprecated|ifaTentative) == 0 {
+			continue
+		}
+		ip := make(net.IP, net.IPv6len)
+		for i := range ip {
+			b, err := strconv.ParseUint(fields[0][2*i:2*i+2], 16, 8)
+			if err != nil {
+				break
+			}
+			ip[i] = byte(b)
+		}
+		skip[ip.String()] = true
+	}
+	return skip
+}
+
+// defaultGateway returns the gateway of the IPv4 default route, from
+// /proc/net/route.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// Iface Destination Gateway Flags ..., in little-endian hex
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil || gw == 0 {
+			continue
+		}
+		return net.IPv4(byte(gw), byte(gw>>8), byte(gw>>16), byte(gw>>24)), nil
+	}
+	return nil, errors.New("no IPv4 default route")
+}
+
+// Watch returns a channel that receives a value soon after an address is
+// added to or removed from any interface, as reported by netlink. The
+// channel is closed if the netlink socket fails.
+func Watch() (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	sa := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		defer syscall.Close(fd)
+		buf := make([]byte, 1<<16)
+		for {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err == syscall.EINTR || err == syscall.ENOBUFS {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				if m.Header.Type == syscall.RTM_NEWADDR || m.Header.Type == syscall.RTM_DELADDR {
+					select {
+					case ch <- struct{}{}:
+					default:
+					}
+					break
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package ipsource
+
+import (
+	"errors"
+	"net"
+)
+
+func unusableAddrs(name string) map[string]bool {
+	return nil
+}
+
+func defaultGateway() (net.IP, error) {
+	return nil, errors.New("cannot find the default gateway on this system; give it as natpmp:GATEWAY")
+}
+
+// Watch is only supported on Linux, where netlink reports address changes.
+func Watch() (<-chan struct{}, error) {
+	return nil, errors.New("address change events are only available on Linux")
+}
@@ -0,0 +1,196 @@
+// Package ipsource discovers the public address of the current host.
+package ipsource
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Family is an IP address family.
+type Family int
+
+const (
+	IPv4 Family = 4
+	IPv6 Family = 6
+)
+
+// Timeout bounds each individual lookup, within any deadline of the
+// context it is made with.
+var Timeout = 10 * time.Second
+
+// DefaultSources are consulted in order when no sources are configured.
+// Sources are either http(s) URLs returning the address as plain text,
+// one of the DNS-based sources such as "dns:opendns", a STUN server as
+// "stun:stun.example.net:3478", the local gateway asked by "natpmp",
+// "natpmp:GATEWAY" or "upnp", a local interface as "iface:eth0", or
+// "iface:eth0/global" to prefer public addresses, the output of a command
+// as "exec:COMMAND", or a Source added with Register.
+var DefaultSources = []string{
+	"https://icanhazip.com",
+	"https://ifconfig.co/ip",
+	"dns:opendns",
+}
+
+// Discover returns the first address of the given family reported by
+// sources.
+func Discover(sources []string, family Family) (net.IP, error) {
+	return DiscoverContext(context.Background(), sources, family)
+}
+
+// DiscoverContext is Discover, giving up when ctx is done.
+func DiscoverContext(ctx context.Context, sources []string, family Family) (net.IP, error) {
+	if len(sources) == 0 {
+		sources = DefaultSources
+	}
+	var errs []string
+	for _, src := range sources {
+		ip, err := LookupContext(ctx, src, family)
+		if err == nil {
+			return ip, nil
+		}
+		errs = append(errs, err.Error())
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("no IP source succeeded: %s", strings.Join(errs, "; "))
+}
+
+// Observe, if set, is called after every lookup with the source, how long
+// it took and the error it failed with, if any.
+var Observe func(source string, d time.Duration, err error)
+
+// Lookup queries a single source for an address of the given family.
+func Lookup(source string, family Family) (net.IP, error) {
+	return LookupContext(context.Background(), source, family)
+}
+
+// LookupContext is Lookup, giving up when ctx is done.
+func LookupContext(ctx context.Context, source string, family Family) (net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	start := time.Now()
+	ip, err := lookup(ctx, source, family)
+	if Observe != nil {
+		Observe(source, time.Since(start), err)
+	}
+	return ip, err
+}
+
+// lookup queries source within the deadline of ctx, which LookupContext
+// always sets.
+func lookup(ctx context.Context, source string, family Family) (net.IP, error) {
+	switch {
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		return lookupHTTP(ctx, source, family)
+	case source == "dns:opendns":
+		return lookupOpenDNS(ctx, family)
+	case strings.HasPrefix(source, "stun:"):
+		return lookupSTUN(ctx, source, family)
+	case source == "natpmp", strings.HasPrefix(source, "natpmp:"):
+		return lookupNATPMP(ctx, source, family)
+	case source == "upnp":
+		return lookupUPnP(ctx, source, family)
+	case strings.HasPrefix(source, "iface:"):
+		return lookupInterface(source, family)
+	}
+	s, ok, err := registered(source)
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("unknown IP source %q", source)
+	case err != nil:
+		return nil, err
+	}
+	return s.Lookup(ctx, family)
+}
+
+func lookupHTTP(ctx context.Context, url string, family Family) (net.IP, error) {
+	network := "tcp4"
+	if family == IPv6 {
+		network = "tcp6"
+	}
+	// Each lookup has its own transport, so keeping the connection open
+	// would only leak it.
+	dialer := &net.Dialer{}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, err
+	}
+	return parseIP(url, strings.TrimSpace(string(body)), family)
+}
+
+func lookupOpenDNS(ctx context.Context, family Family) (net.IP, error) {
+	server, qtype := "208.67.222.222:53", dns.TypeA
+	if family == IPv6 {
+		server, qtype = "[2620:119:35::35]:53", dns.TypeAAAA
+	}
+	m := new(dns.Msg)
+	m.SetQuestion("myip.opendns.com.", qtype)
+	c := new(dns.Client)
+	r, _, err := c.ExchangeContext(ctx, m, server)
+	if err != nil {
+		return nil, err
+	}
+	for _, rr := range r.Answer {
+		switch a := rr.(type) {
+		case *dns.A:
+			return parseIP("dns:opendns", a.A.String(), family)
+		case *dns.AAAA:
+			return parseIP("dns:opendns", a.AAAA.String(), family)
+		}
+	}
+	return nil, fmt.Errorf("dns:opendns: no address in response")
+}
+
+func parseIP(source, s string, family Family) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%s: invalid address %q", source, s)
+	}
+	if (ip.To4() != nil) != (family == IPv4) {
+		return nil, fmt.Errorf("%s: address %s is not IPv%d", source, ip, family)
+	}
+	return ip, nil
+}
+
+// closeOnDone closes c when ctx is done, to interrupt a read blocked on
+// it, until the returned function is called.
+func closeOnDone(ctx context.Context, c io.Closer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
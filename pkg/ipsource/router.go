@@ -0,0 +1,253 @@
+package ipsource
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// natpmpPort is where a NAT-PMP or PCP gateway listens (RFC 6886).
+const natpmpPort = "5351"
+
+// lookupNATPMP asks the gateway of a source "natpmp" or "natpmp:GATEWAY"
+// for its external IPv4 address. Without a gateway the default route's
+// is used. PCP gateways answer too, through their NAT-PMP compatibility.
+func lookupNATPMP(ctx context.Context, source string, family Family) (net.IP, error) {
+	if family != IPv4 {
+		return nil, fmt.Errorf("%s: NAT-PMP only reports IPv4 addresses", source)
+	}
+	gw := strings.TrimPrefix(strings.TrimPrefix(source, "natpmp"), ":")
+	if gw == "" {
+		ip, err := defaultGateway()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		gw = ip.String()
+	}
+	conn, err := new(net.Dialer).DialContext(ctx, "udp4", net.JoinHostPort(gw, natpmpPort))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	// Version 0, opcode 0: external address request. The request is
+	// retransmitted with doubling waits, starting at 250ms.
+	deadline, _ := ctx.Deadline()
+	buf := make([]byte, 16)
+	for wait := 250 * time.Millisecond; time.Now().Before(deadline); wait *= 2 {
+		if _, err := conn.Write([]byte{0, 0}); err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		until := time.Now().Add(wait)
+		if until.After(deadline) {
+			until = deadline
+		}
+		conn.SetReadDeadline(until)
+		n, err := conn.Read(buf)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s: %v", source, ctx.Err())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		if n < 12 || buf[0] != 0 || buf[1] != 128 {
+			return nil, fmt.Errorf("%s: malformed response", source)
+		}
+		if code := int(buf[2])<<8 | int(buf[3]); code != 0 {
+			return nil, fmt.Errorf("%s: gateway returned result code %d", source, code)
+		}
+		return parseIP(source, net.IP(buf[8:12]).String(), family)
+	}
+	return nil, fmt.Errorf("%s: no response from %s", source, gw)
+}
+
+// SSDP discovery of an Internet Gateway Device (UPnP IGD).
+const (
+	ssdpAddr    = "239.255.255.250:1900"
+	upnpIPConn  = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	upnpPPPConn = "urn:schemas-upnp-org:service:WANPPPConnection:1"
+)
+
+// lookupUPnP finds the local Internet Gateway Device by SSDP, from a
+// source "upnp", and asks its WAN connection service for the external
+// address with GetExternalIPAddress.
+func lookupUPnP(ctx context.Context, source string, family Family) (net.IP, error) {
+	if family != IPv4 {
+		return nil, fmt.Errorf("%s: UPnP IGD only reports IPv4 addresses", source)
+	}
+	location, err := ssdpSearch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	control, service, err := upnpControlURL(ctx, location)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	ip, err := upnpExternalIP(ctx, control, service)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	return parseIP(source, ip, family)
+}
+
+// ssdpSearch multicasts M-SEARCH requests for the WAN connection services
+// and returns the description URL from the first answer.
+func ssdpSearch(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+	for _, st := range []string{upnpIPConn, upnpPPPConn} {
+		msg := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: " + ssdpAddr + "\r\n" +
+			"ST: " + st + "\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n\r\n"
+		if _, err := conn.WriteTo([]byte(msg), dst); err != nil {
+			return "", err
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	if d := time.Now().Add(3 * time.Second); d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if err != nil {
+			return "", fmt.Errorf("no gateway answered SSDP discovery: %v", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if loc := resp.Header.Get("Location"); loc != "" {
+			return loc, nil
+		}
+	}
+}
+
+// upnpRoot is the part of a UPnP device description naming services.
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	Type       string `xml:"serviceType"`
+	ControlURL string `xml:"controlURL"`
+}
+
+// services returns the services of the device and its embedded devices.
+func (d *upnpDevice) services() []upnpService {
+	out := append([]upnpService(nil), d.Services...)
+	for i := range d.Devices {
+		out = append(out, d.Devices[i].services()...)
+	}
+	return out
+}
+
+// upnpControlURL reads the device description at location and returns
+// the absolute control URL and type of its WAN connection service.
+func upnpControlURL(ctx context.Context, location string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("%s: %s", location, resp.Status)
+	}
+	var desc upnpRoot
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&desc); err != nil {
+		return "", "", fmt.Errorf("%s: %v", location, err)
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	if desc.URLBase != "" {
+		if b, err := url.Parse(desc.URLBase); err == nil {
+			base = b
+		}
+	}
+	for _, svc := range desc.Device.services() {
+		if strings.HasPrefix(svc.Type, "urn:schemas-upnp-org:service:WANIPConnection:") ||
+			strings.HasPrefix(svc.Type, "urn:schemas-upnp-org:service:WANPPPConnection:") {
+			ref, err := url.Parse(strings.TrimSpace(svc.ControlURL))
+			if err != nil {
+				return "", "", err
+			}
+			return base.ResolveReference(ref).String(), svc.Type, nil
+		}
+	}
+	return "", "", fmt.Errorf("%s: no WAN connection service", location)
+}
+
+// upnpExternalIP calls GetExternalIPAddress on the service at control.
+func upnpExternalIP(ctx context.Context, control, service string) (string, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + service + `"/></s:Body></s:Envelope>`
+	req, err := http.NewRequestWithContext(ctx, "POST", control, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+service+`#GetExternalIPAddress"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GetExternalIPAddress: %s", resp.Status)
+	}
+	var env struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return "", fmt.Errorf("GetExternalIPAddress: %v", err)
+	}
+	if env.IP == "" {
+		return "", fmt.Errorf("GetExternalIPAddress: no address in response")
+	}
+	return strings.TrimSpace(env.IP), nil
+}
@@ -0,0 +1,52 @@
+package ipsource
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Source is a way of finding an address of the host, beyond the built-in
+// ones, added with Register.
+type Source interface {
+	Lookup(ctx context.Context, family Family) (net.IP, error)
+}
+
+// SourceFunc is a Source calling the function.
+type SourceFunc func(ctx context.Context, family Family) (net.IP, error)
+
+// Lookup calls f.
+func (f SourceFunc) Lookup(ctx context.Context, family Family) (net.IP, error) {
+	return f(ctx, family)
+}
+
+var schemes = struct {
+	sync.RWMutex
+	m map[string]func(source string) (Source, error)
+}{m: map[string]func(string) (Source, error){}}
+
+// Register makes open the way to look up sources named scheme or
+// "scheme:..."; open is given the whole name. Built-in sources cannot be
+// replaced.
+func Register(scheme string, open func(source string) (Source, error)) {
+	schemes.Lock()
+	defer schemes.Unlock()
+	schemes.m[scheme] = open
+}
+
+// registered returns the registered source named source, if there is one.
+func registered(source string) (Source, bool, error) {
+	scheme := source
+	if i := strings.Index(source, ":"); i >= 0 {
+		scheme = source[:i]
+	}
+	schemes.RLock()
+	open, ok := schemes.m[scheme]
+	schemes.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	s, err := open(source)
+	return s, true, err
+}
@@ -0,0 +1,162 @@
+package ipsource
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// STUN message types and attributes used for a binding request (RFC 5389).
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112a442
+	stunMappedAddress   = 0x0001
+	stunXorMappedAddr   = 0x0020
+	stunHeaderLen       = 20
+	stunDefaultPort     = "3478"
+	stunAttempts        = 3
+)
+
+// lookupSTUN asks the STUN server of a source "stun:HOST[:PORT]" for the
+// address it sees our binding request come from, over UDP of the given
+// family, retransmitting a few times before the deadline of ctx.
+func lookupSTUN(ctx context.Context, source string, family Family) (net.IP, error) {
+	server := strings.TrimPrefix(source, "stun:")
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, stunDefaultPort)
+	}
+	network := "udp4"
+	if family == IPv6 {
+		network = "udp6"
+	}
+	conn, err := new(net.Dialer).DialContext(ctx, network, server)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	if _, err := rand.Read(req[8:20]); err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	buf := make([]byte, 1500)
+	for i := 0; i < stunAttempts; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		wait := time.Now().Add(time.Until(deadline) / time.Duration(stunAttempts-i))
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		conn.SetReadDeadline(wait)
+		for {
+			n, err := conn.Read(buf)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("%s: %v", source, ctx.Err())
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", source, err)
+			}
+			ip, err := parseSTUNResponse(buf[:n], req[8:20])
+			if err == errSTUNMismatch {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", source, err)
+			}
+			return parseIP(source, ip.String(), family)
+		}
+	}
+	return nil, fmt.Errorf("%s: no response", source)
+}
+
+// errSTUNMismatch marks a datagram that is not a response to our request.
+var errSTUNMismatch = errors.New("not a response to the binding request")
+
+// parseSTUNResponse returns the mapped address in a binding response to
+// the request with transaction ID tid, preferring XOR-MAPPED-ADDRESS.
+func parseSTUNResponse(msg, tid []byte) (net.IP, error) {
+	if len(msg) < stunHeaderLen ||
+		binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie ||
+		!bytes.Equal(msg[8:20], tid) {
+		return nil, errSTUNMismatch
+	}
+	if t := binary.BigEndian.Uint16(msg[0:]); t != stunBindingResponse {
+		return nil, fmt.Errorf("unexpected STUN message type %#04x", t)
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if stunHeaderLen+length > len(msg) {
+		return nil, errors.New("truncated STUN response")
+	}
+	var mapped net.IP
+	attrs := msg[stunHeaderLen : stunHeaderLen+length]
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		n := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+n > len(attrs) {
+			break
+		}
+		val := attrs[4 : 4+n]
+		switch typ {
+		case stunXorMappedAddr:
+			if ip := stunAddress(val, msg[4:20]); ip != nil {
+				return ip, nil
+			}
+		case stunMappedAddress:
+			mapped = stunAddress(val, nil)
+		}
+		// Attributes are padded to a multiple of four bytes.
+		n = (n + 3) &^ 3
+		if 4+n > len(attrs) {
+			break
+		}
+		attrs = attrs[4+n:]
+	}
+	if mapped == nil {
+		return nil, errors.New("no mapped address in STUN response")
+	}
+	return mapped, nil
+}
+
+// stunAddress decodes the address of a (XOR-)MAPPED-ADDRESS attribute,
+// XORed with the magic cookie and transaction ID in key unless it is nil.
+func stunAddress(val, key []byte) net.IP {
+	if len(val) < 4 {
+		return nil
+	}
+	var size int
+	switch val[1] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x02:
+		size = net.IPv6len
+	default:
+		return nil
+	}
+	if len(val) < 4+size {
+		return nil
+	}
+	ip := make(net.IP, size)
+	copy(ip, val[4:4+size])
+	if key != nil {
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return ip
+}
@@ -0,0 +1,337 @@
+// Package kube reads the Ingress and Service resources of a Kubernetes
+// cluster through its REST API and turns the annotated ones into the
+// host names and addresses to publish in DNS, in the manner of
+// external-dns.
+//
+// An Ingress is published when annotated dnsup/publish: "true", under the
+// hosts of its rules, or under the hosts listed in a dnsup/hostname
+// annotation. A Service is published under the hosts of its
+// dnsup/hostname annotation. Either publishes the addresses or host name
+// of its load balancer, or those of a dnsup/target annotation, and may
+// set the records' TTL with dnsup/ttl.
+package kube
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Annotations recognized on Ingresses and Services.
+const (
+	AnnotationPublish  = "dnsup/publish"
+	AnnotationHostname = "dnsup/hostname"
+	AnnotationTarget   = "dnsup/target"
+	AnnotationTTL      = "dnsup/ttl"
+)
+
+// serviceAccount is where a pod finds its credentials for the API.
+const serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client talks to the API server of a cluster.
+type Client struct {
+	Server    string
+	Token     string
+	Namespace string // empty for every namespace
+	HTTP      *http.Client
+}
+
+// NewClient returns a client for the API server at server, such as
+// https://10.0.0.1:6443, authenticating with a bearer token if not empty
+// and trusting the certificate authorities in caFile if not empty.
+func NewClient(server, token, caFile string) (*Client, error) {
+	tr := &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: &tls.Config{}}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", caFile)
+		}
+		tr.TLSClientConfig.RootCAs = pool
+	}
+	return &Client{
+		Server: strings.TrimSuffix(server, "/"),
+		Token:  token,
+		HTTP:   &http.Client{Transport: tr},
+	}, nil
+}
+
+// InCluster returns a client for the cluster the process runs in, using
+// the pod's service account.
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	token, err := ioutil.ReadFile(serviceAccount + "/token")
+	if err != nil {
+		return nil, err
+	}
+	return NewClient("https://"+net.JoinHostPort(host, port), strings.TrimSpace(string(token)), serviceAccount+"/ca.crt")
+}
+
+// Endpoint is a host name to publish and what it should resolve to:
+// addresses for A and AAAA records, or a single host name for a CNAME.
+type Endpoint struct {
+	Host     string
+	Targets  []string
+	TTL      uint32 // zero for the default
+	Resource string // such as ingress/default/web
+}
+
+type objectMeta struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace"`
+	Annotations       map[string]string `json:"annotations"`
+	DeletionTimestamp *time.Time        `json:"deletionTimestamp"`
+}
+
+type loadBalancerStatus struct {
+	Ingress []struct {
+		IP       string `json:"ip"`
+		Hostname string `json:"hostname"`
+	} `json:"ingress"`
+}
+
+type ingress struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		Rules []struct {
+			Host string `json:"host"`
+		} `json:"rules"`
+	} `json:"spec"`
+	Status struct {
+		LoadBalancer loadBalancerStatus `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+type service struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		ExternalIPs []string `json:"externalIPs"`
+	} `json:"spec"`
+	Status struct {
+		LoadBalancer loadBalancerStatus `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+type list struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []json.RawMessage `json:"items"`
+}
+
+// Resource paths under the API server, for every namespace or for one.
+const (
+	ingressPath = "/apis/networking.k8s.io/v1/%singresses"
+	servicePath = "/api/v1/%sservices"
+)
+
+func (c *Client) path(format string) string {
+	ns := ""
+	if c.Namespace != "" {
+		ns = "namespaces/" + url.PathEscape(c.Namespace) + "/"
+	}
+	return fmt.Sprintf(format, ns)
+}
+
+func (c *Client) get(path string, timeout time.Duration) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.Server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	req.Header.Set("Accept", "application/json")
+	hc := *c.HTTP
+	hc.Timeout = timeout
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
+
+func (c *Client) list(path string) (*list, error) {
+	resp, err := c.get(path, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	l := &list{}
+	if err := json.NewDecoder(resp.Body).Decode(l); err != nil {
+		return nil, fmt.Errorf("GET %s: %v", path, err)
+	}
+	return l, nil
+}
+
+// Endpoints lists the Ingresses and Services and returns the endpoints
+// they publish, sorted by host.
+func (c *Client) Endpoints() ([]Endpoint, error) {
+	var eps []Endpoint
+	l, err := c.list(c.path(ingressPath))
+	if err != nil {
+		return nil, err
+	}
+	for _, raw := range l.Items {
+		var ing ingress
+		if err := json.Unmarshal(raw, &ing); err != nil {
+			return nil, err
+		}
+		eps = append(eps, ingressEndpoints(&ing)...)
+	}
+	if l, err = c.list(c.path(servicePath)); err != nil {
+		return nil, err
+	}
+	for _, raw := range l.Items {
+		var svc service
+		if err := json.Unmarshal(raw, &svc); err != nil {
+			return nil, err
+		}
+		eps = append(eps, serviceEndpoints(&svc)...)
+	}
+	sort.SliceStable(eps, func(i, j int) bool { return eps[i].Host < eps[j].Host })
+	return eps, nil
+}
+
+func ingressEndpoints(ing *ingress) []Endpoint {
+	m := &ing.Metadata
+	var hosts []string
+	if h := m.Annotations[AnnotationHostname]; h != "" {
+		hosts = splitList(h)
+	} else if m.Annotations[AnnotationPublish] == "true" {
+		for _, r := range ing.Spec.Rules {
+			if r.Host != "" && !strings.HasPrefix(r.Host, "*") {
+				hosts = append(hosts, r.Host)
+			}
+		}
+	}
+	return endpoints(m, "ingress", hosts, ing.Status.LoadBalancer, nil)
+}
+
+func serviceEndpoints(svc *service) []Endpoint {
+	m := &svc.Metadata
+	return endpoints(m, "service", splitList(m.Annotations[AnnotationHostname]), svc.Status.LoadBalancer, svc.Spec.ExternalIPs)
+}
+
+// endpoints pairs hosts with the targets of a resource: those annotated,
+// or else the addresses of its load balancer and external IPs, or else
+// the load balancer's host name.
+func endpoints(m *objectMeta, kind string, hosts []string, lb loadBalancerStatus, external []string) []Endpoint {
+	if len(hosts) == 0 || m.DeletionTimestamp != nil {
+		return nil
+	}
+	targets := splitList(m.Annotations[AnnotationTarget])
+	if len(targets) == 0 {
+		var names []string
+		for _, in := range lb.Ingress {
+			if in.IP != "" {
+				targets = append(targets, in.IP)
+			} else if in.Hostname != "" {
+				names = append(names, in.Hostname)
+			}
+		}
+		targets = append(targets, external...)
+		if len(targets) == 0 && len(names) > 0 {
+			targets = names[:1]
+		}
+	}
+	if len(targets) == 0 {
+		return nil // no address assigned yet
+	}
+	var ttl uint32
+	if s := m.Annotations[AnnotationTTL]; s != "" {
+		if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+			ttl = uint32(n)
+		}
+	}
+	resource := kind + "/" + m.Namespace + "/" + m.Name
+	var eps []Endpoint
+	for _, h := range hosts {
+		eps = append(eps, Endpoint{Host: strings.ToLower(strings.TrimSuffix(h, ".")) + ".", Targets: targets, TTL: ttl, Resource: resource})
+	}
+	return eps
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		out = append(out, f)
+	}
+	return out
+}
+
+// Watch streams change events for the Ingresses and Services, sending on
+// the returned channel, without blocking, whenever one may have changed.
+// It keeps watching, starting over after errors, for as long as the
+// process runs.
+func (c *Client) Watch() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	for _, p := range []string{c.path(ingressPath), c.path(servicePath)} {
+		go c.watch(p, ch)
+	}
+	return ch
+}
+
+func (c *Client) watch(path string, ch chan<- struct{}) {
+	for {
+		if err := c.watchOnce(path, ch); err != nil {
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// watchOnce lists path to learn its resource version, then follows the
+// watch stream from there until the server ends it.
+func (c *Client) watchOnce(path string, ch chan<- struct{}) error {
+	l, err := c.list(path)
+	if err != nil {
+		return err
+	}
+	q := url.Values{"watch": {"1"}, "resourceVersion": {l.Metadata.ResourceVersion}, "timeoutSeconds": {"300"}}
+	resp, err := c.get(path+"?"+q.Encode(), 330*time.Second)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	s := bufio.NewScanner(resp.Body)
+	s.Buffer(nil, 4<<20)
+	for s.Scan() {
+		var ev struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(s.Bytes(), &ev) != nil || ev.Type == "BOOKMARK" {
+			continue
+		}
+		if ev.Type == "ERROR" {
+			return fmt.Errorf("watch %s: error event", path)
+		}
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return s.Err()
+}
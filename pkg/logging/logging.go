@@ -0,0 +1,184 @@
+// Package logging is a small leveled logger writing either plain text or
+// one JSON object per line, with named fields for shipping to log stores
+// such as Loki or Elasticsearch.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l >= 0 && int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel returns the level named debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) || name == "warning" && n == "warn" {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", name)
+}
+
+// Fields are named values attached to a log entry.
+type Fields map[string]interface{}
+
+// Logger writes entries at or above its level to w.
+type Logger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+	json  bool
+	now   func() time.Time
+}
+
+// New returns a logger writing entries of level and above to w, as JSON
+// lines if asJSON is set.
+func New(w io.Writer, level Level, asJSON bool) *Logger {
+	return &Logger{w: w, level: level, json: asJSON, now: time.Now}
+}
+
+// Enabled reports whether entries at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Log writes an entry with the given fields.
+func (l *Logger) Log(level Level, msg string, fields Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+	var buf bytes.Buffer
+	t := l.now().UTC().Format(time.RFC3339)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if l.json {
+		m := map[string]interface{}{"time": t, "level": level.String(), "msg": msg}
+		for _, k := range keys {
+			v := fields[k]
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			m[k] = v
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			b, _ = json.Marshal(map[string]interface{}{"time": t, "level": level.String(), "msg": msg, "log_error": err.Error()})
+		}
+		buf.Write(b)
+	} else {
+		fmt.Fprintf(&buf, "%s %-5s %s", t, strings.ToUpper(level.String()), msg)
+		for _, k := range keys {
+			v := fmt.Sprint(fields[k])
+			if v == "" || strings.ContainsAny(v, " \t\"=") {
+				v = fmt.Sprintf("%q", v)
+			}
+			fmt.Fprintf(&buf, " %s=%s", k, v)
+		}
+	}
+	buf.WriteByte('\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(buf.Bytes())
+}
+
+// Writer returns a writer logging each line written to it at level, for
+// redirecting the standard log package.
+func (l *Logger) Writer(level Level) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+			l.Log(level, line, nil)
+		}
+		return len(p), nil
+	})
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// Default is the logger used by the package level functions.
+var Default = New(os.Stderr, LevelInfo, false)
+
+// SetDefault replaces the logger used by the package level functions.
+func SetDefault(l *Logger) {
+	Default = l
+}
+
+// Event logs a message with fields on the default logger.
+func Event(level Level, msg string, fields Fields) {
+	Default.Log(level, msg, fields)
+}
+
+// Debugf logs a formatted message at debug level.
+func Debugf(format string, args ...interface{}) {
+	if Default.Enabled(LevelDebug) {
+		Default.Log(LevelDebug, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+// Infof logs a formatted message at info level.
+func Infof(format string, args ...interface{}) {
+	Default.Log(LevelInfo, fmt.Sprintf(format, args...), nil)
+}
+
+// Warnf logs a formatted message at warn level.
+func Warnf(format string, args ...interface{}) {
+	Default.Log(LevelWarn, fmt.Sprintf(format, args...), nil)
+}
+
+// Errorf logs a formatted message at error level.
+func Errorf(format string, args ...interface{}) {
+	Default.Log(LevelError, fmt.Sprintf(format, args...), nil)
+}
+
+// Error logs its arguments, typically an error, at error level.
+func Error(args ...interface{}) {
+	Default.Log(LevelError, fmt.Sprint(args...), nil)
+}
+
+// Exit is called by Fatal and Fatalf with their message, once logged, to
+// end the program. It exits with status 1; commands that report failures
+// in their own way replace it.
+var Exit = func(msg string) { os.Exit(1) }
+
+// Fatal logs its arguments at error level and calls Exit.
+func Fatal(args ...interface{}) {
+	msg := fmt.Sprint(args...)
+	Default.Log(LevelError, msg, nil)
+	Exit(msg)
+}
+
+// Fatalf logs a formatted message at error level and calls Exit.
+func Fatalf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	Default.Log(LevelError, msg, nil)
+	Exit(msg)
+}
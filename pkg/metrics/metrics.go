@@ -0,0 +1,222 @@
+// Package metrics keeps counters, gauges and histograms and serves them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram upper bounds, in seconds, used when
+// none are given.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metric families in the order they were created.
+type Registry struct {
+	mu       sync.Mutex
+	families []*Vec
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Vec is a metric family: one series per combination of label values.
+type Vec struct {
+	r       *Registry
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+// Counter adds a counter family with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Vec {
+	return r.add(&Vec{name: name, help: help, kind: "counter", labels: labels})
+}
+
+// Gauge adds a gauge family with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *Vec {
+	return r.add(&Vec{name: name, help: help, kind: "gauge", labels: labels})
+}
+
+// Histogram adds a histogram family with the given bucket upper bounds,
+// or DefaultBuckets if nil, and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Vec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return r.add(&Vec{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})
+}
+
+func (r *Registry) add(v *Vec) *Vec {
+	v.r = r
+	v.series = map[string]*series{}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, v)
+	return v
+}
+
+// get returns the series for values, creating it if need be. The caller
+// holds the registry lock.
+func (v *Vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s := v.series[key]
+	if s == nil {
+		s = &series{values: append([]string(nil), values...)}
+		if v.kind == "histogram" {
+			s.counts = make([]uint64, len(v.buckets))
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+// Add adds delta to the series with the given label values.
+func (v *Vec) Add(delta float64, values ...string) {
+	v.r.mu.Lock()
+	defer v.r.mu.Unlock()
+	v.get(values).value += delta
+}
+
+// Inc adds one to the series with the given label values.
+func (v *Vec) Inc(values ...string) {
+	v.Add(1, values...)
+}
+
+// Set sets the series with the given label values to x.
+func (v *Vec) Set(x float64, values ...string) {
+	v.r.mu.Lock()
+	defer v.r.mu.Unlock()
+	v.get(values).value = x
+}
+
+// Observe records x in the histogram series with the given label values.
+func (v *Vec) Observe(x float64, values ...string) {
+	v.r.mu.Lock()
+	defer v.r.mu.Unlock()
+	s := v.get(values)
+	for i, le := range v.buckets {
+		if x <= le {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += x
+}
+
+// Delete removes the series whose first label values match values, such
+// as every series of a gauge reporting a value that is no longer current.
+func (v *Vec) Delete(values ...string) {
+	v.r.mu.Lock()
+	defer v.r.mu.Unlock()
+	for key, s := range v.series {
+		match := len(values) <= len(s.values)
+		for i := 0; match && i < len(values); i++ {
+			match = s.values[i] == values[i]
+		}
+		if match {
+			delete(v.series, key)
+		}
+	}
+}
+
+// WriteTo writes every family in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, v := range r.families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", v.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(v.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", v.name, v.kind)
+		keys := make([]string, 0, len(v.series))
+		for key := range v.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := v.series[key]
+			if v.kind != "histogram" {
+				fmt.Fprintf(bw, "%s%s %s\n", v.name, v.labelSet(s.values, ""), formatFloat(s.value))
+				continue
+			}
+			for i, le := range v.buckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", v.name, v.labelSet(s.values, formatFloat(le)), s.counts[i])
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", v.name, v.labelSet(s.values, "+Inf"), s.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", v.name, v.labelSet(s.values, ""), formatFloat(s.value))
+			fmt.Fprintf(bw, "%s_count%s %d\n", v.name, v.labelSet(s.values, ""), s.count)
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// labelSet formats the label pairs of a series, with an le label for a
+// histogram bucket if le is not empty.
+func (v *Vec) labelSet(values []string, le string) string {
+	var pairs []string
+	for i, name := range v.labels {
+		pairs = append(pairs, name+"="+quote(values[i]))
+	}
+	if le != "" {
+		pairs = append(pairs, "le="+quote(le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func formatFloat(x float64) string {
+	switch {
+	case math.IsInf(x, 1):
+		return "+Inf"
+	case math.IsInf(x, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(x, 'g', -1, 64)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,248 @@
+// Package plugin runs provider backends and IP sources shipped as
+// separate executables, found at run time, so that niche registrars and
+// discovery methods need no change to dnsup itself.
+//
+// A plugin is an executable named dnsup-provider-NAME, adding the
+// provider NAME, or dnsup-ipsource-NAME, adding the IP sources "NAME" and
+// "NAME:...". dnsup starts it on first use with DNSUP_PLUGIN set to the
+// kind of plugin and DNSUP_PLUGIN_PROTOCOL to Protocol, and speaks
+// JSON-RPC (net/rpc/jsonrpc) with it over its standard input and output;
+// its standard error is passed through. The plugin exits when its input
+// is closed. ServeProvider and ServeSource implement the plugin side.
+//
+// Provider plugins serve Provider.Configure, taking the provider options,
+// then Provider.GetRecords, Provider.UpsertRecord and
+// Provider.DeleteRecord; IP source plugins serve Source.Lookup.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/johnweldon/dnsup/pkg/ipsource"
+	"github.com/johnweldon/dnsup/pkg/provider"
+)
+
+// Protocol is the version of the protocol between dnsup and plugins.
+const Protocol = "1"
+
+// The kinds of plugin, and the prefixes of their executables' names.
+const (
+	KindProvider = "provider"
+	KindIPSource = "ipsource"
+)
+
+// Discover registers the plugins in dirs, or in $PATH if dirs is empty,
+// with package provider or ipsource. The first executable of a name is
+// used, and built-in providers are not replaced. It returns the plugins
+// found, as KIND-NAME.
+func Discover(dirs []string) ([]string, error) {
+	if len(dirs) == 0 {
+		dirs = filepath.SplitList(os.Getenv("PATH"))
+	}
+	builtin := map[string]bool{}
+	for _, name := range provider.Names() {
+		builtin[name] = true
+	}
+	var found []string
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return found, err
+		}
+		for _, fi := range files {
+			kind, name, ok := parseName(fi.Name())
+			if !ok || fi.IsDir() || runtime.GOOS != "windows" && fi.Mode()&0111 == 0 || seen[kind+"-"+name] {
+				continue
+			}
+			seen[kind+"-"+name] = true
+			path := filepath.Join(dir, fi.Name())
+			switch kind {
+			case KindProvider:
+				if builtin[name] {
+					continue
+				}
+				provider.Register(name, func(opts provider.Options) (provider.Provider, error) {
+					return openProvider(path, opts)
+				})
+			case KindIPSource:
+				p := &process{path: path, kind: KindIPSource}
+				ipsource.Register(name, func(source string) (ipsource.Source, error) {
+					return &remoteSource{p: p, source: source}, nil
+				})
+			}
+			found = append(found, kind+"-"+name)
+		}
+	}
+	return found, nil
+}
+
+// parseName returns the kind and name of the plugin executable file.
+func parseName(file string) (kind, name string, ok bool) {
+	if runtime.GOOS == "windows" {
+		if !strings.EqualFold(filepath.Ext(file), ".exe") {
+			return "", "", false
+		}
+		file = file[:len(file)-4]
+	}
+	for _, kind := range []string{KindProvider, KindIPSource} {
+		prefix := "dnsup-" + kind + "-"
+		if strings.HasPrefix(file, prefix) && len(file) > len(prefix) {
+			return kind, strings.ToLower(file[len(prefix):]), true
+		}
+	}
+	return "", "", false
+}
+
+// process is a running plugin, started again after it exits.
+type process struct {
+	path string
+	kind string
+
+	mu     sync.Mutex
+	client *rpc.Client
+	cmd    *exec.Cmd
+}
+
+// conn returns a client of the plugin, starting it if it is not running.
+func (p *process) conn() (*rpc.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client != nil {
+		return p.client, nil
+	}
+	cmd := exec.Command(p.path)
+	cmd.Env = append(os.Environ(), "DNSUP_PLUGIN="+p.kind, "DNSUP_PLUGIN_PROTOCOL="+Protocol)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin %s: %v", p.path, err)
+	}
+	p.cmd = cmd
+	p.client = jsonrpc.NewClient(pipe{out, in})
+	return p.client, nil
+}
+
+// call calls method on the plugin, giving up when ctx is done. A plugin
+// that has exited is restarted on the next call.
+func (p *process) call(ctx context.Context, method string, args, reply interface{}) error {
+	c, err := p.conn()
+	if err != nil {
+		return err
+	}
+	select {
+	case call := <-c.Go(method, args, reply, make(chan *rpc.Call, 1)).Done:
+		if call.Error == rpc.ErrShutdown || call.Error == io.ErrUnexpectedEOF {
+			p.reset(c)
+			return fmt.Errorf("plugin %s exited", p.path)
+		}
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reset forgets the client c of a plugin that has exited.
+func (p *process) reset(c *rpc.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == c {
+		c.Close()
+		p.cmd.Wait()
+		p.client, p.cmd = nil, nil
+	}
+}
+
+// pipe joins the output and input of a plugin into its connection.
+type pipe struct {
+	io.ReadCloser
+	w io.WriteCloser
+}
+
+func (p pipe) Write(b []byte) (int, error) { return p.w.Write(b) }
+
+func (p pipe) Close() error {
+	err := p.w.Close()
+	p.ReadCloser.Close()
+	return err
+}
+
+// remoteProvider is a provider.Provider served by a plugin.
+type remoteProvider struct {
+	p *process
+}
+
+func openProvider(path string, opts provider.Options) (provider.Provider, error) {
+	rp := &remoteProvider{p: &process{path: path, kind: KindProvider}}
+	if err := rp.p.call(context.Background(), "Provider.Configure", opts, &struct{}{}); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+// GetArgs are the arguments of Provider.GetRecords.
+type GetArgs struct {
+	Name string
+	Type string
+}
+
+func (rp *remoteProvider) GetRecords(ctx context.Context, name, typ string) ([]provider.Record, error) {
+	var recs []provider.Record
+	err := rp.p.call(ctx, "Provider.GetRecords", GetArgs{Name: name, Type: typ}, &recs)
+	return recs, err
+}
+
+func (rp *remoteProvider) UpsertRecord(ctx context.Context, rec provider.Record) error {
+	return rp.p.call(ctx, "Provider.UpsertRecord", rec, &struct{}{})
+}
+
+func (rp *remoteProvider) DeleteRecord(ctx context.Context, rec provider.Record) error {
+	return rp.p.call(ctx, "Provider.DeleteRecord", rec, &struct{}{})
+}
+
+// LookupArgs are the arguments of Source.Lookup, which replies with the
+// address.
+type LookupArgs struct {
+	Source string
+	Family int
+}
+
+// remoteSource is an ipsource.Source served by a plugin.
+type remoteSource struct {
+	p      *process
+	source string
+}
+
+func (rs *remoteSource) Lookup(ctx context.Context, family ipsource.Family) (net.IP, error) {
+	var reply string
+	if err := rs.p.call(ctx, "Source.Lookup", LookupArgs{Source: rs.source, Family: int(family)}, &reply); err != nil {
+		return nil, fmt.Errorf("%s: %v", rs.source, err)
+	}
+	ip := net.ParseIP(strings.TrimSpace(reply))
+	if ip == nil || (ip.To4() != nil) != (family == ipsource.IPv4) {
+		return nil, fmt.Errorf("%s: invalid IPv%d address %q", rs.source, family, reply)
+	}
+	return ip, nil
+}
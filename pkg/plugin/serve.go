@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+
+	"github.com/johnweldon/dnsup/pkg/ipsource"
+	"github.com/johnweldon/dnsup/pkg/provider"
+)
+
+// ServeProvider serves the provider made by newProvider, from the options
+// dnsup configures it with, as a plugin, returning once dnsup closes the
+// connection. It is the body of a provider plugin's main function.
+func ServeProvider(newProvider provider.Factory) error {
+	return serve(KindProvider, &providerService{factory: newProvider})
+}
+
+// ServeSource serves the IP sources opened by open, given each source's
+// name with any argument, as a plugin, returning once dnsup closes the
+// connection. It is the body of an IP source plugin's main function.
+func ServeSource(open func(source string) (ipsource.Source, error)) error {
+	return serve(KindIPSource, &sourceService{open: open})
+}
+
+func serve(kind string, service interface{}) error {
+	if os.Getenv("DNSUP_PLUGIN") != kind {
+		return fmt.Errorf("this is a dnsup %s plugin, to be run by dnsup", kind)
+	}
+	if v := os.Getenv("DNSUP_PLUGIN_PROTOCOL"); v != Protocol {
+		return fmt.Errorf("dnsup speaks plugin protocol %q, not %s", v, Protocol)
+	}
+	name := "Provider"
+	if kind == KindIPSource {
+		name = "Source"
+	}
+	s := rpc.NewServer()
+	if err := s.RegisterName(name, service); err != nil {
+		return err
+	}
+	s.ServeCodec(jsonrpc.NewServerCodec(pipe{os.Stdin, os.Stdout}))
+	return nil
+}
+
+// providerService is the RPC service of a provider plugin.
+type providerService struct {
+	factory provider.Factory
+
+	mu sync.Mutex
+	p  provider.Provider
+}
+
+func (s *providerService) Configure(opts provider.Options, _ *struct{}) error {
+	p, err := s.factory(opts)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.p = p
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *providerService) provider() (provider.Provider, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.p == nil {
+		return nil, fmt.Errorf("provider not configured")
+	}
+	return s.p, nil
+}
+
+func (s *providerService) GetRecords(args GetArgs, reply *[]provider.Record) error {
+	p, err := s.provider()
+	if err != nil {
+		return err
+	}
+	*reply, err = p.GetRecords(context.Background(), args.Name, args.Type)
+	return err
+}
+
+func (s *providerService) UpsertRecord(rec provider.Record, _ *struct{}) error {
+	p, err := s.provider()
+	if err != nil {
+		return err
+	}
+	return p.UpsertRecord(context.Background(), rec)
+}
+
+func (s *providerService) DeleteRecord(rec provider.Record, _ *struct{}) error {
+	p, err := s.provider()
+	if err != nil {
+		return err
+	}
+	return p.DeleteRecord(context.Background(), rec)
+}
+
+// sourceService is the RPC service of an IP source plugin.
+type sourceService struct {
+	open func(source string) (ipsource.Source, error)
+}
+
+func (s *sourceService) Lookup(args LookupArgs, reply *string) error {
+	src, err := s.open(args.Source)
+	if err != nil {
+		return err
+	}
+	ip, err := src.Lookup(context.Background(), ipsource.Family(args.Family))
+	if err != nil {
+		return err
+	}
+	*reply = ip.String()
+	return nil
+}
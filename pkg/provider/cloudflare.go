@@ -0,0 +1,232 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("cloudflare", newCloudflare)
+}
+
+// cloudflare manages records through the Cloudflare v4 API. It needs an
+// API token with Zone:Read and DNS:Edit permissions in the "token" option
+// or CLOUDFLARE_API_TOKEN. The "proxied" option, if set, forces the
+// proxied flag of updated records; otherwise existing records keep theirs
+// and new ones are not proxied.
+type cloudflare struct {
+	api     *apiClient
+	proxied *bool
+	zones   map[string]string
+}
+
+type cfRecord struct {
+	ID       string  `json:"id,omitempty"`
+	Type     string  `json:"type"`
+	Name     string  `json:"name"`
+	Content  string  `json:"content"`
+	TTL      uint32  `json:"ttl"`
+	Priority *uint16 `json:"priority,omitempty"`
+	Proxied  *bool   `json:"proxied,omitempty"`
+}
+
+// newCFRecord converts rec, splitting the preference out of MX data.
+func newCFRecord(rec Record) (cfRecord, error) {
+	r := cfRecord{Type: rec.Type, Name: trimDot(rec.Name), Content: rec.Value, TTL: rec.TTL}
+	if r.TTL == 0 {
+		r.TTL = 1 // automatic
+	}
+	if rec.Type == "MX" {
+		fields := strings.Fields(rec.Value)
+		if len(fields) != 2 {
+			return r, fmt.Errorf("cloudflare: invalid MX value %q", rec.Value)
+		}
+		pref, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return r, fmt.Errorf("cloudflare: invalid MX preference %q", fields[0])
+		}
+		p := uint16(pref)
+		r.Priority, r.Content = &p, trimDot(fields[1])
+	}
+	return r, nil
+}
+
+func (r cfRecord) record() Record {
+	rec := Record{ID: r.ID, Name: fqdn(r.Name), Type: r.Type, Value: r.Content, TTL: r.TTL}
+	if rec.TTL == 1 {
+		rec.TTL = 0
+	}
+	if r.Type == "MX" && r.Priority != nil {
+		rec.Value = fmt.Sprintf("%d %s", *r.Priority, fqdn(r.Content))
+	}
+	return rec
+}
+
+type cfResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (r cfResponse) err() error {
+	if r.Success {
+		return nil
+	}
+	var msgs []string
+	for _, e := range r.Errors {
+		msgs = append(msgs, fmt.Sprintf("%d: %s", e.Code, e.Message))
+	}
+	return fmt.Errorf("cloudflare: %s", strings.Join(msgs, "; "))
+}
+
+func newCloudflare(opts Options) (Provider, error) {
+	token, err := opts.Require("cloudflare", "token", "CLOUDFLARE_API_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	c := &cloudflare{
+		api:   newAPIClient("https://api.cloudflare.com/client/v4"),
+		zones: map[string]string{},
+	}
+	c.api.header.Set("Authorization", "Bearer "+token)
+	if v, ok := opts["proxied"]; ok {
+		p, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("cloudflare: invalid proxied option %q", v)
+		}
+		c.proxied = &p
+	}
+	return c, nil
+}
+
+// zoneID finds the zone holding name, trying each parent domain in turn.
+func (c *cloudflare) zoneID(ctx context.Context, name string) (string, error) {
+	for _, zone := range parents(name) {
+		if id, ok := c.zones[zone]; ok {
+			return id, nil
+		}
+		var resp struct {
+			cfResponse
+			Result []struct {
+				ID string `json:"id"`
+			} `json:"result"`
+		}
+		if err := c.api.do(ctx, "GET", "/zones?name="+url.QueryEscape(zone), nil, &resp); err != nil {
+			return "", err
+		}
+		if err := resp.err(); err != nil {
+			return "", err
+		}
+		if len(resp.Result) > 0 {
+			c.zones[zone] = resp.Result[0].ID
+			return resp.Result[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("cloudflare: no zone found for %q", name)
+}
+
+func (c *cloudflare) list(ctx context.Context, zone, name, typ string) ([]cfRecord, error) {
+	q := url.Values{"name": {trimDot(name)}, "per_page": {"100"}}
+	if typ != "" {
+		q.Set("type", typ)
+	}
+	var resp struct {
+		cfResponse
+		Result []cfRecord `json:"result"`
+	}
+	if err := c.api.do(ctx, "GET", "/zones/"+zone+"/dns_records?"+q.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Result, resp.err()
+}
+
+func (c *cloudflare) GetRecords(ctx context.Context, name, typ string) ([]Record, error) {
+	zone, err := c.zoneID(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := c.list(ctx, zone, name, typ)
+	if err != nil {
+		return nil, err
+	}
+	var out []Record
+	for _, r := range recs {
+		out = append(out, r.record())
+	}
+	return out, nil
+}
+
+func (c *cloudflare) UpsertRecord(ctx context.Context, rec Record) error {
+	zone, err := c.zoneID(ctx, rec.Name)
+	if err != nil {
+		return err
+	}
+	existing, err := c.list(ctx, zone, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	body, err := newCFRecord(rec)
+	if err != nil {
+		return err
+	}
+	body.Proxied = c.proxied
+
+	var resp cfResponse
+	if len(existing) == 0 {
+		if body.Proxied == nil {
+			body.Proxied = new(bool)
+		}
+		if err := c.api.do(ctx, "POST", "/zones/"+zone+"/dns_records", body, &resp); err != nil {
+			return err
+		}
+		return resp.err()
+	}
+	if body.Proxied == nil {
+		body.Proxied = existing[0].Proxied
+	}
+	if err := c.api.do(ctx, "PUT", "/zones/"+zone+"/dns_records/"+existing[0].ID, body, &resp); err != nil {
+		return err
+	}
+	if err := resp.err(); err != nil {
+		return err
+	}
+	for _, extra := range existing[1:] {
+		if err := c.delete(ctx, zone, extra.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cloudflare) DeleteRecord(ctx context.Context, rec Record) error {
+	zone, err := c.zoneID(ctx, rec.Name)
+	if err != nil {
+		return err
+	}
+	if rec.ID != "" {
+		return c.delete(ctx, zone, rec.ID)
+	}
+	existing, err := c.list(ctx, zone, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	for _, r := range existing {
+		if err := c.delete(ctx, zone, r.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cloudflare) delete(ctx context.Context, zone, id string) error {
+	var resp cfResponse
+	if err := c.api.do(ctx, "DELETE", "/zones/"+zone+"/dns_records/"+id, nil, &resp); err != nil {
+		return err
+	}
+	return resp.err()
+}
@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+func init() {
+	Register("digitalocean", newDigitalOcean)
+}
+
+// digitalocean manages records through the DigitalOcean v2 API with the
+// token in the "token" option or DIGITALOCEAN_TOKEN.
+type digitalocean struct {
+	api *apiClient
+}
+
+type doRecord struct {
+	ID       int    `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	Priority *int   `json:"priority,omitempty"`
+	Port     *int   `json:"port,omitempty"`
+	Weight   *int   `json:"weight,omitempty"`
+	Flags    *int   `json:"flags,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	TTL      uint32 `json:"ttl,omitempty"`
+}
+
+type doLinks struct {
+	Pages struct {
+		Next string `json:"next"`
+	} `json:"pages"`
+}
+
+func newDigitalOcean(opts Options) (Provider, error) {
+	token, err := opts.Require("digitalocean", "token", "DIGITALOCEAN_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	d := &digitalocean{api: newAPIClient("https://api.digitalocean.com/v2")}
+	d.api.header.Set("Authorization", "Bearer "+token)
+	return &zonedProvider{name: "digitalocean", api: d}, nil
+}
+
+func (d *digitalocean) zones(ctx context.Context) ([]zone, error) {
+	var zones []zone
+	for page := 1; ; page++ {
+		var resp struct {
+			Domains []struct {
+				Name string `json:"name"`
+			} `json:"domains"`
+			Links doLinks `json:"links"`
+		}
+		if err := d.api.do(ctx, "GET", fmt.Sprintf("/domains?per_page=200&page=%d", page), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, dom := range resp.Domains {
+			zones = append(zones, zone{name: dom.Name, id: dom.Name})
+		}
+		if resp.Links.Pages.Next == "" {
+			return zones, nil
+		}
+	}
+}
+
+func (d *digitalocean) records(ctx context.Context, z zone) ([]Record, error) {
+	var recs []Record
+	for page := 1; ; page++ {
+		var resp struct {
+			Records []doRecord `json:"domain_records"`
+			Links   doLinks    `json:"links"`
+		}
+		if err := d.api.do(ctx, "GET", fmt.Sprintf("/domains/%s/records?per_page=200&page=%d", z.id, page), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Records {
+			recs = append(recs, r.record(z))
+		}
+		if resp.Links.Pages.Next == "" {
+			return recs, nil
+		}
+	}
+}
+
+func (r doRecord) record(z zone) Record {
+	data := r.Data
+	if isHostType(r.Type) {
+		data = absName(data, z)
+	}
+	itoa := func(p *int) string {
+		if p == nil {
+			return "0"
+		}
+		return strconv.Itoa(*p)
+	}
+	switch r.Type {
+	case "TXT":
+		data = quoteTXT(data)
+	case "MX":
+		data = itoa(r.Priority) + " " + data
+	case "SRV":
+		data = itoa(r.Priority) + " " + itoa(r.Weight) + " " + itoa(r.Port) + " " + data
+	case "CAA":
+		data = itoa(r.Flags) + " " + r.Tag + " " + quoteTXT(data)
+	}
+	return Record{ID: strconv.Itoa(r.ID), Name: absName(r.Name, z), Type: r.Type, Value: data, TTL: r.TTL}
+}
+
+func newDORecord(z zone, rec Record) (doRecord, error) {
+	r := doRecord{Type: rec.Type, Name: relName(rec.Name, z, "@"), Data: rec.Value, TTL: rec.TTL}
+	num := func(s string) (*int, error) {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q", rec.Type, rec.Value)
+		}
+		return &n, nil
+	}
+	var err error
+	switch rec.Type {
+	case "TXT":
+		r.Data = unquoteTXT(rec.Value)
+	case "MX":
+		var fs []string
+		if fs, err = splitValue(rec.Type, rec.Value, 2); err != nil {
+			return r, err
+		}
+		r.Data = fs[1]
+		r.Priority, err = num(fs[0])
+	case "SRV":
+		var fs []string
+		if fs, err = splitValue(rec.Type, rec.Value, 4); err != nil {
+			return r, err
+		}
+		r.Data = fs[3]
+		if r.Priority, err = num(fs[0]); err == nil {
+			if r.Weight, err = num(fs[1]); err == nil {
+				r.Port, err = num(fs[2])
+			}
+		}
+	case "CAA":
+		var fs []string
+		if fs, err = splitValue(rec.Type, rec.Value, 3); err != nil {
+			return r, err
+		}
+		r.Tag, r.Data = fs[1], unquoteTXT(fs[2])
+		r.Flags, err = num(fs[0])
+	}
+	return r, err
+}
+
+func (d *digitalocean) create(ctx context.Context, z zone, rec Record) error {
+	body, err := newDORecord(z, rec)
+	if err != nil {
+		return err
+	}
+	if body.TTL == 0 {
+		body.TTL = 1800
+	}
+	return d.api.do(ctx, "POST", "/domains/"+z.id+"/records", body, nil)
+}
+
+func (d *digitalocean) update(ctx context.Context, z zone, rec Record) error {
+	body, err := newDORecord(z, rec)
+	if err != nil {
+		return err
+	}
+	return d.api.do(ctx, "PUT", "/domains/"+z.id+"/records/"+rec.ID, body, nil)
+}
+
+func (d *digitalocean) remove(ctx context.Context, z zone, id string) error {
+	return d.api.do(ctx, "DELETE", "/domains/"+z.id+"/records/"+id, nil, nil)
+}
@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	Register("gandi", newGandi)
+}
+
+// gandi manages records through the Gandi LiveDNS v5 API with a personal
+// access token in the "token" option or GANDI_PAT, or a legacy API key in
+// "api_key" or GANDI_API_KEY. LiveDNS, like Route 53, holds records as
+// sets of every value of a name and type with a single TTL and no record
+// IDs, so upserting replaces the whole set and deleting removes it.
+type gandi struct {
+	api   *apiClient
+	zones []string
+}
+
+type gdRRSet struct {
+	Name   string   `json:"rrset_name,omitempty"`
+	Type   string   `json:"rrset_type,omitempty"`
+	TTL    uint32   `json:"rrset_ttl,omitempty"`
+	Values []string `json:"rrset_values"`
+}
+
+func newGandi(opts Options) (Provider, error) {
+	g := &gandi{api: newAPIClient("https://api.gandi.net/v5/livedns")}
+	switch token, key := opts.Get("token", "GANDI_PAT"), opts.Get("api_key", "GANDI_API_KEY"); {
+	case token != "":
+		g.api.header.Set("Authorization", "Bearer "+token)
+	case key != "":
+		g.api.header.Set("Authorization", "Apikey "+key)
+	default:
+		return nil, fmt.Errorf("gandi: missing %q or %q option or GANDI_PAT", "token", "api_key")
+	}
+	return g, nil
+}
+
+// zone returns the LiveDNS domain holding name.
+func (g *gandi) zone(ctx context.Context, name string) (zone, error) {
+	if g.zones == nil {
+		for page := 1; ; page++ {
+			var resp []struct {
+				FQDN string `json:"fqdn"`
+			}
+			if err := g.api.do(ctx, "GET", fmt.Sprintf("/domains?per_page=100&page=%d", page), nil, &resp); err != nil {
+				return zone{}, fmt.Errorf("gandi: %v", err)
+			}
+			for _, d := range resp {
+				g.zones = append(g.zones, d.FQDN)
+			}
+			if len(resp) < 100 {
+				break
+			}
+		}
+	}
+	for _, cand := range parents(name) {
+		for _, z := range g.zones {
+			if strings.EqualFold(z, cand) {
+				return zone{name: z, id: z}, nil
+			}
+		}
+	}
+	return zone{}, fmt.Errorf("gandi: no domain found for %q", name)
+}
+
+// path returns the API path of the sets named name in z, and of type typ
+// unless it is empty.
+func (g *gandi) path(z zone, name, typ string) string {
+	p := "/domains/" + url.PathEscape(z.id) + "/records/" + url.PathEscape(relName(name, z, "@"))
+	if typ != "" {
+		p += "/" + typ
+	}
+	return p
+}
+
+func (g *gandi) list(ctx context.Context, name, typ string) (zone, []gdRRSet, error) {
+	z, err := g.zone(ctx, name)
+	if err != nil {
+		return z, nil, err
+	}
+	var all []gdRRSet
+	if err := g.api.do(ctx, "GET", g.path(z, name, ""), nil, &all); err != nil {
+		return z, nil, fmt.Errorf("gandi: %v", err)
+	}
+	var sets []gdRRSet
+	for _, set := range all {
+		if typ == "" || set.Type == typ {
+			sets = append(sets, set)
+		}
+	}
+	return z, sets, nil
+}
+
+func (g *gandi) GetRecords(ctx context.Context, name, typ string) ([]Record, error) {
+	z, sets, err := g.list(ctx, name, typ)
+	if err != nil {
+		return nil, err
+	}
+	var recs []Record
+	for _, set := range sets {
+		for _, v := range set.Values {
+			recs = append(recs, Record{Name: absName(set.Name, z), Type: set.Type, Value: v, TTL: set.TTL})
+		}
+	}
+	return recs, nil
+}
+
+func (g *gandi) UpsertRecord(ctx context.Context, rec Record) error {
+	z, sets, err := g.list(ctx, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	set := gdRRSet{TTL: rec.TTL, Values: []string{rec.Value}}
+	if set.TTL == 0 && len(sets) > 0 {
+		set.TTL = sets[0].TTL
+	}
+	if err := g.api.do(ctx, "PUT", g.path(z, rec.Name, rec.Type), set, nil); err != nil {
+		return fmt.Errorf("gandi: %v", err)
+	}
+	return nil
+}
+
+// DeleteRecord deletes the set of rec's name and type, whatever its ID.
+func (g *gandi) DeleteRecord(ctx context.Context, rec Record) error {
+	z, sets, err := g.list(ctx, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	for _, set := range sets {
+		if err := g.api.do(ctx, "DELETE", g.path(z, rec.Name, set.Type), nil, nil); err != nil {
+			return fmt.Errorf("gandi: %v", err)
+		}
+	}
+	return nil
+}
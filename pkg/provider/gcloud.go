@@ -0,0 +1,348 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("gcloud", newGCloud)
+}
+
+const gcloudScope = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+
+// gcloud manages records in Google Cloud DNS managed zones. It
+// authenticates with the service account key file named by the
+// "credentials" option or GOOGLE_APPLICATION_CREDENTIALS; the project is
+// the "project" option or the key's own project. The managed zone is
+// found from the record name unless "zone" is set.
+type gcloud struct {
+	api     *apiClient
+	key     gcloudKey
+	project string
+	zone    string
+	zones   map[string]string
+
+	token   string
+	expires time.Time
+
+	batching bool
+	pending  map[string]*gcChange
+}
+
+type gcloudKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	ProjectID   string `json:"project_id"`
+
+	signer *rsa.PrivateKey
+}
+
+type gcRRSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     uint32   `json:"ttl"`
+	RRDatas []string `json:"rrdatas"`
+}
+
+type gcChange struct {
+	Additions []gcRRSet `json:"additions,omitempty"`
+	Deletions []gcRRSet `json:"deletions,omitempty"`
+}
+
+func newGCloud(opts Options) (Provider, error) {
+	path, err := opts.Require("gcloud", "credentials", "GOOGLE_APPLICATION_CREDENTIALS")
+	if err != nil {
+		return nil, err
+	}
+	key, err := loadGCloudKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("gcloud: %v", err)
+	}
+	g := &gcloud{
+		api:     newAPIClient("https://dns.googleapis.com/dns/v1"),
+		key:     key,
+		project: opts.Get("project", ""),
+		zone:    opts.Get("zone", ""),
+		zones:   map[string]string{},
+	}
+	if g.project == "" {
+		g.project = key.ProjectID
+	}
+	if g.project == "" {
+		return nil, fmt.Errorf("gcloud: missing \"project\" option")
+	}
+	return g, nil
+}
+
+func loadGCloudKey(path string) (gcloudKey, error) {
+	var key gcloudKey
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return key, err
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return key, fmt.Errorf("%s: %v", path, err)
+	}
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return key, fmt.Errorf("%s: not a service account key", path)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return key, fmt.Errorf("%s: invalid private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return key, fmt.Errorf("%s: %v", path, err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return key, fmt.Errorf("%s: private key is not RSA", path)
+	}
+	key.signer = rsaKey
+	return key, nil
+}
+
+// authorize fetches an OAuth access token with a signed JWT assertion
+// when the current one is missing or about to expire.
+func (g *gcloud) authorize(ctx context.Context) error {
+	if g.token != "" && time.Now().Before(g.expires) {
+		return nil
+	}
+	now := time.Now()
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   g.key.ClientEmail,
+		"scope": gcloudScope,
+		"aud":   g.key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	unsigned := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, g.key.signer, crypto.SHA256, sum[:])
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", g.key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := g.api.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("gcloud: %v", err)
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return fmt.Errorf("gcloud: token: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || tok.AccessToken == "" {
+		return fmt.Errorf("gcloud: token: %s: %s", resp.Status, tok.Error)
+	}
+	g.token = tok.AccessToken
+	g.expires = now.Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	g.api.header.Set("Authorization", "Bearer "+g.token)
+	return nil
+}
+
+func (g *gcloud) do(ctx context.Context, method, path string, in, out interface{}) error {
+	if err := g.authorize(ctx); err != nil {
+		return err
+	}
+	if err := g.api.do(ctx, method, "/projects/"+url.PathEscape(g.project)+path, in, out); err != nil {
+		return fmt.Errorf("gcloud: %v", err)
+	}
+	return nil
+}
+
+// managedZone finds the managed zone for name by trying each parent
+// domain.
+func (g *gcloud) managedZone(ctx context.Context, name string) (string, error) {
+	if g.zone != "" {
+		return g.zone, nil
+	}
+	for _, zone := range parents(name) {
+		if id, ok := g.zones[zone]; ok {
+			return id, nil
+		}
+		var resp struct {
+			Zones []struct {
+				Name    string `json:"name"`
+				DNSName string `json:"dnsName"`
+			} `json:"managedZones"`
+		}
+		if err := g.do(ctx, "GET", "/managedZones?dnsName="+url.QueryEscape(fqdn(zone)), nil, &resp); err != nil {
+			return "", err
+		}
+		if len(resp.Zones) > 0 {
+			g.zones[zone] = resp.Zones[0].Name
+			return resp.Zones[0].Name, nil
+		}
+	}
+	return "", fmt.Errorf("gcloud: no managed zone found for %q", name)
+}
+
+func (g *gcloud) rrsets(ctx context.Context, zone, name, typ string) ([]gcRRSet, error) {
+	q := url.Values{"name": {fqdn(name)}}
+	if typ != "" {
+		q.Set("type", typ)
+	}
+	var resp struct {
+		RRSets []gcRRSet `json:"rrsets"`
+	}
+	if err := g.do(ctx, "GET", "/managedZones/"+zone+"/rrsets?"+q.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.RRSets, nil
+}
+
+func (g *gcloud) GetRecords(ctx context.Context, name, typ string) ([]Record, error) {
+	zone, err := g.managedZone(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	sets, err := g.rrsets(ctx, zone, name, typ)
+	if err != nil {
+		return nil, err
+	}
+	var recs []Record
+	for _, set := range sets {
+		for _, v := range set.RRDatas {
+			recs = append(recs, Record{Name: set.Name, Type: set.Type, Value: v, TTL: set.TTL})
+		}
+	}
+	return recs, nil
+}
+
+// UpsertRecord replaces the RRset of rec's name and type. Cloud DNS
+// changes delete the existing set, which must match exactly, and add the
+// new one; the existing TTL is kept when rec has none.
+func (g *gcloud) UpsertRecord(ctx context.Context, rec Record) error {
+	zone, err := g.managedZone(ctx, rec.Name)
+	if err != nil {
+		return err
+	}
+	set := gcRRSet{Name: fqdn(rec.Name), Type: rec.Type, TTL: rec.TTL, RRDatas: []string{rec.Value}}
+	return g.change(ctx, zone, set, true)
+}
+
+// DeleteRecord deletes the RRset of rec's name and type.
+func (g *gcloud) DeleteRecord(ctx context.Context, rec Record) error {
+	zone, err := g.managedZone(ctx, rec.Name)
+	if err != nil {
+		return err
+	}
+	return g.change(ctx, zone, gcRRSet{Name: fqdn(rec.Name), Type: rec.Type}, false)
+}
+
+// change replaces or, unless add is set, deletes the RRset of set's name
+// and type in zone, either now or as part of the pending batch.
+func (g *gcloud) change(ctx context.Context, zone string, set gcRRSet, add bool) error {
+	c := &gcChange{}
+	if g.batching {
+		if g.pending[zone] == nil {
+			g.pending[zone] = c
+		}
+		c = g.pending[zone]
+	}
+	queued := false
+	for i, a := range c.Additions {
+		if strings.EqualFold(a.Name, set.Name) && a.Type == set.Type {
+			// The RRset was already changed in this batch; its
+			// deletion of the original set still stands.
+			if set.TTL == 0 {
+				set.TTL = a.TTL
+			}
+			c.Additions = append(c.Additions[:i], c.Additions[i+1:]...)
+			queued = true
+			break
+		}
+	}
+	if !queued {
+		existing, err := g.rrsets(ctx, zone, set.Name, set.Type)
+		if err != nil {
+			return err
+		}
+		for _, e := range existing {
+			if e.Type != set.Type || !strings.EqualFold(e.Name, set.Name) {
+				continue
+			}
+			c.Deletions = append(c.Deletions, e)
+			if set.TTL == 0 {
+				set.TTL = e.TTL
+			}
+		}
+	}
+	if add {
+		if set.TTL == 0 {
+			set.TTL = 300
+		}
+		c.Additions = append(c.Additions, set)
+	}
+	if g.batching {
+		return nil
+	}
+	return g.submit(ctx, zone, c)
+}
+
+func (g *gcloud) submit(ctx context.Context, zone string, c *gcChange) error {
+	if len(c.Additions) == 0 && len(c.Deletions) == 0 {
+		return nil
+	}
+	return g.do(ctx, "POST", "/managedZones/"+zone+"/changes", c, nil)
+}
+
+func (g *gcloud) Begin() {
+	g.batching = true
+	g.pending = map[string]*gcChange{}
+}
+
+func (g *gcloud) Commit(ctx context.Context) error {
+	g.batching = false
+	pending := g.pending
+	g.pending = nil
+	var zones []string
+	for zone := range pending {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	for _, zone := range zones {
+		if err := g.submit(ctx, zone, pending[zone]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
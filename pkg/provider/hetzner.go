@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+func init() {
+	Register("hetzner", newHetzner)
+}
+
+// hetzner manages records through the Hetzner DNS API with the token in
+// the "token" option or HETZNER_DNS_TOKEN. Hetzner takes record data in
+// presentation format, so values are passed through unchanged.
+type hetzner struct {
+	api *apiClient
+}
+
+type hzRecord struct {
+	ID     string `json:"id,omitempty"`
+	ZoneID string `json:"zone_id"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	TTL    uint32 `json:"ttl,omitempty"`
+}
+
+type hzMeta struct {
+	Pagination struct {
+		LastPage int `json:"last_page"`
+	} `json:"pagination"`
+}
+
+func newHetzner(opts Options) (Provider, error) {
+	token, err := opts.Require("hetzner", "token", "HETZNER_DNS_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	h := &hetzner{api: newAPIClient("https://dns.hetzner.com/api/v1")}
+	h.api.header.Set("Auth-API-Token", token)
+	return &zonedProvider{name: "hetzner", api: h}, nil
+}
+
+func (h *hetzner) zones(ctx context.Context) ([]zone, error) {
+	var zones []zone
+	for page := 1; ; page++ {
+		var resp struct {
+			Zones []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"zones"`
+			Meta hzMeta `json:"meta"`
+		}
+		if err := h.api.do(ctx, "GET", fmt.Sprintf("/zones?per_page=100&page=%d", page), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, z := range resp.Zones {
+			zones = append(zones, zone{name: z.Name, id: z.ID})
+		}
+		if page >= resp.Meta.Pagination.LastPage {
+			return zones, nil
+		}
+	}
+}
+
+func (h *hetzner) records(ctx context.Context, z zone) ([]Record, error) {
+	var recs []Record
+	for page := 1; ; page++ {
+		var resp struct {
+			Records []hzRecord `json:"records"`
+			Meta    hzMeta     `json:"meta"`
+		}
+		path := fmt.Sprintf("/records?zone_id=%s&per_page=100&page=%d", url.QueryEscape(z.id), page)
+		if err := h.api.do(ctx, "GET", path, nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Records {
+			recs = append(recs, Record{ID: r.ID, Name: absName(r.Name, z), Type: r.Type, Value: r.Value, TTL: r.TTL})
+		}
+		if page >= resp.Meta.Pagination.LastPage {
+			return recs, nil
+		}
+	}
+}
+
+func newHZRecord(z zone, rec Record) hzRecord {
+	return hzRecord{ZoneID: z.id, Type: rec.Type, Name: relName(rec.Name, z, "@"), Value: rec.Value, TTL: rec.TTL}
+}
+
+func (h *hetzner) create(ctx context.Context, z zone, rec Record) error {
+	return h.api.do(ctx, "POST", "/records", newHZRecord(z, rec), nil)
+}
+
+func (h *hetzner) update(ctx context.Context, z zone, rec Record) error {
+	return h.api.do(ctx, "PUT", "/records/"+rec.ID, newHZRecord(z, rec), nil)
+}
+
+func (h *hetzner) remove(ctx context.Context, z zone, id string) error {
+	return h.api.do(ctx, "DELETE", "/records/"+id, nil, nil)
+}
@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/johnweldon/dnsup/pkg/retry"
+)
+
+// Retry is the policy for retrying API requests that fail transiently:
+// those throttled or met by an unavailable server, and those that could
+// not be sent, unless creating something.
+var Retry = retry.Default
+
+// apiClient sends JSON requests to a provider's REST API.
+type apiClient struct {
+	base   string
+	header http.Header
+	client *http.Client
+	// sign, if set, adds headers authenticating each request, made with
+	// the given body, for APIs that sign requests rather than take a
+	// fixed token.
+	sign func(req *http.Request, body []byte)
+}
+
+func newAPIClient(base string) *apiClient {
+	return &apiClient{
+		base:   base,
+		header: http.Header{},
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends in, if not nil, as the JSON body of a request for path and
+// decodes a JSON response into out, if not nil. Responses other than 2xx
+// are returned as errors including the start of the response body.
+func (c *apiClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	return Retry.Do(ctx, "provider", func() error {
+		return c.send(ctx, method, path, body, out)
+	})
+}
+
+// send makes a single attempt at a request for do, marking the errors not
+// worth retrying as permanent.
+func (c *apiClient) send(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.sign != nil {
+		c.sign(req, body)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return unsent(method, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(data) > 512 {
+			data = data[:512]
+		}
+		err := fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(data))
+		if !transient(resp.StatusCode) {
+			err = retry.Permanent(err)
+		}
+		return err
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return retry.Permanent(json.Unmarshal(data, out))
+}
+
+// transient reports whether a response with the given status is worth
+// retrying: the request was throttled or the server unavailable.
+func transient(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// unsent returns the error of sending a request, marked permanent for POST
+// requests, which might have been carried out and must not be repeated.
+func unsent(method string, err error) error {
+	if method == "POST" {
+		return retry.Permanent(err)
+	}
+	return err
+}
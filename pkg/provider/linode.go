@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+func init() {
+	Register("linode", newLinode)
+}
+
+// linode manages records through the Linode v4 API with the token in the
+// "token" option or LINODE_TOKEN. SRV records, which Linode models by
+// service and protocol, can be read but not written.
+type linode struct {
+	api *apiClient
+}
+
+type lnRecord struct {
+	ID       int    `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Target   string `json:"target"`
+	Priority int    `json:"priority,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	TTL      uint32 `json:"ttl_sec,omitempty"`
+}
+
+func newLinode(opts Options) (Provider, error) {
+	token, err := opts.Require("linode", "token", "LINODE_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	l := &linode{api: newAPIClient("https://api.linode.com/v4")}
+	l.api.header.Set("Authorization", "Bearer "+token)
+	return &zonedProvider{name: "linode", api: l}, nil
+}
+
+func (l *linode) zones(ctx context.Context) ([]zone, error) {
+	var zones []zone
+	for page := 1; ; page++ {
+		var resp struct {
+			Data []struct {
+				ID     int    `json:"id"`
+				Domain string `json:"domain"`
+			} `json:"data"`
+			Pages int `json:"pages"`
+		}
+		if err := l.api.do(ctx, "GET", fmt.Sprintf("/domains?page_size=500&page=%d", page), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, d := range resp.Data {
+			zones = append(zones, zone{name: d.Domain, id: strconv.Itoa(d.ID)})
+		}
+		if page >= resp.Pages {
+			return zones, nil
+		}
+	}
+}
+
+func (l *linode) records(ctx context.Context, z zone) ([]Record, error) {
+	var recs []Record
+	for page := 1; ; page++ {
+		var resp struct {
+			Data  []lnRecord `json:"data"`
+			Pages int        `json:"pages"`
+		}
+		if err := l.api.do(ctx, "GET", fmt.Sprintf("/domains/%s/records?page_size=500&page=%d", z.id, page), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Data {
+			recs = append(recs, r.record(z))
+		}
+		if page >= resp.Pages {
+			return recs, nil
+		}
+	}
+}
+
+func (r lnRecord) record(z zone) Record {
+	data := r.Target
+	if isHostType(r.Type) {
+		data = absName(data, z)
+	}
+	switch r.Type {
+	case "TXT":
+		data = quoteTXT(data)
+	case "MX":
+		data = fmt.Sprintf("%d %s", r.Priority, data)
+	case "SRV":
+		data = fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, data)
+	case "CAA":
+		data = fmt.Sprintf("0 %s %s", r.Tag, quoteTXT(data))
+	}
+	return Record{ID: strconv.Itoa(r.ID), Name: absName(r.Name, z), Type: r.Type, Value: data, TTL: r.TTL}
+}
+
+func newLNRecord(z zone, rec Record) (lnRecord, error) {
+	r := lnRecord{Type: rec.Type, Name: relName(rec.Name, z, ""), Target: rec.Value, TTL: rec.TTL}
+	switch rec.Type {
+	case "TXT":
+		r.Target = unquoteTXT(rec.Value)
+	case "MX":
+		fs, err := splitValue(rec.Type, rec.Value, 2)
+		if err != nil {
+			return r, err
+		}
+		if r.Priority, err = strconv.Atoi(fs[0]); err != nil {
+			return r, fmt.Errorf("invalid MX value %q", rec.Value)
+		}
+		r.Target = trimDot(fs[1])
+	case "CAA":
+		fs, err := splitValue(rec.Type, rec.Value, 3)
+		if err != nil {
+			return r, err
+		}
+		r.Tag, r.Target = fs[1], unquoteTXT(fs[2])
+	case "SRV":
+		return r, fmt.Errorf("SRV records are not supported")
+	default:
+		if isHostType(rec.Type) {
+			r.Target = trimDot(rec.Value)
+		}
+	}
+	return r, nil
+}
+
+func (l *linode) create(ctx context.Context, z zone, rec Record) error {
+	body, err := newLNRecord(z, rec)
+	if err != nil {
+		return err
+	}
+	return l.api.do(ctx, "POST", "/domains/"+z.id+"/records", body, nil)
+}
+
+func (l *linode) update(ctx context.Context, z zone, rec Record) error {
+	body, err := newLNRecord(z, rec)
+	if err != nil {
+		return err
+	}
+	return l.api.do(ctx, "PUT", "/domains/"+z.id+"/records/"+rec.ID, body, nil)
+}
+
+func (l *linode) remove(ctx context.Context, z zone, id string) error {
+	return l.api.do(ctx, "DELETE", "/domains/"+z.id+"/records/"+id, nil, nil)
+}
@@ -0,0 +1,387 @@
+package provider
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnweldon/dnsup/pkg/retry"
+)
+
+func init() {
+	Register("namecheap", newNamecheap)
+}
+
+// namecheap manages the host records of domains using Namecheap's DNS
+// through its XML API, as the "api_user" with the "api_key" (or
+// NAMECHEAP_API_USER and NAMECHEAP_API_KEY) from the whitelisted address
+// in "client_ip" (or NAMECHEAP_CLIENT_IP); "username" defaults to the API
+// user and "sandbox" = "true" selects the sandbox API.
+//
+// Namecheap splits each domain into its second and top level parts and
+// has no call changing a single record: every change reads all the hosts
+// of the domain and sets them all again. Within a batch the hosts are
+// read once and set on Commit. MX records are only served while the
+// domain's mail setting is "MX", which upserting one selects.
+type namecheap struct {
+	base               string
+	user, key, account string
+	clientIP           string
+	client             *http.Client
+	domains            []string
+
+	batching bool
+	pending  map[string]*ncHosts
+}
+
+// ncHosts are the hosts of a domain, as got and set.
+type ncHosts struct {
+	emailType string
+	hosts     []ncHost
+	changed   bool
+}
+
+type ncHost struct {
+	ID      string `xml:"HostId,attr"`
+	Name    string `xml:"Name,attr"`
+	Type    string `xml:"Type,attr"`
+	Address string `xml:"Address,attr"`
+	MXPref  string `xml:"MXPref,attr"`
+	TTL     uint32 `xml:"TTL,attr"`
+}
+
+type ncResponse struct {
+	Status string `xml:"Status,attr"`
+	Errors []struct {
+		Number  string `xml:"Number,attr"`
+		Message string `xml:",chardata"`
+	} `xml:"Errors>Error"`
+}
+
+func newNamecheap(opts Options) (Provider, error) {
+	n := &namecheap{
+		base:   "https://api.namecheap.com/xml.response",
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	var err error
+	if n.user, err = opts.Require("namecheap", "api_user", "NAMECHEAP_API_USER"); err != nil {
+		return nil, err
+	}
+	if n.key, err = opts.Require("namecheap", "api_key", "NAMECHEAP_API_KEY"); err != nil {
+		return nil, err
+	}
+	if n.clientIP, err = opts.Require("namecheap", "client_ip", "NAMECHEAP_CLIENT_IP"); err != nil {
+		return nil, err
+	}
+	if n.account = opts.Get("username", ""); n.account == "" {
+		n.account = n.user
+	}
+	if v, ok := opts["sandbox"]; ok {
+		sandbox, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("namecheap: invalid sandbox option %q", v)
+		}
+		if sandbox {
+			n.base = "https://api.sandbox.namecheap.com/xml.response"
+		}
+	}
+	return n, nil
+}
+
+// domain returns the Namecheap domain holding name.
+func (n *namecheap) domain(ctx context.Context, name string) (string, error) {
+	if n.domains == nil {
+		for page := 1; ; page++ {
+			var resp struct {
+				Domains []struct {
+					Name string `xml:"Name,attr"`
+				} `xml:"CommandResponse>DomainGetListResult>Domain"`
+				Total int `xml:"CommandResponse>Paging>TotalItems"`
+			}
+			q := url.Values{"PageSize": {"100"}, "Page": {strconv.Itoa(page)}}
+			if err := n.do(ctx, "namecheap.domains.getList", q, &resp); err != nil {
+				return "", err
+			}
+			for _, d := range resp.Domains {
+				n.domains = append(n.domains, d.Name)
+			}
+			if len(resp.Domains) == 0 || len(n.domains) >= resp.Total {
+				break
+			}
+		}
+	}
+	for _, cand := range parents(name) {
+		for _, d := range n.domains {
+			if strings.EqualFold(d, cand) {
+				return d, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("namecheap: no domain found for %q", name)
+}
+
+// ncDomain returns the second and top level parts of domain, as the API
+// names it: "example" and "co.uk" for "example.co.uk".
+func ncDomain(domain string) url.Values {
+	i := strings.Index(domain, ".")
+	return url.Values{"SLD": {domain[:i]}, "TLD": {domain[i+1:]}}
+}
+
+// hosts returns the hosts of domain, those read for the batch if any.
+func (n *namecheap) hosts(ctx context.Context, domain string) (*ncHosts, error) {
+	if h, ok := n.pending[domain]; ok {
+		return h, nil
+	}
+	var resp struct {
+		Result struct {
+			EmailType string   `xml:"EmailType,attr"`
+			Hosts     []ncHost `xml:"host"`
+		} `xml:"CommandResponse>DomainDNSGetHostsResult"`
+	}
+	if err := n.do(ctx, "namecheap.domains.dns.getHosts", ncDomain(domain), &resp); err != nil {
+		return nil, err
+	}
+	h := &ncHosts{emailType: resp.Result.EmailType, hosts: resp.Result.Hosts}
+	if n.batching {
+		n.pending[domain] = h
+	}
+	return h, nil
+}
+
+// set replaces all the hosts of domain.
+func (n *namecheap) set(ctx context.Context, domain string, h *ncHosts) error {
+	q := ncDomain(domain)
+	if h.emailType != "" {
+		q.Set("EmailType", h.emailType)
+	}
+	for i, host := range h.hosts {
+		k := strconv.Itoa(i + 1)
+		q.Set("HostName"+k, host.Name)
+		q.Set("RecordType"+k, host.Type)
+		q.Set("Address"+k, host.Address)
+		q.Set("TTL"+k, strconv.FormatUint(uint64(host.TTL), 10))
+		if host.Type == "MX" {
+			q.Set("MXPref"+k, host.MXPref)
+		}
+	}
+	return n.do(ctx, "namecheap.domains.dns.setHosts", q, nil)
+}
+
+// edit applies f to the hosts of the domain holding name, setting them
+// again at once or, in a batch, on Commit.
+func (n *namecheap) edit(ctx context.Context, name string, f func(domain string, h *ncHosts) error) error {
+	domain, err := n.domain(ctx, name)
+	if err != nil {
+		return err
+	}
+	h, err := n.hosts(ctx, domain)
+	if err != nil {
+		return err
+	}
+	if err := f(domain, h); err != nil {
+		return err
+	}
+	if n.batching || !h.changed {
+		return nil
+	}
+	return n.set(ctx, domain, h)
+}
+
+// matches reports whether host is named name in domain and of type typ,
+// or of any type if typ is empty.
+func (host ncHost) matches(domain, name, typ string) bool {
+	return strings.EqualFold(host.Name, relName(name, zone{name: domain}, "@")) && (typ == "" || host.Type == typ)
+}
+
+func (host ncHost) record(domain string) Record {
+	data := host.Address
+	if isHostType(host.Type) {
+		data = fqdn(data)
+	}
+	switch host.Type {
+	case "TXT":
+		data = quoteTXT(data)
+	case "MX":
+		data = host.MXPref + " " + data
+	}
+	return Record{ID: host.ID, Name: absName(host.Name, zone{name: domain}), Type: host.Type, Value: data, TTL: host.TTL}
+}
+
+func newNCHost(domain string, rec Record) (ncHost, error) {
+	host := ncHost{Name: relName(rec.Name, zone{name: domain}, "@"), Type: rec.Type, Address: rec.Value, TTL: rec.TTL}
+	if host.TTL == 0 {
+		host.TTL = 1800
+	}
+	switch rec.Type {
+	case "TXT":
+		host.Address = unquoteTXT(rec.Value)
+	case "MX":
+		fs, err := splitValue(rec.Type, rec.Value, 2)
+		if err != nil {
+			return host, err
+		}
+		host.MXPref, host.Address = fs[0], fs[1]
+	}
+	return host, nil
+}
+
+func (n *namecheap) GetRecords(ctx context.Context, name, typ string) ([]Record, error) {
+	domain, err := n.domain(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	h, err := n.hosts(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	var recs []Record
+	for _, host := range h.hosts {
+		if host.matches(domain, name, typ) {
+			recs = append(recs, host.record(domain))
+		}
+	}
+	return recs, nil
+}
+
+func (n *namecheap) UpsertRecord(ctx context.Context, rec Record) error {
+	return n.edit(ctx, rec.Name, func(domain string, h *ncHosts) error {
+		host, err := newNCHost(domain, rec)
+		if err != nil {
+			return err
+		}
+		var kept []ncHost
+		replaced := false
+		for _, old := range h.hosts {
+			switch {
+			case !old.matches(domain, rec.Name, rec.Type):
+				kept = append(kept, old)
+			case !replaced:
+				if rec.TTL == 0 {
+					host.TTL = old.TTL
+				}
+				host.ID, replaced = old.ID, true
+				kept = append(kept, host)
+			}
+		}
+		if !replaced {
+			kept = append(kept, host)
+		}
+		h.hosts, h.changed = kept, true
+		if rec.Type == "MX" {
+			h.emailType = "MX"
+		}
+		return nil
+	})
+}
+
+func (n *namecheap) DeleteRecord(ctx context.Context, rec Record) error {
+	return n.edit(ctx, rec.Name, func(domain string, h *ncHosts) error {
+		var kept []ncHost
+		for _, old := range h.hosts {
+			if old.matches(domain, rec.Name, rec.Type) && (rec.ID == "" || old.ID == rec.ID) {
+				h.changed = true
+				continue
+			}
+			kept = append(kept, old)
+		}
+		h.hosts = kept
+		return nil
+	})
+}
+
+func (n *namecheap) Begin() {
+	n.batching = true
+	n.pending = map[string]*ncHosts{}
+}
+
+func (n *namecheap) Commit(ctx context.Context) error {
+	n.batching = false
+	pending := n.pending
+	n.pending = nil
+	var domains []string
+	for domain, h := range pending {
+		if h.changed {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		if err := n.set(ctx, domain, pending[domain]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// do calls the API command with the parameters q, decoding the XML
+// response into out if not nil. Commands are POSTed, as setHosts takes
+// every host of a domain.
+func (n *namecheap) do(ctx context.Context, command string, q url.Values, out interface{}) error {
+	form := url.Values{
+		"ApiUser":  {n.user},
+		"ApiKey":   {n.key},
+		"UserName": {n.account},
+		"ClientIp": {n.clientIP},
+		"Command":  {command},
+	}
+	for k, v := range q {
+		form[k] = v
+	}
+	body := form.Encode()
+	return Retry.Do(ctx, "provider", func() error {
+		return n.send(ctx, command, body, out)
+	})
+}
+
+// send makes a single attempt at a command for do. Namecheap reports
+// failed commands in the body of 200 responses; they are not retried, nor
+// are writes that could not be sent.
+func (n *namecheap) send(ctx context.Context, command, body string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", n.base, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		if strings.HasSuffix(command, ".setHosts") {
+			return retry.Permanent(err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("namecheap: %s: %s", command, resp.Status)
+		if !transient(resp.StatusCode) {
+			err = retry.Permanent(err)
+		}
+		return err
+	}
+	var r ncResponse
+	if err := xml.Unmarshal(data, &r); err != nil {
+		return retry.Permanent(fmt.Errorf("namecheap: %s: %v", command, err))
+	}
+	if r.Status != "OK" {
+		var msgs []string
+		for _, e := range r.Errors {
+			msgs = append(msgs, fmt.Sprintf("%s (%s)", strings.TrimSpace(e.Message), e.Number))
+		}
+		return retry.Permanent(fmt.Errorf("namecheap: %s: %s", command, strings.Join(msgs, "; ")))
+	}
+	if out == nil {
+		return nil
+	}
+	return retry.Permanent(xml.Unmarshal(data, out))
+}
@@ -0,0 +1,247 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("ovh", newOVH)
+}
+
+// ovhEndpoints are the API roots of the OVHcloud regions.
+var ovhEndpoints = map[string]string{
+	"ovh-eu": "https://eu.api.ovh.com/1.0",
+	"ovh-ca": "https://ca.api.ovh.com/1.0",
+	"ovh-us": "https://api.us.ovhcloud.com/1.0",
+}
+
+// ovh manages records through the OVHcloud API of the "endpoint" option,
+// "ovh-eu" (the default), "ovh-ca", "ovh-us" or an API URL. Requests are
+// signed with the "application_key", "application_secret" and
+// "consumer_key" options or OVH_APPLICATION_KEY, OVH_APPLICATION_SECRET
+// and OVH_CONSUMER_KEY, and timestamped by the API's clock. Changed zones
+// are only served once refreshed, which is done after each change or, in
+// a batch, once per zone on Commit.
+type ovh struct {
+	api            *apiClient
+	appKey, secret string
+	consumer       string
+	skew           time.Duration
+	synced         bool
+	zones          []string
+
+	batching bool
+	pending  map[string]bool // zones to refresh on Commit
+}
+
+type ovhRecord struct {
+	ID        int64  `json:"id,omitempty"`
+	FieldType string `json:"fieldType,omitempty"`
+	SubDomain string `json:"subDomain"`
+	Target    string `json:"target"`
+	TTL       uint32 `json:"ttl,omitempty"`
+}
+
+func newOVH(opts Options) (Provider, error) {
+	base := opts.Get("endpoint", "OVH_ENDPOINT")
+	if base == "" {
+		base = "ovh-eu"
+	}
+	if u, ok := ovhEndpoints[base]; ok {
+		base = u
+	} else if !strings.HasPrefix(base, "https://") {
+		return nil, fmt.Errorf("ovh: unknown endpoint %q", base)
+	}
+	o := &ovh{api: newAPIClient(strings.TrimSuffix(base, "/"))}
+	var err error
+	if o.appKey, err = opts.Require("ovh", "application_key", "OVH_APPLICATION_KEY"); err != nil {
+		return nil, err
+	}
+	if o.secret, err = opts.Require("ovh", "application_secret", "OVH_APPLICATION_SECRET"); err != nil {
+		return nil, err
+	}
+	if o.consumer, err = opts.Require("ovh", "consumer_key", "OVH_CONSUMER_KEY"); err != nil {
+		return nil, err
+	}
+	o.api.sign = o.sign
+	return o, nil
+}
+
+// sign adds the OVH application signature to req: the SHA-1 of the
+// secrets, method, URL, body and timestamp, joined by "+".
+func (o *ovh) sign(req *http.Request, body []byte) {
+	ts := strconv.FormatInt(time.Now().Add(o.skew).Unix(), 10)
+	sum := sha1.Sum([]byte(strings.Join([]string{o.secret, o.consumer, req.Method, req.URL.String(), string(body), ts}, "+")))
+	req.Header.Set("X-Ovh-Application", o.appKey)
+	req.Header.Set("X-Ovh-Consumer", o.consumer)
+	req.Header.Set("X-Ovh-Timestamp", ts)
+	req.Header.Set("X-Ovh-Signature", "$1$"+hex.EncodeToString(sum[:]))
+}
+
+// do sends a request as apiClient.do does, first learning how far the
+// local clock is from the API's, which rejects stale signatures.
+func (o *ovh) do(ctx context.Context, method, path string, in, out interface{}) error {
+	if !o.synced {
+		var now int64
+		if err := o.api.do(ctx, "GET", "/auth/time", nil, &now); err != nil {
+			return fmt.Errorf("ovh: %v", err)
+		}
+		o.skew, o.synced = time.Until(time.Unix(now, 0)), true
+	}
+	if err := o.api.do(ctx, method, path, in, out); err != nil {
+		return fmt.Errorf("ovh: %v", err)
+	}
+	return nil
+}
+
+// zone returns the OVH zone holding name.
+func (o *ovh) zone(ctx context.Context, name string) (zone, error) {
+	if o.zones == nil {
+		if err := o.do(ctx, "GET", "/domain/zone", nil, &o.zones); err != nil {
+			return zone{}, err
+		}
+	}
+	for _, cand := range parents(name) {
+		for _, z := range o.zones {
+			if strings.EqualFold(z, cand) {
+				return zone{name: z, id: z}, nil
+			}
+		}
+	}
+	return zone{}, fmt.Errorf("ovh: no zone found for %q", name)
+}
+
+// list returns the zone holding name and its records of type typ, or of
+// every type if typ is empty. OVH lists record IDs only, so each record
+// is fetched in turn.
+func (o *ovh) list(ctx context.Context, name, typ string) (zone, []ovhRecord, error) {
+	z, err := o.zone(ctx, name)
+	if err != nil {
+		return z, nil, err
+	}
+	sub := relName(name, z, "")
+	q := url.Values{"subDomain": {sub}}
+	if typ != "" {
+		q.Set("fieldType", typ)
+	}
+	var ids []int64
+	if err := o.do(ctx, "GET", "/domain/zone/"+z.id+"/record?"+q.Encode(), nil, &ids); err != nil {
+		return z, nil, err
+	}
+	var recs []ovhRecord
+	for _, id := range ids {
+		var r ovhRecord
+		if err := o.do(ctx, "GET", fmt.Sprintf("/domain/zone/%s/record/%d", z.id, id), nil, &r); err != nil {
+			return z, nil, err
+		}
+		if strings.EqualFold(r.SubDomain, sub) && (typ == "" || r.FieldType == typ) {
+			recs = append(recs, r)
+		}
+	}
+	return z, recs, nil
+}
+
+func (o *ovh) GetRecords(ctx context.Context, name, typ string) ([]Record, error) {
+	z, recs, err := o.list(ctx, name, typ)
+	if err != nil {
+		return nil, err
+	}
+	var out []Record
+	for _, r := range recs {
+		value := r.Target
+		if r.FieldType == "TXT" && !strings.HasPrefix(value, `"`) {
+			value = quoteTXT(value)
+		}
+		out = append(out, Record{ID: strconv.FormatInt(r.ID, 10), Name: absName(r.SubDomain, z), Type: r.FieldType, Value: value, TTL: r.TTL})
+	}
+	return out, nil
+}
+
+func (o *ovh) UpsertRecord(ctx context.Context, rec Record) error {
+	z, existing, err := o.list(ctx, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	r := ovhRecord{SubDomain: relName(rec.Name, z, ""), Target: rec.Value, TTL: rec.TTL}
+	if rec.Type == "TXT" {
+		r.Target = unquoteTXT(rec.Value)
+	}
+	if len(existing) == 0 {
+		r.FieldType = rec.Type
+		err = o.do(ctx, "POST", "/domain/zone/"+z.id+"/record", r, nil)
+	} else {
+		if r.TTL == 0 {
+			r.TTL = existing[0].TTL
+		}
+		err = o.do(ctx, "PUT", fmt.Sprintf("/domain/zone/%s/record/%d", z.id, existing[0].ID), r, nil)
+	}
+	if err != nil {
+		return err
+	}
+	for i := 1; i < len(existing); i++ {
+		if err := o.do(ctx, "DELETE", fmt.Sprintf("/domain/zone/%s/record/%d", z.id, existing[i].ID), nil, nil); err != nil {
+			return err
+		}
+	}
+	return o.changed(ctx, z)
+}
+
+func (o *ovh) DeleteRecord(ctx context.Context, rec Record) error {
+	z, existing, err := o.list(ctx, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, r := range existing {
+		if rec.ID != "" && strconv.FormatInt(r.ID, 10) != rec.ID {
+			continue
+		}
+		if err := o.do(ctx, "DELETE", fmt.Sprintf("/domain/zone/%s/record/%d", z.id, r.ID), nil, nil); err != nil {
+			return err
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	return o.changed(ctx, z)
+}
+
+// changed refreshes z now or, in a batch, on Commit.
+func (o *ovh) changed(ctx context.Context, z zone) error {
+	if o.batching {
+		o.pending[z.id] = true
+		return nil
+	}
+	return o.do(ctx, "POST", "/domain/zone/"+z.id+"/refresh", nil, nil)
+}
+
+func (o *ovh) Begin() {
+	o.batching = true
+	o.pending = map[string]bool{}
+}
+
+func (o *ovh) Commit(ctx context.Context) error {
+	o.batching = false
+	var zones []string
+	for z := range o.pending {
+		zones = append(zones, z)
+	}
+	o.pending = nil
+	sort.Strings(zones)
+	for _, z := range zones {
+		if err := o.do(ctx, "POST", "/domain/zone/"+z+"/refresh", nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
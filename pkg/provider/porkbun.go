@@ -0,0 +1,146 @@
+package provider
+
+import (
+	"context"
+	"strconv"
+)
+
+func init() {
+	Register("porkbun", newPorkbun)
+}
+
+// porkbun manages records through the Porkbun v3 API with the keys in the
+// "api_key" and "secret_api_key" options or PORKBUN_API_KEY and
+// PORKBUN_SECRET_API_KEY. Porkbun takes the keys in the body of every
+// request, all of them POSTs, and keeps the priority of MX and SRV
+// records apart from the rest of their data.
+type porkbun struct {
+	api  *apiClient
+	auth pbAuth
+}
+
+type pbAuth struct {
+	APIKey string `json:"apikey"`
+	Secret string `json:"secretapikey"`
+}
+
+type pbRecord struct {
+	pbAuth
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     string `json:"ttl,omitempty"`
+	Prio    string `json:"prio,omitempty"`
+}
+
+func newPorkbun(opts Options) (Provider, error) {
+	key, err := opts.Require("porkbun", "api_key", "PORKBUN_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	secret, err := opts.Require("porkbun", "secret_api_key", "PORKBUN_SECRET_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	p := &porkbun{api: newAPIClient("https://api.porkbun.com/api/json/v3"), auth: pbAuth{key, secret}}
+	return &zonedProvider{name: "porkbun", api: p}, nil
+}
+
+func (p *porkbun) zones(ctx context.Context) ([]zone, error) {
+	var zones []zone
+	for start := 0; ; {
+		var resp struct {
+			Domains []struct {
+				Domain string `json:"domain"`
+			} `json:"domains"`
+		}
+		req := struct {
+			pbAuth
+			Start string `json:"start"`
+		}{p.auth, strconv.Itoa(start)}
+		if err := p.api.do(ctx, "POST", "/domain/listAll", req, &resp); err != nil {
+			return nil, err
+		}
+		for _, d := range resp.Domains {
+			zones = append(zones, zone{name: d.Domain, id: d.Domain})
+		}
+		// Domains are listed 1000 at a time.
+		if len(resp.Domains) < 1000 {
+			return zones, nil
+		}
+		start += len(resp.Domains)
+	}
+}
+
+func (p *porkbun) records(ctx context.Context, z zone) ([]Record, error) {
+	var resp struct {
+		Records []pbRecord `json:"records"`
+	}
+	if err := p.api.do(ctx, "POST", "/dns/retrieve/"+z.id, p.auth, &resp); err != nil {
+		return nil, err
+	}
+	var recs []Record
+	for _, r := range resp.Records {
+		recs = append(recs, r.record())
+	}
+	return recs, nil
+}
+
+// record converts r, named in full without the trailing dot and with
+// host names in its data given the same way.
+func (r pbRecord) record() Record {
+	ttl, _ := strconv.ParseUint(r.TTL, 10, 32)
+	data := r.Content
+	if isHostType(r.Type) {
+		data = fqdn(data)
+	}
+	switch r.Type {
+	case "TXT":
+		data = quoteTXT(data)
+	case "MX", "SRV":
+		data = r.Prio + " " + data
+	}
+	return Record{ID: r.ID, Name: fqdn(r.Name), Type: r.Type, Value: data, TTL: uint32(ttl)}
+}
+
+func (p *porkbun) newPBRecord(z zone, rec Record) (pbRecord, error) {
+	r := pbRecord{pbAuth: p.auth, Name: relName(rec.Name, z, ""), Type: rec.Type, Content: rec.Value}
+	if rec.TTL != 0 {
+		r.TTL = strconv.FormatUint(uint64(rec.TTL), 10)
+	}
+	switch rec.Type {
+	case "TXT":
+		r.Content = unquoteTXT(rec.Value)
+	case "MX", "SRV":
+		fs, err := splitValue(rec.Type, rec.Value, 2)
+		if err != nil {
+			return r, err
+		}
+		r.Prio, r.Content = fs[0], fs[1]
+	}
+	if isHostType(rec.Type) {
+		r.Content = trimDot(r.Content)
+	}
+	return r, nil
+}
+
+func (p *porkbun) create(ctx context.Context, z zone, rec Record) error {
+	body, err := p.newPBRecord(z, rec)
+	if err != nil {
+		return err
+	}
+	return p.api.do(ctx, "POST", "/dns/create/"+z.id, body, nil)
+}
+
+func (p *porkbun) update(ctx context.Context, z zone, rec Record) error {
+	body, err := p.newPBRecord(z, rec)
+	if err != nil {
+		return err
+	}
+	return p.api.do(ctx, "POST", "/dns/edit/"+z.id+"/"+rec.ID, body, nil)
+}
+
+func (p *porkbun) remove(ctx context.Context, z zone, id string) error {
+	return p.api.do(ctx, "POST", "/dns/delete/"+z.id+"/"+id, p.auth, nil)
+}
@@ -0,0 +1,227 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+func init() {
+	Register("powerdns", newPowerDNS)
+}
+
+// powerdns manages records of an authoritative PowerDNS server through
+// its HTTP API at the "url" option or POWERDNS_API_URL, such as
+// http://127.0.0.1:8081, with the key in "api_key" or POWERDNS_API_KEY;
+// "server" selects a server other than "localhost". Changes are PATCHed
+// as RRsets, one request per zone in a batch.
+//
+// PowerDNS advances the serial of zones with a SOA-EDIT-API setting
+// itself. For zones without one, the serial is advanced with each change
+// by the "serial" option's policy, "increment" (the default), "date" or
+// "unix", so that secondaries see it.
+type powerdns struct {
+	api    *apiClient
+	serial zonedb.SerialPolicy
+	zones  []pdnsZone
+
+	batching bool
+	pending  map[string][]pdnsRRSet
+}
+
+type pdnsZone struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	Kind       string      `json:"kind"`
+	SOAEditAPI string      `json:"soa_edit_api"`
+	RRSets     []pdnsRRSet `json:"rrsets"`
+}
+
+type pdnsRRSet struct {
+	Name       string       `json:"name"`
+	Type       string       `json:"type"`
+	TTL        uint32       `json:"ttl,omitempty"`
+	ChangeType string       `json:"changetype,omitempty"`
+	Records    []pdnsRecord `json:"records"`
+}
+
+type pdnsRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+func newPowerDNS(opts Options) (Provider, error) {
+	base, err := opts.Require("powerdns", "url", "POWERDNS_API_URL")
+	if err != nil {
+		return nil, err
+	}
+	key, err := opts.Require("powerdns", "api_key", "POWERDNS_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	server := opts.Get("server", "")
+	if server == "" {
+		server = "localhost"
+	}
+	serial := opts.Get("serial", "")
+	if serial == "" {
+		serial = "increment"
+	}
+	p := &powerdns{api: newAPIClient(strings.TrimSuffix(base, "/") + "/api/v1/servers/" + url.PathEscape(server))}
+	if p.serial, err = zonedb.ParseSerialPolicy(serial); err != nil {
+		return nil, fmt.Errorf("powerdns: %v", err)
+	}
+	p.api.header.Set("X-API-Key", key)
+	return p, nil
+}
+
+// zone returns the zone holding name, as listed.
+func (p *powerdns) zone(ctx context.Context, name string) (pdnsZone, error) {
+	if p.zones == nil {
+		if err := p.api.do(ctx, "GET", "/zones", nil, &p.zones); err != nil {
+			return pdnsZone{}, fmt.Errorf("powerdns: %v", err)
+		}
+	}
+	for _, cand := range parents(name) {
+		for _, z := range p.zones {
+			if strings.EqualFold(trimDot(z.Name), cand) {
+				return z, nil
+			}
+		}
+	}
+	return pdnsZone{}, fmt.Errorf("powerdns: no zone found for %q", name)
+}
+
+// get returns zone id with its RRsets named name, and of type typ unless
+// it is empty. Servers before 4.8 ignore the filter and return them all.
+func (p *powerdns) get(ctx context.Context, id, name, typ string) (pdnsZone, error) {
+	q := url.Values{"rrset_name": {fqdn(name)}}
+	if typ != "" {
+		q.Set("rrset_type", typ)
+	}
+	var z pdnsZone
+	if err := p.api.do(ctx, "GET", "/zones/"+url.PathEscape(id)+"?"+q.Encode(), nil, &z); err != nil {
+		return z, fmt.Errorf("powerdns: %v", err)
+	}
+	var sets []pdnsRRSet
+	for _, set := range z.RRSets {
+		if strings.EqualFold(set.Name, fqdn(name)) && (typ == "" || set.Type == typ) {
+			sets = append(sets, set)
+		}
+	}
+	z.RRSets = sets
+	return z, nil
+}
+
+func (p *powerdns) GetRecords(ctx context.Context, name, typ string) ([]Record, error) {
+	z, err := p.zone(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if z, err = p.get(ctx, z.ID, name, typ); err != nil {
+		return nil, err
+	}
+	var recs []Record
+	for _, set := range z.RRSets {
+		for _, r := range set.Records {
+			if !r.Disabled {
+				recs = append(recs, Record{Name: set.Name, Type: set.Type, Value: r.Content, TTL: set.TTL})
+			}
+		}
+	}
+	return recs, nil
+}
+
+func (p *powerdns) UpsertRecord(ctx context.Context, rec Record) error {
+	ttl := rec.TTL
+	if ttl == 0 {
+		recs, err := p.GetRecords(ctx, rec.Name, rec.Type)
+		if err != nil {
+			return err
+		}
+		ttl = 300
+		if len(recs) > 0 {
+			ttl = recs[0].TTL
+		}
+	}
+	return p.change(ctx, rec.Name, pdnsRRSet{
+		Name: fqdn(rec.Name), Type: rec.Type, TTL: ttl, ChangeType: "REPLACE",
+		Records: []pdnsRecord{{Content: rec.Value}},
+	})
+}
+
+// DeleteRecord deletes the RRset of rec's name and type; PowerDNS has no
+// record IDs.
+func (p *powerdns) DeleteRecord(ctx context.Context, rec Record) error {
+	return p.change(ctx, rec.Name, pdnsRRSet{Name: fqdn(rec.Name), Type: rec.Type, ChangeType: "DELETE", Records: []pdnsRecord{}})
+}
+
+func (p *powerdns) Begin() {
+	p.batching = true
+	p.pending = map[string][]pdnsRRSet{}
+}
+
+func (p *powerdns) Commit(ctx context.Context) error {
+	p.batching = false
+	pending := p.pending
+	p.pending = nil
+	for _, z := range p.zones {
+		if sets, ok := pending[z.ID]; ok {
+			if err := p.submit(ctx, z, sets); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *powerdns) change(ctx context.Context, name string, set pdnsRRSet) error {
+	z, err := p.zone(ctx, name)
+	if err != nil {
+		return err
+	}
+	if p.batching {
+		p.pending[z.ID] = append(p.pending[z.ID], set)
+		return nil
+	}
+	return p.submit(ctx, z, []pdnsRRSet{set})
+}
+
+// submit PATCHes sets into z, replacing its SOA with one of the next
+// serial unless PowerDNS advances it itself.
+func (p *powerdns) submit(ctx context.Context, z pdnsZone, sets []pdnsRRSet) error {
+	z, err := p.get(ctx, z.ID, z.Name, "SOA")
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(z.Kind, "Slave") || strings.EqualFold(z.Kind, "Consumer") {
+		return fmt.Errorf("powerdns: zone %s is a secondary zone", z.Name)
+	}
+	if z.SOAEditAPI == "" && len(z.RRSets) == 1 && len(z.RRSets[0].Records) > 0 {
+		soa := z.RRSets[0]
+		fs := strings.Fields(soa.Records[0].Content)
+		if len(fs) != 7 {
+			return fmt.Errorf("powerdns: zone %s: invalid SOA %q", z.Name, soa.Records[0].Content)
+		}
+		serial, err := strconv.ParseUint(fs[2], 10, 32)
+		if err != nil {
+			return fmt.Errorf("powerdns: zone %s: invalid SOA serial %q", z.Name, fs[2])
+		}
+		fs[2] = strconv.FormatUint(uint64(p.serial.Next(uint32(serial), time.Now())), 10)
+		soa.ChangeType = "REPLACE"
+		soa.Records = []pdnsRecord{{Content: strings.Join(fs, " ")}}
+		sets = append(sets, soa)
+	}
+	body := struct {
+		RRSets []pdnsRRSet `json:"rrsets"`
+	}{sets}
+	if err := p.api.do(ctx, "PATCH", "/zones/"+url.PathEscape(z.ID), body, nil); err != nil {
+		return fmt.Errorf("powerdns: zone %s: %v", z.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Package provider updates records held by hosted DNS services through
+// their APIs, as an alternative to editing local master files.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Record is a single resource record as seen through a provider API. Name
+// is fully qualified, Value is the record data in presentation format and
+// a zero TTL selects the provider's default.
+type Record struct {
+	ID    string
+	Name  string
+	Type  string
+	Value string
+	TTL   uint32
+}
+
+// Provider manages the records of a hosted DNS service.
+type Provider interface {
+	// GetRecords returns the records named name, restricted to type typ
+	// unless it is empty.
+	GetRecords(ctx context.Context, name, typ string) ([]Record, error)
+	// UpsertRecord makes rec the only record of its name and type.
+	UpsertRecord(ctx context.Context, rec Record) error
+	// DeleteRecord removes the record with rec's ID, or every record of
+	// rec's name and type if the ID is empty.
+	DeleteRecord(ctx context.Context, rec Record) error
+}
+
+// Options configure a provider: API credentials and provider specific
+// settings.
+type Options map[string]string
+
+// Get returns the option key, falling back to the environment variable
+// env when the option is not set.
+func (o Options) Get(key, env string) string {
+	if v, ok := o[key]; ok {
+		return v
+	}
+	if env != "" {
+		return os.Getenv(env)
+	}
+	return ""
+}
+
+// Require is like Get but fails when the value is empty.
+func (o Options) Require(provider, key, env string) (string, error) {
+	v := o.Get(key, env)
+	if v == "" {
+		return "", fmt.Errorf("%s: missing %q option or %s", provider, key, env)
+	}
+	return v, nil
+}
+
+// Factory creates a provider from its options.
+type Factory func(opts Options) (Provider, error)
+
+var factories = map[string]Factory{}
+
+// Register makes a provider available by name. It is called from the init
+// functions of the provider implementations.
+func Register(name string, f Factory) {
+	factories[name] = f
+}
+
+// New returns the named provider configured with opts.
+func New(name string, opts Options) (Provider, error) {
+	f, ok := factories[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q (have %s)", name, strings.Join(Names(), ", "))
+	}
+	return f(opts)
+}
+
+// Names returns the registered provider names.
+func Names() []string {
+	var names []string
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// trimDot returns name without its trailing dot, as most APIs expect.
+func trimDot(name string) string {
+	return strings.TrimSuffix(name, ".")
+}
+
+// fqdn returns name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// parents returns name and each of its parent domains down to, but not
+// including, the top level domain; the candidates for the zone holding it.
+func parents(name string) []string {
+	labels := strings.Split(trimDot(name), ".")
+	var zones []string
+	for i := 0; i < len(labels)-1; i++ {
+		zones = append(zones, strings.Join(labels[i:], "."))
+	}
+	return zones
+}
+
+// Batcher is implemented by providers that can apply several changes in
+// one request. Changes made between Begin and Commit are queued and sent
+// together by Commit.
+type Batcher interface {
+	Begin()
+	Commit(ctx context.Context) error
+}
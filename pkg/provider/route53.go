@@ -0,0 +1,393 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnweldon/dnsup/pkg/retry"
+)
+
+func init() {
+	Register("route53", newRoute53)
+}
+
+const route53NS = "https://route53.amazonaws.com/doc/2013-04-01/"
+
+// route53 manages records in AWS Route 53 hosted zones. Credentials come
+// from the "access_key_id", "secret_access_key" and "session_token"
+// options or the usual AWS_* environment variables. The hosted zone is
+// found from the record name unless "zone_id" is set. Unless "wait" is
+// false, changes are waited on until Route 53 reports them INSYNC, for at
+// most "wait_timeout" (default 5m).
+type route53 struct {
+	keyID, secret, token string
+	zoneID               string
+	wait                 bool
+	timeout              time.Duration
+	client               *http.Client
+	zones                map[string]string
+
+	batching bool
+	pending  map[string][]r53Change
+}
+
+type r53RRSet struct {
+	Name   string   `xml:"Name"`
+	Type   string   `xml:"Type"`
+	TTL    uint32   `xml:"TTL,omitempty"`
+	Values []string `xml:"ResourceRecords>ResourceRecord>Value"`
+}
+
+type r53Change struct {
+	Action string   `xml:"Action"`
+	RRSet  r53RRSet `xml:"ResourceRecordSet"`
+}
+
+type r53ChangeRequest struct {
+	XMLName xml.Name    `xml:"ChangeResourceRecordSetsRequest"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Comment string      `xml:"ChangeBatch>Comment"`
+	Changes []r53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type r53ChangeInfo struct {
+	ID     string `xml:"ChangeInfo>Id"`
+	Status string `xml:"ChangeInfo>Status"`
+}
+
+func newRoute53(opts Options) (Provider, error) {
+	r := &route53{
+		keyID:   opts.Get("access_key_id", "AWS_ACCESS_KEY_ID"),
+		secret:  opts.Get("secret_access_key", "AWS_SECRET_ACCESS_KEY"),
+		token:   opts.Get("session_token", "AWS_SESSION_TOKEN"),
+		zoneID:  opts.Get("zone_id", ""),
+		wait:    true,
+		timeout: 5 * time.Minute,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		zones:   map[string]string{},
+	}
+	if r.keyID == "" || r.secret == "" {
+		return nil, fmt.Errorf("route53: missing access_key_id/secret_access_key options or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY")
+	}
+	if v, ok := opts["wait"]; ok {
+		wait, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("route53: invalid wait option %q", v)
+		}
+		r.wait = wait
+	}
+	if v, ok := opts["wait_timeout"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("route53: invalid wait_timeout option %q", v)
+		}
+		r.timeout = d
+	}
+	return r, nil
+}
+
+// hostedZone finds the hosted zone for name by trying each parent domain.
+func (r *route53) hostedZone(ctx context.Context, name string) (string, error) {
+	if r.zoneID != "" {
+		return r.zoneID, nil
+	}
+	for _, zone := range parents(name) {
+		if id, ok := r.zones[zone]; ok {
+			return id, nil
+		}
+		var resp struct {
+			Zones []struct {
+				ID   string `xml:"Id"`
+				Name string `xml:"Name"`
+			} `xml:"HostedZones>HostedZone"`
+		}
+		q := url.Values{"dnsname": {zone}, "maxitems": {"1"}}
+		if err := r.do(ctx, "GET", "/2013-04-01/hostedzonesbyname", q, nil, &resp); err != nil {
+			return "", err
+		}
+		if len(resp.Zones) > 0 && strings.EqualFold(trimDot(resp.Zones[0].Name), zone) {
+			id := strings.TrimPrefix(resp.Zones[0].ID, "/hostedzone/")
+			r.zones[zone] = id
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("route53: no hosted zone found for %q", name)
+}
+
+func (r *route53) list(ctx context.Context, zone, name, typ string) ([]r53RRSet, error) {
+	q := url.Values{"name": {fqdn(name)}, "maxitems": {"100"}}
+	if typ != "" {
+		q.Set("type", typ)
+	}
+	var resp struct {
+		Sets []r53RRSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	}
+	if err := r.do(ctx, "GET", "/2013-04-01/hostedzone/"+zone+"/rrset", q, nil, &resp); err != nil {
+		return nil, err
+	}
+	var sets []r53RRSet
+	for _, set := range resp.Sets {
+		set.Name = strings.Replace(set.Name, `\052`, "*", -1)
+		if strings.EqualFold(set.Name, fqdn(name)) && (typ == "" || set.Type == typ) {
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
+
+func (r *route53) GetRecords(ctx context.Context, name, typ string) ([]Record, error) {
+	zone, err := r.hostedZone(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	sets, err := r.list(ctx, zone, name, typ)
+	if err != nil {
+		return nil, err
+	}
+	var recs []Record
+	for _, set := range sets {
+		for _, v := range set.Values {
+			recs = append(recs, Record{Name: set.Name, Type: set.Type, Value: v, TTL: set.TTL})
+		}
+	}
+	return recs, nil
+}
+
+func (r *route53) UpsertRecord(ctx context.Context, rec Record) error {
+	ttl := rec.TTL
+	if ttl == 0 {
+		ttl = 300
+	}
+	return r.change(ctx, rec.Name, r53Change{
+		Action: "UPSERT",
+		RRSet:  r53RRSet{Name: fqdn(rec.Name), Type: rec.Type, TTL: ttl, Values: []string{rec.Value}},
+	})
+}
+
+// DeleteRecord deletes the RRset of rec's name and type; Route 53 has no
+// record IDs and requires the current values to delete a set.
+func (r *route53) DeleteRecord(ctx context.Context, rec Record) error {
+	zone, err := r.hostedZone(ctx, rec.Name)
+	if err != nil {
+		return err
+	}
+	sets, err := r.list(ctx, zone, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	for _, set := range sets {
+		if err := r.change(ctx, rec.Name, r53Change{Action: "DELETE", RRSet: set}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *route53) Begin() {
+	r.batching = true
+	r.pending = map[string][]r53Change{}
+}
+
+func (r *route53) Commit(ctx context.Context) error {
+	r.batching = false
+	pending := r.pending
+	r.pending = nil
+	var zones []string
+	for zone := range pending {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	for _, zone := range zones {
+		if err := r.submit(ctx, zone, pending[zone]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *route53) change(ctx context.Context, name string, c r53Change) error {
+	zone, err := r.hostedZone(ctx, name)
+	if err != nil {
+		return err
+	}
+	if r.batching {
+		r.pending[zone] = append(r.pending[zone], c)
+		return nil
+	}
+	return r.submit(ctx, zone, []r53Change{c})
+}
+
+// submit sends changes to zone in batches of at most 1000, the Route 53
+// limit, waiting for each to be applied if configured to.
+func (r *route53) submit(ctx context.Context, zone string, changes []r53Change) error {
+	for len(changes) > 0 {
+		n := len(changes)
+		if n > 1000 {
+			n = 1000
+		}
+		req := r53ChangeRequest{Xmlns: route53NS, Comment: "dnsup", Changes: changes[:n]}
+		var info r53ChangeInfo
+		if err := r.do(ctx, "POST", "/2013-04-01/hostedzone/"+zone+"/rrset/", nil, req, &info); err != nil {
+			return err
+		}
+		if r.wait {
+			if err := r.waitInSync(ctx, info); err != nil {
+				return err
+			}
+		}
+		changes = changes[n:]
+	}
+	return nil
+}
+
+func (r *route53) waitInSync(ctx context.Context, info r53ChangeInfo) error {
+	id := strings.TrimPrefix(info.ID, "/change/")
+	deadline := time.Now().Add(r.timeout)
+	for info.Status != "INSYNC" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("route53: change %s not in sync after %s", id, r.timeout)
+		}
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return fmt.Errorf("route53: change %s: %v", id, ctx.Err())
+		}
+		if err := r.do(ctx, "GET", "/2013-04-01/change/"+id, nil, nil, &info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// do sends a request signed with AWS signature version 4, encoding in as
+// XML and decoding the XML response into out.
+func (r *route53) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = xml.Marshal(in); err != nil {
+			return err
+		}
+		body = append([]byte(xml.Header), body...)
+	}
+	return Retry.Do(ctx, "provider", func() error {
+		return r.send(ctx, method, path, query, body, out)
+	})
+}
+
+// send makes a single attempt at a request for do, signed afresh.
+func (r *route53) send(ctx context.Context, method, path string, query url.Values, body []byte, out interface{}) error {
+	u := "https://route53.amazonaws.com" + path
+	if len(query) > 0 {
+		u += "?" + awsQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/xml")
+	}
+	r.sign(req, body, time.Now().UTC())
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return unsent(method, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		err := fmt.Errorf("route53: %s %s: %s", method, path, resp.Status)
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			err = fmt.Errorf("route53: %s: %s", e.Code, e.Message)
+		}
+		// Route 53 throttles with 400 responses.
+		if !transient(resp.StatusCode) && e.Code != "Throttling" && e.Code != "PriorRequestNotComplete" {
+			err = retry.Permanent(err)
+		}
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return retry.Permanent(xml.Unmarshal(data, out))
+}
+
+// sign adds an AWS signature version 4 Authorization header to req. Route
+// 53 is a global service signed for us-east-1.
+func (r *route53) sign(req *http.Request, body []byte, now time.Time) {
+	const region, service = "us-east-1", "route53"
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := sha256.Sum256(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if r.token != "" {
+		req.Header.Set("X-Amz-Security-Token", r.token)
+	}
+
+	var names []string
+	for k := range req.Header {
+		names = append(names, strings.ToLower(k))
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonHeaders, "%s:%s\n", k, strings.TrimSpace(req.Header.Get(k)))
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signed,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+r.secret), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		r.keyID, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsQuery encodes query sorted by key with spaces as %20, as required for
+// the canonical request.
+func awsQuery(query url.Values) string {
+	return strings.Replace(query.Encode(), "+", "%20", -1)
+}
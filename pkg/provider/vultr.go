@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+func init() {
+	Register("vultr", newVultr)
+}
+
+// vultr manages records through the Vultr v2 API with the API key in the
+// "token" option or VULTR_API_KEY.
+type vultr struct {
+	api *apiClient
+}
+
+type vuRecord struct {
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	Priority *int   `json:"priority,omitempty"`
+	TTL      uint32 `json:"ttl,omitempty"`
+}
+
+type vuMeta struct {
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+}
+
+func newVultr(opts Options) (Provider, error) {
+	token, err := opts.Require("vultr", "token", "VULTR_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	v := &vultr{api: newAPIClient("https://api.vultr.com/v2")}
+	v.api.header.Set("Authorization", "Bearer "+token)
+	return &zonedProvider{name: "vultr", api: v}, nil
+}
+
+func (v *vultr) zones(ctx context.Context) ([]zone, error) {
+	var zones []zone
+	cursor := ""
+	for {
+		var resp struct {
+			Domains []struct {
+				Domain string `json:"domain"`
+			} `json:"domains"`
+			Meta vuMeta `json:"meta"`
+		}
+		if err := v.api.do(ctx, "GET", "/domains?per_page=500&cursor="+url.QueryEscape(cursor), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, d := range resp.Domains {
+			zones = append(zones, zone{name: d.Domain, id: d.Domain})
+		}
+		if cursor = resp.Meta.Links.Next; cursor == "" {
+			return zones, nil
+		}
+	}
+}
+
+func (v *vultr) records(ctx context.Context, z zone) ([]Record, error) {
+	var recs []Record
+	cursor := ""
+	for {
+		var resp struct {
+			Records []vuRecord `json:"records"`
+			Meta    vuMeta     `json:"meta"`
+		}
+		if err := v.api.do(ctx, "GET", "/domains/"+z.id+"/records?per_page=500&cursor="+url.QueryEscape(cursor), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Records {
+			recs = append(recs, r.record(z))
+		}
+		if cursor = resp.Meta.Links.Next; cursor == "" {
+			return recs, nil
+		}
+	}
+}
+
+// record converts r; Vultr holds the preference of MX and SRV records
+// separately and the rest of SRV data as "weight port target".
+func (r vuRecord) record(z zone) Record {
+	data := r.Data
+	if r.Type == "CNAME" || r.Type == "NS" || r.Type == "MX" {
+		data = absName(data, z)
+	}
+	if (r.Type == "MX" || r.Type == "SRV") && r.Priority != nil {
+		data = strconv.Itoa(*r.Priority) + " " + data
+	}
+	return Record{ID: r.ID, Name: absName(r.Name, z), Type: r.Type, Value: data, TTL: r.TTL}
+}
+
+func newVURecord(z zone, rec Record) (vuRecord, error) {
+	r := vuRecord{Type: rec.Type, Name: relName(rec.Name, z, ""), Data: rec.Value, TTL: rec.TTL}
+	if rec.Type == "MX" || rec.Type == "SRV" {
+		fs, err := splitValue(rec.Type, rec.Value, 2)
+		if err != nil {
+			return r, err
+		}
+		p, err := strconv.Atoi(fs[0])
+		if err != nil {
+			return r, fmt.Errorf("invalid %s value %q", rec.Type, rec.Value)
+		}
+		r.Priority, r.Data = &p, fs[1]
+	}
+	if isHostType(rec.Type) {
+		r.Data = trimDot(r.Data)
+	}
+	return r, nil
+}
+
+func (v *vultr) create(ctx context.Context, z zone, rec Record) error {
+	body, err := newVURecord(z, rec)
+	if err != nil {
+		return err
+	}
+	return v.api.do(ctx, "POST", "/domains/"+z.id+"/records", body, nil)
+}
+
+func (v *vultr) update(ctx context.Context, z zone, rec Record) error {
+	body, err := newVURecord(z, rec)
+	if err != nil {
+		return err
+	}
+	return v.api.do(ctx, "PATCH", "/domains/"+z.id+"/records/"+rec.ID, body, nil)
+}
+
+func (v *vultr) remove(ctx context.Context, z zone, id string) error {
+	return v.api.do(ctx, "DELETE", "/domains/"+z.id+"/records/"+id, nil, nil)
+}
@@ -0,0 +1,192 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// zone is a DNS zone as known to a provider API.
+type zone struct {
+	name string // without the trailing dot
+	id   string
+}
+
+// zoneAPI is the shape shared by the simpler provider APIs, where records
+// are listed per zone and created, updated and deleted by ID. Records are
+// exchanged with fully qualified names and presentation format values;
+// each API converts them to its own representation.
+type zoneAPI interface {
+	zones(ctx context.Context) ([]zone, error)
+	records(ctx context.Context, z zone) ([]Record, error)
+	create(ctx context.Context, z zone, rec Record) error
+	update(ctx context.Context, z zone, rec Record) error
+	remove(ctx context.Context, z zone, id string) error
+}
+
+// zonedProvider implements Provider on top of a zoneAPI.
+type zonedProvider struct {
+	name  string
+	api   zoneAPI
+	known []zone
+}
+
+// find returns the zone holding name, the longest matching zone name.
+func (p *zonedProvider) find(ctx context.Context, name string) (zone, error) {
+	if p.known == nil {
+		zones, err := p.api.zones(ctx)
+		if err != nil {
+			return zone{}, fmt.Errorf("%s: %v", p.name, err)
+		}
+		p.known = zones
+	}
+	for _, cand := range parents(name) {
+		for _, z := range p.known {
+			if strings.EqualFold(z.name, cand) {
+				return z, nil
+			}
+		}
+	}
+	return zone{}, fmt.Errorf("%s: no zone found for %q", p.name, name)
+}
+
+func (p *zonedProvider) matching(ctx context.Context, name, typ string) (zone, []Record, error) {
+	z, err := p.find(ctx, name)
+	if err != nil {
+		return z, nil, err
+	}
+	all, err := p.api.records(ctx, z)
+	if err != nil {
+		return z, nil, fmt.Errorf("%s: %v", p.name, err)
+	}
+	var recs []Record
+	for _, rec := range all {
+		if strings.EqualFold(rec.Name, fqdn(name)) && (typ == "" || rec.Type == typ) {
+			recs = append(recs, rec)
+		}
+	}
+	return z, recs, nil
+}
+
+func (p *zonedProvider) GetRecords(ctx context.Context, name, typ string) ([]Record, error) {
+	_, recs, err := p.matching(ctx, name, typ)
+	return recs, err
+}
+
+func (p *zonedProvider) UpsertRecord(ctx context.Context, rec Record) error {
+	z, existing, err := p.matching(ctx, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	rec.Name = fqdn(rec.Name)
+	if len(existing) == 0 {
+		err = p.api.create(ctx, z, rec)
+	} else {
+		rec.ID = existing[0].ID
+		if rec.TTL == 0 {
+			rec.TTL = existing[0].TTL
+		}
+		err = p.api.update(ctx, z, rec)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", p.name, err)
+	}
+	for i := 1; i < len(existing); i++ {
+		if err := p.api.remove(ctx, z, existing[i].ID); err != nil {
+			return fmt.Errorf("%s: %v", p.name, err)
+		}
+	}
+	return nil
+}
+
+func (p *zonedProvider) DeleteRecord(ctx context.Context, rec Record) error {
+	z, existing, err := p.matching(ctx, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	for _, r := range existing {
+		if rec.ID != "" && r.ID != rec.ID {
+			continue
+		}
+		if err := p.api.remove(ctx, z, r.ID); err != nil {
+			return fmt.Errorf("%s: %v", p.name, err)
+		}
+	}
+	return nil
+}
+
+// relName returns name relative to zone, or apex for the zone itself, as
+// most APIs name records.
+func relName(name string, z zone, apex string) string {
+	name, suffix := strings.ToLower(trimDot(name)), strings.ToLower(z.name)
+	switch {
+	case name == suffix:
+		return apex
+	case strings.HasSuffix(name, "."+suffix):
+		return name[:len(name)-len(suffix)-1]
+	}
+	return name
+}
+
+// absName is the inverse of relName.
+func absName(rel string, z zone) string {
+	if rel == "" || rel == "@" {
+		return fqdn(z.name)
+	}
+	if strings.HasSuffix(rel, ".") {
+		return rel
+	}
+	return rel + "." + fqdn(z.name)
+}
+
+// splitValue splits a presentation format value into its n-1 leading
+// fields and the remainder, such as the preference and host of an MX.
+func splitValue(typ, value string, n int) ([]string, error) {
+	fs := strings.SplitN(strings.TrimSpace(value), " ", n)
+	if len(fs) != n {
+		return nil, fmt.Errorf("invalid %s value %q", typ, value)
+	}
+	for i := range fs {
+		fs[i] = strings.TrimSpace(fs[i])
+	}
+	return fs, nil
+}
+
+// unquoteTXT returns the text of a quoted TXT value, joining multiple
+// strings, for APIs that take the bare text.
+func unquoteTXT(v string) string {
+	if !strings.HasPrefix(v, `"`) {
+		return v
+	}
+	var b strings.Builder
+	in, esc := false, false
+	for _, c := range v {
+		switch {
+		case esc:
+			b.WriteRune(c)
+			esc = false
+		case c == '\\':
+			esc = true
+		case c == '"':
+			in = !in
+		case in:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// quoteTXT is the inverse of unquoteTXT.
+func quoteTXT(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+// isHostType reports whether the data of typ ends in a host name, which
+// the APIs often return without the trailing dot.
+func isHostType(typ string) bool {
+	switch typ {
+	case "CNAME", "MX", "NS", "SRV", "PTR":
+		return true
+	}
+	return false
+}
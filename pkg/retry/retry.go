@@ -0,0 +1,85 @@
+// Package retry repeats failing network operations, waiting between
+// attempts with exponential backoff and jitter.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Policy says how many times an operation is attempted and how long to
+// wait between attempts.
+type Policy struct {
+	Attempts   int           // in all; 1 or less attempts once
+	Backoff    time.Duration // the wait after the first failure, doubling after each further one
+	MaxBackoff time.Duration // caps the wait; zero caps it at an hour
+	Jitter     float64       // the fraction, 0 to 1, of each wait that is random
+}
+
+// Default is the policy of operations not configured otherwise.
+var Default = Policy{Attempts: 3, Backoff: time.Second, MaxBackoff: 30 * time.Second, Jitter: 0.2}
+
+// Observe, if set, is called before each retry with the name of the
+// operation, the number of the attempt that failed and its error.
+var Observe func(op string, attempt int, err error)
+
+// Do calls f until it succeeds, it fails with an error marked Permanent,
+// the policy's attempts are used up or ctx is done, and returns its last
+// error.
+func (p Policy) Do(ctx context.Context, op string, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if pe, ok := err.(permanent); ok {
+			return pe.err
+		}
+		if attempt >= p.Attempts || ctx.Err() != nil {
+			return err
+		}
+		if Observe != nil {
+			Observe(op, attempt, err)
+		}
+		t := time.NewTimer(p.wait(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// wait returns how long to wait after the given failed attempt.
+func (p Policy) wait(attempt int) time.Duration {
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = time.Hour
+	}
+	d := p.Backoff
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if j := time.Duration(p.Jitter * float64(d)); j > 0 && j <= d {
+		d += time.Duration(rand.Int63n(int64(j))) - j/2
+	}
+	return d
+}
+
+// Permanent marks err as a failure that retrying cannot fix, such as a
+// rejected request, so that Do returns it at once.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanent{err}
+}
+
+type permanent struct{ err error }
+
+func (p permanent) Error() string { return p.err.Error() }
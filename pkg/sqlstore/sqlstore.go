@@ -0,0 +1,148 @@
+// Package sqlstore keeps a copy of zones in a SQLite database, as a
+// zonedb.Store, so that very large zones can be queried with SQL and the
+// state of a long-running daemon survives it. The schema is:
+//
+//	zones(zone TEXT PRIMARY KEY, serial INTEGER, updated INTEGER)
+//	records(zone TEXT, name TEXT, type TEXT, ttl INTEGER, data TEXT)
+//
+// where updated is a Unix time and data the record data in presentation
+// format; records are indexed by name and type.
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS zones (
+	zone    TEXT PRIMARY KEY,
+	serial  INTEGER NOT NULL,
+	updated INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS records (
+	zone TEXT NOT NULL,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL,
+	ttl  INTEGER NOT NULL,
+	data TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS records_name ON records (name, type);
+CREATE INDEX IF NOT EXISTS records_zone ON records (zone);
+`
+
+// Store is a SQLite database of zones.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens the database in file, creating it and its tables if need be.
+func Open(file string) (*Store, error) {
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // one writer; SQLite serializes them anyway
+	for _, stmt := range []string{"PRAGMA journal_mode=WAL", "PRAGMA busy_timeout=5000", schema} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Serial returns the serial of zone as last stored.
+func (s *Store) Serial(zone string) (uint32, bool, error) {
+	var serial int64
+	err := s.db.QueryRow(`SELECT serial FROM zones WHERE zone = ?`, zone).Scan(&serial)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint32(serial), true, nil
+}
+
+// ReplaceZone makes rrs, SOA first, the records of zone, in a single
+// transaction.
+func (s *Store) ReplaceZone(zone string, rrs []dns.RR) error {
+	var serial uint32
+	if len(rrs) > 0 {
+		if soa, ok := rrs[0].(*dns.SOA); ok {
+			serial = soa.Serial
+		}
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM records WHERE zone = ?`, zone); err != nil {
+		return err
+	}
+	ins, err := tx.Prepare(`INSERT INTO records (zone, name, type, ttl, data) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if _, err := ins.Exec(zone, strings.ToLower(hdr.Name), dns.TypeToString[hdr.Rrtype], hdr.Ttl, data(rr)); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO zones (zone, serial, updated) VALUES (?, ?, ?)
+		ON CONFLICT (zone) DO UPDATE SET serial = excluded.serial, updated = excluded.updated`,
+		zone, serial, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Lookup returns the stored records named name of type rrtype, or of
+// every type if rrtype is dns.TypeANY.
+func (s *Store) Lookup(name string, rrtype uint16) ([]dns.RR, error) {
+	q, args := `SELECT name, type, ttl, data FROM records WHERE name = ?`, []interface{}{strings.ToLower(dns.Fqdn(name))}
+	if rrtype != dns.TypeANY {
+		q += ` AND type = ?`
+		args = append(args, dns.TypeToString[rrtype])
+	}
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rrs []dns.RR
+	for rows.Next() {
+		var owner, typ, value string
+		var ttl uint32
+		if err := rows.Scan(&owner, &typ, &ttl, &value); err != nil {
+			return nil, err
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", owner, ttl, typ, value))
+		if err != nil {
+			return nil, err
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, rows.Err()
+}
+
+// data returns the presentation format of rr's data.
+func data(rr dns.RR) string {
+	return strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Header().String()))
+}
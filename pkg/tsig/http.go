@@ -0,0 +1,65 @@
+package tsig
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fudge is how far the time of a signed HTTP request may be from the
+// server's clock.
+const Fudge = 300 * time.Second
+
+// requestData returns the signed content of an HTTP request: the method,
+// request URI, time and hex SHA-256 of the body, each newline terminated.
+func requestData(method, uri string, t int64, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(fmt.Sprintf("%s\n%s\n%d\n%s\n", method, uri, t, hex.EncodeToString(sum[:])))
+}
+
+// SignRequest adds an Authorization header signing req, whose body is
+// body, with k:
+//
+//	Authorization: TSIG name=dnsup-key., time=1700000000, mac=<base64>
+func SignRequest(req *http.Request, body []byte, k *Key, now time.Time) {
+	t := now.Unix()
+	mac := k.Sign(requestData(req.Method, req.URL.RequestURI(), t, body))
+	req.Header.Set("Authorization", fmt.Sprintf("TSIG name=%s, time=%d, mac=%s",
+		k.Name, t, base64.StdEncoding.EncodeToString(mac)))
+}
+
+// VerifyRequest checks the TSIG Authorization header of req, whose body
+// is body, against the keys in kr and returns the signing key.
+func (kr *Keyring) VerifyRequest(req *http.Request, body []byte, now time.Time) (*Key, error) {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "TSIG ") {
+		return nil, fmt.Errorf("request is not TSIG signed")
+	}
+	params := map[string]string{}
+	for _, p := range strings.Split(strings.TrimPrefix(auth, "TSIG "), ",") {
+		if i := strings.IndexByte(p, '='); i > 0 {
+			params[strings.TrimSpace(p[:i])] = strings.TrimSpace(p[i+1:])
+		}
+	}
+	k := kr.Get(params["name"])
+	if k == nil {
+		return nil, fmt.Errorf("unknown TSIG key %q", params["name"])
+	}
+	t, err := strconv.ParseInt(params["time"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSIG time %q", params["time"])
+	}
+	if d := now.Sub(time.Unix(t, 0)); d > Fudge || d < -Fudge {
+		return nil, fmt.Errorf("TSIG time is %s off", d)
+	}
+	mac, err := base64.StdEncoding.DecodeString(params["mac"])
+	if err != nil || !k.Verify(requestData(req.Method, req.URL.RequestURI(), t, body), mac) {
+		return nil, fmt.Errorf("bad TSIG signature for key %s", k.Name)
+	}
+	return k, nil
+}
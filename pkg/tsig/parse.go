@@ -0,0 +1,178 @@
+package tsig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isBIND reports whether text looks like BIND key statements rather than
+// the YAML form.
+func isBIND(text string) bool {
+	for _, tok := range bindTokens(text) {
+		return tok == "key"
+	}
+	return false
+}
+
+// parseBIND parses key statements as written by tsig-keygen and
+// rndc-confgen:
+//
+//	key "dnsup-key" {
+//		algorithm hmac-sha256;
+//		secret "c2VjcmV0";
+//	};
+//
+// Other statements are skipped.
+func parseBIND(text string) ([]*Key, error) {
+	toks := bindTokens(text)
+	var keys []*Key
+	for i := 0; i < len(toks); {
+		if toks[i] != "key" {
+			i = skipStatement(toks, i)
+			continue
+		}
+		if i+2 >= len(toks) || toks[i+2] != "{" {
+			return nil, fmt.Errorf("malformed key statement")
+		}
+		name, fields := toks[i+1], map[string]string{}
+		j := i + 3
+		for ; j < len(toks) && toks[j] != "}"; j++ {
+			if toks[j] == ";" {
+				continue
+			}
+			if j+1 >= len(toks) {
+				return nil, fmt.Errorf("key %s: unterminated", name)
+			}
+			fields[toks[j]] = toks[j+1]
+			j++
+		}
+		if j >= len(toks) {
+			return nil, fmt.Errorf("key %s: missing }", name)
+		}
+		k, err := NewKey(name, fields["algorithm"], fields["secret"])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+		i = j + 1
+		if i < len(toks) && toks[i] == ";" {
+			i++
+		}
+	}
+	return keys, nil
+}
+
+// skipStatement returns the index after the statement starting at i.
+func skipStatement(toks []string, i int) int {
+	depth := 0
+	for ; i < len(toks); i++ {
+		switch toks[i] {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		case ";":
+			if depth <= 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// bindTokens splits named.conf syntax into words, unquoted strings and
+// the punctuation { } ;, dropping # // and /* */ comments.
+func bindTokens(text string) []string {
+	var toks []string
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#' || strings.HasPrefix(text[i:], "//"):
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(text[i:], "/*"):
+			if end := strings.Index(text[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(text)
+			}
+		case c == '{' || c == '}' || c == ';':
+			toks = append(toks, string(c))
+			i++
+		case c == '"':
+			j := strings.IndexByte(text[i+1:], '"')
+			if j < 0 {
+				j = len(text) - i - 1
+			}
+			toks = append(toks, text[i+1:i+1+j])
+			i += j + 2
+		default:
+			j := i
+			for j < len(text) && !strings.ContainsRune(" \t\r\n{};\"", rune(text[j])) {
+				j++
+			}
+			toks = append(toks, text[i:j])
+			i = j
+		}
+	}
+	return toks
+}
+
+// parseYAML parses the simple YAML form, a list of keys:
+//
+//	keys:
+//	  - name: dnsup-key.
+//	    algorithm: hmac-sha256
+//	    secret: c2VjcmV0
+//
+// Only this shape is understood, not YAML in general.
+func parseYAML(text string) ([]*Key, error) {
+	var keys []*Key
+	var cur map[string]string
+	flush := func() error {
+		if cur == nil {
+			return nil
+		}
+		k, err := NewKey(cur["name"], cur["algorithm"], cur["secret"])
+		if err != nil {
+			return err
+		}
+		keys = append(keys, k)
+		return nil
+	}
+	for n, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" || line == "keys:" {
+			continue
+		}
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			cur = map[string]string{}
+			line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+			if line == "" {
+				continue
+			}
+		}
+		i := strings.IndexByte(line, ':')
+		if cur == nil || i < 0 {
+			return nil, fmt.Errorf("line %d: want \"- name: ...\" entries under keys:", n+1)
+		}
+		v := strings.TrimSpace(line[i+1:])
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		cur[strings.TrimSpace(line[:i])] = v
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
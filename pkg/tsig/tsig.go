@@ -0,0 +1,155 @@
+// Package tsig manages the TSIG keys used to sign DNS UPDATE, NOTIFY and
+// zone transfer messages and to authenticate requests to dnsup itself.
+package tsig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Key is a named TSIG key. Name and Algorithm are fully qualified and
+// Secret is base64 encoded, as the dns package expects.
+type Key struct {
+	Name      string
+	Algorithm string
+	Secret    string
+}
+
+// algorithms maps the supported algorithm names to their hashes.
+var algorithms = map[string]func() hash.Hash{
+	dns.HmacSHA256: sha256.New,
+	dns.HmacSHA512: sha512.New,
+}
+
+// NewKey returns a key after checking its algorithm and secret. An empty
+// algorithm means hmac-sha256.
+func NewKey(name, algorithm, secret string) (*Key, error) {
+	if algorithm == "" {
+		algorithm = dns.HmacSHA256
+	}
+	k := &Key{
+		Name:      dns.Fqdn(strings.ToLower(name)),
+		Algorithm: dns.Fqdn(strings.ToLower(algorithm)),
+		Secret:    secret,
+	}
+	if name == "" {
+		return nil, fmt.Errorf("TSIG key without a name")
+	}
+	if _, ok := algorithms[k.Algorithm]; !ok {
+		return nil, fmt.Errorf("TSIG key %s: unsupported algorithm %q (want hmac-sha256 or hmac-sha512)", k.Name, algorithm)
+	}
+	if _, err := base64.StdEncoding.DecodeString(secret); err != nil || secret == "" {
+		return nil, fmt.Errorf("TSIG key %s: secret is not base64", k.Name)
+	}
+	return k, nil
+}
+
+// ParseKey parses a key given inline as [algorithm:]name:secret.
+func ParseKey(spec string) (*Key, error) {
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 2:
+		return NewKey(parts[0], "", parts[1])
+	case 3:
+		return NewKey(parts[1], parts[0], parts[2])
+	}
+	return nil, fmt.Errorf("invalid TSIG key %q: want [algorithm:]name:secret", spec)
+}
+
+// Sign returns the HMAC of data under the key.
+func (k *Key) Sign(data []byte) []byte {
+	secret, _ := base64.StdEncoding.DecodeString(k.Secret)
+	h := hmac.New(algorithms[k.Algorithm], secret)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// Verify reports whether mac is the HMAC of data under the key.
+func (k *Key) Verify(data, mac []byte) bool {
+	return hmac.Equal(k.Sign(data), mac)
+}
+
+// Keyring is a set of keys by name.
+type Keyring struct {
+	keys map[string]*Key
+}
+
+// NewKeyring returns an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[string]*Key{}}
+}
+
+// Load reads the keys in the named file, in BIND key statement or YAML
+// form, into a new keyring.
+func Load(path string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*Key
+	if isBIND(string(data)) {
+		keys, err = parseBIND(string(data))
+	} else {
+		keys, err = parseYAML(string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	kr := NewKeyring()
+	for _, k := range keys {
+		if err := kr.Add(k); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return kr, nil
+}
+
+// Add adds k, failing if a key of the same name is present.
+func (kr *Keyring) Add(k *Key) error {
+	if _, ok := kr.keys[k.Name]; ok {
+		return fmt.Errorf("duplicate TSIG key %s", k.Name)
+	}
+	kr.keys[k.Name] = k
+	return nil
+}
+
+// Get returns the named key, or nil. A nil keyring holds no keys.
+func (kr *Keyring) Get(name string) *Key {
+	if kr == nil {
+		return nil
+	}
+	return kr.keys[dns.Fqdn(strings.ToLower(name))]
+}
+
+// Names returns the key names in order.
+func (kr *Keyring) Names() []string {
+	var names []string
+	if kr != nil {
+		for name := range kr.keys {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Secrets returns the secrets by key name, for the TsigSecret fields of
+// dns.Client, dns.Server and dns.Transfer.
+func (kr *Keyring) Secrets() map[string]string {
+	secrets := map[string]string{}
+	if kr != nil {
+		for name, k := range kr.keys {
+			secrets[name] = k.Secret
+		}
+	}
+	return secrets
+}
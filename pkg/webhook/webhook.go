@@ -0,0 +1,122 @@
+// Package webhook posts record change events to HTTP endpoints, optionally
+// signed with an HMAC so the receiver can check where they came from.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Event describes a change to the records of a name and type.
+type Event struct {
+	Event  string    `json:"event"`
+	Name   string    `json:"name"`
+	Type   string    `json:"type"`
+	Old    []string  `json:"old"`
+	New    string    `json:"new"`
+	Zone   string    `json:"zone,omitempty"`
+	Serial uint32    `json:"serial,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// SignatureHeader carries the hex HMAC-SHA256 of the body, keyed with the
+// hook's secret, as "sha256=<hex>".
+const SignatureHeader = "X-Dnsup-Signature"
+
+// Hook is an endpoint events are posted to.
+type Hook struct {
+	URL    string
+	Secret string
+	// Body, if set, renders the request body from the Event; otherwise
+	// the Event itself is posted as JSON.
+	Body   *template.Template
+	Client *http.Client
+}
+
+// funcs are available to body templates: json quotes a value as JSON, so
+// that {"text": {{json .Name}}} stays valid whatever the name contains,
+// and join joins a list such as .Old.
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+}
+
+// New returns a hook posting to url, with the body rendered from the
+// text/template body if it is not empty.
+func New(url, secret, body string) (*Hook, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("invalid webhook URL %q", url)
+	}
+	h := &Hook{URL: url, Secret: secret}
+	if body != "" {
+		t, err := template.New(url).Funcs(funcs).Parse(body)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %v", url, err)
+		}
+		h.Body = t
+	}
+	return h, nil
+}
+
+// Render returns the request body for ev.
+func (h *Hook) Render(ev *Event) ([]byte, error) {
+	if h.Body == nil {
+		return json.Marshal(ev)
+	}
+	var buf bytes.Buffer
+	if err := h.Body.Execute(&buf, ev); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign returns the signature header value for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send posts ev, failing unless the endpoint answers with a 2xx status.
+func (h *Hook) Send(ev *Event) error {
+	body, err := h.Render(ev)
+	if err != nil {
+		return fmt.Errorf("webhook %s: %v", h.URL, err)
+	}
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dnsup")
+	if h.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(h.Secret, body))
+	}
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook %s: %s: %s", h.URL, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package zonedb
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// AddressPolicy determines how UpdateIP changes a name with several
+// address records of the new address's family, such as a round-robin
+// set.
+type AddressPolicy int
+
+const (
+	// Overwrite points every record at the new address.
+	Overwrite AddressPolicy = iota
+	// ReplaceAll points the first record at the new address and removes
+	// the others.
+	ReplaceAll
+	// ReplaceOne points a single record at the new address: the one
+	// holding the old address given to ReplaceIP, or the only one, and
+	// removes it instead if another already holds the new address. A
+	// name with several records, none holding either address, is an
+	// error.
+	ReplaceOne
+)
+
+var addressPolicyNames = map[string]AddressPolicy{
+	"overwrite":   Overwrite,
+	"replace-all": ReplaceAll,
+	"replace-one": ReplaceOne,
+}
+
+// ParseAddressPolicy returns the policy named "overwrite", "replace-all"
+// or "replace-one".
+func ParseAddressPolicy(name string) (AddressPolicy, error) {
+	if p, ok := addressPolicyNames[name]; ok {
+		return p, nil
+	}
+	return 0, fmt.Errorf("unknown address policy %q", name)
+}
+
+func (p AddressPolicy) String() string {
+	for name, v := range addressPolicyNames {
+		if v == p {
+			return name
+		}
+	}
+	return fmt.Sprintf("AddressPolicy(%d)", int(p))
+}
+
+// SetAddressPolicy sets the policy of UpdateIP.
+func (r *DB) SetAddressPolicy(p AddressPolicy) {
+	r.addrPolicy = p
+}
+
+// ReplaceIP points the address record named domain holding old at ip,
+// whatever the DB's policy, as ReplaceOne does.
+func (r *DB) ReplaceIP(domain, old, ip string) error {
+	ipa := net.ParseIP(ip)
+	if ipa == nil {
+		return fmt.Errorf("invalid IP address %q for %q", ip, domain)
+	}
+	rrtype, addr := dns.TypeAAAA, ipa.String()
+	if ipa.To4() != nil {
+		rrtype, addr = dns.TypeA, ipa.To4().String()
+	}
+	if o := net.ParseIP(old); o != nil {
+		old = o.String()
+	}
+	err := r.checkManaged(domain, rrtype, func(tok *dns.Token) bool { return getRecord(tok).ip == old && old != addr })
+	if err != nil {
+		return err
+	}
+	return r.replaceOne(domain, rrtype, old, ipa)
+}
+
+// SetIPs makes the addresses of the records of type rrtype named domain
+// exactly ips: records holding one of them are kept, the others removed,
+// and the missing addresses added with the TTL of the existing records or
+// else of the zone's SOA.
+func (r *DB) SetIPs(domain string, rrtype uint16, ips []string) error {
+	want := map[string]net.IP{}
+	for _, ip := range ips {
+		ipa := net.ParseIP(ip)
+		if ipa == nil {
+			return fmt.Errorf("invalid IP address %q for %q", ip, domain)
+		}
+		if (ipa.To4() != nil) != (rrtype == dns.TypeA) {
+			return fmt.Errorf("%s is not an address for %s records", ip, dns.TypeToString[rrtype])
+		}
+		want[ipa.String()] = ipa
+	}
+	err := r.checkManaged(domain, rrtype, func(tok *dns.Token) bool { return want[getRecord(tok).ip] == nil })
+	if err != nil {
+		return err
+	}
+	auth := r.Zone(domain)
+	if auth == nil || auth.SOA() == nil {
+		return fmt.Errorf("no loaded zone contains %q", domain)
+	}
+	ttl := auth.SOA().Hdr.Ttl
+	have := map[string]bool{}
+	r.eachAddress(domain, rrtype, func(y *Authority, tok *dns.Token) {
+		ttl = tok.RR.Header().Ttl
+		if ip := getRecord(tok).ip; want[ip] == nil || have[ip] {
+			y.deleteToken(tok)
+		} else {
+			have[ip] = true
+		}
+	})
+	var missing []string
+	for ip := range want {
+		if !have[ip] {
+			missing = append(missing, ip)
+		}
+	}
+	sort.Strings(missing)
+	for _, ip := range missing {
+		hdr := dns.RR_Header{Name: domain, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+		var rr dns.RR = &dns.A{Hdr: hdr, A: want[ip].To4()}
+		if rrtype == dns.TypeAAAA {
+			rr = &dns.AAAA{Hdr: hdr, AAAA: want[ip]}
+		}
+		auth.addRecord(rr)
+	}
+	return nil
+}
+
+// eachAddress calls f for each record of type rrtype named domain, in
+// load order; f may delete the record.
+func (r *DB) eachAddress(domain string, rrtype uint16, f func(*Authority, *dns.Token)) {
+	key := nameKey(domain)
+	for _, mf := range r.filesOf(r.domains, key) {
+		for _, y := range mf.domains[key] {
+			for _, tok := range y.names[key] {
+				if getRecord(tok).rrtype == rrtype {
+					f(y, tok)
+				}
+			}
+		}
+	}
+}
+
+func (r *DB) replaceAll(domain string, rrtype uint16, ipa net.IP) {
+	first := true
+	r.eachAddress(domain, rrtype, func(y *Authority, tok *dns.Token) {
+		if first {
+			y.setIP(domain, tok, ipa)
+			first = false
+			return
+		}
+		y.deleteToken(tok)
+	})
+}
+
+func (r *DB) replaceOne(domain string, rrtype uint16, old string, ipa net.IP) error {
+	type address struct {
+		y   *Authority
+		tok *dns.Token
+	}
+	var all []address
+	match, held := -1, false
+	r.eachAddress(domain, rrtype, func(y *Authority, tok *dns.Token) {
+		ip := getRecord(tok).ip
+		if old != "" && ip == old && match < 0 {
+			match = len(all)
+		}
+		all = append(all, address{y, tok})
+		held = held || ip == ipa.String()
+	})
+	switch {
+	case match >= 0 && held:
+		all[match].y.deleteToken(all[match].tok) // another record holds ip already
+		return nil
+	case match >= 0:
+	case held || len(all) == 0:
+		return nil
+	case len(all) == 1:
+		match = 0
+	default:
+		return fmt.Errorf("%s has %d %s records and none holds the old address %q; not choosing one to replace", domain, len(all), dns.TypeToString[rrtype], old)
+	}
+	all[match].y.setIP(domain, all[match].tok, ipa)
+	return nil
+}
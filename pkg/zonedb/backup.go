@@ -0,0 +1,113 @@
+package zonedb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTime is the layout of the timestamp suffixed to backup names.
+const backupTime = "20060102T150405.000000000Z"
+
+// Backup is a copy of a master file taken before it was rewritten.
+type Backup struct {
+	Path string
+	Time time.Time
+}
+
+// EnableBackups makes Write copy each master file it is about to change
+// into dir, as <name>.<timestamp>, keeping only the newest keep copies of
+// each file, or every copy if keep is zero.
+func (r *DB) EnableBackups(dir string, keep int) {
+	r.backupDir, r.backupKeep = dir, keep
+}
+
+// backup copies cur, the current content of file, into the backup
+// directory, if enabled and the file is about to change to next.
+func (r *DB) backup(file string, cur, next []byte) error {
+	if r.backupDir == "" || cur == nil || bytes.Equal(cur, next) {
+		return nil
+	}
+	return saveBackup(r.backupDir, file, cur, r.backupKeep)
+}
+
+// BackupFile copies file into dir as Write does with backups enabled,
+// whether or not it is about to change.
+func BackupFile(dir, file string, keep int) error {
+	cur, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return saveBackup(dir, file, cur, keep)
+}
+
+func saveBackup(dir, file string, content []byte, keep int) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, filepath.Base(file)+"."+time.Now().UTC().Format(backupTime))
+	err := writeFile(name, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("backing up %s: %v", file, err)
+	}
+	if keep <= 0 {
+		return nil
+	}
+	backups, err := Backups(dir, file)
+	if err != nil {
+		return err
+	}
+	for len(backups) > keep {
+		if err := os.Remove(backups[0].Path); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Backups returns the backups of file in dir, oldest first.
+func Backups(dir, file string) ([]Backup, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	prefix := filepath.Base(file) + "."
+	var backups []Backup
+	for _, fi := range infos {
+		if fi.IsDir() || !strings.HasPrefix(fi.Name(), prefix) {
+			continue
+		}
+		t, err := time.Parse(backupTime, strings.TrimPrefix(fi.Name(), prefix))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Path: filepath.Join(dir, fi.Name()), Time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+	return backups, nil
+}
+
+// Restore atomically replaces file with the content of b.
+func Restore(b Backup, file string) error {
+	content, err := ioutil.ReadFile(b.Path)
+	if err != nil {
+		return err
+	}
+	return writeFile(file, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
@@ -0,0 +1,183 @@
+package zonedb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TTL bounds beyond which Check warns.
+const (
+	maxSaneTTL    = 7 * 24 * 3600
+	maxSaneMinTTL = 24 * 3600
+)
+
+// Problem is an issue Check found in a loaded zone. Warnings are dubious
+// but legal; everything else is an error that breaks the zone.
+type Problem struct {
+	File    string
+	Zone    string
+	Name    string
+	Warning bool
+	Msg     string
+}
+
+func (p Problem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	where := p.File + ": " + p.Zone
+	if p.Name != "" && !strings.EqualFold(p.Name, p.Zone) {
+		where += ": " + p.Name
+	}
+	return fmt.Sprintf("%s: %s: %s", where, level, p.Msg)
+}
+
+// Check validates the loaded zones without changing them: each zone has a
+// single SOA, with retry below refresh below expire, and apex NS records,
+// no CNAME shares its name with other data, name servers inside the zone
+// have glue, TTLs are sane, records belong to their zone and none is
+// duplicated, and the serial is of the form its policy produces.
+func (r *DB) Check() []Problem {
+	var problems []Problem
+	soas := map[string]int{}
+	for _, mf := range r.records {
+		for _, auth := range mf.records {
+			soas[strings.ToLower(auth.domain)]++
+		}
+	}
+	for _, mf := range r.records {
+		for _, auth := range mf.records {
+			c := &checker{db: r, file: mf.file, auth: auth}
+			if n := soas[strings.ToLower(auth.domain)]; n > 1 {
+				c.errorf(auth.domain, "%d SOA records for the zone", n)
+				soas[strings.ToLower(auth.domain)] = 0 // reported once
+			}
+			c.check()
+			problems = append(problems, c.problems...)
+		}
+	}
+	return problems
+}
+
+type checker struct {
+	db       *DB
+	file     string
+	auth     *Authority
+	problems []Problem
+}
+
+func (c *checker) errorf(name, format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{File: c.file, Zone: c.auth.domain, Name: name, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) warnf(name, format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{File: c.file, Zone: c.auth.domain, Name: name, Warning: true, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) check() {
+	y := c.auth
+	soa := y.SOA()
+	if soa == nil {
+		c.errorf(y.domain, "first record is not a SOA")
+		return
+	}
+	if len(y.NS()) == 0 {
+		c.errorf(y.domain, "no NS records at the zone apex")
+	}
+	if soa.Minttl > maxSaneMinTTL {
+		c.warnf(y.domain, "SOA minimum (negative caching) TTL %d is over a day", soa.Minttl)
+	}
+	if err := CheckSOATimers(soa.Refresh, soa.Retry, soa.Expire); err != nil {
+		c.warnf(y.domain, "%v", err)
+	}
+	c.checkSerial(soa.Serial)
+
+	names := make([]string, 0, len(y.names))
+	for name := range y.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		toks := y.names[name]
+		if !dns.IsSubDomain(strings.ToLower(y.domain), strings.ToLower(name)) {
+			c.errorf(name, "record is outside the zone")
+			continue
+		}
+		types := map[uint16]int{}
+		seen := map[string]bool{}
+		for _, tok := range toks {
+			hdr := tok.RR.Header()
+			types[hdr.Rrtype]++
+			if key := dupKey(tok); seen[key] {
+				c.warnf(name, "duplicate %s record %s", dns.TypeToString[hdr.Rrtype], rdata(tok.RR))
+			} else {
+				seen[key] = true
+			}
+			switch {
+			case hdr.Ttl > 1<<31-1:
+				c.errorf(name, "%s TTL %d is over 2^31-1", dns.TypeToString[hdr.Rrtype], hdr.Ttl)
+			case hdr.Ttl > maxSaneTTL:
+				c.warnf(name, "%s TTL %d is over a week", dns.TypeToString[hdr.Rrtype], hdr.Ttl)
+			}
+		}
+		if n := types[dns.TypeCNAME]; n > 0 {
+			if n > 1 {
+				c.errorf(name, "%d CNAME records", n)
+			}
+			for t := range types {
+				if t != dns.TypeCNAME && t != dns.TypeRRSIG && t != dns.TypeNSEC {
+					c.errorf(name, "CNAME alongside %s records", dns.TypeToString[t])
+				}
+			}
+		}
+		if types[dns.TypeNS] > 0 {
+			c.checkGlue(name, toks)
+		}
+	}
+}
+
+// checkGlue requires addresses for the name servers of the apex or a
+// delegation that lie inside the zone, without which they cannot be
+// reached.
+func (c *checker) checkGlue(name string, toks []*dns.Token) {
+	for _, tok := range toks {
+		ns, ok := tok.RR.(*dns.NS)
+		if !ok || !dns.IsSubDomain(strings.ToLower(c.auth.domain), strings.ToLower(ns.Ns)) {
+			continue
+		}
+		if len(c.db.Lookup(ns.Ns, dns.TypeA)) == 0 && len(c.db.Lookup(ns.Ns, dns.TypeAAAA)) == 0 {
+			c.errorf(name, "no glue A or AAAA record for name server %s", ns.Ns)
+		}
+	}
+}
+
+// checkSerial warns about a serial its zone's policy would not produce:
+// a date serial that is no YYYYMMDDnn date or a Unix time serial out of
+// range, either of them in the future.
+func (c *checker) checkSerial(serial uint32) {
+	policy := c.db.serialPolicy(c.auth.domain)
+	tomorrow := time.Now().UTC().Add(24 * time.Hour)
+	switch policy {
+	case SerialDate:
+		day, err := time.Parse("20060102", fmt.Sprintf("%08d", serial/100))
+		switch {
+		case err != nil || serial < 1000000000:
+			c.warnf(c.auth.domain, "serial %d is not of the form YYYYMMDDnn for policy %s", serial, policy)
+		case day.After(tomorrow):
+			c.warnf(c.auth.domain, "serial %d is a date in the future", serial)
+		}
+	case SerialUnix:
+		t := time.Unix(int64(serial), 0)
+		switch {
+		case t.Year() < 2000:
+			c.warnf(c.auth.domain, "serial %d is not a Unix time for policy %s", serial, policy)
+		case t.After(tomorrow):
+			c.warnf(c.auth.domain, "serial %d is a time in the future", serial)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package zonedb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxChain is the longest chain of CNAME records Canonical follows.
+const maxChain = 8
+
+// EnableCNAMEChase sets whether UpdateIP, given a name that is an alias
+// in the loaded zones, follows its chain of CNAME records and updates the
+// addresses of the canonical name. Otherwise such an update fails with an
+// *AliasError, as an alias cannot have addresses of its own.
+func (r *DB) EnableCNAMEChase(on bool) {
+	r.chase = on
+}
+
+// AliasError is returned by UpdateIP for a name that is a CNAME for
+// Target while CNAME chasing is disabled; nothing is changed.
+type AliasError struct {
+	Name   string
+	Target string
+}
+
+func (e *AliasError) Error() string {
+	return fmt.Sprintf("%s is an alias for %s; not updating it without CNAME chasing", e.Name, e.Target)
+}
+
+// Canonical returns the name at the end of the chain of CNAME records in
+// the loaded zones that starts at name, which is name itself if it is not
+// an alias or CNAME chasing is disabled. It fails if the chain loops, is
+// longer than 8 records or leads out of the loaded zones.
+func (r *DB) Canonical(name string) (string, error) {
+	if !r.chase {
+		return name, nil
+	}
+	return r.follow(name)
+}
+
+func (r *DB) follow(name string) (string, error) {
+	start := name
+	seen := map[string]bool{}
+	for {
+		target := r.cname(name)
+		if target == "" {
+			return name, nil
+		}
+		seen[strings.ToLower(name)] = true
+		switch {
+		case seen[strings.ToLower(target)]:
+			return "", fmt.Errorf("the CNAME chain of %s loops at %s", start, target)
+		case len(seen) >= maxChain:
+			return "", fmt.Errorf("the CNAME chain of %s is longer than %d records", start, maxChain)
+		case r.Zone(target) == nil:
+			return "", fmt.Errorf("the CNAME chain of %s leads to %s, outside the loaded zones", start, target)
+		}
+		name = target
+	}
+}
+
+// cname returns the target of the CNAME record named name, or "".
+func (r *DB) cname(name string) string {
+	for _, rr := range r.Lookup(name, dns.TypeCNAME) {
+		if c, ok := rr.(*dns.CNAME); ok {
+			return c.Target
+		}
+	}
+	return ""
+}
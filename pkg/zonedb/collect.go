@@ -0,0 +1,52 @@
+package zonedb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CollectErrors sets whether Load, rather than failing on the first file
+// it cannot load, loads every other file and returns a *LoadError listing
+// all those that failed, so that the valid zones can still be updated.
+func (r *DB) CollectErrors(on bool) {
+	r.collect = on
+}
+
+// LoadError is returned by Load, when collecting errors, for the master
+// files it left out.
+type LoadError struct {
+	Files []string // in the order given to Load
+	Errs  []error  // the error of each file, a *ParseError for a syntax error
+}
+
+func (e *LoadError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d master files failed to load: %s", len(e.Files), strings.Join(msgs, "; "))
+}
+
+// drop removes m, which failed to load, and its names and addresses from
+// the DB.
+func (r *DB) drop(m *MasterFile) {
+	for i, mf := range r.records {
+		if mf == m {
+			r.records = append(r.records[:i:i], r.records[i+1:]...)
+			break
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, idx := range []struct {
+		keys   map[string][]*Authority
+		parent map[string][]*MasterFile
+	}{{m.ips, r.ips}, {m.domains, r.domains}} {
+		for key := range idx.keys {
+			idx.parent[key] = dropMasterFile(idx.parent[key], m)
+			if len(idx.parent[key]) == 0 {
+				delete(idx.parent, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package zonedb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// comments are the comment and blank lines of a master file, and the
+// comments of its records, carried into a new text of its zone so that
+// its documentation survives a zone transfer or import replacing it.
+type comments struct {
+	header  []string            // the lines before the first record
+	lead    map[string][]string // the lines before the first record of an RRset, by rrsetKey
+	inline  map[string]string   // the comments of records, by dupKey
+	trailer []string            // the lines after the last record
+}
+
+// readComments returns the comments of file, which is about to be
+// replaced with rrs, the records of origin, or nil if it cannot be read.
+// The lines before an RRset that rrs no longer hold go before the next
+// RRset of the file instead.
+func readComments(file, origin string, rrs []dns.RR) *comments {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	prev := newMasterFile(file)
+	prev.parent = New()
+	if err := prev.read(prev.src, bytes.NewReader(data), &readState{origin: dns.Fqdn(origin)}); err != nil {
+		return nil
+	}
+	keep := map[string]bool{}
+	for _, rr := range rrs {
+		keep[rrsetKey(rr.Header())] = true
+	}
+	c := &comments{lead: map[string][]string{}, inline: map[string]string{}}
+	var lines []string
+	first := true
+	for _, e := range prev.src.entries {
+		switch {
+		case e.tok == nil && len(words(e.text)) == 0:
+			lines = append(lines, strings.TrimRight(e.text, "\n"))
+		case e.tok == nil: // a directive, written anew
+		case first:
+			c.header, lines, first = lines, nil, false
+		default:
+			key := rrsetKey(e.tok.RR.Header())
+			if keep[key] && c.lead[key] == nil && len(lines) > 0 {
+				c.lead[key], lines = lines, nil
+			}
+		}
+		if e.tok != nil && e.tok.Comment != "" && e.tok.RR.Header().Rrtype != dns.TypeSOA {
+			c.inline[dupKey(e.tok)] = e.tok.Comment
+		}
+	}
+	if first {
+		c.header, lines = lines, nil
+	}
+	c.trailer = lines
+	return c
+}
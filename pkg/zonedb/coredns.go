@@ -0,0 +1,88 @@
+package zonedb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// EnableCoreDNS sets whether Write and Diff render each master file in a
+// form CoreDNS's file plugin loads as intended, rather than keeping its
+// layout: the zone's $ORIGIN and $TTL, then the SOA, then every record
+// with an explicit TTL, the same for all the records of an RRset, and the
+// records of $INCLUDEd files inlined. Comments are kept. A file holding
+// other than one zone with a SOA, $GENERATE or unknown directives, or
+// records of unknown types cannot be written this way.
+func (r *DB) EnableCoreDNS(on bool) {
+	r.coredns = on
+}
+
+// renderCoreDNS writes m for CoreDNS; see EnableCoreDNS.
+func (m *MasterFile) renderCoreDNS(w io.Writer) error {
+	if len(m.records) != 1 || len(m.fragments) > 0 || m.records[0].SOA() == nil {
+		return fmt.Errorf("%s: CoreDNS needs a file to hold one zone, with a SOA record", m.file)
+	}
+	auth := m.records[0]
+	soa := auth.records[0]
+	ttls := map[string]uint32{}
+	for _, tok := range auth.records {
+		key := rrsetKey(tok.RR.Header())
+		if _, ok := ttls[key]; !ok {
+			ttls[key] = tok.RR.Header().Ttl
+		}
+	}
+	line := func(tok *dns.Token) string {
+		rr := dns.Copy(tok.RR)
+		rr.Header().Ttl = ttls[rrsetKey(rr.Header())]
+		text, name := rr.String(), rr.Header().Name
+		if strings.HasPrefix(text, name) {
+			text = relative(name, auth.domain) + text[len(name):]
+		}
+		if tok.Comment != "" {
+			text += " " + tok.Comment
+		}
+		return text + "\n"
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "$ORIGIN %s\n$TTL %d\n%s", auth.domain, soa.RR.Header().Ttl, line(soa))
+	if err := m.coreDNSEntries(bw, m.src, soa, line); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// coreDNSEntries writes the entries of src but soa for renderCoreDNS,
+// with those of the files it includes in place of its $INCLUDEs and
+// without the directives made redundant.
+func (m *MasterFile) coreDNSEntries(w io.Writer, src *source, soa *dns.Token, line func(*dns.Token) string) error {
+	included := 0
+	for _, e := range src.entries {
+		words := fields(e.text)
+		switch {
+		case e.deleted || e.tok == soa:
+		case e.tok != nil:
+			io.WriteString(w, line(e.tok))
+		case len(words) == 0:
+			io.WriteString(w, e.text)
+		case strings.EqualFold(words[0], "$INCLUDE"):
+			if err := m.coreDNSEntries(w, src.includes[included], soa, line); err != nil {
+				return err
+			}
+			included++
+		case strings.EqualFold(words[0], "$ORIGIN"), strings.EqualFold(words[0], "$TTL"):
+		case strings.HasPrefix(e.text, "$"):
+			return &ParseError{File: src.file, Line: e.line, Err: fmt.Errorf("%s cannot be written for CoreDNS", words[0])}
+		default:
+			return &ParseError{File: src.file, Line: e.line, Err: fmt.Errorf("record of unknown type cannot be written for CoreDNS")}
+		}
+	}
+	return nil
+}
+
+// rrsetKey identifies the RRset of a record.
+func rrsetKey(h *dns.RR_Header) string {
+	return fmt.Sprintf("%s/%d/%d", strings.ToLower(h.Name), h.Class, h.Rrtype)
+}
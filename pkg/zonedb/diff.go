@@ -0,0 +1,163 @@
+package zonedb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3
+
+type diffOp struct {
+	kind byte
+	a, b int
+}
+
+// unifiedDiff returns the differences between a and b in unified diff
+// format, or an empty string if they are identical.
+func unifiedDiff(fromName, toName string, a, b []byte) string {
+	al, bl := splitLines(a), splitLines(b)
+	ops := diffLines(al, bl)
+
+	var buf bytes.Buffer
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", fromName, toName)
+		}
+
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		last := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind == ' ' {
+				continue
+			}
+			if j-last > 2*diffContext {
+				break
+			}
+			last = j
+		}
+		end := last + diffContext + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		writeHunk(&buf, al, bl, ops[start:end])
+		i = end
+	}
+	return buf.String()
+}
+
+func writeHunk(buf *bytes.Buffer, a, b []string, ops []diffOp) {
+	var alen, blen int
+	for _, op := range ops {
+		if op.kind != '+' {
+			alen++
+		}
+		if op.kind != '-' {
+			blen++
+		}
+	}
+	astart, bstart := ops[0].a+1, ops[0].b+1
+	if alen == 0 {
+		astart--
+	}
+	if blen == 0 {
+		bstart--
+	}
+	fmt.Fprintf(buf, "@@ -%d,%d +%d,%d @@\n", astart, alen, bstart, blen)
+	for _, op := range ops {
+		var line string
+		if op.kind == '+' {
+			line = b[op.b]
+		} else {
+			line = a[op.a]
+		}
+		buf.WriteByte(op.kind)
+		buf.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func splitLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a shortest edit script from a to b using Myers'
+// algorithm.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[off+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', x - 1, y - 1})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', x, y - 1})
+			} else {
+				ops = append(ops, diffOp{'-', x - 1, y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
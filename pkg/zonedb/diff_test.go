@@ -0,0 +1,81 @@
+package zonedb
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"identical", "a\nb\n", "a\nb\n", ""},
+		{"both empty", "", "", ""},
+		{"changed line", "a\nb\nc\n", "a\nB\nc\n",
+			"--- f\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"added at end", "a\n", "a\nb\n",
+			"--- f\n+++ f\n@@ -1,1 +1,2 @@\n a\n+b\n"},
+		{"removed all", "a\n", "",
+			"--- f\n+++ f\n@@ -1,1 +0,0 @@\n-a\n"},
+		{"into empty", "", "a\n",
+			"--- f\n+++ f\n@@ -0,0 +1,1 @@\n+a\n"},
+		{"no newline at end", "a\nb", "a\nc",
+			"--- f\n+++ f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"},
+		{"context trimmed", "1\n2\n3\n4\n5\n6\n7\n8\n", "1\n2\n3\n4\nX\n6\n7\n8\n",
+			"--- f\n+++ f\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+X\n 6\n 7\n 8\n"},
+		{"separate hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n", "X\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\nY\n",
+			"--- f\n+++ f\n@@ -1,4 +1,4 @@\n-1\n+X\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+Y\n"},
+	} {
+		if got := unifiedDiff("f", "f", []byte(tc.a), []byte(tc.b)); got != tc.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDBDiff(t *testing.T) {
+	dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone})
+	defer done()
+	file := filepath.Join(dir, "example.com.zone")
+	r := load(t, file)
+
+	var buf bytes.Buffer
+	if err := r.Diff(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("diff of unmodified zone:\n%s", buf.String())
+	}
+
+	if err := r.UpdateRecord("www.example.com.", dns.TypeA, "192.0.2.11"); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := r.Diff(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "--- " + file + "\n+++ " + file + "\n" +
+		"@@ -1,10 +1,10 @@\n" +
+		" $ORIGIN example.com.\n" +
+		" $TTL 3600\n" +
+		" @\tIN\tSOA\tns1 hostmaster (\n" +
+		"-\t\t2024010101 ; serial\n" +
+		"+\t\t2024010102 ; serial\n" +
+		" \t\t7200 3600 1209600 300 )\n" +
+		" \tIN\tNS\tns1\n" +
+		" ; hosts\n" +
+		" ns1\tIN\tA\t192.0.2.1 ; the name server\n" +
+		"-www\t300\tIN\tA\t192.0.2.10\n" +
+		"+www\t300\tIN\tA\t192.0.2.11\n" +
+		" mail   IN  A   192.0.2.20\n"
+	if buf.String() != want {
+		t.Errorf("diff:\n%s\nwant\n%s", buf.String(), want)
+	}
+	if text := readFile(t, file); text != exampleZone {
+		t.Errorf("Diff wrote the master file:\n%s", text)
+	}
+}
@@ -0,0 +1,67 @@
+package zonedb
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ExpiresMarker, in a record's comment, gives the time after which
+// DeleteExpired removes the record, as in
+//
+//	lab1 300 IN A 192.0.2.7 ; dnsup:expires 2024-01-02T15:04:05Z
+const ExpiresMarker = "dnsup:expires"
+
+// AddExpiringRecord adds rr to the zone containing its owner name, as
+// AddRecord does, marked to expire at at.
+func (r *DB) AddExpiringRecord(rr dns.RR, at time.Time) error {
+	return r.addRecord(rr, "; "+ExpiresMarker+" "+at.UTC().Format(time.RFC3339))
+}
+
+// DeleteExpired removes every record whose expiry is not after now, and
+// returns them.
+func (r *DB) DeleteExpired(now time.Time) []dns.RR {
+	var rrs []dns.RR
+	for _, mf := range r.records {
+		for _, auth := range append(mf.records, mf.fragments...) {
+			for _, tok := range append([]*dns.Token(nil), auth.records...) {
+				if at, ok := expiry(tok); ok && !now.Before(at) {
+					auth.deleteToken(tok)
+					rrs = append(rrs, tok.RR)
+				}
+			}
+		}
+	}
+	return rrs
+}
+
+// NextExpiry returns the earliest expiry of any record, and false if no
+// record expires.
+func (r *DB) NextExpiry() (time.Time, bool) {
+	var next time.Time
+	for _, mf := range r.records {
+		for _, auth := range append(mf.records, mf.fragments...) {
+			for _, tok := range auth.records {
+				if at, ok := expiry(tok); ok && (next.IsZero() || at.Before(next)) {
+					next = at
+				}
+			}
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// expiry returns the time tok's comment marks it to expire at.
+func expiry(tok *dns.Token) (time.Time, bool) {
+	i := strings.Index(tok.Comment, ExpiresMarker)
+	if i < 0 {
+		return time.Time{}, false
+	}
+	f := strings.Fields(tok.Comment[i+len(ExpiresMarker):])
+	if len(f) == 0 {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339, f[0])
+	return at, err == nil
+}
@@ -0,0 +1,72 @@
+package zonedb
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile atomically replaces name with the output of render, as the
+// master files themselves are written.
+func WriteFile(name string, render func(io.Writer) error) error {
+	return writeFile(name, render)
+}
+
+// writeFile atomically replaces name with the output of render.
+func writeFile(name string, render func(io.Writer) error) error {
+	tmp, err := stageFile(name, render)
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// stageFile writes the output of render to a temporary file in the same
+// directory as name, so that renaming it into place never crosses
+// filesystems, and returns its name. The content is synced, and an
+// existing file's mode and ownership are copied to it.
+func stageFile(name string, render func(io.Writer) error) (tmp string, err error) {
+	fi, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err != nil {
+		return "", err
+	}
+	tmp = fi.Name()
+	defer func() {
+		if err != nil {
+			fi.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	if err = render(fi); err != nil {
+		return "", err
+	}
+	if err = fi.Sync(); err != nil {
+		return "", err
+	}
+	if err = fi.Close(); err != nil {
+		return "", err
+	}
+
+	mode := os.FileMode(0644)
+	st, err := os.Stat(name)
+	switch {
+	case err == nil:
+		mode = st.Mode().Perm()
+		if err = preserveOwner(tmp, st); err != nil {
+			return "", fmt.Errorf("cannot preserve ownership of %s: %v", name, err)
+		}
+	case !os.IsNotExist(err):
+		return "", err
+	}
+	if err = os.Chmod(tmp, mode); err != nil {
+		return "", err
+	}
+	return tmp, nil
+}
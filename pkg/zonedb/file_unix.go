@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package zonedb
+
+import (
+	"os"
+	"syscall"
+)
+
+func preserveOwner(name string, st os.FileInfo) error {
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Chown(name, int(sys.Uid), int(sys.Gid))
+}
+
+// syncDir flushes the directory entry of a rename to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// replaceFile renames tmp over name, which rename(2) does atomically.
+func replaceFile(tmp, name string) error {
+	return os.Rename(tmp, name)
+}
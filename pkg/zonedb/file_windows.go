@@ -0,0 +1,61 @@
+package zonedb
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var procMoveFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("MoveFileExW")
+
+const (
+	moveFileReplaceExisting = 0x1
+	moveFileWriteThrough    = 0x8
+)
+
+func preserveOwner(name string, st os.FileInfo) error {
+	return nil
+}
+
+// syncDir does nothing on Windows, where replaceFile writes the rename
+// through to disk.
+func syncDir(dir string) error {
+	return nil
+}
+
+// replaceFile moves tmp over name with MoveFileEx, which replaces the
+// file in one step on NTFS but fails while another process, such as a
+// DNS server reloading the zone or a virus scanner, has name open without
+// sharing it. Such failures are retried for a few seconds.
+func replaceFile(tmp, name string) error {
+	from, err := syscall.UTF16PtrFromString(tmp)
+	if err != nil {
+		return err
+	}
+	to, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	for wait := 10 * time.Millisecond; ; wait *= 2 {
+		r, _, e := procMoveFileEx.Call(uintptr(unsafe.Pointer(from)), uintptr(unsafe.Pointer(to)), moveFileReplaceExisting|moveFileWriteThrough)
+		if r != 0 {
+			return nil
+		}
+		if !retryable(e) || wait > 2*time.Second {
+			return &os.LinkError{Op: "rename", Old: tmp, New: name, Err: e}
+		}
+		time.Sleep(wait)
+	}
+}
+
+// retryable reports whether err is the transient failure of a file held
+// open by another process.
+func retryable(err error) bool {
+	const errorSharingViolation = 32
+	switch err {
+	case syscall.ERROR_ACCESS_DENIED, syscall.Errno(errorSharingViolation):
+		return true
+	}
+	return false
+}
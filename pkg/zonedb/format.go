@@ -0,0 +1,252 @@
+package zonedb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Format writes the text of m in a canonical style, as gofmt does for Go:
+// every record on one line with its owner name, in lower case and relative
+// to the $ORIGIN, an explicit TTL in seconds and its class, in columns
+// aligned across its paragraph; the records of each paragraph, a run of
+// records not broken by a blank line or directive, sorted by name and type
+// with the SOA first; directives in upper case, with the name given to
+// $ORIGIN in lower case, and single blank lines. Comments are kept, those
+// on lines of their own with the record they precede. Records of unknown
+// types and the files m includes are left as they are.
+func (m *MasterFile) Format(w io.Writer) error {
+	f := &formatter{w: bufio.NewWriter(w)}
+	for _, e := range m.src.entries {
+		words := fields(e.text)
+		text := strings.TrimSpace(e.text)
+		switch {
+		case e.deleted:
+		case e.tok != nil:
+			f.run = append(f.run, formatGroup{comments: f.comments, e: e})
+			f.comments = nil
+		case text == "":
+			f.flush()
+			f.blank = f.started
+		case len(words) == 0:
+			f.comments = append(f.comments, text)
+		case strings.HasPrefix(text, "$"):
+			f.flush()
+			f.line(formatDirective(words, text))
+		default:
+			f.flush()
+			f.line(strings.TrimRight(e.text, "\n"))
+		}
+	}
+	f.flush()
+	return f.w.Flush()
+}
+
+// FormatDiff writes the changes Format would make to m as a unified diff.
+func (m *MasterFile) FormatDiff(w io.Writer) error {
+	return diffFile(w, m.file, m.Format)
+}
+
+// WriteFormatted replaces the file of m with its Format, failing if it
+// has changed since it was loaded. Its serial is left as it is, as the
+// records are unchanged.
+func (m *MasterFile) WriteFormatted() error {
+	unlock, err := lockFile(m.file, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := m.src.unchanged(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := m.Format(&buf); err != nil {
+		return err
+	}
+	if err := writeFile(m.file, func(w io.Writer) error { _, err := w.Write(buf.Bytes()); return err }); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	m.src.sum = sum[:]
+	return nil
+}
+
+// formatter collects the paragraph being formatted by Format.
+type formatter struct {
+	w        *bufio.Writer
+	run      []formatGroup
+	comments []string // comment lines not yet followed by a record
+	blank    bool     // a blank line is due before the next line
+	started  bool     // a line has been written
+}
+
+// formatGroup is a record with the comment lines before it.
+type formatGroup struct {
+	comments []string
+	e        *entry
+}
+
+func (f *formatter) line(s string) {
+	if f.blank {
+		f.w.WriteString("\n")
+		f.blank = false
+	}
+	f.w.WriteString(s + "\n")
+	f.started = true
+}
+
+// flush writes the paragraph collected, sorted and aligned, and the
+// comment lines after it.
+func (f *formatter) flush() {
+	sort.SliceStable(f.run, func(i, j int) bool { return formatLess(f.run[i].e.tok.RR, f.run[j].e.tok.RR) })
+	var cols [][]string
+	widths := make([]int, 4)
+	for _, g := range f.run {
+		c := formatColumns(g.e.tok.RR, g.e.origin)
+		for i := range widths {
+			if len(c[i]) > widths[i] {
+				widths[i] = len(c[i])
+			}
+		}
+		cols = append(cols, c)
+	}
+	for i, g := range f.run {
+		for _, c := range g.comments {
+			f.line(c)
+		}
+		var b strings.Builder
+		for j, w := range widths {
+			fmt.Fprintf(&b, "%-*s ", w, cols[i][j])
+		}
+		b.WriteString(cols[i][4])
+		if g.e.tok.Comment != "" {
+			b.WriteString(" " + g.e.tok.Comment)
+		}
+		f.line(b.String())
+	}
+	for _, c := range f.comments {
+		f.line(c)
+	}
+	f.run, f.comments = nil, nil
+}
+
+// formatColumns returns the owner, TTL, class, type and data of rr as
+// Format writes them.
+func formatColumns(rr dns.RR, origin string) []string {
+	rr = dns.Copy(rr)
+	h := rr.Header()
+	h.Name = strings.ToLower(h.Name)
+	switch rr := rr.(type) {
+	case *dns.NS:
+		rr.Ns = strings.ToLower(rr.Ns)
+	case *dns.CNAME:
+		rr.Target = strings.ToLower(rr.Target)
+	case *dns.DNAME:
+		rr.Target = strings.ToLower(rr.Target)
+	case *dns.PTR:
+		rr.Ptr = strings.ToLower(rr.Ptr)
+	case *dns.MX:
+		rr.Mx = strings.ToLower(rr.Mx)
+	case *dns.SRV:
+		rr.Target = strings.ToLower(rr.Target)
+	case *dns.SOA:
+		rr.Ns, rr.Mbox = strings.ToLower(rr.Ns), strings.ToLower(rr.Mbox)
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(rr.String(), h.String()))
+	return []string{
+		relative(h.Name, strings.ToLower(origin)),
+		strconv.FormatUint(uint64(h.Ttl), 10),
+		dns.ClassToString[h.Class],
+		dns.TypeToString[h.Rrtype],
+		data,
+	}
+}
+
+// formatLess orders records by name, in the canonical order of RFC 4034
+// so that names follow their parents, then by type: SOA, NS, and the
+// rest by mnemonic.
+func formatLess(a, b dns.RR) bool {
+	ha, hb := a.Header(), b.Header()
+	if ha.Rrtype == dns.TypeSOA || hb.Rrtype == dns.TypeSOA {
+		return ha.Rrtype == dns.TypeSOA && hb.Rrtype != dns.TypeSOA
+	}
+	if c := compareNames(ha.Name, hb.Name); c != 0 {
+		return c < 0
+	}
+	rank := func(t uint16) string {
+		if t == dns.TypeNS {
+			return ""
+		}
+		return dns.TypeToString[t]
+	}
+	return rank(ha.Rrtype) < rank(hb.Rrtype)
+}
+
+// compareNames compares names label by label from the root, ignoring
+// case.
+func compareNames(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// formatDirective returns the directive text with its keyword in upper
+// case, its words single-spaced, the name of $ORIGIN in lower case and
+// the value of $TTL in seconds. A comment after it is kept.
+func formatDirective(words []string, text string) string {
+	words = append([]string(nil), words...)
+	words[0] = strings.ToUpper(words[0])
+	switch {
+	case len(words) < 2:
+	case words[0] == "$ORIGIN":
+		words[1] = strings.ToLower(words[1])
+	case words[0] == "$TTL":
+		if ttl, ok := ttlSeconds(words[1]); ok {
+			words[1] = strconv.FormatUint(uint64(ttl), 10)
+		}
+	}
+	out := strings.Join(words, " ")
+	if i := commentStart(text); i >= 0 {
+		out += " " + text[i:]
+	}
+	return out
+}
+
+// ttlSeconds parses a TTL in seconds or in BIND's units, such as 1h30m.
+func ttlSeconds(s string) (uint32, bool) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(n), true
+	}
+	if s == "" {
+		return 0, false
+	}
+	var total, n uint64
+	digits := false
+	for _, c := range strings.ToLower(s) {
+		if c >= '0' && c <= '9' {
+			n, digits = n*10+uint64(c-'0'), true
+			continue
+		}
+		unit := map[rune]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}[c]
+		if unit == 0 || !digits {
+			return 0, false
+		}
+		total, n, digits = total+n*unit, 0, false
+	}
+	if digits || total > 1<<32-1 {
+		return 0, false
+	}
+	return uint32(total), true
+}
@@ -0,0 +1,40 @@
+package zonedb
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	const text = `$origin Example.COM.
+$ttl 1h
+@ IN SOA NS1 hostmaster 1 7200 3600 1209600 300
+WWW 300 IN A 192.0.2.10 ; web
+Mail IN  MX 10 MAIL.Example.COM.
+
+
+; hosts
+ns1 IN A 192.0.2.1
+`
+	const want = `$ORIGIN example.com.
+$TTL 3600
+@    3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300
+mail 3600 IN MX  10 mail.example.com.
+www  300  IN A   192.0.2.10 ; web
+
+; hosts
+ns1 3600 IN A 192.0.2.1
+`
+	dir, done := tempDir(t, map[string]string{"example.com.zone": text})
+	defer done()
+	r := load(t, filepath.Join(dir, "example.com.zone"))
+
+	var b strings.Builder
+	if err := r.Files()[0].Format(&b); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("formatted as:\n%s\nwant:\n%s", got, want)
+	}
+}
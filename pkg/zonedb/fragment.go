@@ -0,0 +1,95 @@
+package zonedb
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/miekg/dns"
+)
+
+// SetOrigin sets the default $ORIGIN of the master file file, or of every
+// file without its own when file is empty. A file with an origin may lack
+// a SOA record, like the fragments a zone includes with $INCLUDE: records
+// before any SOA are loaded as fragments of the zone of the $ORIGIN in
+// effect, so that a file with several $ORIGIN directives holds fragments
+// of several zones. Their records can be looked up and changed like any
+// other, but they are not authorities of their own. A change to one
+// advances the serial of the loaded zone containing its origin, if any;
+// otherwise the zone including the fragment is left to be reloaded by
+// whoever serves it.
+func (r *DB) SetOrigin(file, origin string) {
+	if origin != "" {
+		origin = dns.Fqdn(origin)
+	}
+	if file == "" {
+		r.origin = origin
+		return
+	}
+	r.origins[filepath.Clean(file)] = origin
+}
+
+func (r *DB) originOf(file string) string {
+	if o, ok := r.origins[filepath.Clean(file)]; ok {
+		return o
+	}
+	return r.origin
+}
+
+// fragmentOf returns the fragment of m for origin, adding it if need be.
+func (m *MasterFile) fragmentOf(origin string) *Authority {
+	for _, y := range m.fragments {
+		if y.domain == origin {
+			return y
+		}
+	}
+	y := newAuthority(origin)
+	y.master = m
+	y.fragment = true
+	m.fragments = append(m.fragments, y)
+	return y
+}
+
+// Fragments returns the origins of the fragments in the file, in file
+// order.
+func (m *MasterFile) Fragments() []string {
+	var origins []string
+	for _, y := range m.fragments {
+		origins = append(origins, y.domain)
+	}
+	return origins
+}
+
+// bumpFragments advances the serial of the zone containing each of m's
+// fragments that has changed, if the zone is loaded.
+func (m *MasterFile) bumpFragments() error {
+	for _, y := range m.fragments {
+		if !y.dirty {
+			continue
+		}
+		if z := m.parent.Zone(y.domain); z != nil {
+			z.dirty = true
+			if err := z.bumpSerial(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkFragments rejects fragments that a loaded zone also includes, as
+// the file would be loaded, and written, twice.
+func (r *DB) checkFragments() error {
+	for _, frag := range r.records {
+		if len(frag.fragments) == 0 {
+			continue
+		}
+		for _, mf := range r.records {
+			for _, src := range mf.src.all()[1:] {
+				if mf != frag && src.file == frag.file {
+					return fmt.Errorf("%s is included by %s; load only the zone", frag.file, mf.file)
+				}
+			}
+		}
+	}
+	return nil
+}
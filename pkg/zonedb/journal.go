@@ -0,0 +1,261 @@
+package zonedb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Delta is one journaled change to a zone: the records deleted and added
+// as its serial moved from From to To. Deleted starts with the old SOA
+// and Added with the new one, the order in which IXFR carries them.
+type Delta struct {
+	Zone    string
+	From    uint32
+	To      uint32
+	Time    time.Time
+	Deleted []dns.RR
+	Added   []dns.RR
+}
+
+// Reverse returns the delta that undoes d.
+func (d *Delta) Reverse() *Delta {
+	return &Delta{Zone: d.Zone, From: d.To, To: d.From, Time: d.Time, Deleted: d.Added, Added: d.Deleted}
+}
+
+// JournalPath returns the journal kept alongside a master file.
+func JournalPath(file string) string {
+	return file + ".jnl"
+}
+
+// EnableJournal makes Write append the changes of each modified zone to
+// the journal of its master file.
+func (r *DB) EnableJournal(on bool) {
+	r.journal = on
+}
+
+// Journal returns the journaled deltas of zone, oldest first.
+func (r *DB) Journal(zone string) ([]*Delta, error) {
+	var deltas []*Delta
+	for _, mf := range r.records {
+		for _, auth := range mf.records {
+			if !strings.EqualFold(auth.domain, zone) {
+				continue
+			}
+			ds, err := ReadJournal(JournalPath(mf.file))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			for _, d := range ds {
+				if strings.EqualFold(d.Zone, zone) {
+					deltas = append(deltas, d)
+				}
+			}
+		}
+	}
+	return deltas, nil
+}
+
+// Since returns the chain of deltas leading from serial to the latest
+// serial, as an IXFR response carries them. It fails if the journal does
+// not reach back to serial.
+func Since(deltas []*Delta, serial uint32) ([]*Delta, bool) {
+	for i := len(deltas) - 1; i >= 0; i-- {
+		if deltas[i].From != serial {
+			continue
+		}
+		chain := deltas[i:]
+		for j := 1; j < len(chain); j++ {
+			if chain[j].From != chain[j-1].To {
+				return nil, false
+			}
+		}
+		return chain, true
+	}
+	return nil, false
+}
+
+// ApplyDelta deletes and adds the records of d other than its SOAs, so
+// that journaled changes can be replayed or, reversed, rolled back. The
+// serial advances on Write as for any other change.
+func (r *DB) ApplyDelta(d *Delta) error {
+	var auth *Authority
+	for _, mf := range r.records {
+		for _, a := range mf.records {
+			if strings.EqualFold(a.domain, d.Zone) {
+				auth = a
+			}
+		}
+	}
+	if auth == nil {
+		return fmt.Errorf("zone %s is not loaded", d.Zone)
+	}
+	for _, rr := range d.Deleted {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			continue
+		}
+		hdr := rr.Header()
+		if n := auth.deleteRecords(hdr.Name, hdr.Rrtype, rdata(rr)); n == 0 {
+			return fmt.Errorf("zone %s: %s not found", d.Zone, rr)
+		}
+	}
+	for _, rr := range d.Added {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			continue
+		}
+		auth.addRecord(dns.Copy(rr))
+	}
+	return nil
+}
+
+// snapshot keeps a copy of the records of each authority, the base that
+// journal deltas are computed against.
+func (m *MasterFile) snapshot() {
+	for _, auth := range m.records {
+		auth.base = map[string]dns.RR{}
+		auth.baseSOA = nil
+		for _, tok := range auth.records {
+			rr := dns.Copy(tok.RR)
+			if rr.Header().Rrtype == dns.TypeSOA && auth.baseSOA == nil {
+				auth.baseSOA = rr
+				continue
+			}
+			auth.base[rr.String()] = rr
+		}
+	}
+}
+
+// delta returns the changes to y since the last snapshot, or nil if
+// there are none.
+func (y *Authority) delta(now time.Time) *Delta {
+	soa := y.SOA()
+	if !y.dirty || soa == nil || y.baseSOA == nil {
+		return nil
+	}
+	d := &Delta{Zone: y.domain, From: y.baseSOA.(*dns.SOA).Serial, To: soa.Serial, Time: now}
+	cur := map[string]bool{}
+	var added []dns.RR
+	for _, tok := range y.records[1:] {
+		s := tok.RR.String()
+		cur[s] = true
+		if _, ok := y.base[s]; !ok {
+			added = append(added, dns.Copy(tok.RR))
+		}
+	}
+	d.Deleted = []dns.RR{y.baseSOA}
+	for s, rr := range y.base {
+		if !cur[s] {
+			d.Deleted = append(d.Deleted, rr)
+		}
+	}
+	if len(added) == 0 && len(d.Deleted) == 1 && d.From == d.To {
+		return nil
+	}
+	d.Added = append([]dns.RR{dns.Copy(soa)}, added...)
+	return d
+}
+
+// journalChanges appends the changes to each modified authority of m to
+// its journal and takes a new snapshot.
+func (m *MasterFile) journalChanges() error {
+	var deltas []*Delta
+	now := time.Now()
+	for _, auth := range m.records {
+		if d := auth.delta(now); d != nil {
+			deltas = append(deltas, d)
+		}
+	}
+	if len(deltas) > 0 {
+		if err := appendJournal(JournalPath(m.file), deltas); err != nil {
+			return err
+		}
+	}
+	m.snapshot()
+	return nil
+}
+
+// The journal is a text file of deltas, each a header line followed by
+// the deleted and added records in presentation format:
+//
+//	$DELTA example.com. 2024010101 2024010102 1704067200
+//	-example.com.	3600	IN	SOA	ns1.example.com. ...
+//	-www.example.com.	300	IN	A	192.0.2.1
+//	+example.com.	3600	IN	SOA	ns1.example.com. ...
+//	+www.example.com.	300	IN	A	192.0.2.2
+
+func appendJournal(path string, deltas []*Delta) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, d := range deltas {
+		fmt.Fprintf(w, "$DELTA %s %d %d %d\n", d.Zone, d.From, d.To, d.Time.Unix())
+		for _, rr := range d.Deleted {
+			fmt.Fprintf(w, "-%s\n", rr)
+		}
+		for _, rr := range d.Added {
+			fmt.Fprintf(w, "+%s\n", rr)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadJournal reads the deltas in the journal at path, oldest first.
+func ReadJournal(path string) ([]*Delta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var deltas []*Delta
+	var cur *Delta
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64<<10), 1<<20)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "$DELTA "):
+			fs := strings.Fields(line)
+			if len(fs) != 5 {
+				return nil, fmt.Errorf("%s:%d: malformed delta header", path, n)
+			}
+			from, err1 := strconv.ParseUint(fs[2], 10, 32)
+			to, err2 := strconv.ParseUint(fs[3], 10, 32)
+			t, err3 := strconv.ParseInt(fs[4], 10, 64)
+			if err1 != nil || err2 != nil || err3 != nil {
+				return nil, fmt.Errorf("%s:%d: malformed delta header", path, n)
+			}
+			cur = &Delta{Zone: fs[1], From: uint32(from), To: uint32(to), Time: time.Unix(t, 0)}
+			deltas = append(deltas, cur)
+		case cur != nil && (line[0] == '-' || line[0] == '+'):
+			rr, err := dns.NewRR(line[1:])
+			if err != nil || rr == nil {
+				return nil, fmt.Errorf("%s:%d: invalid record: %v", path, n, err)
+			}
+			if line[0] == '-' {
+				cur.Deleted = append(cur.Deleted, rr)
+			} else {
+				cur.Added = append(cur.Added, rr)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unexpected line", path, n)
+		}
+	}
+	return deltas, s.Err()
+}
@@ -0,0 +1,54 @@
+package zonedb
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// fileLocks serializes access to each master file within the process, as
+// the advisory lock on its lock file does between processes.
+var fileLocks = struct {
+	sync.Mutex
+	m map[string]*sync.RWMutex
+}{m: map[string]*sync.RWMutex{}}
+
+// lockFile locks the master file name, shared for reading or exclusive
+// for writing, until the returned function is called. Between processes
+// the lock is an flock(2) on name+".lock", which other dnsup processes
+// honour; a shared lock whose lock file cannot be created, as in a
+// read-only directory, is held within the process only.
+func lockFile(name string, exclusive bool) (func(), error) {
+	name = filepath.Clean(name)
+	fileLocks.Lock()
+	mu := fileLocks.m[name]
+	if mu == nil {
+		mu = &sync.RWMutex{}
+		fileLocks.m[name] = mu
+	}
+	fileLocks.Unlock()
+
+	if exclusive {
+		mu.Lock()
+	} else {
+		mu.RLock()
+	}
+	release := func() {
+		if exclusive {
+			mu.Unlock()
+		} else {
+			mu.RUnlock()
+		}
+	}
+	unflock, err := flockFile(name+".lock", exclusive)
+	if err != nil {
+		if exclusive {
+			release()
+			return nil, err
+		}
+		return release, nil
+	}
+	return func() {
+		unflock()
+		release()
+	}, nil
+}
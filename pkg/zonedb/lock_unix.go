@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+package zonedb
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// flockFile takes an flock(2) on name, creating it if need be, waiting
+// for any conflicting lock to be released.
+func flockFile(name string, exclusive bool) (func(), error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %s: %v", name, err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
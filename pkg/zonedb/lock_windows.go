@@ -0,0 +1,40 @@
+package zonedb
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const lockfileExclusiveLock = 0x2
+
+// flockFile takes a LockFileEx lock on the first byte of name, creating
+// it if need be, waiting for any conflicting lock to be released. Such
+// locks are mandatory, but nothing reads the lock file itself.
+func flockFile(name string, exclusive bool) (func(), error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	var flags uintptr
+	if exclusive {
+		flags = lockfileExclusiveLock
+	}
+	ol := new(syscall.Overlapped)
+	r, _, e := procLockFileEx.Call(f.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		f.Close()
+		return nil, fmt.Errorf("locking %s: %v", name, e)
+	}
+	return func() {
+		procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+		f.Close()
+	}, nil
+}
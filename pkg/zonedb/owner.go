@@ -0,0 +1,162 @@
+package zonedb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ManagedMarker, in a record's comment, marks the record as one dnsup may
+// change when ownership is enabled.
+const ManagedMarker = "dnsup:managed"
+
+// EnableOwnership makes the DB refuse, with an *UnmanagedError, to change
+// or delete records whose comment lacks ManagedMarker, so that records
+// maintained by hand are never rewritten by accident. Each record the DB
+// changes or adds is stamped with the marker and the time, as in
+//
+//	www 300 IN A 192.0.2.1 ; dnsup:managed 2024-01-02T15:04:05Z
+func (r *DB) EnableOwnership(on bool) {
+	r.owners = on
+}
+
+// SetOwnership enables or disables ownership for zone alone, whatever
+// EnableOwnership says for the others.
+func (r *DB) SetOwnership(zone string, on bool) {
+	r.owned[strings.ToLower(zone)] = on
+}
+
+// owns reports whether ownership is enabled for zone.
+func (r *DB) owns(zone string) bool {
+	if on, ok := r.owned[strings.ToLower(zone)]; ok {
+		return on
+	}
+	return r.owners
+}
+
+// UnmanagedError is returned for a change to a record not marked with
+// ManagedMarker while ownership is enabled; nothing is changed.
+type UnmanagedError struct {
+	Name string
+	Type uint16
+	File string // the master file of the record
+	Line int    // its line, or zero if it was added since loading
+}
+
+func (e *UnmanagedError) Error() string {
+	msg := fmt.Sprintf("%s %s is not marked \"; %s\"; not changing it", e.Name, dns.TypeToString[e.Type], ManagedMarker)
+	switch {
+	case e.File == "":
+		return msg
+	case e.Line == 0:
+		return e.File + ": " + msg
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, msg)
+}
+
+func managed(tok *dns.Token) bool {
+	return strings.Contains(tok.Comment, ManagedMarker)
+}
+
+// checkManaged returns an *UnmanagedError if a record named name of type
+// rrtype, or of any type but SOA for dns.TypeANY, in a zone with ownership
+// enabled, for which change reports true lacks the marker. A nil change
+// checks every such record.
+func (r *DB) checkManaged(name string, rrtype uint16, change func(*dns.Token) bool) error {
+	if !r.owners && len(r.owned) == 0 {
+		return nil
+	}
+	key := nameKey(name)
+	for _, mf := range r.filesOf(r.domains, key) {
+		for _, auth := range mf.domains[key] {
+			if !r.owns(auth.domain) {
+				continue
+			}
+			for _, tok := range auth.names[key] {
+				t := tok.RR.Header().Rrtype
+				if t == dns.TypeSOA || rrtype != dns.TypeANY && t != rrtype {
+					continue
+				}
+				if !managed(tok) && (change == nil || change(tok)) {
+					file, line := mf.position(tok)
+					return &UnmanagedError{Name: name, Type: t, File: file, Line: line}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// stamp marks tok, just changed or added in zone, with the marker and the
+// time if ownership is enabled for the zone, replacing any earlier stamp
+// and keeping the rest of its comment.
+func (r *DB) stamp(zone string, tok *dns.Token) {
+	if !r.owns(zone) {
+		return
+	}
+	s := ManagedMarker + " " + time.Now().UTC().Format(time.RFC3339)
+	switch i := strings.Index(tok.Comment, ManagedMarker); {
+	case i >= 0:
+		tok.Comment = tok.Comment[:i] + s
+	case tok.Comment == "":
+		tok.Comment = "; " + s
+	default:
+		tok.Comment += " " + s
+	}
+}
+
+// restamp updates the comment in an entry's text to comment, a stamped
+// comment of its record: the old stamp is replaced up to the end of its
+// line, or else the new one is added to the end of the first line.
+func restamp(text, comment string) string {
+	i := strings.Index(comment, ManagedMarker)
+	if i < 0 {
+		return text
+	}
+	s := comment[i:]
+	if j := strings.Index(text, ManagedMarker); j >= 0 {
+		end := strings.IndexByte(text[j:], '\n')
+		if end < 0 {
+			return text[:j] + s
+		}
+		return text[:j] + s + text[j+end:]
+	}
+	end := strings.IndexByte(text, '\n')
+	if end < 0 {
+		end = len(text)
+	}
+	line := strings.TrimRight(text[:end], " \t\r")
+	if hasComment(line) {
+		line += " " + s
+	} else {
+		line += " ; " + s
+	}
+	return line + text[end:]
+}
+
+// hasComment reports whether line ends in a comment, outside any quoted
+// string.
+func hasComment(line string) bool {
+	return commentStart(line) >= 0
+}
+
+// commentStart returns the index of the comment in line, outside any
+// quoted string, or -1 if it has none.
+func commentStart(line string) int {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,74 @@
+package zonedb
+
+import (
+	"runtime"
+	"sync"
+)
+
+// SetWorkers sets how many master files Load parses and Write renders at
+// once. With n less than 1 it is the number of CPUs.
+func (r *DB) SetWorkers(n int) {
+	r.workers = n
+}
+
+// each calls f for each i below n, on up to the DB's workers at once, and
+// returns the error of the lowest i that failed.
+func (r *DB) each(n int, f func(i int) error) error {
+	workers := r.workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	if workers > n {
+		workers = n
+	}
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filesOf returns the master files holding records of key, a name or an
+// address, from the DB index idx. The slice is not changed once
+// returned, so the caller may range over it while editing records.
+func (r *DB) filesOf(idx map[string][]*MasterFile, key string) []*MasterFile {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return idx[key]
+}
+
+// index adds m to the DB index idx under key.
+func (r *DB) index(idx map[string][]*MasterFile, key string, m *MasterFile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	idx[key] = addMasterFile(idx[key], m)
+}
+
+// names returns the names in the DB index of names.
+func (r *DB) names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.domains))
+	for name := range r.domains {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,57 @@
+package zonedb
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Records dnsup does not manage are still carried through: those of a
+// class other than IN are kept with the records of their zone, for
+// Records, the journal and the record store, though not indexed by name
+// so that no change touches them; and entries of a record type package
+// dns does not know, unless written in the generic TYPEnnn form of RFC
+// 3597, are written back as they are.
+
+// keep adds tok, a record of a class other than IN, to the records of y
+// without indexing it.
+func (y *Authority) keep(tok *dns.Token) {
+	y.records = append(y.records, tok)
+}
+
+// unknownType returns the owner name, if the entry text gives one, and the
+// record type of an entry whose type is unknown to package dns, or false
+// if its type is known or cannot be found.
+func unknownType(text string) (owner, rrtype string, ok bool) {
+	words := fields(text)
+	if len(words) == 0 {
+		return "", "", false
+	}
+	if text[0] != ' ' && text[0] != '\t' {
+		owner, words = words[0], words[1:]
+	}
+	for i := 0; i < len(words) && i < 3; i++ {
+		w := strings.ToUpper(words[i])
+		if _, ok := dns.StringToClass[w]; ok || strings.HasPrefix(w, "CLASS") || isTTL(w) {
+			continue
+		}
+		if _, ok := dns.StringToType[w]; ok || strings.HasPrefix(w, "TYPE") {
+			return "", "", false
+		}
+		return owner, words[i], true
+	}
+	return "", "", false
+}
+
+// isTTL reports whether w is a TTL, in seconds or with units as in 1h30m.
+func isTTL(w string) bool {
+	if w == "" || w[0] < '0' || w[0] > '9' {
+		return false
+	}
+	for _, c := range strings.ToLower(w) {
+		if (c < '0' || c > '9') && !strings.ContainsRune("smhdw", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package zonedb
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// EnablePTRSync makes changes to A and AAAA records keep the PTR records
+// of the loaded reverse zones, under in-addr.arpa. and ip6.arpa., in step:
+// the PTR of an address a name gains is created or repointed at the name,
+// and the PTR of an address it loses is removed.
+func (r *DB) EnablePTRSync(on bool) {
+	r.ptrSync = on
+}
+
+// syncPTR moves the PTR record for name from address old to address ip,
+// either of which may be empty. With ownership enabled, PTR records not
+// marked as managed are left alone.
+func (r *DB) syncPTR(name, old, ip string, ttl uint32) {
+	if !r.ptrSync || old == ip {
+		return
+	}
+	if auth, rev := r.reverseZone(old); auth != nil && r.checkManaged(rev, dns.TypePTR, nil) == nil {
+		target := rdata(&dns.PTR{Hdr: dns.RR_Header{Name: rev, Rrtype: dns.TypePTR, Class: dns.ClassINET}, Ptr: name})
+		auth.deleteRecords(rev, dns.TypePTR, target)
+	}
+	auth, rev := r.reverseZone(ip)
+	if auth == nil || r.checkManaged(rev, dns.TypePTR, nil) != nil {
+		return
+	}
+	ptr := &dns.PTR{Hdr: dns.RR_Header{Name: rev, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl}, Ptr: name}
+	for _, tok := range auth.names[nameKey(rev)] {
+		if tok.RR.Header().Rrtype == dns.TypePTR {
+			auth.updateRecord(rev, "", ptr)
+			return
+		}
+	}
+	auth.addRecord(ptr)
+}
+
+// reverseZone returns the loaded reverse zone holding the PTR for ip and
+// the PTR's owner name, or nil if there is none.
+func (r *DB) reverseZone(ip string) (*Authority, string) {
+	if ip == "" {
+		return nil, ""
+	}
+	rev, err := dns.ReverseAddr(ip)
+	if err != nil {
+		return nil, ""
+	}
+	auth := r.Zone(rev)
+	if auth == nil || !strings.HasSuffix(strings.ToLower(auth.domain), ".arpa.") {
+		return nil, ""
+	}
+	return auth, rev
+}
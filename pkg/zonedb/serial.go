@@ -0,0 +1,83 @@
+package zonedb
+
+import (
+	"fmt"
+	"time"
+)
+
+// SerialPolicy determines how a zone's SOA serial advances when the zone
+// is modified.
+type SerialPolicy int
+
+const (
+	// SerialIncrement adds one to the current serial.
+	SerialIncrement SerialPolicy = iota
+	// SerialDate encodes the date as YYYYMMDDnn, where nn counts changes
+	// made that day.
+	SerialDate
+	// SerialUnix uses the current Unix time in seconds.
+	SerialUnix
+)
+
+var serialPolicyNames = map[string]SerialPolicy{
+	"increment": SerialIncrement,
+	"date":      SerialDate,
+	"unix":      SerialUnix,
+}
+
+// ParseSerialPolicy returns the policy named "increment", "date" or "unix".
+func ParseSerialPolicy(name string) (SerialPolicy, error) {
+	if p, ok := serialPolicyNames[name]; ok {
+		return p, nil
+	}
+	return 0, fmt.Errorf("unknown serial policy %q", name)
+}
+
+// SetLiveSerial sets a function returning the serial the name servers of
+// zone are serving, if it can be found. When a zone is modified and that
+// serial is ahead of its master file's, in the sense of RFC 1982, as when
+// the zone was changed by nsupdate, the new serial advances from it, so
+// that secondaries do not ignore the zone for a serial gone backwards.
+func (r *DB) SetLiveSerial(f func(zone string) (uint32, bool)) {
+	r.live = f
+}
+
+// nextSerial returns the serial of zone to use after serial, advanced by
+// the zone's policy from serial or from the live serial if that is ahead.
+func (r *DB) nextSerial(zone string, serial uint32) uint32 {
+	if r.live != nil {
+		if s, ok := r.live(zone); ok && int32(s-serial) > 0 {
+			serial = s
+		}
+	}
+	return r.serialPolicy(zone).Next(serial, time.Now())
+}
+
+func (p SerialPolicy) String() string {
+	for name, v := range serialPolicyNames {
+		if v == p {
+			return name
+		}
+	}
+	return fmt.Sprintf("SerialPolicy(%d)", int(p))
+}
+
+// Next returns the serial to use after serial for a change made at now.
+// The result never moves backwards: if serial is already ahead of what
+// the policy would produce for now, it is simply incremented. Once the
+// 100 changes of a day are used up a date serial rolls over to the
+// following day's numbering.
+func (p SerialPolicy) Next(serial uint32, now time.Time) uint32 {
+	var candidate uint32
+	switch p {
+	case SerialDate:
+		y, m, d := now.UTC().Date()
+		candidate = uint32(y*1000000 + int(m)*10000 + d*100)
+	case SerialUnix:
+		candidate = uint32(now.Unix())
+	}
+	if candidate > serial {
+		return candidate
+	}
+	return serial + 1
+}
@@ -0,0 +1,91 @@
+package zonedb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSerialPolicyNext(t *testing.T) {
+	day := time.Date(2024, 3, 9, 15, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		policy SerialPolicy
+		serial uint32
+		now    time.Time
+		want   uint32
+	}{
+		{"increment", SerialIncrement, 41, day, 42},
+		{"increment wraps", SerialIncrement, 0xFFFFFFFF, day, 0},
+		{"date from older day", SerialDate, 2024030805, day, 2024030900},
+		{"date same day", SerialDate, 2024030900, day, 2024030901},
+		{"date ahead of today", SerialDate, 2024031000, day, 2024031001},
+		{"date day used up", SerialDate, 2024030999, day, 2024031000},
+		{"date from increment", SerialDate, 7, day, 2024030900},
+		{"date in another zone", SerialDate, 2024030900, day.In(time.FixedZone("", 12*3600)), 2024030901},
+		{"unix", SerialUnix, 1, day, uint32(day.Unix())},
+		{"unix ahead", SerialUnix, uint32(day.Unix()) + 5, day, uint32(day.Unix()) + 6},
+	} {
+		if got := tc.policy.Next(tc.serial, tc.now); got != tc.want {
+			t.Errorf("%s: Next(%d) = %d, want %d", tc.name, tc.serial, got, tc.want)
+		}
+	}
+}
+
+func TestParseSerialPolicy(t *testing.T) {
+	for _, name := range []string{"increment", "date", "unix"} {
+		p, err := ParseSerialPolicy(name)
+		if err != nil {
+			t.Errorf("ParseSerialPolicy(%q): %v", name, err)
+			continue
+		}
+		if p.String() != name {
+			t.Errorf("ParseSerialPolicy(%q).String() = %q", name, p.String())
+		}
+	}
+	if _, err := ParseSerialPolicy("daily"); err == nil {
+		t.Error("ParseSerialPolicy(\"daily\") succeeded")
+	}
+}
+
+func TestNextSerialLive(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		serial uint32
+		live   uint32
+		found  bool
+		want   uint32
+	}{
+		{"no live serial", 10, 0, false, 11},
+		{"live ahead", 10, 20, true, 21},
+		{"live behind", 20, 10, true, 21},
+		{"live equal", 20, 20, true, 21},
+		{"live ahead across wrap", 0xFFFFFFF0, 5, true, 6},
+		{"live behind across wrap", 5, 0xFFFFFFF0, true, 6},
+		{"master file wraps", 0xFFFFFFFF, 0xFFFFFFFE, true, 0},
+	} {
+		r := New()
+		var asked string
+		r.SetLiveSerial(func(zone string) (uint32, bool) {
+			asked = zone
+			return tc.live, tc.found
+		})
+		if got := r.nextSerial("example.com.", tc.serial); got != tc.want {
+			t.Errorf("%s: nextSerial(%d) with live %d = %d, want %d", tc.name, tc.serial, tc.live, got, tc.want)
+		}
+		if asked != "example.com." {
+			t.Errorf("%s: live serial asked for %q", tc.name, asked)
+		}
+	}
+}
+
+func TestZoneSerialPolicy(t *testing.T) {
+	r := New()
+	r.SetSerialPolicy("", SerialUnix)
+	r.SetSerialPolicy("Example.COM.", SerialDate)
+	if p := r.serialPolicy("example.com."); p != SerialDate {
+		t.Errorf("policy of example.com. = %v, want date", p)
+	}
+	if p := r.serialPolicy("example.net."); p != SerialUnix {
+		t.Errorf("policy of example.net. = %v, want unix", p)
+	}
+}
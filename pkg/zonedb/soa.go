@@ -0,0 +1,123 @@
+package zonedb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// SOAFields are the fields of a SOA record other than the serial, which
+// SetSOA sets. Empty and zero fields, and a nil Minimum, are left as they
+// are.
+type SOAFields struct {
+	Mname   string // the primary name server
+	Rname   string // the mailbox of the administrator, as a name or an address
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum *uint32 // the negative caching TTL, which may be zero
+}
+
+// ParseTTL parses a TTL or SOA timer in seconds or in BIND's units, such
+// as 1h30m.
+func ParseTTL(s string) (uint32, error) {
+	ttl, ok := ttlSeconds(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return ttl, nil
+}
+
+// SetSOA sets the fields of f in the SOA record of zone, which must be
+// loaded, leaving the serial to be advanced when the zone is written. The
+// timers must keep retry below refresh and refresh below expire, and be
+// non-zero but for the minimum.
+func (r *DB) SetSOA(zone string, f SOAFields) error {
+	zone = dns.Fqdn(strings.ToLower(zone))
+	auth := r.Zone(zone)
+	if auth == nil || !strings.EqualFold(auth.domain, zone) || auth.SOA() == nil {
+		return fmt.Errorf("zone %s is not loaded", zone)
+	}
+	soa := *auth.SOA()
+	if f.Mname != "" {
+		soa.Ns = absolute(f.Mname, auth.domain)
+	}
+	if f.Rname != "" {
+		soa.Mbox = mailbox(f.Rname, auth.domain)
+	}
+	for _, set := range []struct {
+		field *uint32
+		value uint32
+	}{{&soa.Refresh, f.Refresh}, {&soa.Retry, f.Retry}, {&soa.Expire, f.Expire}} {
+		if set.value != 0 {
+			*set.field = set.value
+		}
+	}
+	if f.Minimum != nil {
+		soa.Minttl = *f.Minimum
+	}
+	if err := CheckSOATimers(soa.Refresh, soa.Retry, soa.Expire); err != nil {
+		return fmt.Errorf("zone %s: %v", zone, err)
+	}
+	if _, ok := dns.IsDomainName(soa.Ns); !ok {
+		return fmt.Errorf("zone %s: invalid primary name server %q", zone, soa.Ns)
+	}
+	if _, ok := dns.IsDomainName(soa.Mbox); !ok {
+		return fmt.Errorf("zone %s: invalid mailbox %q", zone, soa.Mbox)
+	}
+	cur := auth.SOA()
+	if soa.Ns == cur.Ns && soa.Mbox == cur.Mbox && soa.Refresh == cur.Refresh && soa.Retry == cur.Retry &&
+		soa.Expire == cur.Expire && soa.Minttl == cur.Minttl {
+		return nil
+	}
+	*cur = soa
+	auth.dirty = true
+	return nil
+}
+
+// SetSOAFields sets the fields of f, as SetSOA does, in the SOA record of
+// zone whenever Write or Diff writes the zone modified, replacing those
+// set for it before.
+func (r *DB) SetSOAFields(zone string, f SOAFields) {
+	r.soaFields[dns.Fqdn(strings.ToLower(zone))] = f
+}
+
+// applySOAFields sets the fields given to SetSOAFields in the SOA records
+// of the modified zones of m.
+func (m *MasterFile) applySOAFields() error {
+	for _, auth := range m.records {
+		f, ok := m.parent.soaFields[strings.ToLower(auth.domain)]
+		if !ok || !auth.dirty || auth.bumped {
+			continue
+		}
+		if err := m.parent.SetSOA(auth.domain, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckSOATimers reports an error unless 0 < retry < refresh < expire.
+func CheckSOATimers(refresh, retry, expire uint32) error {
+	switch {
+	case refresh == 0 || retry == 0 || expire == 0:
+		return fmt.Errorf("SOA refresh, retry and expire must be non-zero")
+	case retry >= refresh:
+		return fmt.Errorf("SOA retry %d is not below refresh %d", retry, refresh)
+	case refresh >= expire:
+		return fmt.Errorf("SOA refresh %d is not below expire %d", refresh, expire)
+	}
+	return nil
+}
+
+// mailbox returns the SOA RNAME of rname, a domain name relative to
+// origin or an address such as hostmaster@example.com, whose local part
+// becomes the first label with its dots escaped.
+func mailbox(rname, origin string) string {
+	i := strings.LastIndex(rname, "@")
+	if i < 0 {
+		return absolute(rname, origin)
+	}
+	return strings.Replace(rname[:i], ".", `\.`, -1) + "." + dns.Fqdn(rname[i+1:])
+}
@@ -0,0 +1,171 @@
+package zonedb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseTTL(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want uint32
+		ok   bool
+	}{
+		{"3600", 3600, true},
+		{"0", 0, true},
+		{"1h30m", 5400, true},
+		{"2w", 1209600, true},
+		{"1D", 86400, true},
+		{"", 0, false},
+		{"1x", 0, false},
+		{"-5", 0, false},
+	} {
+		got, err := ParseTTL(tc.in)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("ParseTTL(%q) = %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestCheckSOATimers(t *testing.T) {
+	for _, tc := range []struct {
+		refresh, retry, expire uint32
+		ok                     bool
+	}{
+		{7200, 3600, 1209600, true},
+		{0, 3600, 1209600, false},
+		{7200, 0, 1209600, false},
+		{7200, 3600, 0, false},
+		{3600, 3600, 1209600, false},
+		{7200, 3600, 7200, false},
+		{7200, 9000, 8000, false},
+	} {
+		if err := CheckSOATimers(tc.refresh, tc.retry, tc.expire); (err == nil) != tc.ok {
+			t.Errorf("CheckSOATimers(%d, %d, %d) = %v", tc.refresh, tc.retry, tc.expire, err)
+		}
+	}
+}
+
+func TestMailbox(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"hostmaster", "hostmaster.example.com."},
+		{"hostmaster.example.net.", "hostmaster.example.net."},
+		{"dns@example.net", "dns.example.net."},
+		{"dns.admin@example.net", `dns\.admin.example.net.`},
+	} {
+		if got := mailbox(tc.in, "example.com."); got != tc.want {
+			t.Errorf("mailbox(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSetSOA(t *testing.T) {
+	zero, same, day := uint32(0), uint32(300), uint32(86400)
+	for _, tc := range []struct {
+		name   string
+		fields SOAFields
+		want   string // the SOA's fields after the serial, or "" for an error
+		dirty  bool
+	}{
+		{"nothing", SOAFields{}, "7200 3600 1209600 300", false},
+		{"same values", SOAFields{Refresh: 7200, Minimum: &same}, "7200 3600 1209600 300", false},
+		{"timers", SOAFields{Refresh: 14400, Expire: 2419200}, "14400 3600 2419200 300", true},
+		{"zero minimum", SOAFields{Minimum: &zero}, "7200 3600 1209600 0", true},
+		{"minimum", SOAFields{Minimum: &day}, "7200 3600 1209600 86400", true},
+		{"retry not below refresh", SOAFields{Retry: 7200}, "", false},
+		{"refresh not below expire", SOAFields{Refresh: 1209600}, "", false},
+	} {
+		dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone})
+		r := load(t, filepath.Join(dir, "example.com.zone"))
+		err := r.SetSOA("example.com", tc.fields)
+		done()
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s: SetSOA succeeded", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: SetSOA: %v", tc.name, err)
+			continue
+		}
+		soa := r.Zone("example.com.").SOA()
+		if got := soaTimers(soa); got != tc.want {
+			t.Errorf("%s: SOA timers %s, want %s", tc.name, got, tc.want)
+		}
+		if soa.Serial != 2024010101 {
+			t.Errorf("%s: SetSOA changed the serial to %d", tc.name, soa.Serial)
+		}
+		if r.Dirty() != tc.dirty {
+			t.Errorf("%s: dirty %v, want %v", tc.name, r.Dirty(), tc.dirty)
+		}
+	}
+
+	dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone})
+	defer done()
+	r := load(t, filepath.Join(dir, "example.com.zone"))
+	if err := r.SetSOA("EXAMPLE.com.", SOAFields{Mname: "ns2", Rname: "dns.admin@example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if soa := r.Zone("example.com.").SOA(); soa.Ns != "ns2.example.com." || soa.Mbox != `dns\.admin.example.org.` {
+		t.Errorf("SOA names %s %s", soa.Ns, soa.Mbox)
+	}
+	if err := r.SetSOA("example.org.", SOAFields{Refresh: 7200}); err == nil {
+		t.Error("SetSOA of a zone not loaded succeeded")
+	}
+}
+
+func TestSetSOAFields(t *testing.T) {
+	zero := uint32(0)
+	fields := SOAFields{Refresh: 14400, Minimum: &zero}
+	for _, tc := range []struct {
+		name     string
+		zone     string
+		modify   bool
+		want     string
+		serial   uint32
+		diffOnly bool
+	}{
+		{"modified zone", "example.com.", true, "14400 3600 1209600 0", 2024010102, false},
+		{"zone in another case", "Example.COM", true, "14400 3600 1209600 0", 2024010102, false},
+		{"unmodified zone", "example.com.", false, "7200 3600 1209600 300", 2024010101, false},
+		{"other zone", "example.net.", true, "7200 3600 1209600 300", 2024010102, false},
+		{"diff", "example.com.", true, "14400 3600 1209600 0", 2024010102, true},
+	} {
+		dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone})
+		file := filepath.Join(dir, "example.com.zone")
+		r := load(t, file)
+		r.SetSOAFields(tc.zone, fields)
+		if tc.modify {
+			if err := r.UpdateRecord("www.example.com.", dns.TypeA, "192.0.2.11"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var err error
+		if tc.diffOnly {
+			err = r.Diff(ioutil.Discard)
+		} else {
+			err = r.Write()
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !tc.diffOnly {
+			r = load(t, file)
+		}
+		done()
+		soa := r.Zone("example.com.").SOA()
+		if got := soaTimers(soa); got != tc.want || soa.Serial != tc.serial {
+			t.Errorf("%s: SOA serial %d and timers %s, want %d and %s", tc.name, soa.Serial, got, tc.serial, tc.want)
+		}
+	}
+}
+
+// soaTimers returns the refresh, retry, expire and minimum of soa.
+func soaTimers(soa *dns.SOA) string {
+	return fmt.Sprintf("%d %d %d %d", soa.Refresh, soa.Retry, soa.Expire, soa.Minttl)
+}
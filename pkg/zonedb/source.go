@@ -0,0 +1,665 @@
+package zonedb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// source is the text of a master file, or of a file it includes, split
+// into entries so that it can be written back with only the modified
+// records changed.
+type source struct {
+	file     string
+	entries  []*entry
+	includes []*source
+	// sum is the SHA-256 of the file as last read or written, to detect
+	// changes made behind the DB's back; nil for imported files.
+	sum []byte
+}
+
+// entry is one logical line of a master file: a directive, a comment or
+// blank line, or a resource record which may span several lines inside
+// parentheses.
+type entry struct {
+	text    string
+	line    int
+	origin  string
+	tok     *dns.Token
+	orig    string
+	comment string
+	changed bool
+	deleted bool
+}
+
+// readState is the parser context carried from one entry to the next.
+type readState struct {
+	origin string
+	ttl    string
+	ttlSet bool
+	owner  string
+	auth   *Authority
+	files  map[string][]byte // the text of files to read instead of their own, if any
+}
+
+func (m *MasterFile) read(src *source, r io.Reader, st *readState) error {
+	entries, err := splitEntries(r)
+	if err != nil {
+		return &ParseError{File: src.file, Err: err}
+	}
+	for _, e := range entries {
+		e.origin = st.origin
+		src.entries = append(src.entries, e)
+
+		words := fields(e.text)
+		switch {
+		case len(words) == 0:
+			continue
+		case strings.HasPrefix(e.text, "$"):
+			switch err := m.directive(src, words, st).(type) {
+			case nil:
+			case *ParseError:
+				return err // in an included file
+			case skipped:
+				if err := m.parent.warn(&ParseError{File: src.file, Line: e.line, Err: err.error}); err != nil {
+					return err
+				}
+			default:
+				return &ParseError{File: src.file, Line: e.line, Err: err}
+			}
+			continue
+		}
+
+		tok, err := parseEntry(e.text, st)
+		if err != nil {
+			owner, rrtype, ok := unknownType(e.text)
+			if !ok {
+				return entryError(src.file, e, err)
+			}
+			err := fmt.Errorf("record of unknown type %s kept unchanged", rrtype)
+			if err := m.parent.warn(&ParseError{File: src.file, Line: e.line, Err: err}); err != nil {
+				return err
+			}
+			if owner != "" {
+				st.owner = absolute(owner, st.origin)
+			}
+			continue
+		}
+		e.tok, e.orig, e.comment = tok, tok.RR.String(), tok.Comment
+		st.owner = tok.RR.Header().Name
+		if !st.ttlSet {
+			st.ttl = strconv.FormatUint(uint64(tok.RR.Header().Ttl), 10)
+		}
+		if (st.auth == nil || st.auth.fragment) && st.origin != "" && m.parent.originOf(m.file) != "" {
+			st.auth = m.fragmentOf(st.origin)
+		}
+		if st.auth, err = m.accept(st.auth, tok); err != nil {
+			return &ParseError{File: src.file, Line: e.line, Err: err}
+		}
+	}
+	return nil
+}
+
+func (m *MasterFile) directive(src *source, words []string, st *readState) error {
+	switch strings.ToUpper(words[0]) {
+	case "$ORIGIN":
+		if len(words) < 2 {
+			return fmt.Errorf("$ORIGIN without a name")
+		}
+		st.origin = absolute(words[1], st.origin)
+	case "$TTL":
+		if len(words) < 2 {
+			return fmt.Errorf("$TTL without a value")
+		}
+		st.ttl, st.ttlSet = words[1], true
+	case "$INCLUDE":
+		if len(words) < 2 {
+			return fmt.Errorf("$INCLUDE without a file name")
+		}
+		name := filepath.FromSlash(words[1])
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(src.file), name)
+		}
+		inc := &source{file: name}
+		src.includes = append(src.includes, inc)
+		sub := *st
+		if len(words) > 2 {
+			sub.origin = absolute(words[2], st.origin)
+		}
+		data, ok := st.files[name]
+		if !ok {
+			var err error
+			if data, err = inc.readFile(); err != nil {
+				return err
+			}
+		}
+		if err := m.read(inc, bytes.NewReader(data), &sub); err != nil {
+			return err
+		}
+		st.auth = sub.auth
+	case "$GENERATE":
+		// written back unchanged, but the records it produces are not
+		// loaded
+		return skipped{fmt.Errorf("$GENERATE records not loaded")}
+	default:
+		return skipped{fmt.Errorf("unknown directive %s ignored", words[0])}
+	}
+	return nil
+}
+
+// parseEntry parses a single record entry in the context of the
+// preceding directives and owner name. An error with a position in the
+// entry is a *ParseError with the line relative to the entry.
+func parseEntry(text string, st *readState) (*dns.Token, error) {
+	var buf bytes.Buffer
+	lines, owner := 0, 0
+	if st.ttl != "" {
+		fmt.Fprintf(&buf, "$TTL %s\n", st.ttl)
+		lines++
+	}
+	if text[0] == ' ' || text[0] == '\t' {
+		if st.owner == "" {
+			return nil, fmt.Errorf("record without an owner name")
+		}
+		buf.WriteString(st.owner)
+		owner = len(st.owner)
+	}
+	buf.WriteString(text)
+
+	var tok *dns.Token
+	for t := range dns.ParseZone(&buf, st.origin, "") {
+		if t.Error != nil {
+			return nil, tokenError(t.Error, lines, owner)
+		}
+		if tok == nil {
+			tok = t
+		}
+	}
+	if tok == nil {
+		return nil, fmt.Errorf("no record found")
+	}
+	return tok, nil
+}
+
+// splitEntries splits master file text into logical entries, joining
+// lines within parentheses.
+func splitEntries(r io.Reader) ([]*entry, error) {
+	var entries []*entry
+	s := newEntryScanner(r)
+	for {
+		e, _, err := s.next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+// entryScanner reads the logical entries of master file text one at a
+// time, keeping track of the offset of each, so that a file need not be
+// held in memory.
+type entryScanner struct {
+	r      *bufio.Reader
+	line   int
+	offset int64
+}
+
+func newEntryScanner(r io.Reader) *entryScanner {
+	return &entryScanner{r: bufio.NewReader(r), line: 1}
+}
+
+// next returns the next entry and its offset, or io.EOF after the last.
+func (s *entryScanner) next() (*entry, int64, error) {
+	var cur *entry
+	start, depth := s.offset, 0
+	for {
+		line, err := s.r.ReadString('\n')
+		if line == "" && err != nil {
+			if err != io.EOF {
+				return nil, 0, err
+			}
+			if cur != nil {
+				return nil, 0, fmt.Errorf("line %d: unbalanced parentheses", cur.line)
+			}
+			return nil, 0, io.EOF
+		}
+		if cur == nil {
+			cur = &entry{line: s.line}
+		}
+		s.line++
+		s.offset += int64(len(line))
+		cur.text += line
+		depth += parenDepth(line)
+		if depth <= 0 {
+			return cur, start, nil
+		}
+	}
+}
+
+func parenDepth(line string) int {
+	depth := 0
+	for _, w := range scan(line) {
+		switch line[w[0]:w[1]] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+	}
+	return depth
+}
+
+// scan returns the offsets of the words, quoted strings and parentheses
+// in text, skipping comments.
+func scan(text string) [][2]int {
+	var spans [][2]int
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == ';':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case c == '(' || c == ')':
+			spans = append(spans, [2]int{i, i + 1})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(text) && text[j] != '"' {
+				if text[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(text) {
+				j++
+			}
+			spans = append(spans, [2]int{i, j})
+			i = j
+		default:
+			j := i
+			for j < len(text) && !strings.ContainsRune(" \t\r\n;()\"", rune(text[j])) {
+				if text[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j > len(text) {
+				j = len(text)
+			}
+			spans = append(spans, [2]int{i, j})
+			i = j
+		}
+	}
+	return spans
+}
+
+// words returns the offsets of the words in text, excluding parentheses.
+func words(text string) [][2]int {
+	var ws [][2]int
+	for _, w := range scan(text) {
+		if s := text[w[0]:w[1]]; s != "(" && s != ")" {
+			ws = append(ws, w)
+		}
+	}
+	return ws
+}
+
+func fields(text string) []string {
+	var fs []string
+	for _, w := range words(text) {
+		fs = append(fs, text[w[0]:w[1]])
+	}
+	return fs
+}
+
+// render returns the entry's text, updated for any change to its record.
+func (e *entry) render() string {
+	if e.deleted {
+		return ""
+	}
+	if e.tok == nil {
+		return e.text
+	}
+	cur := e.tok.RR.String()
+	text, ok := e.text, cur == e.orig
+	if !ok {
+		text, ok = patchRdata(e.text, e.orig, cur)
+	}
+	if ok {
+		if e.tok.Comment != e.comment {
+			text = restamp(text, e.tok.Comment)
+		}
+		return text
+	}
+	return e.full()
+}
+
+// full returns the entry's record on a line of its own, with its owner
+// relative to the entry's origin, TTL and class, and its comment.
+func (e *entry) full() string {
+	cur, name := e.tok.RR.String(), e.tok.RR.Header().Name
+	if strings.HasPrefix(cur, name) {
+		cur = relative(name, e.origin) + cur[len(name):]
+	}
+	if e.tok.Comment != "" {
+		cur += " " + e.tok.Comment
+	}
+	return cur + "\n"
+}
+
+// patchRdata rewrites only the rdata words of text that differ between
+// the old and new presentation of its record, keeping the layout and
+// comments of the original. It fails if anything other than rdata has
+// changed or the old values do not appear verbatim.
+func patchRdata(text, old, cur string) (string, bool) {
+	of, cf := fields(old), fields(cur)
+	if len(of) != len(cf) || len(of) < 4 {
+		return "", false
+	}
+	for i := 0; i < 4; i++ {
+		if of[i] != cf[i] {
+			return "", false
+		}
+	}
+	ws := words(text)
+	n := len(of) - 4
+	if len(ws) < n+1 {
+		return "", false
+	}
+	ws = ws[len(ws)-n:]
+	out := text
+	for i := n - 1; i >= 0; i-- {
+		o, c := of[4+i], cf[4+i]
+		if o == c {
+			continue
+		}
+		w := ws[i]
+		if !strings.EqualFold(text[w[0]:w[1]], o) {
+			return "", false
+		}
+		out = out[:w[0]] + c + out[w[1]:]
+	}
+	return out, true
+}
+
+func absolute(name, origin string) string {
+	if name == "@" {
+		return origin
+	}
+	if dns.IsFqdn(name) || origin == "" {
+		return name
+	}
+	if origin == "." {
+		return name + "."
+	}
+	return name + "." + origin
+}
+
+func relative(name, origin string) string {
+	switch {
+	case origin == "":
+		return name
+	case strings.EqualFold(name, origin):
+		return "@"
+	case strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(origin)):
+		return name[:len(name)-len(origin)-1]
+	}
+	return name
+}
+
+// WriteZone writes rrs as a master file for origin: $ORIGIN and $TTL
+// directives followed by one record per line with owner names relative
+// to origin. The SOA, if any, is written first.
+func WriteZone(w io.Writer, origin string, rrs []dns.RR) error {
+	return writeZone(w, origin, rrs, nil)
+}
+
+// writeZone writes rrs as WriteZone does, with the comment and blank lines
+// of c, if not nil: its header first, the lines before each RRset where
+// the RRset begins, the comments of the records that remain, and its
+// trailer last.
+func writeZone(w io.Writer, origin string, rrs []dns.RR, c *comments) error {
+	if c == nil {
+		c = &comments{}
+	}
+	origin = dns.Fqdn(origin)
+	var soa dns.RR
+	var rest []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeSOA && soa == nil {
+			soa = rr
+			continue
+		}
+		rest = append(rest, rr)
+	}
+	bw := bufio.NewWriter(w)
+	for _, line := range c.header {
+		fmt.Fprintln(bw, line)
+	}
+	fmt.Fprintf(bw, "$ORIGIN %s\n", origin)
+	if soa != nil {
+		fmt.Fprintf(bw, "$TTL %d\n", soa.Header().Ttl)
+		rest = append([]dns.RR{soa}, rest...)
+	}
+	for _, rr := range rest {
+		key := rrsetKey(rr.Header())
+		for _, line := range c.lead[key] {
+			fmt.Fprintln(bw, line)
+		}
+		delete(c.lead, key)
+		text, name := rr.String(), rr.Header().Name
+		if strings.HasPrefix(text, name) {
+			text = relative(name, origin) + text[len(name):]
+		}
+		if comment := c.inline[dupKey(&dns.Token{RR: rr})]; comment != "" {
+			text += " " + comment
+		}
+		fmt.Fprintln(bw, text)
+	}
+	for _, line := range c.trailer {
+		fmt.Fprintln(bw, line)
+	}
+	return bw.Flush()
+}
+
+func (s *source) render(w io.Writer) error {
+	for _, e := range s.entries {
+		if _, err := io.WriteString(w, e.render()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modified reports whether any record in the source has changed.
+func (s *source) modified() bool {
+	for _, e := range s.entries {
+		if e.changed || e.deleted || e.tok != nil && (e.tok.RR.String() != e.orig || e.tok.Comment != e.comment) {
+			return true
+		}
+	}
+	return false
+}
+
+// insert adds an entry for tok, a new record of y, after the last entry of
+// y with the same owner name or, failing that, after the last entry of y
+// in the master file itself, or in the files it includes only if y has
+// none there. A file given by $INCLUDE, which may be shared or generated,
+// thus gains only records for the names it already holds.
+func (m *MasterFile) insert(y *Authority, tok *dns.Token) {
+	in := map[*dns.Token]bool{}
+	for _, t := range y.records {
+		in[t] = true
+	}
+	var owner, main, last struct {
+		src *source
+		i   int
+	}
+	name := tok.RR.Header().Name
+	for _, src := range m.src.all() {
+		for i, e := range src.entries {
+			if e.tok == nil || e.deleted || !in[e.tok] {
+				continue
+			}
+			last.src, last.i = src, i
+			if src == m.src {
+				main = last
+			}
+			if strings.EqualFold(e.tok.RR.Header().Name, name) {
+				owner.src, owner.i = src, i
+			}
+		}
+	}
+	at := last
+	switch {
+	case owner.src != nil:
+		at = owner
+	case main.src != nil:
+		at = main
+	}
+	if at.src == nil {
+		if y.fragment {
+			m.src.entries = append(m.src.entries, &entry{origin: y.domain, tok: tok, changed: true})
+		}
+		return
+	}
+	e := &entry{origin: at.src.entries[at.i].origin, tok: tok, changed: true}
+	entries := append([]*entry(nil), at.src.entries[:at.i+1]...)
+	entries = append(entries, e)
+	at.src.entries = append(entries, at.src.entries[at.i+1:]...)
+}
+
+// delete removes the entry of tok. A following record that inherited its
+// owner name from the deleted one is given an explicit owner.
+func (m *MasterFile) delete(tok *dns.Token) {
+	for _, src := range m.src.all() {
+		for i, e := range src.entries {
+			if e.tok != tok || e.deleted {
+				continue
+			}
+			e.deleted = true
+			for _, next := range src.entries[i+1:] {
+				if next.deleted || len(words(next.text)) == 0 {
+					continue
+				}
+				if next.tok != nil && (next.text[0] == ' ' || next.text[0] == '\t') {
+					next.text = relative(tok.RR.Header().Name, next.origin) + next.text
+					next.changed = true
+				}
+				break
+			}
+			return
+		}
+	}
+}
+
+// all returns s and every source it includes, recursively.
+func (s *source) all() []*source {
+	srcs := []*source{s}
+	for _, inc := range s.includes {
+		srcs = append(srcs, inc.all()...)
+	}
+	return srcs
+}
+
+// position returns the file and line of tok's entry, or the master file
+// and zero if tok was added since loading.
+func (m *MasterFile) position(tok *dns.Token) (string, int) {
+	for _, src := range m.src.all() {
+		for _, e := range src.entries {
+			if e.tok == tok {
+				return src.file, e.line
+			}
+		}
+	}
+	return m.file, 0
+}
+
+// readFile reads the source's file, noting its checksum.
+func (s *source) readFile() ([]byte, error) {
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	s.sum = sum[:]
+	return data, nil
+}
+
+// unchanged reports an error if the source's file differs from when it
+// was read, as when another process or an editor has rewritten it.
+func (s *source) unchanged() error {
+	if s.sum == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(data); !bytes.Equal(sum[:], s.sum) {
+		return &ChangedError{File: s.file}
+	}
+	return nil
+}
+
+// ParseError is a syntax error in the master file File, at Line unless
+// it is zero.
+type ParseError struct {
+	File   string
+	Line   int
+	Column int // or zero if not known
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %v", e.File, e.Err)
+	case e.Column == 0:
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d:%d: %v", e.File, e.Line, e.Column, e.Err)
+}
+
+// ChangedError is returned by Write for a file that was modified on disk
+// after it was loaded, such as by hand while the daemon was running; the
+// file is left as it is. Loading the zones again and repeating the change
+// applies it on top of the other.
+type ChangedError struct {
+	File string
+}
+
+func (e *ChangedError) Error() string {
+	return e.File + " has changed since it was loaded; not overwriting it"
+}
+
+func (s *source) diff(w io.Writer, render func(io.Writer) error) error {
+	return diffFile(w, s.file, render)
+}
+
+// diffFile writes a unified diff of file and the output of render.
+func diffFile(w io.Writer, file string, render func(io.Writer) error) error {
+	orig, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, unifiedDiff(file, file, orig, buf.Bytes()))
+	return err
+}
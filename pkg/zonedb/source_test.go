@@ -0,0 +1,122 @@
+package zonedb
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPatchRdata(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		text     string
+		old, cur string
+		want     string
+		ok       bool
+	}{
+		{"address", "www\t300\tIN\tA\t192.0.2.10\n",
+			"www.example.com.\t300\tIN\tA\t192.0.2.10", "www.example.com.\t300\tIN\tA\t192.0.2.11",
+			"www\t300\tIN\tA\t192.0.2.11\n", true},
+		{"spacing kept", "mail   IN  A   192.0.2.20\n",
+			"mail.example.com.\t3600\tIN\tA\t192.0.2.20", "mail.example.com.\t3600\tIN\tA\t198.51.100.7",
+			"mail   IN  A   198.51.100.7\n", true},
+		{"one of several fields", "@ IN MX 10 mail\n",
+			"example.com.\t3600\tIN\tMX\t10 mail.example.com.", "example.com.\t3600\tIN\tMX\t20 mail.example.com.",
+			"@ IN MX 20 mail\n", true},
+		{"case of old value", "www IN AAAA 2001:DB8::1\n",
+			"www.example.com.\t3600\tIN\tAAAA\t2001:db8::1", "www.example.com.\t3600\tIN\tAAAA\t2001:db8::2",
+			"www IN AAAA 2001:db8::2\n", true},
+		{"ttl changed", "www 300 IN A 192.0.2.10\n",
+			"www.example.com.\t300\tIN\tA\t192.0.2.10", "www.example.com.\t60\tIN\tA\t192.0.2.10",
+			"", false},
+		{"relative name in old value", "@ IN MX 10 mail\n",
+			"example.com.\t3600\tIN\tMX\t10 mail.example.com.", "example.com.\t3600\tIN\tMX\t10 mx.example.com.",
+			"", false},
+		{"field count changed", "@ IN TXT \"a\"\n",
+			"example.com.\t3600\tIN\tTXT\t\"a\"", "example.com.\t3600\tIN\tTXT\t\"a\" \"b\"",
+			"", false},
+	} {
+		got, ok := patchRdata(tc.text, tc.old, tc.cur)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%s: patchRdata = %q, %v, want %q, %v", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone})
+	defer done()
+	file := filepath.Join(dir, "example.com.zone")
+
+	r := load(t, file)
+	if err := r.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if text := readFile(t, file); text != exampleZone {
+		t.Errorf("unmodified zone written as:\n%s", text)
+	}
+	if serial := r.Zone("example.com.").SOA().Serial; serial != 2024010101 {
+		t.Errorf("unmodified zone has serial %d", serial)
+	}
+
+	r = load(t, file)
+	for _, up := range []struct{ name, ip string }{
+		{"ns1.example.com.", "192.0.2.2"},
+		{"mail.example.com.", "192.0.2.21"},
+	} {
+		if err := r.UpdateRecord(up.name, dns.TypeA, up.ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Write(); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.NewReplacer(
+		"2024010101 ; serial", "2024010102 ; serial",
+		"192.0.2.1 ; the name server", "192.0.2.2 ; the name server",
+		"mail   IN  A   192.0.2.20", "mail   IN  A   192.0.2.21",
+	).Replace(exampleZone)
+	if text := readFile(t, file); text != want {
+		t.Errorf("updated zone written as:\n%s\nwant\n%s", text, want)
+	}
+}
+
+func TestInsertInclude(t *testing.T) {
+	main := exampleZone + "$INCLUDE hosts.inc\n"
+	const hosts = "db\tIN\tA\t192.0.2.30\n"
+	dir, done := tempDir(t, map[string]string{"example.com.zone": main, "hosts.inc": hosts})
+	defer done()
+	file, inc := filepath.Join(dir, "example.com.zone"), filepath.Join(dir, "hosts.inc")
+
+	r := load(t, file)
+	for _, s := range []string{
+		"db.example.com. 300 IN A 192.0.2.31",
+		"new.example.com. 300 IN A 192.0.2.40",
+		"www.example.com. 300 IN AAAA 2001:db8::10",
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.AddRecord(rr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	if text, want := readFile(t, inc), hosts+"db\t300\tIN\tA\t192.0.2.31\n"; text != want {
+		t.Errorf("included file written as:\n%s\nwant\n%s", text, want)
+	}
+	want := strings.NewReplacer(
+		"2024010101", "2024010102",
+		"192.0.2.10\n", "192.0.2.10\nwww\t300\tIN\tAAAA\t2001:db8::10\n",
+		"192.0.2.20\n", "192.0.2.20\nnew\t300\tIN\tA\t192.0.2.40\n",
+	).Replace(main)
+	if text := readFile(t, file); text != want {
+		t.Errorf("main master file written as:\n%s\nwant\n%s", text, want)
+	}
+}
@@ -0,0 +1,52 @@
+package zonedb
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// Store keeps a copy of the DB's zones outside the process, such as the
+// SQLite database of package sqlstore, for durable state that other tools
+// can query. The master files remain the source of truth. A Store must be
+// safe for concurrent use, as Load mirrors files in parallel.
+type Store interface {
+	// Serial returns the serial of zone as last stored, and false if it
+	// has not been stored.
+	Serial(zone string) (uint32, bool, error)
+	// ReplaceZone makes rrs, SOA first, the records of zone.
+	ReplaceZone(zone string, rrs []dns.RR) error
+}
+
+// SetStore mirrors the zones into s: on Load those whose serial differs
+// from the stored one, and on Write those modified.
+func (r *DB) SetStore(s Store) {
+	r.store = s
+}
+
+// storeZones copies the authorities of m into the store, all of them or
+// only the modified ones.
+func (m *MasterFile) storeZones(modified bool) error {
+	s := m.parent.store
+	if s == nil {
+		return nil
+	}
+	for _, auth := range m.records {
+		if modified && !auth.dirty {
+			continue
+		}
+		if !modified {
+			serial, ok, err := s.Serial(auth.domain)
+			if err != nil {
+				return fmt.Errorf("record store: %v", err)
+			}
+			if ok && serial == auth.SOA().Serial {
+				continue
+			}
+		}
+		if err := s.ReplaceZone(auth.domain, auth.Records()); err != nil {
+			return fmt.Errorf("record store: zone %s: %v", auth.domain, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package zonedb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// A master file too large to load can be updated by StreamUpdate, which
+// finds the records to change through an index of the offset of every
+// record entry, kept beside the file, and copies the rest of the file
+// through unchanged. Memory use does not depend on the size of the file.
+//
+// The index is a text file: a header line recording the size and
+// modification time of the master file it describes, then one line per
+// record entry,
+//
+//	offset	length	line	TYPE	name	origin	ttl	owner
+//
+// where origin, ttl and owner are the $ORIGIN, default TTL and previous
+// owner name the entry is parsed with, or "-" if unset.
+
+// IndexPath returns the path of the offset index of the master file name.
+func IndexPath(name string) string {
+	return name + ".idx"
+}
+
+// StreamChange sets the data of every record of a name and type to Value,
+// in presentation format, as UpdateRecord does.
+type StreamChange struct {
+	Name  string
+	Type  uint16
+	Value string
+}
+
+// indexEntry is one record entry of an index.
+type indexEntry struct {
+	offset, length int64
+	line           int
+	rrtype         string
+	name           string
+	origin         string
+	ttl            string
+	owner          string
+}
+
+// edit is the replacement text of an entry, which had lines lines.
+type edit struct {
+	indexEntry
+	text  string
+	lines int
+}
+
+// StreamUpdate applies changes to the single zone in the master file
+// file, advancing its serial by the DB's serial policy if any record
+// changed, and returns how many records changed. It fails, changing
+// nothing, if a change names a name and type with no records. The file is
+// read and written as a stream, with its index rebuilt first if missing
+// or stale, so that a very large zone is rewritten in constant memory.
+// Files with $INCLUDE directives cannot be streamed, and journals,
+// backups, PTR synchronization, ownership and the record store do not
+// apply.
+func (r *DB) StreamUpdate(file string, changes []StreamChange) (int, error) {
+	want := map[string]StreamChange{}
+	for _, c := range changes {
+		if c.Type == dns.TypeSOA {
+			return 0, fmt.Errorf("%s: SOA records cannot be changed by streaming", c.Name)
+		}
+		want[indexKey(c.Name, typeName(c.Type))] = c
+	}
+
+	unlock, err := lockFile(file, true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	idx := IndexPath(file)
+	if !indexCurrent(idx, fi) {
+		if err := writeIndex(file, idx); err != nil {
+			return 0, err
+		}
+	}
+	soa, spans, err := findEntries(idx, want)
+	if err != nil {
+		return 0, err
+	}
+	if soa == nil {
+		return 0, fmt.Errorf("%s: no SOA record", file)
+	}
+	matched := map[string]bool{}
+	for _, ie := range spans {
+		matched[indexKey(ie.name, ie.rrtype)] = true
+	}
+	for _, c := range changes {
+		if !matched[indexKey(c.Name, typeName(c.Type))] {
+			return 0, fmt.Errorf("%s: no %s records found for %q", file, typeName(c.Type), c.Name)
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var edits []edit
+	for _, ie := range spans {
+		e, err := ie.read(f, file)
+		if err != nil {
+			return 0, err
+		}
+		c := want[indexKey(ie.name, ie.rrtype)]
+		hdr := e.tok.RR.Header()
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d %s %s %s", hdr.Name, hdr.Ttl, dns.ClassToString[hdr.Class], ie.rrtype, c.Value))
+		if err != nil {
+			return 0, err
+		}
+		if rr == nil {
+			return 0, fmt.Errorf("empty %s value for %q", ie.rrtype, c.Name)
+		}
+		*rr.Header() = *hdr
+		if rr.String() == e.orig {
+			continue
+		}
+		e.tok.RR = rr
+		edits = append(edits, edit{ie, e.render(), strings.Count(e.text, "\n")})
+	}
+	if len(edits) == 0 {
+		return 0, nil
+	}
+
+	e, err := soa.read(f, file)
+	if err != nil {
+		return 0, err
+	}
+	rr := e.tok.RR.(*dns.SOA)
+	rr.Serial = r.nextSerial(rr.Hdr.Name, rr.Serial)
+	edits = append(edits, edit{*soa, e.render(), strings.Count(e.text, "\n")})
+	sort.Slice(edits, func(i, j int) bool { return edits[i].offset < edits[j].offset })
+
+	err = writeFile(file, func(w io.Writer) error {
+		var pos int64
+		for _, ed := range edits {
+			if _, err := io.Copy(w, io.NewSectionReader(f, pos, ed.offset-pos)); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, ed.text); err != nil {
+				return err
+			}
+			pos = ed.offset + ed.length
+		}
+		_, err := io.Copy(w, io.NewSectionReader(f, pos, fi.Size()-pos))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := shiftIndex(file, idx, edits); err != nil {
+		os.Remove(idx) // the update stands; the index is rebuilt on next use
+	}
+	return len(edits) - 1, nil
+}
+
+func writeIndex(file, idx string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeFile(idx, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		fmt.Fprintln(bw, indexHeader(fi))
+		st := &readState{}
+		s := newEntryScanner(f)
+		for {
+			e, off, err := s.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %v", file, err)
+			}
+			words := fields(e.text)
+			switch {
+			case len(words) == 0:
+				continue
+			case strings.HasPrefix(e.text, "$"):
+				switch strings.ToUpper(words[0]) {
+				case "$ORIGIN":
+					if len(words) < 2 {
+						return fmt.Errorf("%s:%d: $ORIGIN without a name", file, e.line)
+					}
+					st.origin = absolute(words[1], st.origin)
+				case "$TTL":
+					if len(words) < 2 {
+						return fmt.Errorf("%s:%d: $TTL without a value", file, e.line)
+					}
+					st.ttl, st.ttlSet = words[1], true
+				case "$INCLUDE":
+					return fmt.Errorf("%s:%d: files with $INCLUDE cannot be streamed", file, e.line)
+				}
+				continue
+			}
+			ie := indexEntry{offset: off, length: int64(len(e.text)), line: e.line, origin: st.origin, ttl: st.ttl, owner: st.owner}
+			tok, err := parseEntry(e.text, st)
+			if err != nil {
+				owner, _, ok := unknownType(e.text)
+				if !ok {
+					return entryError(file, e, err)
+				}
+				if owner != "" {
+					st.owner = absolute(owner, st.origin)
+				}
+				continue // copied through unchanged
+			}
+			hdr := tok.RR.Header()
+			ie.rrtype, ie.name = typeName(hdr.Rrtype), hdr.Name
+			fmt.Fprintln(bw, ie.String())
+			st.owner = hdr.Name
+			if !st.ttlSet {
+				st.ttl = strconv.FormatUint(uint64(hdr.Ttl), 10)
+			}
+		}
+		return bw.Flush()
+	})
+}
+
+func indexHeader(fi os.FileInfo) string {
+	return fmt.Sprintf("; dnsup index %d %d", fi.Size(), fi.ModTime().UnixNano())
+}
+
+// indexCurrent reports whether the index idx describes the master file
+// as it is now.
+func indexCurrent(idx string, fi os.FileInfo) bool {
+	f, err := os.Open(idx)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	return strings.TrimSpace(line) == indexHeader(fi)
+}
+
+func typeName(rrtype uint16) string {
+	if s, ok := dns.TypeToString[rrtype]; ok {
+		return s
+	}
+	return fmt.Sprintf("TYPE%d", rrtype)
+}
+
+func indexKey(name, rrtype string) string {
+	return strings.ToLower(dns.Fqdn(name)) + " " + rrtype
+}
+
+// findEntries scans the index idx for the first SOA record and the
+// entries of the names and types in want.
+func findEntries(idx string, want map[string]StreamChange) (*indexEntry, []indexEntry, error) {
+	f, err := os.Open(idx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var soa *indexEntry
+	var found []indexEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), ";") {
+			continue
+		}
+		ie, err := parseIndexEntry(s.Text())
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", idx, err)
+		}
+		if soa == nil && ie.rrtype == "SOA" {
+			soa = &ie
+		}
+		if _, ok := want[indexKey(ie.name, ie.rrtype)]; ok {
+			found = append(found, ie)
+		}
+	}
+	return soa, found, s.Err()
+}
+
+// shiftIndex rewrites the index idx for the master file after edits,
+// moving the entries after each edit by the change in its length.
+func shiftIndex(file, idx string, edits []edit) error {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(idx)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(idx, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		fmt.Fprintln(bw, indexHeader(fi))
+		s := bufio.NewScanner(in)
+		s.Buffer(nil, 1<<20)
+		for s.Scan() {
+			if strings.HasPrefix(s.Text(), ";") {
+				continue
+			}
+			ie, err := parseIndexEntry(s.Text())
+			if err != nil {
+				return fmt.Errorf("%s: %v", idx, err)
+			}
+			var shift int64
+			var lines int
+			for _, ed := range edits {
+				switch {
+				case ed.offset < ie.offset:
+					shift += int64(len(ed.text)) - ed.length
+					lines += strings.Count(ed.text, "\n") - ed.lines
+				case ed.offset == ie.offset:
+					ie.length = int64(len(ed.text))
+				}
+			}
+			ie.offset += shift
+			ie.line += lines
+			fmt.Fprintln(bw, ie.String())
+		}
+		if err := s.Err(); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+}
+
+func (ie indexEntry) String() string {
+	return strings.Join([]string{
+		strconv.FormatInt(ie.offset, 10), strconv.FormatInt(ie.length, 10), strconv.Itoa(ie.line),
+		ie.rrtype, ie.name, orDash(ie.origin), orDash(ie.ttl), orDash(ie.owner),
+	}, "\t")
+}
+
+func parseIndexEntry(line string) (indexEntry, error) {
+	f := strings.Split(line, "\t")
+	if len(f) != 8 {
+		return indexEntry{}, fmt.Errorf("malformed index line %q", line)
+	}
+	ie := indexEntry{rrtype: f[3], name: f[4], origin: unDash(f[5]), ttl: unDash(f[6]), owner: unDash(f[7])}
+	var err1, err2, err3 error
+	ie.offset, err1 = strconv.ParseInt(f[0], 10, 64)
+	ie.length, err2 = strconv.ParseInt(f[1], 10, 64)
+	ie.line, err3 = strconv.Atoi(f[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return indexEntry{}, fmt.Errorf("malformed index line %q", line)
+	}
+	return ie, nil
+}
+
+// read parses the entry ie of the master file f in the context recorded
+// for it.
+func (ie indexEntry) read(f *os.File, file string) (*entry, error) {
+	buf := make([]byte, ie.length)
+	if _, err := f.ReadAt(buf, ie.offset); err != nil {
+		return nil, fmt.Errorf("%s:%d: %v", file, ie.line, err)
+	}
+	e := &entry{text: string(buf), line: ie.line, origin: ie.origin}
+	tok, err := parseEntry(e.text, &readState{origin: ie.origin, ttl: ie.ttl, owner: ie.owner})
+	if err != nil {
+		return nil, fmt.Errorf("%v; the index may be stale", entryError(file, e, err))
+	}
+	e.tok, e.orig, e.comment = tok, tok.RR.String(), tok.Comment
+	return e, nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func unDash(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
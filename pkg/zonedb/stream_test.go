@@ -0,0 +1,107 @@
+package zonedb
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestStreamUpdate(t *testing.T) {
+	dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone})
+	defer done()
+	file := filepath.Join(dir, "example.com.zone")
+	want := exampleZone
+
+	for _, tc := range []struct {
+		name    string
+		changes []StreamChange
+		n       int
+		replace []string
+	}{
+		// The first change lengthens www, shifting the entries after it.
+		{"longer", []StreamChange{{"www.example.com.", dns.TypeA, "192.0.2.100"}}, 1,
+			[]string{"2024010101", "2024010102", "192.0.2.10\n", "192.0.2.100\n"}},
+		{"after the shift", []StreamChange{{"mail.example.com.", dns.TypeA, "192.0.2.2"}}, 1,
+			[]string{"2024010102", "2024010103", "192.0.2.20", "192.0.2.2"}},
+		{"unchanged", []StreamChange{{"mail.example.com.", dns.TypeA, "192.0.2.2"}}, 0, nil},
+		{"several, shorter", []StreamChange{
+			{"ns1.example.com.", dns.TypeA, "10.0.0.1"},
+			{"www.example.com.", dns.TypeA, "10.0.0.2"},
+		}, 2, []string{"2024010103", "2024010104", "192.0.2.1 ;", "10.0.0.1 ;", "192.0.2.100\n", "10.0.0.2\n"}},
+	} {
+		n, err := New().StreamUpdate(file, tc.changes)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if n != tc.n {
+			t.Errorf("%s: %d records changed, want %d", tc.name, n, tc.n)
+		}
+		if tc.replace != nil {
+			want = strings.NewReplacer(tc.replace...).Replace(want)
+		}
+		if got := readFile(t, file); got != want {
+			t.Fatalf("%s: master file holds:\n%s\nwant\n%s", tc.name, got, want)
+		}
+
+		fresh := filepath.Join(dir, "fresh.idx")
+		if err := writeIndex(file, fresh); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := readFile(t, IndexPath(file)), readFile(t, fresh); got != want {
+			t.Errorf("%s: shifted index:\n%s\nwant\n%s", tc.name, got, want)
+		}
+	}
+}
+
+func TestStreamUpdateErrors(t *testing.T) {
+	dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone})
+	defer done()
+	file := filepath.Join(dir, "example.com.zone")
+
+	for _, tc := range []struct {
+		name    string
+		changes []StreamChange
+		err     string
+	}{
+		{"no such name", []StreamChange{
+			{"www.example.com.", dns.TypeA, "192.0.2.11"},
+			{"nope.example.com.", dns.TypeA, "192.0.2.12"},
+		}, `no A records found for "nope.example.com."`},
+		{"no such type", []StreamChange{{"www.example.com.", dns.TypeAAAA, "2001:db8::1"}}, "no AAAA records"},
+		{"SOA", []StreamChange{{"example.com.", dns.TypeSOA, "ns1 hostmaster 1 2 3 4 5"}}, "SOA records cannot be changed"},
+		{"bad value", []StreamChange{{"www.example.com.", dns.TypeA, "not-an-address"}}, "not-an-address"},
+	} {
+		_, err := New().StreamUpdate(file, tc.changes)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: %v, want an error containing %q", tc.name, err, tc.err)
+		}
+		if got := readFile(t, file); got != exampleZone {
+			t.Errorf("%s: master file written:\n%s", tc.name, got)
+		}
+	}
+}
+
+func TestStreamUpdateStaleIndex(t *testing.T) {
+	dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone})
+	defer done()
+	file := filepath.Join(dir, "example.com.zone")
+	if err := writeIndex(file, IndexPath(file)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Edit the file behind the index's back, moving every entry.
+	edited := "; edited\n" + exampleZone
+	if err := ioutil.WriteFile(file, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New().StreamUpdate(file, []StreamChange{{"mail.example.com.", dns.TypeA, "192.0.2.21"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.NewReplacer("2024010101", "2024010102", "192.0.2.20", "192.0.2.21").Replace(edited)
+	if got := readFile(t, file); got != want {
+		t.Errorf("master file holds:\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,73 @@
+package zonedb
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// EnableStrict sets whether Load fails on what are otherwise warnings:
+// records of an unknown type, and $GENERATE or unknown directives, none
+// of which are loaded though they are written back unchanged.
+func (r *DB) EnableStrict(on bool) {
+	r.strict = on
+}
+
+// SetWarnings sets a function to receive each warning of a load that is
+// not strict, as a *ParseError.
+func (r *DB) SetWarnings(f func(error)) {
+	r.warnf = f
+}
+
+// warn reports err, the error when strict, or else passes it to the
+// warning function.
+func (r *DB) warn(err *ParseError) error {
+	if r.strict {
+		return err
+	}
+	if r.warnf != nil {
+		r.warnf(err)
+	}
+	return nil
+}
+
+// skipped is a warning from a directive that is not loaded.
+type skipped struct{ error }
+
+// dnsPos is the position at the end of an error of package dns.
+var dnsPos = regexp.MustCompile(`^(.*) at line: (\d+):(\d+)$`)
+
+// entryError returns err, from reading the entry e of file, as a
+// *ParseError at its line, and column if known, within the file.
+func entryError(file string, e *entry, err error) *ParseError {
+	if pe, ok := err.(*ParseError); ok {
+		pe.File, pe.Line = file, pe.Line+e.line-1
+		return pe
+	}
+	return &ParseError{File: file, Line: e.line, Err: err}
+}
+
+// tokenError returns the error of package dns parsing an entry as a
+// *ParseError at its line and column within the entry, given the number
+// of lines and the length of the owner name parseEntry inserted before
+// it.
+func tokenError(err error, lines, owner int) error {
+	m := dnsPos.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(m[2])
+	col, _ := strconv.Atoi(m[3])
+	if line -= lines; line < 1 {
+		return err
+	}
+	if line == 1 {
+		if col -= owner; col < 1 {
+			col = 1
+		}
+	}
+	return &ParseError{Line: line, Column: col, Err: errorString(m[1])}
+}
+
+type errorString string
+
+func (e errorString) Error() string { return string(e) }
@@ -0,0 +1,115 @@
+package zonedb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+
+	"github.com/miekg/dns"
+)
+
+// SetTemplate makes the master file file the output of t. Once
+// SetTemplateData has been called, Load renders such a file from its
+// template instead of reading it, and Write writes the rendered text,
+// edits included. The SOA serials in the template are placeholders: a
+// zone keeps the serial of the file on disk while its records are the
+// same as there, and is otherwise marked modified so that Write advances
+// the serial by the zone's policy.
+func (r *DB) SetTemplate(file string, t *template.Template) {
+	r.templates[filepath.Clean(file)] = t
+}
+
+// SetTemplateData sets the data templates are executed with. Until it is
+// set, files with templates are loaded from disk as rendered last.
+func (r *DB) SetTemplateData(data interface{}) {
+	r.tmplData = data
+}
+
+func (r *DB) templateOf(file string) *template.Template {
+	if r.tmplData == nil {
+		return nil
+	}
+	return r.templates[filepath.Clean(file)]
+}
+
+// render loads m from the template t, comparing each zone and fragment
+// with the file on disk, if any, which becomes the snapshot journaled
+// changes are made against.
+func (m *MasterFile) render(t *template.Template) error {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, m.parent.tmplData); err != nil {
+		return &ParseError{File: m.file, Err: err}
+	}
+	if err := m.read(m.src, &buf, &readState{origin: m.parent.originOf(m.file)}); err != nil {
+		return err
+	}
+	m.snapshot()
+
+	disk := New()
+	disk.origin, disk.origins = m.parent.origin, m.parent.origins
+	cur := disk.newMasterFile(m.file)
+	if _, err := os.Stat(m.file); err == nil {
+		unlock, err := lockFile(m.file, false)
+		if err != nil {
+			return err
+		}
+		data, err := cur.src.readFile()
+		unlock()
+		if err != nil {
+			return err
+		}
+		if err := cur.read(cur.src, bytes.NewReader(data), &readState{origin: m.parent.originOf(m.file)}); err != nil {
+			return err
+		}
+		cur.snapshot()
+		m.src.sum = cur.src.sum
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, auth := range m.records {
+		old := findAuthority(cur.records, auth.domain)
+		if old == nil || old.SOA() == nil {
+			auth.dirty = true
+			continue
+		}
+		auth.SOA().Serial = old.SOA().Serial
+		auth.base, auth.baseSOA = old.base, old.baseSOA
+		auth.dirty = !sameRecords(auth.Records(), old.Records())
+	}
+	for _, frag := range m.fragments {
+		old := findAuthority(cur.fragments, frag.domain)
+		frag.dirty = old == nil || !sameRecords(frag.Records(), old.Records())
+	}
+	return nil
+}
+
+func findAuthority(auths []*Authority, domain string) *Authority {
+	for _, y := range auths {
+		if y.domain == domain {
+			return y
+		}
+	}
+	return nil
+}
+
+// sameRecords reports whether a and b hold the same records, in any order.
+func sameRecords(a, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	as, bs := make([]string, len(a)), make([]string, len(b))
+	for i := range a {
+		as[i], bs[i] = a[i].String(), b[i].String()
+	}
+	sort.Strings(as)
+	sort.Strings(bs)
+	for i := range as {
+		if as[i] != bs[i] {
+			return false
+		}
+	}
+	return true
+}
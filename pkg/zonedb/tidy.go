@@ -0,0 +1,132 @@
+package zonedb
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// EnableTidy sets whether Write and Diff tidy the master files they
+// write: records exactly duplicating an earlier one are removed, and the
+// records between two directives are grouped by owner name and then by
+// type, in the order each name and type first appears, each keeping the
+// comment and blank lines before it. A record that moves is written
+// afresh, with its owner and TTL, as the ones before it no longer supply
+// them; the others keep their layout.
+func (r *DB) EnableTidy(on bool) {
+	r.tidy = on
+}
+
+// dupKey identifies the records that duplicate each other exactly, but
+// for the case of the owner name.
+func dupKey(tok *dns.Token) string {
+	h := tok.RR.Header()
+	return strings.ToLower(h.Name) + " " + strings.TrimPrefix(tok.RR.String(), h.Name)
+}
+
+// dedupe removes the records of m that duplicate an earlier one. The zone
+// is not dirtied, as its RRsets are unchanged.
+func (m *MasterFile) dedupe() {
+	for _, auth := range m.records {
+		seen := map[string]bool{}
+		for _, tok := range append([]*dns.Token(nil), auth.records...) {
+			key := dupKey(tok)
+			if !seen[key] {
+				seen[key] = true
+				continue
+			}
+			auth.remove(getRecord(tok), tok)
+			auth.records = dropToken(auth.records, tok)
+			m.delete(tok)
+		}
+	}
+}
+
+// renderTidy writes s with the records grouped; see EnableTidy.
+func (s *source) renderTidy(w io.Writer) error {
+	var out []string
+	var seg []*entry
+	flush := func() {
+		out = append(out, groupEntries(seg)...)
+		seg = nil
+	}
+	for _, e := range s.entries {
+		if e.deleted {
+			continue
+		}
+		if e.tok == nil && len(words(e.text)) > 0 { // a directive or a record of unknown type
+			flush()
+			out = append(out, e.render())
+			continue
+		}
+		seg = append(seg, e)
+	}
+	flush()
+	for _, text := range out {
+		if _, err := io.WriteString(w, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupEntries returns the text of the records and comment lines of seg,
+// which holds no directives, with the records grouped by owner and type.
+func groupEntries(seg []*entry) []string {
+	type unit struct {
+		lead  []*entry // the comment and blank lines before the record
+		e     *entry
+		prev  *entry // the record before it in seg
+		order [3]int
+	}
+	var units []*unit
+	var lead []*entry
+	var prev *entry
+	names, types := map[string]int{}, map[string]int{}
+	for _, e := range seg {
+		if e.tok == nil {
+			lead = append(lead, e)
+			continue
+		}
+		h := e.tok.RR.Header()
+		name := strings.ToLower(h.Name)
+		if _, ok := names[name]; !ok {
+			names[name] = len(names)
+		}
+		key := rrsetKey(h)
+		if _, ok := types[key]; !ok {
+			types[key] = len(types)
+		}
+		units = append(units, &unit{lead: lead, e: e, prev: prev, order: [3]int{names[name], types[key], len(units)}})
+		lead, prev = nil, e
+	}
+	sort.SliceStable(units, func(i, j int) bool {
+		a, b := units[i].order, units[j].order
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[2] < b[2]
+	})
+	var out []string
+	prev = nil
+	for _, u := range units {
+		for _, e := range u.lead {
+			out = append(out, e.render())
+		}
+		if u.prev == prev {
+			out = append(out, u.e.render())
+		} else {
+			out = append(out, u.e.full())
+		}
+		prev = u.e
+	}
+	for _, e := range lead {
+		out = append(out, e.render())
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package zonedb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// EnableValidation sets whether Write validates the master files it is
+// about to write before renaming any of them into place. Each file whose
+// text, or that of a file it includes, is written is parsed again from
+// its new text and checked as Check does; Write fails, leaving every file
+// as it was, if the file no longer loads, has lost a zone, or has errors
+// its old text did not have.
+func (r *DB) EnableValidation(on bool) {
+	r.validate = on
+}
+
+// SetValidator sets a function that also validates each zone Write is
+// about to write, given the name of the zone and of a file holding its new
+// text, such as one running named-checkzone. Files that file includes are
+// read as they are on disk. Write fails, leaving every file as it was, if
+// the function returns an error.
+func (r *DB) SetValidator(f func(zone, file string) error) {
+	r.validator = f
+}
+
+// validateStaged validates the master files of files, staged by Write.
+func (r *DB) validateStaged(files []*staged) error {
+	if !r.validate && r.validator == nil {
+		return nil
+	}
+	text := map[string][]byte{}
+	tmps := map[string]string{}
+	for _, f := range files {
+		text[f.src.file], tmps[f.src.file] = f.data, f.tmp
+	}
+	for _, mf := range r.records {
+		written := false
+		for _, src := range mf.src.all() {
+			if _, ok := text[src.file]; ok {
+				written = true
+			}
+		}
+		if !written {
+			continue
+		}
+		if r.validate {
+			if err := r.revalidate(mf, text); err != nil {
+				return err
+			}
+		}
+		if r.validator == nil {
+			continue
+		}
+		file, ok := tmps[mf.file]
+		if !ok {
+			file = mf.file
+		}
+		for _, auth := range mf.records {
+			if err := r.validator(auth.domain, file); err != nil {
+				return fmt.Errorf("%s: zone %s fails validation: %v", mf.file, auth.domain, err)
+			}
+		}
+	}
+	return nil
+}
+
+// revalidate parses mf again from text, holding the new text of the files
+// being written, and checks the result against its old text.
+func (r *DB) revalidate(mf *MasterFile, text map[string][]byte) error {
+	cur, err := r.reparse(mf.file, text)
+	if err != nil {
+		return fmt.Errorf("new text of %s does not load: %v", mf.file, err)
+	}
+	zones := map[string]bool{}
+	for _, auth := range cur.records[0].records {
+		zones[strings.ToLower(auth.domain)] = true
+	}
+	for _, auth := range mf.records {
+		if !zones[strings.ToLower(auth.domain)] {
+			return fmt.Errorf("new text of %s does not hold zone %s", mf.file, auth.domain)
+		}
+	}
+	old := map[string]bool{}
+	if prev, err := r.reparse(mf.file, nil); err == nil {
+		for _, p := range prev.Check() {
+			old[problemKey(p)] = true
+		}
+	}
+	for _, p := range cur.Check() {
+		if !p.Warning && !old[problemKey(p)] {
+			return fmt.Errorf("new text of %s is invalid: %s", mf.file, p)
+		}
+	}
+	return nil
+}
+
+// reparse parses the master file named file as the DB would load it, from
+// its text in text or else on disk, into a DB of its own.
+func (r *DB) reparse(file string, text map[string][]byte) (*DB, error) {
+	v := New()
+	v.origin, v.origins = r.origin, r.origins
+	v.serial, v.serials = r.serial, r.serials
+	mf := v.newMasterFile(file)
+	data, ok := text[file]
+	if !ok {
+		var err error
+		if data, err = ioutil.ReadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := mf.read(mf.src, bytes.NewReader(data), &readState{origin: r.originOf(file), files: text}); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// problemKey identifies p apart from the file it was found in.
+func problemKey(p Problem) string {
+	return strings.ToLower(p.Zone+" "+p.Name) + " " + p.Msg
+}
@@ -0,0 +1,98 @@
+package zonedb
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestWriteValidation(t *testing.T) {
+	// broken already has an error, which changes elsewhere do not fix.
+	broken := exampleZone + "alias\tIN\tCNAME\twww\nalias\tIN\tA\t192.0.2.50\n"
+	for _, tc := range []struct {
+		name   string
+		text   string
+		change func(r *DB) error
+		fails  string
+	}{
+		{"valid change", exampleZone, func(r *DB) error {
+			return r.UpdateRecord("www.example.com.", dns.TypeA, "192.0.2.11")
+		}, ""},
+		{"new error", exampleZone, func(r *DB) error {
+			_, err := r.DeleteRecords("ns1.example.com.", dns.TypeA, "")
+			return err
+		}, "no glue"},
+		{"old error kept", broken, func(r *DB) error {
+			return r.UpdateRecord("www.example.com.", dns.TypeA, "192.0.2.11")
+		}, ""},
+	} {
+		dir, done := tempDir(t, map[string]string{"example.com.zone": tc.text})
+		file := filepath.Join(dir, "example.com.zone")
+		r := load(t, file)
+		r.EnableValidation(true)
+		if err := tc.change(r); err != nil {
+			t.Fatal(err)
+		}
+		err := r.Write()
+		switch {
+		case tc.fails == "" && err != nil:
+			t.Errorf("%s: Write: %v", tc.name, err)
+		case tc.fails != "" && (err == nil || !strings.Contains(err.Error(), tc.fails)):
+			t.Errorf("%s: Write: %v, want an error about %s", tc.name, err, tc.fails)
+		case tc.fails != "":
+			if got := readFile(t, file); got != tc.text {
+				t.Errorf("%s: invalid zone written:\n%s", tc.name, got)
+			}
+			if serial := r.Zone("example.com.").SOA().Serial; serial != 2024010101 {
+				t.Errorf("%s: serial left at %d", tc.name, serial)
+			}
+		}
+		done()
+	}
+}
+
+func TestWriteValidator(t *testing.T) {
+	dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone})
+	defer done()
+	file := filepath.Join(dir, "example.com.zone")
+
+	for _, fail := range []bool{true, false} {
+		r := load(t, file)
+		var zone, text string
+		r.SetValidator(func(z, f string) error {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				return err
+			}
+			zone, text = z, string(data)
+			if fail {
+				return errors.New("rejected")
+			}
+			return nil
+		})
+		if err := r.UpdateRecord("www.example.com.", dns.TypeA, "192.0.2.11"); err != nil {
+			t.Fatal(err)
+		}
+		err := r.Write()
+		if fail != (err != nil) {
+			t.Fatalf("Write with validator failing %v: %v", fail, err)
+		}
+		if zone != "example.com." || !strings.Contains(text, "192.0.2.11") || !strings.Contains(text, "2024010102") {
+			t.Errorf("validator given zone %q and text:\n%s", zone, text)
+		}
+		want := exampleZone
+		if !fail {
+			want = text
+		}
+		if got := readFile(t, file); got != want {
+			t.Errorf("validator failing %v: master file holds:\n%s\nwant\n%s", fail, got, want)
+		}
+		if serial := r.Zone("example.com.").SOA().Serial; fail && serial != 2024010101 {
+			t.Errorf("serial left at %d after a failed validation", serial)
+		}
+	}
+}
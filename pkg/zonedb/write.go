@@ -0,0 +1,235 @@
+package zonedb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// staged is a file rewritten by Write, waiting to be renamed into place.
+type staged struct {
+	src  *source
+	tmp  string
+	orig []byte // nil if the file did not exist
+	data []byte
+}
+
+// Write rewrites every loaded master file, setting the SOA fields given to
+// SetSOAFields and bumping the serial of each modified authority and, if
+// enabled, journaling its changes and copying it to the Store.
+//
+// The files are written as one transaction: all of them are locked
+// against other writers, none is written if any has changed since it was
+// loaded, and the new content of each is staged in a temporary file, and
+// validated if enabled, before any is renamed into place. If staging,
+// validation or renaming fails, the files already replaced are put back
+// and the serials restored, so that an update spanning several zones never
+// leaves some updated and some not.
+func (r *DB) Write() error {
+	unlock, err := r.lockAll()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, rec := range r.records {
+		for _, src := range rec.src.all() {
+			if err := src.unchanged(); err != nil {
+				return err
+			}
+		}
+	}
+
+	var bumped []*Authority
+	for _, rec := range r.records {
+		for _, auth := range rec.records {
+			if !auth.bumped {
+				bumped = append(bumped, auth)
+			}
+		}
+	}
+	for _, rec := range r.records {
+		if err := rec.applySOAFields(); err != nil {
+			unbump(bumped)
+			return err
+		}
+		if err := rec.bumpSerials(); err != nil {
+			unbump(bumped)
+			return err
+		}
+	}
+
+	var srcs []*source
+	var renders []func(io.Writer) error
+	for _, rec := range r.records {
+		if r.tidy {
+			rec.dedupe()
+		}
+		if r.coredns {
+			srcs, renders = append(srcs, rec.src), append(renders, rec.renderCoreDNS)
+			continue
+		}
+		for i, src := range rec.src.all() {
+			render := src.render
+			if r.tidy {
+				render = src.renderTidy
+			}
+			if i == 0 || src.modified() {
+				srcs, renders = append(srcs, src), append(renders, render)
+			}
+		}
+	}
+	files := make([]*staged, len(srcs))
+	abort := func(err error) error {
+		for _, f := range files {
+			if f != nil {
+				os.Remove(f.tmp)
+			}
+		}
+		unbump(bumped)
+		return err
+	}
+	err = r.each(len(srcs), func(i int) error {
+		var err error
+		files[i], err = stage(srcs[i], renders[i])
+		return err
+	})
+	if err != nil {
+		return abort(err)
+	}
+	if err := r.validateStaged(files); err != nil {
+		return abort(err)
+	}
+	for _, f := range files {
+		if err := r.backup(f.src.file, f.orig, f.data); err != nil {
+			return abort(err)
+		}
+	}
+
+	for i, f := range files {
+		if err := replaceFile(f.tmp, f.src.file); err != nil {
+			for _, done := range files[:i] {
+				done.revert()
+			}
+			return abort(err)
+		}
+	}
+	dirs := map[string]bool{}
+	for _, f := range files {
+		sum := sha256.Sum256(f.data)
+		f.src.sum = sum[:]
+		if dir := filepath.Dir(f.src.file); !dirs[dir] {
+			dirs[dir] = true
+			if err := syncDir(dir); err != nil {
+				return err
+			}
+		}
+	}
+	defer r.markWritten()
+
+	if r.journal {
+		for _, rec := range r.records {
+			if err := rec.journalChanges(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, rec := range r.records {
+		if err := rec.storeZones(true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markWritten makes the written files the base of the next Write: the
+// serials written are those to advance and the changes from here on are
+// those to journal.
+func (r *DB) markWritten() {
+	for _, rec := range r.records {
+		if !r.journal {
+			rec.snapshot()
+		}
+		for _, auth := range append(rec.records, rec.fragments...) {
+			auth.markWritten()
+		}
+	}
+}
+
+// lockAll takes the write lock of every master file, in a fixed order so
+// that two writers cannot deadlock, and returns a function releasing
+// them.
+func (r *DB) lockAll() (func(), error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, rec := range r.records {
+		if !seen[rec.file] {
+			seen[rec.file] = true
+			names = append(names, rec.file)
+		}
+	}
+	sort.Strings(names)
+	var unlocks []func()
+	unlock := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, name := range names {
+		u, err := lockFile(name, true)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		unlocks = append(unlocks, u)
+	}
+	return unlock, nil
+}
+
+// stage renders the file of src with render into a temporary file beside
+// it, keeping the content it replaces.
+func stage(src *source, render func(io.Writer) error) (*staged, error) {
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		return nil, err
+	}
+	orig, err := ioutil.ReadFile(src.file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if orig == nil && err == nil {
+		orig = []byte{}
+	}
+	f := &staged{src: src, orig: orig, data: buf.Bytes()}
+	f.tmp, err = stageFile(src.file, func(w io.Writer) error {
+		_, err := w.Write(f.data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// revert puts back the content a renamed file replaced, or removes it if
+// there was none.
+func (f *staged) revert() {
+	if f.orig == nil {
+		os.Remove(f.src.file)
+		return
+	}
+	writeFile(f.src.file, func(w io.Writer) error {
+		_, err := w.Write(f.orig)
+		return err
+	})
+}
+
+func unbump(auths []*Authority) {
+	for _, auth := range auths {
+		auth.unbumpSerial()
+	}
+}
@@ -0,0 +1,174 @@
+package zonedb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const otherZone = `$ORIGIN example.net.
+$TTL 3600
+@	IN	SOA	ns1.example.com. hostmaster.example.com. 7 7200 3600 1209600 300
+	IN	NS	ns1.example.com.
+www	IN	A	198.51.100.1
+`
+
+// updateBoth loads the master files of example.com and example.net from
+// dir and changes a record of each.
+func updateBoth(t *testing.T, dir string) *DB {
+	r := load(t, filepath.Join(dir, "example.com.zone"), filepath.Join(dir, "example.net.zone"))
+	for _, name := range []string{"www.example.com.", "www.example.net."} {
+		if err := r.UpdateRecord(name, dns.TypeA, "203.0.113.9"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestWriteAllOrNothing(t *testing.T) {
+	files := map[string]string{"example.com.zone": exampleZone, "example.net.zone": otherZone}
+	dir, done := tempDir(t, files)
+	defer done()
+	notDir := filepath.Join(dir, "example.com.zone")
+	backups := filepath.Join(dir, "backups")
+
+	for _, tc := range []struct {
+		name      string
+		backupDir string
+		fails     bool
+	}{
+		{"backup fails", notDir, true},
+		{"written", backups, false},
+	} {
+		r := updateBoth(t, dir)
+		r.EnableBackups(tc.backupDir, 0)
+		err := r.Write()
+		if tc.fails != (err != nil) {
+			t.Fatalf("%s: Write: %v", tc.name, err)
+		}
+
+		serials := map[string]uint32{"example.com.": 2024010101, "example.net.": 7}
+		if !tc.fails {
+			serials = map[string]uint32{"example.com.": 2024010102, "example.net.": 8}
+		}
+		for zone, want := range serials {
+			if got := r.Zone(zone).SOA().Serial; got != want {
+				t.Errorf("%s: serial of %s is %d, want %d", tc.name, zone, got, want)
+			}
+		}
+		for name, text := range files {
+			got := readFile(t, filepath.Join(dir, name))
+			if changed := got != text; changed == tc.fails {
+				t.Errorf("%s: %s changed %v:\n%s", tc.name, name, changed, got)
+			}
+			if !tc.fails && !strings.Contains(got, "203.0.113.9") {
+				t.Errorf("%s: %s lacks the new address:\n%s", tc.name, name, got)
+			}
+		}
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fi := range infos {
+			if _, ok := files[fi.Name()]; !ok && fi.Name() != "backups" && !strings.HasSuffix(fi.Name(), ".lock") {
+				t.Errorf("%s: left %s behind", tc.name, fi.Name())
+			}
+		}
+	}
+
+	for name, text := range files {
+		bs, err := Backups(backups, filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bs) != 1 {
+			t.Errorf("%d backups of %s, want 1", len(bs), name)
+			continue
+		}
+		if got := readFile(t, bs[0].Path); got != text {
+			t.Errorf("backup of %s holds:\n%s", name, got)
+		}
+	}
+}
+
+func TestWriteChangedSinceLoad(t *testing.T) {
+	dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone, "example.net.zone": otherZone})
+	defer done()
+	r := updateBoth(t, dir)
+
+	edited := otherZone + "ftp\tIN\tA\t198.51.100.2\n"
+	file := filepath.Join(dir, "example.net.zone")
+	if err := ioutil.WriteFile(file, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := r.Write()
+	if _, ok := err.(*ChangedError); !ok {
+		t.Fatalf("Write: %v, want a *ChangedError", err)
+	}
+	if got := readFile(t, file); got != edited {
+		t.Errorf("edited file overwritten:\n%s", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "example.com.zone")); got != exampleZone {
+		t.Errorf("other file written:\n%s", got)
+	}
+}
+
+func TestWriteAgain(t *testing.T) {
+	dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone})
+	defer done()
+	file := filepath.Join(dir, "example.com.zone")
+	r := load(t, file)
+
+	for i, tc := range []struct {
+		name, ip string
+		serial   uint32
+	}{
+		{"www.example.com.", "192.0.2.11", 2024010102},
+		{"ns1.example.com.", "192.0.2.2", 2024010103},
+		{"", "", 2024010103},
+	} {
+		if tc.name != "" {
+			if err := r.UpdateIP(tc.name, tc.ip); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Write(); err != nil {
+			t.Fatalf("write %d: %v", i+1, err)
+		}
+		if got := r.Zone("example.com.").SOA().Serial; got != tc.serial {
+			t.Errorf("write %d: serial %d, want %d", i+1, got, tc.serial)
+		}
+		reloaded := load(t, file).Zone("example.com.")
+		if got := reloaded.SOA().Serial; got != tc.serial {
+			t.Errorf("write %d: serial %d written, want %d", i+1, got, tc.serial)
+		}
+	}
+	if !r.Zone("example.com.").Dirty() {
+		t.Error("zone written twice not reported modified")
+	}
+	got := readFile(t, file)
+	for _, want := range []string{"192.0.2.11", "192.0.2.2 "} {
+		if !strings.Contains(got, want) {
+			t.Errorf("written file lacks %s:\n%s", want, got)
+		}
+	}
+}
+
+func TestStagedRevert(t *testing.T) {
+	dir, done := tempDir(t, map[string]string{"old.zone": "new text\n", "created.zone": "new text\n"})
+	defer done()
+	old, created := filepath.Join(dir, "old.zone"), filepath.Join(dir, "created.zone")
+
+	(&staged{src: &source{file: old}, orig: []byte("old text\n")}).revert()
+	if got := readFile(t, old); got != "old text\n" {
+		t.Errorf("reverted file holds %q", got)
+	}
+	(&staged{src: &source{file: created}}).revert()
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("file with no old content not removed: %v", err)
+	}
+}
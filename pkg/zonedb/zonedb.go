@@ -0,0 +1,937 @@
+// Package zonedb loads DNS master files, edits their records in memory and
+// writes them back out with updated SOA serials.
+package zonedb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/miekg/dns"
+)
+
+type record struct {
+	name   string
+	rrtype uint16
+	ip     string
+	value  string
+}
+
+// DB indexes the records of a set of master files by name and address.
+type DB struct {
+	records []*MasterFile
+	mu      sync.RWMutex // guards ips and domains, which parallel loads share
+	ips     map[string][]*MasterFile
+	domains map[string][]*MasterFile
+	workers int
+	collect bool
+	strict  bool
+	coredns bool
+	tidy    bool
+	origin  string
+	origins map[string]string
+	chase   bool
+
+	templates map[string]*template.Template
+	tmplData  interface{}
+
+	addrPolicy AddressPolicy
+	warnf      func(error)
+	serial     SerialPolicy
+	serials    map[string]SerialPolicy
+	live       func(zone string) (uint32, bool)
+	soaFields  map[string]SOAFields // by lowercased zone
+	journal    bool
+	ptrSync    bool
+	owners     bool
+	owned      map[string]bool // zones with their own ownership setting
+	store      Store
+	validate   bool
+	validator  func(zone, file string) error
+
+	backupDir  string
+	backupKeep int
+}
+
+// New returns an empty DB.
+func New() *DB {
+	return &DB{
+		ips:       map[string][]*MasterFile{},
+		domains:   map[string][]*MasterFile{},
+		serials:   map[string]SerialPolicy{},
+		owned:     map[string]bool{},
+		soaFields: map[string]SOAFields{},
+		origins:   map[string]string{},
+		templates: map[string]*template.Template{},
+	}
+}
+
+// SetSerialPolicy sets the policy used to advance the serial of zone, or
+// the default for zones without their own policy when zone is empty.
+func (r *DB) SetSerialPolicy(zone string, p SerialPolicy) {
+	if zone == "" {
+		r.serial = p
+		return
+	}
+	r.serials[strings.ToLower(zone)] = p
+}
+
+func (r *DB) serialPolicy(zone string) SerialPolicy {
+	if p, ok := r.serials[strings.ToLower(zone)]; ok {
+		return p
+	}
+	return r.serial
+}
+
+// Files returns the master files loaded into the DB, in load order.
+func (r *DB) Files() []*MasterFile {
+	return r.records
+}
+
+// Records returns every resource record in the DB, in file order.
+func (r *DB) Records() []dns.RR {
+	var rrs []dns.RR
+	for _, mf := range r.records {
+		rrs = append(rrs, mf.Records()...)
+	}
+	return rrs
+}
+
+// Dirty reports whether any loaded master file has pending changes.
+func (r *DB) Dirty() bool {
+	for _, mf := range r.records {
+		if mf.Dirty() {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff writes a unified diff of the pending changes to each master file.
+func (r *DB) Diff(w io.Writer) error {
+	for _, rec := range r.records {
+		if err := rec.diff(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateIP sets the address of every record named domain of ip's family
+// to ip: the A records for an IPv4 address and the AAAA records for an
+// IPv6 one, or as the DB's AddressPolicy says for a name with several
+// such records. An alias is followed to its canonical name with CNAME
+// chasing, and is otherwise an *AliasError.
+func (r *DB) UpdateIP(domain string, ip string) error {
+	ipa := net.ParseIP(ip)
+	if ipa == nil {
+		return fmt.Errorf("invalid IP address %q for %q", ip, domain)
+	}
+	if target := r.cname(domain); target != "" {
+		if !r.chase {
+			return &AliasError{Name: domain, Target: target}
+		}
+		var err error
+		if domain, err = r.follow(domain); err != nil {
+			return err
+		}
+	}
+	rrtype, addr := dns.TypeAAAA, ipa.String()
+	if ipa.To4() != nil {
+		rrtype, addr = dns.TypeA, ipa.To4().String()
+	}
+	err := r.checkManaged(domain, rrtype, func(tok *dns.Token) bool { return getRecord(tok).ip != addr })
+	if err != nil {
+		return err
+	}
+	switch r.addrPolicy {
+	case ReplaceAll:
+		r.replaceAll(domain, rrtype, ipa)
+		return nil
+	case ReplaceOne:
+		return r.replaceOne(domain, rrtype, "", ipa)
+	}
+	for _, mf := range r.filesOf(r.domains, nameKey(domain)) {
+		mf.updateIP(domain, ipa)
+	}
+	return nil
+}
+
+// UpdateRecord sets the data of every record of type rrtype named name to
+// value, given in presentation format such as "10 mail.example.com." for
+// MX or "0 issue \"letsencrypt.org\"" for CAA. The owner name, class and
+// TTL of each record are left unchanged.
+func (r *DB) UpdateRecord(name string, rrtype uint16, value string) error {
+	return r.ReplaceRecord(name, rrtype, "", value)
+}
+
+// ReplaceRecord sets the data of the records of type rrtype named name
+// whose data is old, in presentation format, to value, as UpdateRecord
+// does for them all when old is empty.
+func (r *DB) ReplaceRecord(name string, rrtype uint16, old, value string) error {
+	typ, ok := dns.TypeToString[rrtype]
+	if !ok {
+		return fmt.Errorf("unknown record type %d", rrtype)
+	}
+	rr, err := dns.NewRR(fmt.Sprintf("%s 0 IN %s %s", name, typ, value))
+	if err != nil {
+		return err
+	}
+	if rr == nil {
+		return fmt.Errorf("empty %s value for %q", typ, name)
+	}
+	err = r.checkManaged(name, rrtype, func(tok *dns.Token) bool {
+		return (old == "" || rdata(tok.RR) == old) && rdata(tok.RR) != rdata(rr)
+	})
+	if err != nil {
+		return err
+	}
+	for _, mf := range r.filesOf(r.domains, nameKey(name)) {
+		mf.updateRecord(name, old, rr)
+	}
+	return nil
+}
+
+// SetTTL sets the TTL of every record of type rrtype named name.
+func (r *DB) SetTTL(name string, rrtype uint16, ttl uint32) error {
+	err := r.checkManaged(name, rrtype, func(tok *dns.Token) bool { return tok.RR.Header().Ttl != ttl })
+	if err != nil {
+		return err
+	}
+	key := nameKey(name)
+	for _, mf := range r.filesOf(r.domains, key) {
+		for _, auth := range mf.domains[key] {
+			for _, tok := range auth.names[key] {
+				if hdr := tok.RR.Header(); hdr.Rrtype == rrtype && hdr.Ttl != ttl {
+					hdr.Ttl = ttl
+					auth.dirty = true
+					r.stamp(auth.domain, tok)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Lookup returns the records named name of type rrtype, or of every type
+// if rrtype is dns.TypeANY.
+func (r *DB) Lookup(name string, rrtype uint16) []dns.RR {
+	var rrs []dns.RR
+	key := nameKey(name)
+	for _, mf := range r.filesOf(r.domains, key) {
+		for _, auth := range mf.domains[key] {
+			for _, tok := range auth.names[key] {
+				if rrtype == dns.TypeANY || tok.RR.Header().Rrtype == rrtype {
+					rrs = append(rrs, tok.RR)
+				}
+			}
+		}
+	}
+	return rrs
+}
+
+// IsPattern reports whether name is a pattern for Expand rather than a
+// single domain name.
+func IsPattern(name string) bool {
+	return name == "@" || name == "@." || strings.ContainsAny(name, "*?[")
+}
+
+// Expand returns the names matching pattern that have records of rrtype,
+// sorted. The pattern "@" matches the apex of every loaded zone, and a
+// pattern with glob characters matches as with path.Match, except that *
+// also matches dots: "*.home.example.com." matches every name below
+// home.example.com., including a literal wildcard record there. A name
+// without glob characters is returned as is.
+func (r *DB) Expand(pattern string, rrtype uint16) []string {
+	if !IsPattern(pattern) {
+		return []string{pattern}
+	}
+	var names []string
+	if pattern == "@" || pattern == "@." {
+		for _, mf := range r.records {
+			for _, auth := range mf.records {
+				if len(r.Lookup(auth.domain, rrtype)) > 0 {
+					names = append(names, auth.domain)
+				}
+			}
+		}
+	} else {
+		pattern = strings.ToLower(pattern)
+		for _, name := range r.names() {
+			if ok, _ := path.Match(pattern, strings.ToLower(name)); ok && len(r.Lookup(name, rrtype)) > 0 {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Zone returns the most specific loaded authority containing name, or nil.
+func (r *DB) Zone(name string) *Authority {
+	var best *Authority
+	for _, mf := range r.records {
+		for _, auth := range mf.records {
+			if dns.IsSubDomain(auth.domain, name) && (best == nil || dns.CountLabel(auth.domain) > dns.CountLabel(best.domain)) {
+				best = auth
+			}
+		}
+	}
+	return best
+}
+
+// AddRecord adds rr to the zone containing its owner name.
+func (r *DB) AddRecord(rr dns.RR) error {
+	return r.addRecord(rr, "")
+}
+
+// addRecord adds rr with the comment comment, which may be empty.
+func (r *DB) addRecord(rr dns.RR, comment string) error {
+	name := rr.Header().Name
+	auth := r.Zone(name)
+	if auth == nil {
+		return fmt.Errorf("no loaded zone contains %q", name)
+	}
+	if rr.Header().Rrtype == dns.TypeSOA {
+		return fmt.Errorf("cannot add a second SOA record to %q", auth.domain)
+	}
+	auth.addToken(&dns.Token{RR: rr, Comment: comment})
+	return nil
+}
+
+// DeleteRecords removes the records named name of type rrtype, or of any
+// type but SOA if rrtype is dns.TypeANY. If value is not empty only records
+// with that data, in presentation format, are removed. It returns the
+// number of records removed.
+func (r *DB) DeleteRecords(name string, rrtype uint16, value string) (int, error) {
+	if rrtype == dns.TypeSOA {
+		return 0, fmt.Errorf("cannot delete the SOA record of %q", name)
+	}
+	if value != "" {
+		rr, err := dns.NewRR(fmt.Sprintf("%s 0 IN %s %s", name, dns.TypeToString[rrtype], value))
+		if err != nil {
+			return 0, err
+		}
+		if rr == nil {
+			return 0, fmt.Errorf("empty %s value for %q", dns.TypeToString[rrtype], name)
+		}
+		value = rdata(rr)
+	}
+	err := r.checkManaged(name, rrtype, func(tok *dns.Token) bool { return value == "" || getRecord(tok).value == value })
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, mf := range r.records {
+		for _, auth := range mf.records {
+			n += auth.deleteRecords(name, rrtype, value)
+		}
+	}
+	return n, nil
+}
+
+// Load parses the named master files into the DB. Directives, comments
+// and the layout of each file are retained so that Write changes only the
+// modified records.
+func (r *DB) Load(files ...string) error {
+	mfs := make([]*MasterFile, len(files))
+	for i, x := range files {
+		mfs[i] = r.newMasterFile(x)
+	}
+	errs := make([]error, len(mfs))
+	err := r.each(len(mfs), func(i int) error {
+		mf := mfs[i]
+		if errs[i] = mf.load(); errs[i] == nil {
+			errs[i] = mf.storeZones(false)
+		}
+		if r.collect {
+			return nil
+		}
+		return errs[i]
+	})
+	if !r.collect {
+		if err != nil {
+			return err
+		}
+		return r.checkFragments()
+	}
+	le := &LoadError{}
+	for i, err := range errs {
+		if err != nil {
+			r.drop(mfs[i])
+			le.Files = append(le.Files, files[i])
+			le.Errs = append(le.Errs, err)
+		}
+	}
+	if len(le.Files) > 0 {
+		return le
+	}
+	return r.checkFragments()
+}
+
+// Import adds a master file named file holding rrs, the records of origin
+// such as those of a zone transfer, as if it had been loaded from disk.
+// Write creates the file, or replaces it keeping its comments and blank
+// lines: those before each RRset it still holds stay before it, and the
+// records that remain keep their comments.
+func (r *DB) Import(file, origin string, rrs []dns.RR) (*MasterFile, error) {
+	var buf bytes.Buffer
+	if err := writeZone(&buf, origin, rrs, readComments(file, origin, rrs)); err != nil {
+		return nil, err
+	}
+	mf := r.newMasterFile(file)
+	if err := mf.read(mf.src, &buf, &readState{}); err != nil {
+		return nil, err
+	}
+	mf.snapshot()
+	return mf, nil
+}
+
+func (r *DB) newMasterFile(name string) *MasterFile {
+	mf := newMasterFile(name)
+	mf.parent = r
+	mf.seq = len(r.records)
+	r.records = append(r.records, mf)
+	return mf
+}
+
+// MasterFile is a single zone file and the authorities it contains.
+type MasterFile struct {
+	file      string
+	src       *source
+	parent    *DB
+	seq       int // the load order, which the DB indexes keep
+	records   []*Authority
+	fragments []*Authority // the records before any SOA, by origin
+	ips       map[string][]*Authority
+	domains   map[string][]*Authority
+}
+
+func newMasterFile(name string) *MasterFile {
+	return &MasterFile{
+		file:    name,
+		src:     &source{file: name},
+		ips:     map[string][]*Authority{},
+		domains: map[string][]*Authority{},
+	}
+}
+
+// Name returns the path the master file was loaded from.
+func (m *MasterFile) Name() string {
+	return m.file
+}
+
+// Authorities returns the zones in the file, in file order.
+func (m *MasterFile) Authorities() []*Authority {
+	return m.records
+}
+
+// Records returns every resource record in the file.
+func (m *MasterFile) Records() []dns.RR {
+	var rrs []dns.RR
+	for _, auth := range m.records {
+		rrs = append(rrs, auth.Records()...)
+	}
+	return rrs
+}
+
+// Dirty reports whether any authority or fragment in the file has pending
+// changes.
+func (m *MasterFile) Dirty() bool {
+	for _, auth := range append(m.records, m.fragments...) {
+		if auth.Dirty() {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MasterFile) load() error {
+	if t := m.parent.templateOf(m.file); t != nil {
+		return m.render(t)
+	}
+	unlock, err := lockFile(m.file, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := m.src.readFile()
+	if err != nil {
+		return err
+	}
+	if err := m.read(m.src, bytes.NewReader(data), &readState{origin: m.parent.originOf(m.file)}); err != nil {
+		return err
+	}
+	m.snapshot()
+	return nil
+}
+
+func (m *MasterFile) diff(w io.Writer) error {
+	if err := m.applySOAFields(); err != nil {
+		return err
+	}
+	if err := m.bumpSerials(); err != nil {
+		return err
+	}
+	if m.parent.tidy {
+		m.dedupe()
+	}
+	if m.parent.coredns {
+		return diffFile(w, m.file, m.renderCoreDNS)
+	}
+	for _, src := range m.src.all() {
+		render := src.render
+		if m.parent.tidy {
+			render = src.renderTidy
+		}
+		if err := src.diff(w, render); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MasterFile) bumpSerials() error {
+	for _, auth := range m.records {
+		if err := auth.bumpSerial(); err != nil {
+			return err
+		}
+	}
+	return m.bumpFragments()
+}
+
+func (m *MasterFile) updateIP(domain string, ip net.IP) {
+	for _, auth := range m.domains[nameKey(domain)] {
+		auth.updateIP(domain, ip)
+	}
+}
+
+func (m *MasterFile) updateRecord(name, old string, rr dns.RR) {
+	for _, auth := range m.domains[nameKey(name)] {
+		auth.updateRecord(name, old, rr)
+	}
+}
+
+func (m *MasterFile) process(tokens <-chan *dns.Token) error {
+	var auth *Authority
+	for tok := range tokens {
+		if tok.Error != nil {
+			return tok.Error
+		}
+		var err error
+		if auth, err = m.accept(auth, tok); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accept adds tok to the current authority auth, or to a new authority if
+// tok is a SOA record, and returns the authority now current.
+func (m *MasterFile) accept(auth *Authority, tok *dns.Token) (*Authority, error) {
+	hdr := tok.RR.Header()
+	switch hdr.Class {
+	case dns.ClassINET:
+		switch hdr.Rrtype {
+		case dns.TypeSOA:
+			if soa, ok := tok.RR.(*dns.SOA); ok {
+				auth = m.newAuthority(soa.Hdr.Name)
+			} else {
+				return auth, fmt.Errorf("mismatched Rrtype SOA and type %T", tok.RR)
+			}
+		default:
+		}
+	default:
+		// carried with the zone, but only INET records are managed
+		if auth != nil {
+			auth.keep(tok)
+		}
+		return auth, nil
+	}
+	if auth == nil {
+		return nil, fmt.Errorf("missing SOA resource record")
+	}
+	auth.add(tok)
+	return auth, nil
+}
+
+func (m *MasterFile) newAuthority(domain string) *Authority {
+	dr := newAuthority(domain)
+	dr.master = m
+	m.records = append(m.records, dr)
+	return dr
+}
+
+// Authority is a zone: a SOA record and the records that follow it.
+type Authority struct {
+	domain   string
+	master   *MasterFile
+	fragment bool // records without a SOA, of the zone containing domain
+	dirty    bool // modified since loaded or last written
+	written  bool // modified and written since loaded
+	bumped   bool
+	prev     uint32 // the serial before bumpSerial, for unbumpSerial
+	records  []*dns.Token
+	ips      map[string][]*dns.Token
+	names    map[string][]*dns.Token
+
+	base    map[string]dns.RR
+	baseSOA dns.RR
+}
+
+func newAuthority(domain string) *Authority {
+	return &Authority{
+		domain: domain,
+		ips:    map[string][]*dns.Token{},
+		names:  map[string][]*dns.Token{},
+	}
+}
+
+// Domain returns the owner name of the zone's SOA record.
+func (y *Authority) Domain() string {
+	return y.domain
+}
+
+// Dirty reports whether the zone has been modified since it was loaded.
+func (y *Authority) Dirty() bool {
+	return y.dirty || y.written
+}
+
+// Touch marks the zone modified, so that the next Write bumps its serial
+// even though no record changed.
+func (y *Authority) Touch() {
+	y.dirty = true
+}
+
+// SOA returns the zone's SOA record.
+func (y *Authority) SOA() *dns.SOA {
+	if len(y.records) == 0 {
+		return nil
+	}
+	soa, _ := y.records[0].RR.(*dns.SOA)
+	return soa
+}
+
+// NS returns the targets of the NS records at the zone apex.
+func (y *Authority) NS() []string {
+	var ns []string
+	for _, tok := range y.names[nameKey(y.domain)] {
+		if rr, ok := tok.RR.(*dns.NS); ok {
+			ns = append(ns, rr.Ns)
+		}
+	}
+	return ns
+}
+
+// Records returns the zone's resource records, SOA first.
+func (y *Authority) Records() []dns.RR {
+	rrs := make([]dns.RR, 0, len(y.records))
+	for _, tok := range y.records {
+		rrs = append(rrs, tok.RR)
+	}
+	return rrs
+}
+
+// bumpSerial advances the SOA serial of a modified zone, at most once.
+func (y *Authority) bumpSerial() error {
+	if !y.dirty || y.bumped {
+		return nil
+	}
+	soa, ok := y.records[0].RR.(*dns.SOA)
+	if !ok {
+		return fmt.Errorf("first record should be SOA %q: %T", y.domain, y.records[0].RR)
+	}
+	y.prev = soa.Serial
+	soa.Serial = y.master.parent.nextSerial(y.domain, soa.Serial)
+	y.bumped = true
+	return nil
+}
+
+// unbumpSerial undoes bumpSerial after a failed write.
+func (y *Authority) unbumpSerial() {
+	if !y.bumped {
+		return
+	}
+	if soa, ok := y.records[0].RR.(*dns.SOA); ok {
+		soa.Serial = y.prev
+	}
+	y.bumped = false
+}
+
+// markWritten marks the zone written by Write, so that its next modification
+// bumps the serial again.
+func (y *Authority) markWritten() {
+	y.written = y.written || y.dirty
+	y.dirty, y.bumped = false, false
+}
+
+// updateIP points the records named domain of ip's family at ip.
+func (y *Authority) updateIP(domain string, ipa net.IP) {
+	rrtype := dns.TypeAAAA
+	if ipa.To4() != nil {
+		rrtype, ipa = dns.TypeA, ipa.To4()
+	}
+	for _, tok := range y.names[nameKey(domain)] {
+		if getRecord(tok).rrtype == rrtype {
+			y.setIP(domain, tok, ipa)
+		}
+	}
+}
+
+// setIP points tok, an address record named domain of ipa's family, at
+// ipa.
+func (y *Authority) setIP(domain string, tok *dns.Token, ipa net.IP) {
+	rec := getRecord(tok)
+	ip := ipa.String()
+	if rec.ip == ip {
+		return
+	}
+	y.dirty = true
+	y.remove(rec, tok)
+	if a, ok := tok.RR.(*dns.A); ok {
+		a.A = ipa
+	}
+	if aaaa, ok := tok.RR.(*dns.AAAA); ok {
+		aaaa.AAAA = ipa
+	}
+	y.master.parent.syncPTR(domain, rec.ip, ip, tok.RR.Header().Ttl)
+	y.master.parent.stamp(y.domain, tok)
+	y.update(getRecord(tok), tok)
+}
+
+// updateRecord sets the data of the records named name of rr's type and
+// class, those with the data old unless it is empty, to that of rr.
+func (y *Authority) updateRecord(name, old string, rr dns.RR) {
+	for _, tok := range y.names[nameKey(name)] {
+		hdr := tok.RR.Header()
+		if hdr.Rrtype != rr.Header().Rrtype || hdr.Class != rr.Header().Class || old != "" && rdata(tok.RR) != old {
+			continue
+		}
+		nrr := dns.Copy(rr)
+		*nrr.Header() = *hdr
+		if nrr.String() == tok.RR.String() {
+			continue
+		}
+		y.dirty = true
+		old := getRecord(tok)
+		y.remove(old, tok)
+		tok.RR = nrr
+		y.master.parent.stamp(y.domain, tok)
+		rec := getRecord(tok)
+		y.update(rec, tok)
+		if old.ip != "" {
+			y.master.parent.syncPTR(name, old.ip, rec.ip, hdr.Ttl)
+		}
+	}
+}
+
+func (y *Authority) addRecord(rr dns.RR) {
+	y.addToken(&dns.Token{RR: rr})
+}
+
+// addToken adds the new record tok, stamping it if ownership is enabled.
+func (y *Authority) addToken(tok *dns.Token) {
+	rr := tok.RR
+	y.master.parent.stamp(y.domain, tok)
+	y.master.insert(y, tok)
+	y.add(tok)
+	y.dirty = true
+	if r := getRecord(tok); r.ip != "" {
+		y.master.parent.syncPTR(r.name, "", r.ip, rr.Header().Ttl)
+	}
+}
+
+func (y *Authority) deleteRecords(name string, rrtype uint16, value string) int {
+	n := 0
+	for _, tok := range y.names[nameKey(name)] {
+		rec := getRecord(tok)
+		if rec.rrtype == dns.TypeSOA {
+			continue
+		}
+		if rrtype != dns.TypeANY && rec.rrtype != rrtype || value != "" && rec.value != value {
+			continue
+		}
+		y.deleteToken(tok)
+		n++
+	}
+	return n
+}
+
+// deleteToken removes the record tok from y and its master file.
+func (y *Authority) deleteToken(tok *dns.Token) {
+	rec := getRecord(tok)
+	y.remove(rec, tok)
+	y.records = dropToken(y.records, tok)
+	y.master.delete(tok)
+	y.dirty = true
+	if rec.ip != "" {
+		y.master.parent.syncPTR(rec.name, rec.ip, "", 0)
+	}
+}
+
+func (y *Authority) add(tok *dns.Token) {
+	y.records = append(y.records, tok)
+	r := getRecord(tok)
+	y.update(r, tok)
+}
+
+// remove drops tok, described by r, from the indexes, and drops y from
+// the file and DB indexes for any name or address it no longer holds.
+func (y *Authority) remove(r record, tok *dns.Token) {
+	if r.ip != "" {
+		y.ips[r.ip] = dropToken(y.ips[r.ip], tok)
+		if len(y.ips[r.ip]) == 0 {
+			delete(y.ips, r.ip)
+			y.master.forget(y.master.ips, y.master.parent.ips, r.ip, y)
+		}
+	}
+	key := nameKey(r.name)
+	y.names[key] = dropToken(y.names[key], tok)
+	if len(y.names[key]) == 0 {
+		delete(y.names, key)
+		y.master.forget(y.master.domains, y.master.parent.domains, key, y)
+	}
+}
+
+// update indexes tok, described by r, by name and address.
+func (y *Authority) update(r record, tok *dns.Token) {
+	if r.ip != "" {
+		y.ips[r.ip] = addToken(y.ips[r.ip], tok)
+		y.master.ips[r.ip] = addAuthority(y.master.ips[r.ip], y)
+		y.master.parent.index(y.master.parent.ips, r.ip, y.master)
+	}
+	key := nameKey(r.name)
+	y.names[key] = addToken(y.names[key], tok)
+	y.master.domains[key] = addAuthority(y.master.domains[key], y)
+	y.master.parent.index(y.master.parent.domains, key, y.master)
+}
+
+// nameKey returns the key of name in the indexes by name, which fold case
+// as DNS names do.
+func nameKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// forget removes y from the file index idx under key, and the file from
+// the DB index parent once none of its authorities remain there.
+func (m *MasterFile) forget(idx map[string][]*Authority, parent map[string][]*MasterFile, key string, y *Authority) {
+	idx[key] = dropAuthority(idx[key], y)
+	if len(idx[key]) > 0 {
+		return
+	}
+	delete(idx, key)
+	m.parent.mu.Lock()
+	defer m.parent.mu.Unlock()
+	parent[key] = dropMasterFile(parent[key], m)
+	if len(parent[key]) == 0 {
+		delete(parent, key)
+	}
+}
+
+// The add helpers append a value unless it is already present; the drop
+// helpers return a new slice without it, so that callers may range over
+// an index while modifying it.
+
+func addToken(toks []*dns.Token, tok *dns.Token) []*dns.Token {
+	for _, t := range toks {
+		if t == tok {
+			return toks
+		}
+	}
+	return append(toks, tok)
+}
+
+func dropToken(toks []*dns.Token, tok *dns.Token) []*dns.Token {
+	var out []*dns.Token
+	for _, t := range toks {
+		if t != tok {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func addAuthority(auths []*Authority, y *Authority) []*Authority {
+	for _, a := range auths {
+		if a == y {
+			return auths
+		}
+	}
+	return append(auths, y)
+}
+
+func dropAuthority(auths []*Authority, y *Authority) []*Authority {
+	var out []*Authority
+	for _, a := range auths {
+		if a != y {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// addMasterFile keeps mfs in load order, whichever file is parsed first.
+func addMasterFile(mfs []*MasterFile, m *MasterFile) []*MasterFile {
+	at := len(mfs)
+	for i, f := range mfs {
+		if f == m {
+			return mfs
+		}
+		if f.seq > m.seq && at == len(mfs) {
+			at = i
+		}
+	}
+	if at == len(mfs) {
+		return append(mfs, m)
+	}
+	out := make([]*MasterFile, 0, len(mfs)+1)
+	out = append(out, mfs[:at]...)
+	out = append(out, m)
+	return append(out, mfs[at:]...)
+}
+
+func dropMasterFile(mfs []*MasterFile, m *MasterFile) []*MasterFile {
+	var out []*MasterFile
+	for _, f := range mfs {
+		if f != m {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// rdata returns the presentation format of rr's data.
+func rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+func getRecord(tok *dns.Token) record {
+	hdr := tok.RR.Header()
+	r := record{
+		name:   hdr.Name,
+		rrtype: hdr.Rrtype,
+		value:  rdata(tok.RR),
+	}
+	switch hdr.Class {
+	case dns.ClassINET:
+		switch hdr.Rrtype {
+		case dns.TypeA:
+			if a, ok := tok.RR.(*dns.A); ok {
+				r.ip = a.A.String()
+			}
+		case dns.TypeAAAA:
+			if a, ok := tok.RR.(*dns.AAAA); ok {
+				r.ip = a.AAAA.String()
+			}
+		default:
+		}
+	default:
+	}
+	return r
+}
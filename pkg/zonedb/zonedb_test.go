@@ -0,0 +1,88 @@
+package zonedb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// exampleZone is a master file with comments, a multi-line SOA and
+// records written in several styles, as hand-edited files are.
+const exampleZone = `$ORIGIN example.com.
+$TTL 3600
+@	IN	SOA	ns1 hostmaster (
+		2024010101 ; serial
+		7200 3600 1209600 300 )
+	IN	NS	ns1
+; hosts
+ns1	IN	A	192.0.2.1 ; the name server
+www	300	IN	A	192.0.2.10
+mail   IN  A   192.0.2.20
+`
+
+// tempDir returns a new temporary directory holding files, by name, and a
+// function removing it.
+func tempDir(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "zonedb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, text := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// load returns a new DB with the master files loaded.
+func load(t *testing.T, files ...string) *DB {
+	r := New()
+	if err := r.Load(files...); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// readFile returns the text of the file named name.
+func readFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestNamesFoldCase(t *testing.T) {
+	dir, done := tempDir(t, map[string]string{"example.com.zone": exampleZone + "WWW\t300\tIN\tA\t192.0.2.10\n"})
+	defer done()
+	file := filepath.Join(dir, "example.com.zone")
+	r := load(t, file)
+
+	if err := r.UpdateIP("Www.Example.COM.", "192.0.2.55"); err != nil {
+		t.Fatal(err)
+	}
+	rrs := r.Lookup("WWW.example.com.", dns.TypeA)
+	if len(rrs) != 2 {
+		t.Fatalf("Lookup found %d records, want 2: %v", len(rrs), rrs)
+	}
+	for _, rr := range rrs {
+		if a := rr.(*dns.A).A.String(); a != "192.0.2.55" {
+			t.Errorf("%s not updated", rr)
+		}
+	}
+	if ns := r.Zone("EXAMPLE.com.").NS(); len(ns) != 1 {
+		t.Errorf("NS = %v, want the one record", ns)
+	}
+	if err := r.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, file); strings.Contains(got, "192.0.2.10") {
+		t.Errorf("record left with the old address:\n%s", got)
+	}
+}
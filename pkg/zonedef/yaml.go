@@ -0,0 +1,219 @@
+package zonedef
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// line is a significant line of YAML text: its indentation, its content
+// without the indentation or any comment, and its number for errors.
+type line struct {
+	indent int
+	text   string
+	n      int
+}
+
+// parseYAML parses the block style subset of YAML that zone definitions
+// need: maps, lists of maps or scalars, quoted and plain scalars, "[]"
+// and comments. Scalars are returned as strings, maps as
+// map[string]interface{} and lists as []interface{}. Flow style beyond
+// "[]" and "{}", anchors and multi-line scalars are not understood.
+func parseYAML(text string) (interface{}, error) {
+	var lines []line
+	for n, l := range strings.Split(text, "\n") {
+		l = strings.TrimRight(stripComment(l), " \t\r")
+		trimmed := strings.TrimLeft(l, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", n+1)
+		}
+		lines = append(lines, line{indent: len(l) - len(trimmed), text: trimmed, n: n + 1})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	v, next, err := parseBlock(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if next < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[next].n)
+	}
+	return v, nil
+}
+
+// parseBlock parses the map or list starting at lines[i], whose entries
+// are indented by indent, returning it and the index of the line after.
+func parseBlock(lines []line, i, indent int) (interface{}, int, error) {
+	if isItem(lines[i].text) {
+		return parseList(lines, i, indent)
+	}
+	return parseMap(lines, i, indent)
+}
+
+func isItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func parseList(lines []line, i, indent int) (interface{}, int, error) {
+	list := []interface{}{}
+	for i < len(lines) && lines[i].indent == indent && isItem(lines[i].text) {
+		l := lines[i]
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		switch {
+		case rest == "":
+			if i+1 >= len(lines) || lines[i+1].indent <= indent {
+				list = append(list, "")
+				i++
+				continue
+			}
+			v, next, err := parseBlock(lines, i+1, lines[i+1].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			list, i = append(list, v), next
+		case mapKey(rest) >= 0:
+			// "- key: value" starts a map whose keys line up with key.
+			lines[i] = line{indent: indent + len(l.text) - len(rest), text: rest, n: l.n}
+			v, next, err := parseMap(lines, i, lines[i].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			list, i = append(list, v), next
+		default:
+			v, err := scalar(rest, l.n)
+			if err != nil {
+				return nil, 0, err
+			}
+			list = append(list, v)
+			i++
+		}
+	}
+	return list, i, nil
+}
+
+func parseMap(lines []line, i, indent int) (interface{}, int, error) {
+	m := map[string]interface{}{}
+	for i < len(lines) && lines[i].indent == indent {
+		l := lines[i]
+		k := mapKey(l.text)
+		if k < 0 {
+			return nil, 0, fmt.Errorf("line %d: want key: value", l.n)
+		}
+		key, rest := strings.TrimSpace(l.text[:k]), strings.TrimSpace(l.text[k+1:])
+		if unq, err := unquote(key); err == nil {
+			key = unq
+		}
+		if _, dup := m[key]; dup {
+			return nil, 0, fmt.Errorf("line %d: duplicate key %q", l.n, key)
+		}
+		i++
+		if rest != "" {
+			v, err := scalar(rest, l.n)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			continue
+		}
+		switch {
+		case i < len(lines) && lines[i].indent > indent:
+			v, next, err := parseBlock(lines, i, lines[i].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key], i = v, next
+		case i < len(lines) && lines[i].indent == indent && isItem(lines[i].text):
+			// A list may sit at the same indentation as its key.
+			v, next, err := parseList(lines, i, indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key], i = v, next
+		default:
+			m[key] = ""
+		}
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, 0, fmt.Errorf("line %d: unexpected indentation", lines[i].n)
+	}
+	return m, i, nil
+}
+
+// mapKey returns the offset of the colon ending the key of a "key: value"
+// or "key:" line, or -1 if text is not one.
+func mapKey(text string) int {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case i == 0 && (c == '"' || c == '\''):
+			quote = c
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+// scalar parses a value: a quoted string, an empty list or map, or plain
+// text.
+func scalar(text string, n int) (interface{}, error) {
+	switch text {
+	case "[]":
+		return []interface{}{}, nil
+	case "{}":
+		return map[string]interface{}{}, nil
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		s, err := unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid quoted string %s", n, text)
+		}
+		return s, nil
+	}
+	return text, nil
+}
+
+func unquote(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	if len(s) >= 2 && s[0] == '"' {
+		return strconv.Unquote(s)
+	}
+	return "", fmt.Errorf("not quoted")
+}
+
+// stripComment removes a comment, a # at the start of the line or after
+// a space, outside any quoted string.
+func stripComment(l string) string {
+	quote := byte(0)
+	for i := 0; i < len(l); i++ {
+		c := l[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" \t-:[", rune(l[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || l[i-1] == ' ' || l[i-1] == '\t'):
+			return l[:i]
+		}
+	}
+	return l
+}
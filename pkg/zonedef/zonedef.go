@@ -0,0 +1,324 @@
+// Package zonedef compiles structured zone definitions, written in JSON
+// or YAML, into the records of a zone, so that zones can be kept as data
+// and master files generated from them.
+//
+// A definition names the zone, its default TTL, its SOA and its records:
+//
+//	zone: example.com.
+//	ttl: 3600
+//	soa:
+//	  ns: ns1.example.com.
+//	  mbox: hostmaster@example.com
+//	records:
+//	  - name: "@"
+//	    type: NS
+//	    value: ns1
+//	  - name: www
+//	    type: A
+//	    ttl: 300
+//	    value: 192.0.2.1
+//
+// Names, and names within record data, are relative to the zone unless
+// they end in a dot. The SOA may instead be given among the records.
+//
+// The output of "dnsup export", a list of master files each holding a
+// list of zones, is a definition too if it holds a single zone: its
+// records, SOA among them, are those of the zone, and its serial and NS
+// lists, which they repeat, are ignored. Keys other than these are
+// errors, so that a misspelt one does not silently drop data.
+package zonedef
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Defaults for the SOA fields a definition leaves out.
+const (
+	DefaultTTL     = 3600
+	DefaultRefresh = 7200
+	DefaultRetry   = 3600
+	DefaultExpire  = 1209600
+	DefaultMinimum = 300
+)
+
+// Zone is a parsed zone definition.
+type Zone struct {
+	Origin  string
+	TTL     uint32
+	SOA     *SOA // nil if the SOA is among Records
+	Records []Record
+}
+
+// SOA holds the fields of a zone's SOA record. A zero Serial is left for
+// the caller to choose.
+type SOA struct {
+	NS, Mbox                                string
+	Serial, Refresh, Retry, Expire, Minimum uint32
+}
+
+// Record is one record of a definition. A zero TTL means the zone's.
+type Record struct {
+	Name, Type, Value string
+	TTL               uint32
+}
+
+// Parse parses a definition in format "json" or "yaml".
+func Parse(data []byte, format string) (*Zone, error) {
+	var tree interface{}
+	switch format {
+	case "json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&tree); err != nil {
+			return nil, err
+		}
+	case "yaml":
+		var err error
+		if tree, err = parseYAML(string(data)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q: want json or yaml", format)
+	}
+	switch t := tree.(type) {
+	case []interface{}:
+		return fromExport(t)
+	case map[string]interface{}:
+		if err := known(t, "zone", "ttl", "soa", "records"); err != nil {
+			return nil, err
+		}
+		return fromTree(t)
+	}
+	return nil, fmt.Errorf("definition is neither a map nor a list of files")
+}
+
+// fromExport returns the zone of files, the output of "dnsup export",
+// which must hold exactly one.
+func fromExport(files []interface{}) (*Zone, error) {
+	var zones []map[string]interface{}
+	for i, v := range files {
+		f, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("file %d: want a map", i+1)
+		}
+		if err := known(f, "file", "zones"); err != nil {
+			return nil, fmt.Errorf("file %d: %v", i+1, err)
+		}
+		list, _ := f["zones"].([]interface{})
+		if _, ok := f["zones"]; ok && list == nil {
+			return nil, fmt.Errorf("file %d: zones: want a list", i+1)
+		}
+		for j, v := range list {
+			z, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("file %d: zone %d: want a map", i+1, j+1)
+			}
+			if err := known(z, "zone", "serial", "ns", "records"); err != nil {
+				return nil, fmt.Errorf("file %d: zone %d: %v", i+1, j+1, err)
+			}
+			zones = append(zones, z)
+		}
+	}
+	if len(zones) != 1 {
+		return nil, fmt.Errorf("export holds %d zones, not one", len(zones))
+	}
+	return fromTree(zones[0])
+}
+
+// known reports an error if m has keys other than keys.
+func known(m map[string]interface{}, keys ...string) error {
+	var unknown []string
+	for k := range m {
+		found := false
+		for _, key := range keys {
+			if k == key {
+				found = true
+			}
+		}
+		if !found {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func fromTree(top map[string]interface{}) (*Zone, error) {
+	z := &Zone{TTL: DefaultTTL}
+	origin, err := str(top, "zone")
+	if err != nil {
+		return nil, err
+	}
+	if origin == "" {
+		return nil, fmt.Errorf("missing zone")
+	}
+	z.Origin = dns.Fqdn(strings.ToLower(origin))
+	if ttl, ok, err := num(top, "ttl"); err != nil {
+		return nil, err
+	} else if ok {
+		z.TTL = ttl
+	}
+
+	if v, ok := top["soa"]; ok {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("soa: want a map")
+		}
+		if err := known(m, "ns", "mbox", "serial", "refresh", "retry", "expire", "minimum"); err != nil {
+			return nil, fmt.Errorf("soa: %v", err)
+		}
+		soa := &SOA{Refresh: DefaultRefresh, Retry: DefaultRetry, Expire: DefaultExpire, Minimum: DefaultMinimum}
+		if soa.NS, err = str(m, "ns"); err != nil {
+			return nil, fmt.Errorf("soa: %v", err)
+		}
+		if soa.Mbox, err = str(m, "mbox"); err != nil {
+			return nil, fmt.Errorf("soa: %v", err)
+		}
+		if soa.NS == "" || soa.Mbox == "" {
+			return nil, fmt.Errorf("soa: missing ns or mbox")
+		}
+		for key, p := range map[string]*uint32{"serial": &soa.Serial, "refresh": &soa.Refresh, "retry": &soa.Retry, "expire": &soa.Expire, "minimum": &soa.Minimum} {
+			if n, ok, err := num(m, key); err != nil {
+				return nil, fmt.Errorf("soa: %v", err)
+			} else if ok {
+				*p = n
+			}
+		}
+		z.SOA = soa
+	}
+
+	list, _ := top["records"].([]interface{})
+	if _, ok := top["records"]; ok && list == nil {
+		return nil, fmt.Errorf("records: want a list")
+	}
+	for i, v := range list {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record %d: want a map", i+1)
+		}
+		if err := known(m, "name", "type", "ttl", "value"); err != nil {
+			return nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+		var rec Record
+		for key, p := range map[string]*string{"name": &rec.Name, "type": &rec.Type, "value": &rec.Value} {
+			if *p, err = str(m, key); err != nil {
+				return nil, fmt.Errorf("record %d: %v", i+1, err)
+			}
+		}
+		if rec.TTL, _, err = num(m, "ttl"); err != nil {
+			return nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+		if rec.Type == "" || rec.Value == "" {
+			return nil, fmt.Errorf("record %d: missing type or value", i+1)
+		}
+		if rec.Name == "" {
+			rec.Name = "@"
+		}
+		z.Records = append(z.Records, rec)
+	}
+	return z, nil
+}
+
+// str returns the scalar m[key] as a string, or "" if it is absent.
+func str(m map[string]interface{}, key string) (string, error) {
+	switch v := m[key].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("%s: want a string", key)
+}
+
+// num returns m[key] as a 32-bit unsigned number, reporting whether it is
+// present.
+func num(m map[string]interface{}, key string) (uint32, bool, error) {
+	s, err := str(m, key)
+	if err != nil || s == "" {
+		return 0, false, err
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: invalid number %q", key, s)
+	}
+	return uint32(n), true, nil
+}
+
+// RRs returns the zone's records, its SOA first.
+func (z *Zone) RRs() ([]dns.RR, error) {
+	var rrs []dns.RR
+	if z.SOA != nil {
+		mbox := z.SOA.Mbox
+		if i := strings.IndexByte(mbox, '@'); i >= 0 {
+			mbox = strings.Replace(mbox[:i], ".", "\\.", -1) + "." + dns.Fqdn(mbox[i+1:])
+		}
+		s := z.SOA
+		soa, err := parseRR(fmt.Sprintf("@ %d IN SOA %s %s %d %d %d %d %d", z.TTL, s.NS, mbox, s.Serial, s.Refresh, s.Retry, s.Expire, s.Minimum), z.Origin)
+		if err != nil {
+			return nil, fmt.Errorf("soa: %v", err)
+		}
+		rrs = append(rrs, soa)
+	}
+	for i, rec := range z.Records {
+		ttl := rec.TTL
+		if ttl == 0 {
+			ttl = z.TTL
+		}
+		rr, err := parseRR(fmt.Sprintf("%s %d IN %s %s", rec.Name, ttl, strings.ToUpper(rec.Type), rec.Value), z.Origin)
+		if err != nil {
+			return nil, fmt.Errorf("record %d (%s %s): %v", i+1, rec.Name, rec.Type, err)
+		}
+		if rr.Header().Rrtype == dns.TypeSOA {
+			if len(rrs) > 0 && rrs[0].Header().Rrtype == dns.TypeSOA {
+				return nil, fmt.Errorf("record %d: a second SOA", i+1)
+			}
+			rrs = append([]dns.RR{rr}, rrs...)
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	if len(rrs) == 0 || rrs[0].Header().Rrtype != dns.TypeSOA {
+		return nil, fmt.Errorf("%s: no SOA: give soa or a SOA record", z.Origin)
+	}
+	if !strings.EqualFold(rrs[0].Header().Name, z.Origin) {
+		return nil, fmt.Errorf("%s: SOA is for %s", z.Origin, rrs[0].Header().Name)
+	}
+	for _, rr := range rrs {
+		if !dns.IsSubDomain(z.Origin, strings.ToLower(rr.Header().Name)) {
+			return nil, fmt.Errorf("%s is outside %s", rr.Header().Name, z.Origin)
+		}
+	}
+	return rrs, nil
+}
+
+// parseRR parses one record in master file syntax, with names relative to
+// origin.
+func parseRR(text, origin string) (dns.RR, error) {
+	var rr dns.RR
+	for t := range dns.ParseZone(strings.NewReader(text+"\n"), origin, "") {
+		if t.Error != nil {
+			return nil, t.Error
+		}
+		if rr == nil {
+			rr = t.RR
+		}
+	}
+	if rr == nil {
+		return nil, fmt.Errorf("no record")
+	}
+	return rr, nil
+}
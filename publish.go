@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// registryPrefix is prepended to a published host to name the TXT record
+// recording that dnsup owns the host's records, and for which resource.
+const registryPrefix = "_dnsup."
+
+// endpoint is a host to publish and what it should resolve to: addresses
+// for A and AAAA records, or a single host name for a CNAME.
+type endpoint struct {
+	host     string
+	targets  []string
+	ttl      uint32 // zero for the records' current TTL, else the SOA's
+	resource string // such as ingress/default/web
+}
+
+// publisher keeps the records of the hosts published by a source of
+// endpoints, such as the kube and docker controllers, in step with it.
+// Each host it publishes is marked by a TXT record at _dnsup.<host>
+// naming the owner and resource. Hosts with records but no marker of the
+// owner's are left alone, and the records of hosts no longer published
+// are removed.
+type publisher struct {
+	cfg    *config
+	actor  string // for the audit log
+	owner  string
+	warned map[string]bool
+}
+
+// publishedChange is a change made by a publisher, to report once
+// written.
+type publishedChange struct {
+	name   string
+	rrtype uint16
+	old    []string
+	value  string
+}
+
+func newPublisher(cfg *config, actor, owner string) *publisher {
+	return &publisher{cfg: cfg, actor: actor, owner: owner, warned: map[string]bool{}}
+}
+
+// reconcile updates the zones to publish eps, and no other hosts.
+func (p *publisher) reconcile(eps []endpoint) error {
+	desired := map[string]endpoint{}
+	for _, ep := range eps {
+		if prev, ok := desired[ep.host]; ok {
+			p.warnOnce(ep.host+ep.resource, "%s: published by both %s and %s; using %s", ep.host, prev.resource, ep.resource, prev.resource)
+			continue
+		}
+		desired[ep.host] = ep
+	}
+	return reapply(func() error { return p.sync(desired) })
+}
+
+// sync makes the records of the hosts the publisher owns match desired.
+func (p *publisher) sync(desired map[string]endpoint) error {
+	db, err := p.cfg.newDB()
+	if err == nil {
+		err = db.Load(p.cfg.zoneFiles()...)
+	}
+	if err != nil {
+		return err
+	}
+	owned := p.owned(db)
+
+	var changes []publishedChange
+	for host, ep := range desired {
+		if db.Zone(host) == nil {
+			p.warnOnce(host, "%s (%s) is in none of the zones", host, ep.resource)
+			continue
+		}
+		if _, ok := owned[host]; !ok && (len(publishedRecords(db, host)) > 0 || len(db.Lookup(registryPrefix+host, dns.TypeTXT)) > 0) {
+			p.warnOnce(host, "%s (%s) already has records not published by this controller; leaving them", host, ep.resource)
+			continue
+		}
+		cs, err := p.publish(db, ep)
+		if err != nil {
+			p.warnOnce(host+err.Error(), "%s (%s): %v", host, ep.resource, err)
+		}
+		changes = append(changes, cs...)
+	}
+	for host, resource := range owned {
+		if _, ok := desired[host]; ok {
+			continue
+		}
+		cs, err := p.unpublish(db, host, resource)
+		if err != nil {
+			logging.Warnf("%s: %v", host, err)
+		}
+		changes = append(changes, cs...)
+	}
+
+	if !db.Dirty() {
+		return nil
+	}
+	if err := commit(p.cfg, db, nil); err != nil {
+		return err
+	}
+	if !p.cfg.DryRun {
+		for _, c := range changes {
+			recordChanged(p.cfg, db, p.actor, c.name, c.rrtype, c.old, c.value)
+		}
+	}
+	return nil
+}
+
+// owned returns the hosts whose TXT markers name the publisher's owner,
+// with the marked resource.
+func (p *publisher) owned(db *zonedb.DB) map[string]string {
+	prefix := p.marker("")
+	hosts := map[string]string{}
+	for _, rr := range db.Records() {
+		txt, ok := rr.(*dns.TXT)
+		name := strings.ToLower(rr.Header().Name)
+		if !ok || !strings.HasPrefix(name, registryPrefix) {
+			continue
+		}
+		if v := strings.Join(txt.Txt, ""); strings.HasPrefix(v, prefix) {
+			hosts[strings.TrimPrefix(name, registryPrefix)] = strings.TrimPrefix(v, prefix)
+		}
+	}
+	return hosts
+}
+
+// marker returns the data of the TXT marker for resource.
+func (p *publisher) marker(resource string) string {
+	return "heritage=dnsup,dnsup/owner=" + p.owner + ",dnsup/resource=" + resource
+}
+
+// publish sets the records of ep's host, and its marker.
+func (p *publisher) publish(db *zonedb.DB, ep endpoint) ([]publishedChange, error) {
+	want := map[uint16][]string{}
+	for _, t := range ep.targets {
+		switch ip := net.ParseIP(t); {
+		case ip == nil:
+			want[dns.TypeCNAME] = []string{dns.Fqdn(t)}
+		case ip.To4() != nil:
+			want[dns.TypeA] = append(want[dns.TypeA], ip.String())
+		default:
+			want[dns.TypeAAAA] = append(want[dns.TypeAAAA], ip.String())
+		}
+	}
+	if want[dns.TypeCNAME] != nil {
+		if len(ep.targets) > 1 {
+			return nil, fmt.Errorf("cannot mix the host name and addresses of %v", ep.targets)
+		}
+		if auth := db.Zone(ep.host); auth != nil && auth.Domain() == ep.host {
+			return nil, fmt.Errorf("cannot publish a CNAME at the zone apex")
+		}
+	}
+	var ttl *uint32
+	if ep.ttl != 0 {
+		ttl = &ep.ttl
+	}
+
+	var changes []publishedChange
+	// Removals first, so that switching between addresses and a CNAME
+	// never leaves both.
+	for _, rrtype := range []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeAAAA} {
+		if want[rrtype] != nil {
+			continue
+		}
+		if old := values(db.Lookup(ep.host, rrtype)); len(old) > 0 {
+			if _, err := db.DeleteRecords(ep.host, rrtype, ""); err != nil {
+				return changes, err
+			}
+			changes = append(changes, publishedChange{ep.host, rrtype, old, ""})
+		}
+	}
+	sets := []*rrset{{name: registryPrefix + ep.host, rrtype: dns.TypeTXT, values: []string{fmt.Sprintf("%q", p.marker(ep.resource))}, ttl: ttl}}
+	for _, rrtype := range []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeAAAA} {
+		if want[rrtype] != nil {
+			sets = append(sets, &rrset{name: ep.host, rrtype: rrtype, values: want[rrtype], ttl: ttl})
+		}
+	}
+	for _, set := range sets {
+		old := values(db.Lookup(set.name, set.rrtype))
+		if err := setRRset(db, set); err != nil {
+			return changes, err
+		}
+		if value := strings.Join(set.values, ","); set.rrtype != dns.TypeTXT && strings.Join(old, ",") != value {
+			changes = append(changes, publishedChange{set.name, set.rrtype, old, value})
+		}
+	}
+	return changes, nil
+}
+
+// unpublish removes the records of host and its marker for resource.
+func (p *publisher) unpublish(db *zonedb.DB, host, resource string) ([]publishedChange, error) {
+	var changes []publishedChange
+	for _, rrtype := range []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeAAAA} {
+		if old := values(db.Lookup(host, rrtype)); len(old) > 0 {
+			if _, err := db.DeleteRecords(host, rrtype, ""); err != nil {
+				return changes, err
+			}
+			changes = append(changes, publishedChange{host, rrtype, old, ""})
+		}
+	}
+	_, err := db.DeleteRecords(registryPrefix+host, dns.TypeTXT, fmt.Sprintf("%q", p.marker(resource)))
+	return changes, err
+}
+
+// publishedRecords returns the records of host of the types published.
+func publishedRecords(db *zonedb.DB, host string) []dns.RR {
+	var rrs []dns.RR
+	for _, rrtype := range []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeAAAA} {
+		rrs = append(rrs, db.Lookup(host, rrtype)...)
+	}
+	return rrs
+}
+
+// warnOnce logs a warning the first time it is given key, so that a
+// standing problem is not repeated at every resynchronization.
+func (p *publisher) warnOnce(key, format string, args ...interface{}) {
+	if p.warned[key] {
+		return
+	}
+	p.warned[key] = true
+	logging.Warnf(format, args...)
+}
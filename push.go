@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// pushConfig is a remote server that the master files of changed zones
+// are uploaded to with scp or rsync over SSH, for an authoritative server
+// on another host, followed by the reload command run there.
+type pushConfig struct {
+	Host     string   `toml:"host"` // [user@]host
+	Port     int      `toml:"port"`
+	Identity string   `toml:"identity"` // private key file
+	Path     string   `toml:"path"`     // remote directory
+	Method   string   `toml:"method"`   // "scp", the default, or "rsync"
+	Reload   string   `toml:"reload"`
+	Zones    []string `toml:"zones"` // the zones pushed; every zone if empty
+}
+
+// pushList is a flag.Value adding a push by [user@]host:directory.
+type pushList []pushConfig
+
+func (l *pushList) String() string {
+	var s []string
+	for _, pc := range *l {
+		s = append(s, pc.Host+":"+pc.Path)
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *pushList) Set(v string) error {
+	i := strings.LastIndex(v, ":")
+	if i < 1 {
+		return fmt.Errorf("want [user@]host:directory, got %q", v)
+	}
+	*l = append(*l, pushConfig{Host: v[:i], Path: v[i+1:]})
+	return nil
+}
+
+// pushChanged uploads the master file of every modified zone in db to
+// each configured server that takes the zone, once per file, then runs
+// the server's reload command for each zone. The command is run by the
+// remote shell with DNSUP_ZONE, DNSUP_FILE (the remote path) and
+// DNSUP_SERIAL set. Failures are logged and counted in the returned
+// error.
+func pushChanged(cfg *config, db *zonedb.DB) error {
+	failed := 0
+	for _, pc := range cfg.Push {
+		for _, mf := range db.Files() {
+			var zones []*zonedb.Authority
+			for _, auth := range mf.Authorities() {
+				if auth.Dirty() && pc.takes(auth.Domain()) {
+					zones = append(zones, auth)
+				}
+			}
+			if len(zones) == 0 {
+				continue
+			}
+			remote := path.Join(pc.Path, filepath.Base(mf.Name()))
+			if err := pc.run(cfg, "push", pc.upload(mf.Name(), remote)); err != nil {
+				logging.Errorf("pushing %s to %s: %v", mf.Name(), pc.Host, err)
+				failed++
+				continue
+			}
+			if pc.Reload == "" {
+				continue
+			}
+			for _, auth := range zones {
+				script := fmt.Sprintf("DNSUP_ZONE=%s DNSUP_FILE=%s DNSUP_SERIAL=%d; %s",
+					shellQuote(auth.Domain()), shellQuote(remote), auth.SOA().Serial, pc.Reload)
+				if err := pc.run(cfg, "push", append(pc.ssh(), pc.Host, script)); err != nil {
+					logging.Errorf("reloading %s on %s: %v", auth.Domain(), pc.Host, err)
+					failed++
+				}
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d push(es) failed", failed)
+	}
+	return nil
+}
+
+// takes reports whether the server takes zone.
+func (pc *pushConfig) takes(zone string) bool {
+	if len(pc.Zones) == 0 {
+		return true
+	}
+	for _, z := range pc.Zones {
+		if strings.EqualFold(dns.Fqdn(z), zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// ssh returns the ssh command and its options.
+func (pc *pushConfig) ssh() []string {
+	args := []string{"ssh", "-o", "BatchMode=yes"}
+	if pc.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(pc.Port))
+	}
+	if pc.Identity != "" {
+		args = append(args, "-i", pc.Identity)
+	}
+	return args
+}
+
+// upload returns the command copying file to remote on the server.
+func (pc *pushConfig) upload(file, remote string) []string {
+	dest := pc.Host + ":" + remote
+	if pc.Method == "rsync" {
+		return []string{"rsync", "-t", "-e", strings.Join(pc.ssh(), " "), file, dest}
+	}
+	args := []string{"scp", "-q", "-o", "BatchMode=yes"}
+	if pc.Port != 0 {
+		args = append(args, "-P", strconv.Itoa(pc.Port))
+	}
+	if pc.Identity != "" {
+		args = append(args, "-i", pc.Identity)
+	}
+	return append(args, file, dest)
+}
+
+// run runs argv under the retry policy of op, returning its output with
+// any error.
+func (pc *pushConfig) run(cfg *config, op string, argv []string) error {
+	return cfg.retryPolicy(op).Do(context.Background(), op, func() error {
+		out, err := exec.Command(argv[0], argv[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %v\n%s", argv[0], err, out)
+		}
+		return nil
+	})
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// restoreOptions are the flags of "dnsup restore".
+type restoreOptions struct {
+	list bool
+	at   string
+	from string
+}
+
+func restoreFlags(fs *flag.FlagSet, c *config) {
+	fs.BoolVar(&c.restore.list, "list", false, "list the backups of each master file instead")
+	fs.StringVar(&c.restore.at, "at", "", "restore the newest backup taken at or before this time")
+	fs.StringVar(&c.restore.from, "backup", "", "restore this backup file, for a single master file")
+}
+
+// runRestore implements "dnsup restore [-list] [-at time] [-backup file]
+// [zonefile...]", putting back the newest backup of each master file, or
+// the newest taken at or before a time. The file being replaced is backed
+// up first, and each restored zone gets a serial above the one it
+// replaces so that secondaries pick it up.
+func runRestore(args []string) {
+	cfg, err := parseConfig("restore", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	cfg.zoneArgs(cfg.Args)
+	if cfg.BackupDir == "" {
+		logging.Fatal("no backup directory: set backup_dir or -backup-dir")
+	}
+	files := cfg.zoneFiles()
+	if len(files) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	if cfg.restore.from != "" && len(files) != 1 {
+		logging.Fatal("-backup restores a single master file")
+	}
+	var until time.Time
+	if cfg.restore.at != "" {
+		if _, until, err = parseRollbackTarget(cfg.restore.at); err != nil || until.IsZero() {
+			logging.Fatalf("invalid -at %q: want a time", cfg.restore.at)
+		}
+	}
+
+	if cfg.restore.list {
+		for _, file := range files {
+			backups, err := zonedb.Backups(cfg.BackupDir, file)
+			if err != nil {
+				logging.Fatal(err)
+			}
+			fmt.Printf("%s:\n", file)
+			for _, b := range backups {
+				fmt.Printf("  %s  %s\n", b.Time.Local().Format(time.RFC3339), b.Path)
+			}
+		}
+		return
+	}
+
+	if cfg.DryRun {
+		logging.Fatal("restore does not support -dry-run; use -list")
+	}
+
+	// The serials being replaced, to keep the restored ones above, if the
+	// files being replaced still parse.
+	serials := map[string]uint32{}
+	cur, err := cfg.newDB()
+	if err == nil {
+		err = cur.Load(files...)
+	}
+	if err != nil {
+		logging.Warnf("not keeping serials: %v", err)
+	} else {
+		for _, mf := range cur.Files() {
+			for _, auth := range mf.Authorities() {
+				serials[auth.Domain()] = auth.SOA().Serial
+			}
+		}
+	}
+
+	for _, file := range files {
+		b, err := pickBackup(cfg.BackupDir, file, cfg.restore.from, until)
+		if err != nil {
+			logging.Fatal(err)
+		}
+		if err := zonedb.BackupFile(cfg.BackupDir, file, cfg.BackupKeep); err != nil {
+			logging.Fatal(err)
+		}
+		if err := zonedb.Restore(b, file); err != nil {
+			logging.Fatal(err)
+		}
+		logging.Infof("restored %s from %s", file, b.Path)
+	}
+
+	cfg.BackupDir = "" // the files were just backed up
+	db := loadZones(cfg)
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			if old, ok := serials[auth.Domain()]; ok && int32(old-auth.SOA().Serial) > 0 {
+				auth.SOA().Serial = old
+			}
+			auth.Touch()
+		}
+	}
+	if err := commit(cfg, db, nil); err != nil {
+		logging.Fatal(err)
+	}
+}
+
+// pickBackup returns the backup of file to restore: path if given, or
+// else the newest at or before until, or the newest if until is zero.
+func pickBackup(dir, file, path string, until time.Time) (zonedb.Backup, error) {
+	if path != "" {
+		return zonedb.Backup{Path: path}, nil
+	}
+	backups, err := zonedb.Backups(dir, file)
+	if err != nil {
+		return zonedb.Backup{}, err
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		if until.IsZero() || !backups[i].Time.After(until) {
+			return backups[i], nil
+		}
+	}
+	return zonedb.Backup{}, fmt.Errorf("no backup of %s in %s", file, dir)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// The exit statuses of an update, for scripts to tell its outcomes apart
+// without reading the log. The other commands exit with 1 on failure and
+// 0 otherwise.
+const (
+	exitChanged      = 0 // records were changed, or would be with -dry-run
+	exitError        = 1
+	exitUnchanged    = 2 // the records already held the addresses
+	exitVerifyFailed = 3 // the changes were made but did not appear with -verify
+	exitPartial      = 4 // master files failed to load with keep_going; the rest were updated
+)
+
+var statuses = map[int]string{
+	exitChanged:      "changed",
+	exitError:        "error",
+	exitUnchanged:    "unchanged",
+	exitVerifyFailed: "verify-failed",
+	exitPartial:      "partial",
+}
+
+// result is the outcome of an update, printed as a JSON line with -quiet.
+// Changes through a provider or server count whether or not the records
+// already held the values, as most backends do not tell.
+type result struct {
+	Status  string         `json:"status"`
+	Code    int            `json:"code"`
+	Changed int            `json:"changed"`
+	Changes []resultChange `json:"changes,omitempty"`
+	Zones   []string       `json:"zones,omitempty"` // the zones written
+	Error   string         `json:"error,omitempty"`
+
+	quiet bool
+}
+
+type resultChange struct {
+	Name string   `json:"name"`
+	Type string   `json:"type"`
+	Old  []string `json:"old,omitempty"`
+	New  string   `json:"new"`
+}
+
+// outcome collects the result of the update, across views.
+var outcome result
+
+// noteChange adds a change of the rrtype records of name to the result.
+func noteChange(name string, rrtype uint16, old []string, value string) {
+	outcome.Changed++
+	outcome.Changes = append(outcome.Changes, resultChange{Name: name, Type: dns.TypeToString[rrtype], Old: old, New: value})
+}
+
+// noteZones adds the modified zones of db to the result, so that changes
+// the templates make count too.
+func noteZones(db *zonedb.DB) {
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			if auth.Dirty() {
+				outcome.Zones = append(outcome.Zones, auth.Domain())
+			}
+		}
+	}
+}
+
+// succeed ends an update that went through, with exitChanged or
+// exitUnchanged.
+func succeed() {
+	if outcome.Changed > 0 || len(outcome.Zones) > 0 {
+		finish(exitChanged, "")
+	}
+	finish(exitUnchanged, "")
+}
+
+// finish ends the update with code, printing the result with -quiet. msg
+// is the error of a failure.
+func finish(code int, msg string) {
+	outcome.Code = code
+	outcome.Status = statuses[code]
+	outcome.Error = msg
+	if outcome.quiet {
+		json.NewEncoder(os.Stdout).Encode(outcome)
+	}
+	os.Exit(code)
+}
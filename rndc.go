@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// showzoneFile finds the file of a zone in rndc showzone output, such as
+//
+//	zone "example.com" { type primary; file "/var/lib/bind/db.example.com"; };
+var showzoneFile = regexp.MustCompile(`\bfile\s+"([^"]*)"`)
+
+// rndcChanged has BIND serve the new contents of every modified zone in
+// db through rndc: zones it does not know are added with addzone, which
+// needs allow-new-zones, zones it loads from another file are pointed at
+// this one with modzone, and the rest are reloaded. Failures are logged
+// with rndc's output and counted in the returned error.
+func rndcChanged(cfg *config, db *zonedb.DB) error {
+	if cfg.Rndc == "" {
+		return nil
+	}
+	failed := 0
+	for _, mf := range db.Files() {
+		file, err := filepath.Abs(mf.Name())
+		if err != nil {
+			return err
+		}
+		for _, auth := range mf.Authorities() {
+			if !auth.Dirty() {
+				continue
+			}
+			if err := rndcZone(cfg.Rndc, strings.TrimSuffix(auth.Domain(), "."), file); err != nil {
+				logging.Errorf("rndc for %s: %v", auth.Domain(), err)
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("rndc failed for %d zone(s)", failed)
+	}
+	return nil
+}
+
+// rndcZone adds, modifies or reloads zone, served from file.
+func rndcZone(command, zone, file string) error {
+	conf := fmt.Sprintf(`{ type primary; file "%s"; };`, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(file))
+	out, err := rndc(command, "showzone", zone)
+	switch {
+	case err != nil && strings.Contains(out, "not found"):
+		_, err = rndc(command, "addzone", zone, conf)
+	case err != nil:
+	case zoneFile(out) != "" && zoneFile(out) != file:
+		_, err = rndc(command, "modzone", zone, conf)
+	default:
+		_, err = rndc(command, "reload", zone)
+	}
+	return err
+}
+
+// zoneFile returns the file named in rndc showzone output.
+func zoneFile(out string) string {
+	if m := showzoneFile.FindStringSubmatch(out); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// rndc runs the rndc command, such as "rndc -s 127.0.0.1 -k rndc.key",
+// with args, returning its output, which is also in the error if it fails.
+func rndc(command string, args ...string) (string, error) {
+	argv := append(strings.Fields(command), args...)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("rndc %s: %v: %s", args[0], err, bytes.TrimSpace(out.Bytes()))
+	}
+	return out.String(), nil
+}
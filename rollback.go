@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/audit"
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// rollbackOptions are the flags of "dnsup rollback".
+type rollbackOptions struct {
+	to string
+}
+
+func rollbackFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.rollback.to, "to", "", "serial, or time such as 2006-01-02T15:04:05Z or \"2006-01-02 15:04\", to roll back to")
+	fs.StringVar(&c.Zone, "zone", c.Zone, "zone to roll back")
+}
+
+// runRollback implements "dnsup rollback -zone zone -to serial|time
+// [zonefile...]", writing the zone as it was at a serial or a time, under
+// a new serial. The zone's journal is used if it reaches back far enough,
+// and otherwise the audit log.
+func runRollback(args []string) {
+	cfg, err := parseConfig("rollback", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	zone := cfg.Zone
+	if zone == "" || cfg.rollback.to == "" {
+		logging.Fatal("usage: dnsup rollback -zone zone -to serial|time [flags] [zonefile...]")
+	}
+	zone = dns.Fqdn(strings.ToLower(zone))
+	cfg.zoneArgs(cfg.Args)
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	serial, at, err := parseRollbackTarget(cfg.rollback.to)
+	if err != nil {
+		logging.Fatal(err)
+	}
+
+	cfg.Journal = true // the rollback is itself a change to journal
+	db := loadZones(cfg)
+	auth := db.Zone(zone)
+	if auth == nil || auth.Domain() != zone {
+		logging.Fatalf("zone %s is not loaded", zone)
+	}
+	journal, err := db.Journal(zone)
+	if err != nil {
+		logging.Fatal(err)
+	}
+
+	ok := false
+	if at.IsZero() {
+		_, ok = zonedb.Since(journal, serial)
+		ok = ok || serial == auth.SOA().Serial
+	} else {
+		serial, ok = serialAt(journal, auth.SOA().Serial, at)
+	}
+	if ok {
+		err = rollbackZone(db, auth, journal, serial)
+	} else if cfg.AuditLog != "" {
+		err = rollbackAudit(cfg, db, auth, serial, at)
+	} else {
+		err = fmt.Errorf("%s: journal does not reach back to %s and there is no audit log", zone, cfg.rollback.to)
+	}
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if err := commit(cfg, db, nil); err != nil {
+		logging.Fatal(err)
+	}
+}
+
+// parseRollbackTarget parses s as a serial or else as a time.
+func parseRollbackTarget(s string) (uint32, time.Time, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(n), time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return 0, t, nil
+		}
+	}
+	return 0, time.Time{}, fmt.Errorf("invalid -to %q: want a serial or a time", s)
+}
+
+// serialAt returns the serial the zone had at t according to journal,
+// reporting false if the journal starts after t.
+func serialAt(journal []*zonedb.Delta, current uint32, t time.Time) (uint32, bool) {
+	if len(journal) == 0 {
+		return current, false
+	}
+	if t.Before(journal[0].Time) {
+		return journal[0].From, false
+	}
+	serial := journal[0].From
+	for _, d := range journal {
+		if d.Time.After(t) {
+			break
+		}
+		serial = d.To
+	}
+	return serial, true
+}
+
+// rollbackAudit undoes the audited changes within auth made after serial
+// or, if at is set, after at, newest first. Each change is undone by
+// giving the name and type their old data back.
+func rollbackAudit(cfg *config, db *zonedb.DB, auth *zonedb.Authority, serial uint32, at time.Time) error {
+	entries, err := audit.Read(cfg.AuditLog, func(e *audit.Entry) bool {
+		if !strings.EqualFold(e.Zone, auth.Domain()) {
+			return false
+		}
+		if !at.IsZero() {
+			return e.Time.After(at)
+		}
+		return e.Serial != 0 && int32(e.Serial-serial) > 0
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := revertEntry(db, auth, entries[i]); err != nil {
+			return err
+		}
+	}
+	logging.Infof("%s: rolled back %d audited changes", auth.Domain(), len(entries))
+	return nil
+}
+
+// revertEntry restores the data e replaced, keeping the TTL of the
+// records it replaces or else using the SOA's.
+func revertEntry(db *zonedb.DB, auth *zonedb.Authority, e *audit.Entry) error {
+	rrtype, ok := dns.StringToType[e.Type]
+	if !ok {
+		return fmt.Errorf("audit entry for %s: unknown type %q", e.Name, e.Type)
+	}
+	ttl := auth.SOA().Hdr.Ttl
+	if rrs := db.Lookup(e.Name, rrtype); len(rrs) > 0 {
+		ttl = rrs[0].Header().Ttl
+	}
+	if _, err := db.DeleteRecords(e.Name, rrtype, ""); err != nil {
+		return err
+	}
+	for _, v := range e.Old {
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", e.Name, ttl, e.Type, v))
+		if err != nil {
+			return fmt.Errorf("audit entry for %s: %v", e.Name, err)
+		}
+		if err := db.AddRecord(rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/tsig"
+)
+
+// secondaryOptions are the flags of "dnsup secondary" kept out of the
+// configuration file.
+type secondaryOptions struct {
+	once bool
+}
+
+func secondaryFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.Primary, "primary", c.Primary, "server to transfer the zones from, as host[:port]")
+	fs.DurationVar(&c.SecondaryRefresh.Duration, "refresh", c.SecondaryRefresh.Duration, "how often to check each zone's serial (default the refresh interval of its SOA)")
+	fs.BoolVar(&c.secondary.once, "once", false, "check every zone once, then exit")
+}
+
+// runSecondary implements "dnsup secondary [flags] [zone=file...]", which
+// keeps master files in step with the zones of a primary server, as a
+// secondary does, for tools that read zone files. Each zone's serial is
+// checked on the primary every refresh interval of its SOA, or every
+// -refresh, and a newer one transferred by IXFR, falling back to AXFR, and
+// written over the file, keeping its comments. The changed zones are then
+// announced as updates are: re-signed, notified and hooked.
+func runSecondary(args []string) {
+	cfg, err := parseConfig("secondary", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	zones := map[string]string{}
+	for zone, file := range cfg.SecondaryZones {
+		zones[dns.Fqdn(strings.ToLower(zone))] = file
+	}
+	for _, arg := range cfg.Args {
+		i := strings.Index(arg, "=")
+		if i < 1 || i == len(arg)-1 {
+			logging.Fatalf("want zone=file, got %q", arg)
+		}
+		zones[dns.Fqdn(strings.ToLower(arg[:i]))] = arg[i+1:]
+	}
+	if cfg.Primary == "" || len(zones) == 0 {
+		logging.Fatal("usage: dnsup secondary -primary server [flags] [zone=file...]")
+	}
+	key, err := cfg.tsigKey()
+	if err != nil {
+		logging.Fatal(err)
+	}
+
+	s := &secondary{cfg: cfg, key: key}
+	for zone, file := range zones {
+		s.zones = append(s.zones, &secondaryZone{name: zone, file: file})
+	}
+	sort.Slice(s.zones, func(i, j int) bool { return s.zones[i].name < s.zones[j].name })
+	for {
+		failed := 0
+		now := time.Now()
+		next := now.Add(time.Hour)
+		for _, z := range s.zones {
+			if z.due.After(now) {
+				if z.due.Before(next) {
+					next = z.due
+				}
+				continue
+			}
+			if err := s.refresh(z); err != nil {
+				logging.Errorf("%s: %v", z.name, err)
+				failed++
+			}
+			z.due = time.Now().Add(s.interval(z, err != nil))
+			if z.due.Before(next) {
+				next = z.due
+			}
+		}
+		flushEvents()
+		if cfg.secondary.once {
+			if failed > 0 {
+				logging.Fatalf("%d zones failed to refresh", failed)
+			}
+			return
+		}
+		time.Sleep(time.Until(next))
+	}
+}
+
+// secondary is the state of "dnsup secondary".
+type secondary struct {
+	cfg   *config
+	key   *tsig.Key
+	zones []*secondaryZone
+}
+
+// secondaryZone is a zone copied from the primary into file.
+type secondaryZone struct {
+	name string
+	file string
+	rrs  []dns.RR  // the records of the file, SOA first; nil until loaded
+	ok   time.Time // when the zone was last found current
+	due  time.Time // when its serial is next checked
+}
+
+func (z *secondaryZone) soa() *dns.SOA {
+	if len(z.rrs) == 0 {
+		return nil
+	}
+	soa, _ := z.rrs[0].(*dns.SOA)
+	return soa
+}
+
+// interval returns how long to wait before checking z again: -refresh,
+// or the SOA's refresh interval, or its retry interval after a failure.
+func (s *secondary) interval(z *secondaryZone, failed bool) time.Duration {
+	soa := z.soa()
+	switch {
+	case s.cfg.SecondaryRefresh.Duration > 0:
+		return s.cfg.SecondaryRefresh.Duration
+	case soa == nil:
+		return time.Minute
+	case failed:
+		if expire := time.Duration(soa.Expire) * time.Second; !z.ok.IsZero() && time.Since(z.ok) > expire {
+			logging.Warnf("%s: not refreshed from %s within the SOA's expiry of %s", z.name, s.cfg.Primary, expire)
+		}
+		return time.Duration(soa.Retry) * time.Second
+	}
+	return time.Duration(soa.Refresh) * time.Second
+}
+
+// refresh transfers z from the primary if the primary's serial is newer
+// than the file's, or the file does not exist, and writes it.
+func (s *secondary) refresh(z *secondaryZone) error {
+	if z.rrs == nil {
+		if err := z.loadFile(s.cfg); err != nil {
+			return err
+		}
+	}
+	serial, err := primarySerial(s.cfg.Primary, z.name, s.key)
+	if err != nil {
+		return err
+	}
+	if soa := z.soa(); soa != nil && int32(serial-soa.Serial) <= 0 {
+		z.ok = time.Now()
+		return nil
+	}
+
+	var rrs []dns.RR
+	if z.soa() != nil {
+		if rrs, err = transferChanges(s.cfg.Primary, z.name, z.rrs, s.key); err != nil {
+			logging.Warnf("%s: %v; transferring the whole zone", z.name, err)
+			rrs = nil
+		}
+	}
+	if rrs == nil {
+		if rrs, err = transferZone(s.cfg.Primary, z.name, s.key); err != nil {
+			return err
+		}
+	}
+
+	db, err := s.cfg.newDB()
+	if err != nil {
+		return err
+	}
+	mf, err := db.Import(z.file, z.name, rrs)
+	if err != nil {
+		return err
+	}
+	if s.cfg.DryRun {
+		printImported(db)
+	} else {
+		if err := db.Write(); err != nil {
+			return err
+		}
+		for _, auth := range mf.Authorities() {
+			auth.Touch() // for announce; the serial is the primary's
+		}
+		if err := announce(s.cfg, db, nil); err != nil {
+			logging.Error(err)
+		}
+		logging.Infof("wrote %s serial %d from %s to %s", z.name, serial, s.cfg.Primary, z.file)
+	}
+	z.rrs, z.ok = rrs, time.Now()
+	return nil
+}
+
+// loadFile reads the records of z from its file, if it exists yet.
+func (z *secondaryZone) loadFile(cfg *config) error {
+	if _, err := os.Stat(z.file); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := cfg.newDB()
+	if err != nil {
+		return err
+	}
+	if err := db.Load(z.file); err != nil {
+		return err
+	}
+	auth := db.Zone(z.name)
+	if auth == nil || !strings.EqualFold(auth.Domain(), z.name) {
+		return fmt.Errorf("%s does not hold %s", z.file, z.name)
+	}
+	z.rrs = auth.Records()
+	return nil
+}
+
+// primarySerial asks server for the serial of zone.
+func primarySerial(server, zone string, key *tsig.Key) (uint32, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	c := &dns.Client{}
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeSOA)
+	if key != nil {
+		c.TsigSecret = map[string]string{key.Name: key.Secret}
+		m.SetTsig(key.Name, key.Algorithm, tsigFudge, time.Now().Unix())
+	}
+	r, _, err := c.Exchange(m, server)
+	if err != nil {
+		return 0, fmt.Errorf("SOA of %s from %s: %v", zone, server, err)
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return 0, fmt.Errorf("SOA of %s from %s: %s", zone, server, dns.RcodeToString[r.Rcode])
+	}
+	for _, rr := range r.Answer {
+		if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, zone) {
+			return soa.Serial, nil
+		}
+	}
+	return 0, fmt.Errorf("SOA of %s from %s: no SOA in the answer", zone, server)
+}
+
+// transferChanges fetches the changes to zone since the serial of cur,
+// its records SOA first, from server by IXFR (RFC 1995), and returns cur
+// with them applied. A server may answer with the whole zone instead,
+// which is returned as it is.
+func transferChanges(server, zone string, cur []dns.RR, key *tsig.Key) ([]dns.RR, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	old := cur[0].(*dns.SOA)
+	t := &dns.Transfer{}
+	m := new(dns.Msg)
+	m.SetIxfr(zone, old.Serial, old.Ns, old.Mbox)
+	if key != nil {
+		t.TsigSecret = map[string]string{key.Name: key.Secret}
+		m.SetTsig(key.Name, key.Algorithm, tsigFudge, time.Now().Unix())
+	}
+	ch, err := t.In(m, server)
+	if err != nil {
+		return nil, fmt.Errorf("ixfr %s from %s: %v", zone, server, err)
+	}
+	var rrs []dns.RR
+	for env := range ch {
+		if env.Error != nil {
+			return nil, fmt.Errorf("ixfr %s from %s: %v", zone, server, env.Error)
+		}
+		rrs = append(rrs, env.RR...)
+	}
+	if len(rrs) == 0 || rrs[0].Header().Rrtype != dns.TypeSOA {
+		return nil, fmt.Errorf("ixfr %s from %s: transfer did not start with a SOA", zone, server)
+	}
+	if len(rrs) == 1 {
+		return cur, nil // already current
+	}
+	if rrs[1].Header().Rrtype != dns.TypeSOA {
+		// the whole zone, as by AXFR
+		if last := len(rrs) - 1; rrs[last].Header().Rrtype == dns.TypeSOA {
+			rrs = rrs[:last]
+		}
+		return rrs, nil
+	}
+
+	// Each change is the old SOA and the records deleted, then the new
+	// SOA and the records added; the new SOA also closes the transfer.
+	ident := func(rr dns.RR) string {
+		c := dns.Copy(rr)
+		c.Header().Ttl = 0
+		c.Header().Name = strings.ToLower(c.Header().Name)
+		return c.String()
+	}
+	var order []string
+	recs := map[string]dns.RR{}
+	for _, rr := range cur[1:] {
+		k := ident(rr)
+		order = append(order, k)
+		recs[k] = rr
+	}
+	adding := false
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			adding = !adding
+			continue
+		}
+		k := ident(rr)
+		if !adding {
+			delete(recs, k)
+			continue
+		}
+		if _, ok := recs[k]; !ok {
+			order = append(order, k)
+		}
+		recs[k] = rr
+	}
+	out := []dns.RR{rrs[0]}
+	for _, k := range order {
+		if rr, ok := recs[k]; ok {
+			out = append(out, rr)
+			delete(recs, k)
+		}
+	}
+	return out, nil
+}
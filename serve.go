@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/dyndns"
+	"github.com/johnweldon/dnsup/pkg/grpcapi"
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/tsig"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// serveUser is an account allowed to update records through the server.
+// The password is plain text or "sha256:" followed by the hex digest; the
+// user may update only the listed host names, or any if there are none.
+type serveUser struct {
+	Password string   `toml:"password"`
+	Hosts    []string `toml:"hosts"`
+}
+
+func (u serveUser) check(password string) bool {
+	return checkSecret(u.Password, password)
+}
+
+func (u serveUser) allowed(name string) bool {
+	if len(u.Hosts) == 0 {
+		return true
+	}
+	for _, h := range u.Hosts {
+		if strings.EqualFold(dns.Fqdn(h), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// server applies updates received over HTTP or gRPC to the master files.
+// Updates are applied one at a time, each to freshly loaded zones; with
+// -dns the zones as last written are also served to DNS clients.
+type server struct {
+	// cfgMu guards cfg, keys and transferNets, which a reload replaces
+	// while also holding mu, so code holding mu may read them directly.
+	cfgMu sync.RWMutex
+	cfg   *config
+	keys  *tsig.Keyring
+	hub   *grpcapi.Hub
+	mu    sync.Mutex
+
+	liveMu sync.RWMutex
+	live   *zonedb.DB
+
+	transferNets []*net.IPNet
+	health       *health
+}
+
+func serveFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.Listen, "listen", c.Listen, "address to accept HTTP requests on")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "serve HTTPS with this certificate file")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "private key file for -tls-cert")
+	fs.BoolVar(&c.ServeDynDNS, "dyndns", c.ServeDynDNS, "serve the dyndns2 /nic/update endpoint for routers and DDNS clients")
+	fs.BoolVar(&c.ServeAPI, "api", c.ServeAPI, "serve the JSON REST API")
+	fs.Var((*stringList)(&c.APIKeys), "api-key", "key accepted by the REST and gRPC APIs (repeatable)")
+	fs.StringVar(&c.GRPCListen, "grpc", c.GRPCListen, "serve the gRPC API on this address")
+	fs.StringVar(&c.DNSListen, "dns", c.DNSListen, "answer DNS queries for the zones on this address, such as :53")
+	fs.Var((*stringList)(&c.TransferAllow), "transfer-allow", "address or CIDR prefix allowed AXFR and IXFR with -dns without TSIG (repeatable)")
+}
+
+// runServe implements "dnsup serve [-dyndns] [-api] [-grpc addr]
+// [-dns addr] [zonefile...]". SIGHUP reloads the configuration and the
+// zones; SIGTERM and SIGINT stop it once the requests in flight are
+// answered.
+func runServe(args []string) {
+	cfg, err := serveConfig(args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+
+	keys, err := cfg.keyring()
+	if err != nil {
+		logging.Fatal(err)
+	}
+	s := &server{cfg: cfg, keys: keys, hub: grpcapi.NewHub(), health: newHealth(cfg, 0)}
+	if cfg.GRPCListen != "" {
+		startGRPC(s)
+	}
+	if cfg.DNSListen != "" {
+		s.startDNS()
+	}
+	mux := http.NewServeMux()
+	s.health.register(mux)
+	s.health.watchdog()
+	if cfg.ServeDynDNS {
+		if len(cfg.Users) == 0 && len(keys.Names()) == 0 {
+			logging.Fatal("no users or TSIG keys configured for -dyndns")
+		}
+		mux.Handle("/nic/update", &dyndns.Server{Authenticate: s.authenticate, Update: s.dyndnsUpdate})
+	}
+	if cfg.ServeAPI {
+		if len(cfg.APIKeys) == 0 && len(keys.Names()) == 0 {
+			logging.Fatal("no API or TSIG keys configured for -api")
+		}
+		api := s.apiHandler()
+		mux.Handle("/zones", api)
+		mux.Handle("/zones/", api)
+		mux.Handle("/records/", api)
+	}
+	reload := func() error {
+		cfg, err := serveConfig(args)
+		if err == nil {
+			err = s.reload(cfg)
+		}
+		if err == nil {
+			s.health.setConfig(cfg, 0)
+		}
+		return err
+	}
+	if !cfg.ServeDynDNS && !cfg.ServeAPI {
+		if cfg.GRPCListen == "" && cfg.DNSListen == "" {
+			logging.Fatal("nothing to serve: use -dyndns, -api, -grpc or -dns")
+		}
+		warnUnusedSockets()
+		s.handleSignals(reload, nil)
+	}
+
+	srv := &http.Server{
+		Addr:         cfg.Listen,
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: time.Minute,
+	}
+	l, err := listenTCP("http", cfg.Listen)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	warnUnusedSockets()
+	logging.Infof("listening on %s", cfg.Listen)
+	sdStatus("listening on %s", cfg.Listen)
+	go s.handleSignals(reload, srv)
+	if cfg.TLSCert != "" {
+		err = srv.ServeTLS(l, cfg.TLSCert, cfg.TLSKey)
+	} else {
+		err = srv.Serve(l)
+	}
+	if err == http.ErrServerClosed {
+		select {} // handleSignals exits once shut down
+	}
+	logging.Fatal(err)
+}
+
+// serveConfig parses the configuration of "dnsup serve", at startup and
+// on reload.
+func serveConfig(args []string) (*config, error) {
+	cfg, err := parseConfig("serve", args)
+	if err != nil {
+		return nil, err
+	}
+	cfg.zoneArgs(cfg.Args)
+	if len(cfg.Zones) < 1 {
+		return nil, fmt.Errorf("missing master file name")
+	}
+	return cfg, nil
+}
+
+// startGRPC serves the gRPC API in the background, exiting if it fails.
+func startGRPC(s *server) {
+	if len(s.cfg.APIKeys) == 0 {
+		logging.Fatal("no API keys configured for -grpc")
+	}
+	lis, err := listenTCP("grpc", s.cfg.GRPCListen)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	go func() {
+		logging.Fatal(s.serveGRPC(lis))
+	}()
+	logging.Infof("serving gRPC on %s", s.cfg.GRPCListen)
+}
+
+// authenticate checks the credentials of a configured user or, failing
+// that, of a keyring key named user with its base64 secret as password.
+// Keys may update any host.
+func (s *server) authenticate(user, password string) bool {
+	if u, ok := s.config().Users[user]; ok {
+		return u.check(password)
+	}
+	k := s.keyring().Get(user)
+	return k != nil && subtle.ConstantTimeCompare([]byte(k.Secret), []byte(password)) == 1
+}
+
+// dyndnsUpdate points the address records of host at ip, answering with
+// a dyndns2 return code.
+func (s *server) dyndnsUpdate(user, host, ip string) string {
+	name := strings.ToLower(dns.Fqdn(host))
+	if _, ok := dns.IsDomainName(name); !ok || dns.CountLabel(name) < 2 {
+		return dyndns.NotFQDN
+	}
+	if !s.config().Users[user].allowed(name) {
+		return dyndns.NoHost
+	}
+	rrtype, err := addressType(ip)
+	if err != nil {
+		return dyndns.DNSErr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var code string
+	err = reapply(func() (err error) {
+		code, err = s.dyndnsApply(user, name, rrtype, ip)
+		return err
+	})
+	if err != nil {
+		logging.Error(err)
+	}
+	return code
+}
+
+// dyndnsApply is the part of dyndnsUpdate made with the server's lock
+// held, returning the code and any error to log.
+func (s *server) dyndnsApply(user, name string, rrtype uint16, ip string) (string, error) {
+	db, err := s.load()
+	if err != nil {
+		return dyndns.DNSErr, err
+	}
+	old := values(db.Lookup(name, rrtype))
+	if len(old) == 0 {
+		return dyndns.NoHost, nil
+	}
+	if err := db.UpdateRecord(name, rrtype, ip); err != nil {
+		return dyndns.DNSErr, err
+	}
+	if err := clampTTL(s.cfg, db, name, rrtype); err != nil {
+		return dyndns.DNSErr, err
+	}
+	if !db.Dirty() {
+		return dyndns.NoChange, nil
+	}
+	if s.cfg.DryRun {
+		return dyndns.Good, db.Diff(os.Stdout)
+	}
+	if err := db.Write(); err != nil {
+		return dyndns.DNSErr, err
+	}
+	s.health.wrote()
+	recordChanged(s.cfg, db, "dyndns:"+user, name, rrtype, old, ip)
+	s.setLive(db)
+	s.publish(db, name, rrtype)
+	if err := announce(s.cfg, db, []update{{domain: name, ip: ip}}); err != nil {
+		logging.Error(err)
+	}
+	return dyndns.Good, nil
+}
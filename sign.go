@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/johnweldon/dnsup/pkg/dnssec"
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// signedPath returns where the signed copy of a master file is written.
+func signedPath(mf *zonedb.MasterFile) string {
+	return mf.Name() + ".signed"
+}
+
+// signChanged re-signs the master files holding a modified zone with
+// DNSSEC keys, writing the signed zones to <file>.signed beside them. With
+// dnssec_signer the command is run for each modified zone instead, with
+// the hook environment plus the zone's key files in DNSUP_KEYS and the
+// file to write in DNSUP_SIGNED.
+func signChanged(cfg *config, db *zonedb.DB) error {
+	if cfg.DNSSECSigner != "" {
+		return runSigner(cfg, db)
+	}
+	if len(cfg.DNSSECKeys) == 0 {
+		return nil
+	}
+	keys, err := dnssec.LoadKeys(cfg.DNSSECKeys)
+	if err != nil {
+		return err
+	}
+	opt := dnssec.Options{
+		Inception:  time.Now().Add(-time.Hour),
+		Expiration: time.Now().Add(cfg.DNSSECValidity.Duration),
+		NSEC3:      cfg.DNSSECNSEC3,
+	}
+	for _, mf := range db.Files() {
+		changed := false
+		for _, auth := range mf.Authorities() {
+			if auth.Dirty() && len(dnssec.ForZone(keys, auth.Domain())) > 0 {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		err := zonedb.WriteFile(signedPath(mf), func(w io.Writer) error {
+			for _, auth := range mf.Authorities() {
+				rrs := auth.Records()
+				if zkeys := dnssec.ForZone(keys, auth.Domain()); len(zkeys) > 0 {
+					var err error
+					if rrs, err = dnssec.Sign(auth.Domain(), rrs, zkeys, opt); err != nil {
+						return err
+					}
+				}
+				if err := zonedb.WriteZone(w, auth.Domain(), rrs); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("signing %s: %v", mf.Name(), err)
+		}
+		logging.Infof("signed %s", signedPath(mf))
+	}
+	return nil
+}
+
+// runSigner runs the dnssec_signer command for each modified zone.
+func runSigner(cfg *config, db *zonedb.DB) error {
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			if !auth.Dirty() {
+				continue
+			}
+			env := append(hookEnv(mf, auth, nil),
+				"DNSUP_KEYS="+strings.Join(keyFiles(cfg.DNSSECKeys, auth.Domain()), " "),
+				"DNSUP_SIGNED="+signedPath(mf),
+			)
+			if out, err := runHook(cfg.DNSSECSigner, env); err != nil {
+				return fmt.Errorf("signer %q for %s failed: %v\n%s", cfg.DNSSECSigner, auth.Domain(), err, out)
+			}
+		}
+	}
+	return nil
+}
+
+// keyFiles returns the key paths named for zone, K<zone>+<alg>+<tag>.
+func keyFiles(paths []string, zone string) []string {
+	var out []string
+	for _, p := range paths {
+		if strings.HasPrefix(strings.ToLower(filepath.Base(p)), "k"+strings.ToLower(zone)+"+") {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// runSign implements "dnsup sign [zonefile...]", bumping the serial of
+// every zone and re-signing it, as is needed before its signatures
+// expire.
+func runSign(args []string) {
+	cfg, err := parseConfig("sign", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	cfg.zoneArgs(cfg.Args)
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	if len(cfg.DNSSECKeys) == 0 && cfg.DNSSECSigner == "" {
+		logging.Fatal("no -dnssec-key or -dnssec-signer configured")
+	}
+	db := loadZones(cfg)
+	for _, mf := range db.Files() {
+		for _, auth := range mf.Authorities() {
+			auth.Touch()
+		}
+	}
+	if err := commit(cfg, db, nil); err != nil {
+		logging.Fatal(err)
+	}
+}
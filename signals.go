@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/tsig"
+)
+
+// shutdownTimeout bounds how long shutting down waits for HTTP requests
+// in flight.
+const shutdownTimeout = 30 * time.Second
+
+// notifySignals returns channels receiving SIGHUP, which asks for a
+// reload, and SIGTERM or SIGINT, which ask for a shutdown.
+func notifySignals() (hup, term <-chan os.Signal) {
+	h, t := make(chan os.Signal, 1), make(chan os.Signal, 1)
+	signal.Notify(h, syscall.SIGHUP)
+	signal.Notify(t, syscall.SIGTERM, os.Interrupt)
+	return h, t
+}
+
+// handleSignals reloads with reload on SIGHUP, keeping the running
+// configuration if it fails, and shuts down on SIGTERM or SIGINT.
+func (s *server) handleSignals(reload func() error, srv *http.Server) {
+	hup, term := notifySignals()
+	for {
+		select {
+		case <-hup:
+			s.reloading(reload)
+		case sig := <-term:
+			s.shutdown(sig, srv)
+		}
+	}
+}
+
+// reloading runs reload, telling systemd and the log how it went.
+func (s *server) reloading(reload func() error) {
+	sdNotify("RELOADING=1")
+	if err := reload(); err != nil {
+		logging.Errorf("reload failed, keeping the running configuration: %v", err)
+	} else {
+		logging.Infof("reloaded the configuration and zones")
+	}
+	sdNotify("READY=1")
+}
+
+// shutdown stops accepting HTTP requests, waits for those in flight, for
+// any update being written and for the webhooks and alerts it sent, and
+// exits.
+func (s *server) shutdown(sig os.Signal, srv *http.Server) {
+	logging.Infof("%v: shutting down", sig)
+	sdNotify("STOPPING=1")
+	if srv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := srv.Shutdown(ctx); err != nil {
+			logging.Error(err)
+		}
+		cancel()
+	}
+	s.mu.Lock() // held until exit, so that no further update starts
+	flushEvents()
+	os.Exit(0)
+}
+
+// reload makes cfg the server's configuration, re-reading the keyring
+// and the zones, which must parse, and serving the zones afresh over DNS.
+// It waits for any update in progress. The listeners keep running as they
+// started; warnRestart logs any of their settings that changed.
+func (s *server) reload(cfg *config) error {
+	keys, err := cfg.keyring()
+	if err != nil {
+		return err
+	}
+	nets, err := parseNets(cfg.TransferAllow)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := cfg.newDB()
+	if err != nil {
+		return err
+	}
+	if err := db.Load(cfg.zoneFiles()...); err != nil {
+		return err
+	}
+	warnRestart(s.cfg, cfg)
+	s.cfgMu.Lock()
+	s.cfg, s.keys, s.transferNets = cfg, keys, nets
+	s.cfgMu.Unlock()
+	if s.snapshot() != nil {
+		s.setLive(db)
+	}
+	return nil
+}
+
+// warnRestart logs the settings changed from old to cfg that only take
+// effect on restart.
+func warnRestart(old, cfg *config) {
+	for _, s := range []struct{ name, old, new string }{
+		{"listen", old.Listen, cfg.Listen},
+		{"tls_cert", old.TLSCert, cfg.TLSCert},
+		{"tls_key", old.TLSKey, cfg.TLSKey},
+		{"serve_dyndns", fmt.Sprint(old.ServeDynDNS), fmt.Sprint(cfg.ServeDynDNS)},
+		{"serve_api", fmt.Sprint(old.ServeAPI), fmt.Sprint(cfg.ServeAPI)},
+		{"grpc_listen", old.GRPCListen, cfg.GRPCListen},
+		{"dns_listen", old.DNSListen, cfg.DNSListen},
+		{"metrics_listen", old.MetricsListen, cfg.MetricsListen},
+	} {
+		if s.old != s.new {
+			logging.Warnf("%s changed from %q to %q: restart to apply it", s.name, s.old, s.new)
+		}
+	}
+	if cfg.DNSListen != "" && old.Keyring != cfg.Keyring {
+		logging.Warnf("keyring changed: restart for dns_listen to verify TSIG with it")
+	}
+}
+
+// config returns the server's configuration, for code not holding mu.
+func (s *server) config() *config {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.cfg
+}
+
+// keyring returns the server's keyring, for code not holding mu.
+func (s *server) keyring() *tsig.Keyring {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.keys
+}
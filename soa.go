@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// soaOptions are the flags of "dnsup soa".
+type soaOptions struct {
+	mname   string
+	rname   string
+	refresh string
+	retry   string
+	expire  string
+	minimum string
+}
+
+func soaFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.soa.mname, "mname", "", "set the primary name server")
+	fs.StringVar(&c.soa.rname, "rname", "", "set the administrator's mailbox, as a name or an address such as hostmaster@example.com")
+	fs.StringVar(&c.soa.refresh, "refresh", "", "set the interval at which secondaries check for a new serial")
+	fs.StringVar(&c.soa.retry, "retry", "", "set the interval at which secondaries retry a failed refresh")
+	fs.StringVar(&c.soa.expire, "expire", "", "set the time after which secondaries stop answering without a refresh")
+	fs.StringVar(&c.soa.minimum, "minimum", "", "set the TTL of negative answers")
+}
+
+// runSOA implements "dnsup soa [-mname name] [-rname mailbox] [-refresh t]
+// [-retry t] [-expire t] [-minimum t] zone [zonefile...]", setting the
+// fields of the zone's SOA record given by the flags, or else by the
+// zone's zone_settings, and printing the record if there are none. The
+// timers take BIND's units, such as 1h or 2w, and must keep retry below
+// refresh and refresh below expire.
+func runSOA(args []string) {
+	cfg, err := parseConfig("soa", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if len(cfg.Args) < 1 {
+		logging.Fatal("usage: dnsup soa [flags] zone [zonefile...]")
+	}
+	zone := dns.Fqdn(strings.ToLower(cfg.Args[0]))
+	cfg.zoneArgs(cfg.Args[1:])
+	// The flags override the zone's settings, which db applies again when
+	// it writes the zone.
+	z := cfg.ZoneSettings[zone]
+	for _, set := range []struct {
+		field *string
+		flag  string
+	}{
+		{&z.SOAMname, cfg.soa.mname}, {&z.SOARname, cfg.soa.rname}, {&z.SOARefresh, cfg.soa.refresh},
+		{&z.SOARetry, cfg.soa.retry}, {&z.SOAExpire, cfg.soa.expire}, {&z.SOAMinimum, cfg.soa.minimum},
+	} {
+		if set.flag != "" {
+			*set.field = set.flag
+		}
+	}
+	fields, err := z.soa()
+	if err != nil {
+		logging.Fatal(err)
+	}
+
+	if cfg.backends() > 0 {
+		logging.Fatal("soa needs master files")
+	}
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	db := loadZones(cfg)
+	db.SetSOAFields(zone, fields)
+	auth := db.Zone(zone)
+	if auth == nil || !strings.EqualFold(auth.Domain(), zone) || auth.SOA() == nil {
+		logging.Fatalf("zone %s is not loaded", zone)
+	}
+	if fields == (zonedb.SOAFields{}) {
+		fmt.Println(auth.SOA().String())
+		return
+	}
+	old := auth.SOA().String()
+	if err := db.SetSOA(zone, fields); err != nil {
+		logging.Fatal(err)
+	}
+	if !db.Dirty() {
+		logging.Infof("SOA of %s unchanged", zone)
+		return
+	}
+	logging.Event(logging.LevelInfo, "soa changed", logging.Fields{
+		"actor": cliActor(), "zone": zone, "old": old, "new": auth.SOA().String(),
+	})
+	if err := commit(cfg, db, nil); err != nil {
+		logging.Fatal(err)
+	}
+}
+
+// soaFields parses the SOA fields given as strings, empty for those left
+// as they are. The timers given must keep retry below refresh and refresh
+// below expire; SetSOA checks them against the others.
+func soaFields(mname, rname, refresh, retry, expire, minimum string) (zonedb.SOAFields, error) {
+	f := zonedb.SOAFields{Mname: mname, Rname: rname}
+	for _, t := range []struct {
+		name  string
+		value string
+		field *uint32
+	}{{"refresh", refresh, &f.Refresh}, {"retry", retry, &f.Retry}, {"expire", expire, &f.Expire}} {
+		if t.value == "" {
+			continue
+		}
+		n, err := zonedb.ParseTTL(t.value)
+		if err != nil {
+			return f, fmt.Errorf("SOA %s: %v", t.name, err)
+		}
+		if n == 0 {
+			return f, fmt.Errorf("SOA %s must be non-zero", t.name)
+		}
+		*t.field = n
+	}
+	if minimum != "" {
+		n, err := zonedb.ParseTTL(minimum)
+		if err != nil {
+			return f, fmt.Errorf("SOA minimum: %v", err)
+		}
+		f.Minimum = &n
+	}
+	switch {
+	case f.Retry != 0 && f.Refresh != 0 && f.Retry >= f.Refresh:
+		return f, fmt.Errorf("SOA retry %d is not below refresh %d", f.Retry, f.Refresh)
+	case f.Refresh != 0 && f.Expire != 0 && f.Refresh >= f.Expire:
+		return f, fmt.Errorf("SOA refresh %d is not below expire %d", f.Refresh, f.Expire)
+	case f.Retry != 0 && f.Expire != 0 && f.Retry >= f.Expire:
+		return f, fmt.Errorf("SOA retry %d is not below expire %d", f.Retry, f.Expire)
+	}
+	return f, nil
+}
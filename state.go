@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// ipState is the address last applied to each of the daemon's domains, by
+// record type, kept in state_file so that a restarted daemon neither
+// pushes unchanged addresses again nor bumps serials for them.
+type ipState struct {
+	path string
+	IPs  map[string]map[string]string `json:"ips"` // domain, then "A" or "AAAA"
+}
+
+// stateFile returns the configured state_file or, under systemd with
+// StateDirectory=, a file in that directory. It is empty when the
+// daemon keeps no state.
+func (c *config) stateFile() string {
+	if c.StateFile != "" {
+		return c.StateFile
+	}
+	if dir := os.Getenv("STATE_DIRECTORY"); dir != "" {
+		return filepath.Join(filepath.SplitList(dir)[0], "dnsup.state.json")
+	}
+	return ""
+}
+
+// loadState reads the state in path, which need not exist yet. With an
+// empty path the state is kept in memory only.
+func loadState(path string) (*ipState, error) {
+	s := &ipState{path: path, IPs: map[string]map[string]string{}}
+	if path == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if s.IPs == nil {
+		s.IPs = map[string]map[string]string{}
+	}
+	return s, nil
+}
+
+// current returns the address of rrtype last applied to every one of
+// domains, or "" unless they all have the same one.
+func (s *ipState) current(domains []string, rrtype uint16) string {
+	ip := ""
+	for i, domain := range domains {
+		got := s.IPs[domain][dns.TypeToString[rrtype]]
+		if got == "" || i > 0 && got != ip {
+			return ""
+		}
+		ip = got
+	}
+	return ip
+}
+
+// applied records ip as the address of rrtype of domains, saving the
+// state if it has a file.
+func (s *ipState) applied(domains []string, rrtype uint16, ip string) error {
+	for _, domain := range domains {
+		if s.IPs[domain] == nil {
+			s.IPs[domain] = map[string]string{}
+		}
+		s.IPs[domain][dns.TypeToString[rrtype]] = ip
+	}
+	if s.path == "" {
+		return nil
+	}
+	return zonedb.WriteFile(s.path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	})
+}
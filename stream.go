@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// streamUpdates applies the updates and record settings to the single
+// master file of a zone too large to load, rewriting it as a stream
+// through its offset index rather than loading it. The features that need
+// the whole zone in memory are refused.
+func streamUpdates(cfg *config, updates []update, sets []recordUpdate) error {
+	switch {
+	case len(cfg.Zones) != 1:
+		return fmt.Errorf("-stream updates a single master file")
+	case cfg.DryRun:
+		return fmt.Errorf("-dry-run is not supported with -stream")
+	case cfg.Journal, cfg.BackupDir != "", len(cfg.ReverseZones) > 0, cfg.ManagedOnly,
+		len(cfg.DNSSECKeys) > 0, cfg.DNSSECSigner != "", cfg.MinTTL > 0, cfg.MaxTTL > 0, len(cfg.ZoneSettings) > 0:
+		return fmt.Errorf("-stream does not support journals, backups, reverse zones, -managed-only, DNSSEC signing, TTL limits or zone_settings")
+	}
+	var changes []zonedb.StreamChange
+	for _, up := range updates {
+		if zonedb.IsPattern(up.domain) {
+			return fmt.Errorf("%s: patterns are not supported with -stream", up.domain)
+		}
+		rrtype, err := addressType(up.ip)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, zonedb.StreamChange{Name: up.domain, Type: rrtype, Value: up.ip})
+	}
+	for _, set := range sets {
+		changes = append(changes, zonedb.StreamChange{Name: set.name, Type: set.rrtype, Value: set.value})
+	}
+	db, err := cfg.newDB()
+	if err != nil {
+		return err
+	}
+	n, err := db.StreamUpdate(cfg.Zones[0], changes)
+	if err != nil {
+		return err
+	}
+	logging.Infof("%s: %d records changed", cfg.Zones[0], n)
+	outcome.Changed += n
+	return nil
+}
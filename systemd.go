@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// activated is a socket passed by systemd, under the name of its
+// FileDescriptorName= setting or, by default, of its socket unit.
+type activated struct {
+	file *os.File
+	name string
+	used bool
+}
+
+var (
+	socketsOnce sync.Once
+	sockets     []*activated
+	socketsMu   sync.Mutex
+)
+
+// activatedSockets returns the sockets passed to this process by systemd
+// socket activation, reading LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES
+// once and unsetting them so that child processes do not see them.
+func activatedSockets() []*activated {
+	socketsOnce.Do(func() {
+		defer os.Unsetenv("LISTEN_PID")
+		defer os.Unsetenv("LISTEN_FDS")
+		defer os.Unsetenv("LISTEN_FDNAMES")
+		if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+			return
+		}
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || n <= 0 {
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := 0; i < n; i++ {
+			fd := listenFDsStart + i
+			name := "unknown"
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			sockets = append(sockets, &activated{file: os.NewFile(uintptr(fd), name), name: name})
+		}
+	})
+	return sockets
+}
+
+// listenTCP returns a stream listener for the listener called name on
+// addr: the activated socket named name, or else the one bound to addr,
+// or else a new listener on addr.
+func listenTCP(name, addr string) (net.Listener, error) {
+	for _, s := range candidates(name) {
+		l, err := net.FileListener(s.file)
+		if err != nil {
+			continue // a datagram socket
+		}
+		if matches(s, name, addr, l.Addr()) {
+			s.claim(name)
+			return l, nil
+		}
+		l.Close()
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenUDP is listenTCP for a datagram socket.
+func listenUDP(name, addr string) (net.PacketConn, error) {
+	for _, s := range candidates(name) {
+		pc, err := net.FilePacketConn(s.file)
+		if err != nil {
+			continue // a stream socket
+		}
+		if matches(s, name, addr, pc.LocalAddr()) {
+			s.claim(name)
+			return pc, nil
+		}
+		pc.Close()
+	}
+	return net.ListenPacket("udp", addr)
+}
+
+// candidates returns the unclaimed activated sockets, those named name
+// first.
+func candidates(name string) []*activated {
+	socketsMu.Lock()
+	defer socketsMu.Unlock()
+	var named, rest []*activated
+	for _, s := range activatedSockets() {
+		switch {
+		case s.used:
+		case s.name == name:
+			named = append(named, s)
+		default:
+			rest = append(rest, s)
+		}
+	}
+	return append(named, rest...)
+}
+
+// matches reports whether the socket s, bound to bound, is the one to use
+// for the listener called name on addr.
+func matches(s *activated, name, addr string, bound net.Addr) bool {
+	if s.name == name {
+		return true
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	bhost, bport, err := net.SplitHostPort(bound.String())
+	if err != nil || bport != port {
+		return false
+	}
+	ip, bip := net.ParseIP(host), net.ParseIP(bhost)
+	if host == "" || ip != nil && ip.IsUnspecified() {
+		return bip != nil && bip.IsUnspecified()
+	}
+	return ip != nil && ip.Equal(bip)
+}
+
+func (s *activated) claim(name string) {
+	socketsMu.Lock()
+	s.used = true
+	socketsMu.Unlock()
+	s.file.Close() // the listener has its own copy
+	logging.Infof("using socket %s passed by systemd for %s", s.name, name)
+}
+
+// warnUnusedSockets logs the activated sockets no listener claimed.
+func warnUnusedSockets() {
+	socketsMu.Lock()
+	defer socketsMu.Unlock()
+	for _, s := range activatedSockets() {
+		if !s.used {
+			logging.Warnf("socket %s passed by systemd matches no configured listener", s.name)
+		}
+	}
+}
+
+// sdNotify sends state to the systemd notification socket, if there is
+// one.
+func sdNotify(state string) error {
+	addr := &net.UnixAddr{Name: os.Getenv("NOTIFY_SOCKET"), Net: "unixgram"}
+	if addr.Name == "" {
+		return nil
+	}
+	if addr.Name[0] == '@' {
+		addr.Name = "\x00" + addr.Name[1:] // abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdStatus sets the status line systemctl status shows for the service,
+// when run under systemd.
+func sdStatus(format string, args ...interface{}) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	status := strings.Replace(fmt.Sprintf(format, args...), "\n", " ", -1)
+	if err := sdNotify("STATUS=" + status); err != nil {
+		logging.Debugf("systemd notify: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"text/template"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// templateData is what zone templates are executed with, as in
+// {{ .PublicIPv4 }}. Templates can also call env to read an environment
+// variable, as in {{ env "MAIL_HOST" }}.
+type templateData struct {
+	PublicIPv4 string
+	PublicIPv6 string
+	Hostname   string
+}
+
+// newTemplateData returns the data of templates given the current
+// addresses, the first of each family taken.
+func newTemplateData(ips ...string) *templateData {
+	d := &templateData{}
+	d.Hostname, _ = os.Hostname()
+	for _, ip := range ips {
+		switch rrtype, _ := addressType(ip); {
+		case rrtype == dns.TypeA && d.PublicIPv4 == "":
+			d.PublicIPv4 = ip
+		case rrtype == dns.TypeAAAA && d.PublicIPv6 == "":
+			d.PublicIPv6 = ip
+		}
+	}
+	return d
+}
+
+// renderData returns the data of templates from the addresses of updates
+// or, if there are none, the configured or discovered ones.
+func renderData(ctx context.Context, cfg *config, updates []update) (*templateData, error) {
+	var ips []string
+	for _, up := range updates {
+		ips = append(ips, up.ip)
+	}
+	if len(ips) == 0 {
+		var err error
+		ips, err = addresses(ctx, cfg)
+		switch {
+		case len(ips) == 0 && err != nil:
+			return nil, err
+		case err != nil:
+			logging.Error(err)
+		}
+	}
+	return newTemplateData(ips...), nil
+}
+
+// setTemplates parses the configured templates into db, which renders
+// them once it has template data.
+func (c *config) setTemplates(db *zonedb.DB) error {
+	for file, path := range c.Templates {
+		text, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		t, err := template.New(path).Funcs(template.FuncMap{"env": os.Getenv}).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			return err
+		}
+		db.SetTemplate(file, t)
+	}
+	if c.tmplData != nil {
+		db.SetTemplateData(c.tmplData)
+	}
+	return nil
+}
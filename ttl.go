@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// setTTLOptions are the flags of "dnsup set-ttl".
+type setTTLOptions struct {
+	typ string
+}
+
+func setTTLFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.setTTL.typ, "type", "", "only set the TTL of records of this type (default A and AAAA)")
+}
+
+// runSetTTL implements "dnsup set-ttl [-type TYPE] name ttl [zonefile...]",
+// setting the TTL of the A and AAAA records of name, or of its records of
+// one type. The name may be a pattern such as *.home.example.com. Lowering
+// a TTL ahead of an expected address change lets caches pick the new
+// address up quickly.
+func runSetTTL(args []string) {
+	cfg, err := parseConfig("set-ttl", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if len(cfg.Args) < 2 {
+		logging.Fatal("usage: dnsup set-ttl [flags] name ttl [zonefile...]")
+	}
+	name := cfg.Args[0]
+	if !zonedb.IsPattern(name) {
+		name = dns.Fqdn(strings.ToLower(name))
+	}
+	n, err := strconv.ParseUint(cfg.Args[1], 10, 32)
+	if err != nil {
+		logging.Fatalf("invalid TTL %q", cfg.Args[1])
+	}
+	ttl := uint32(n)
+	cfg.zoneArgs(cfg.Args[2:])
+	rrtypes := []uint16{dns.TypeA, dns.TypeAAAA}
+	if cfg.setTTL.typ != "" {
+		rrtype, ok := dns.StringToType[strings.ToUpper(cfg.setTTL.typ)]
+		if !ok {
+			logging.Fatalf("unknown record type %q", cfg.setTTL.typ)
+		}
+		rrtypes = []uint16{rrtype}
+	}
+
+	if cfg.backends() > 0 {
+		logging.Fatal("set-ttl needs master files")
+	}
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	db := loadZones(cfg)
+	found := false
+	for _, rrtype := range rrtypes {
+		for _, domain := range db.Expand(name, rrtype) {
+			rrs := db.Lookup(domain, rrtype)
+			if len(rrs) == 0 {
+				continue
+			}
+			found = true
+			if err := cfg.nameConfig(db, domain).checkTTL(ttl); err != nil {
+				logging.Fatalf("%s: %v", domain, err)
+			}
+			if old := rrs[0].Header().Ttl; old != ttl {
+				if err := db.SetTTL(domain, rrtype, ttl); err != nil {
+					logging.Fatal(err)
+				}
+				logging.Event(logging.LevelInfo, "ttl changed", logging.Fields{
+					"actor": cliActor(), "name": domain, "type": dns.TypeToString[rrtype],
+					"old_ttl": old, "new_ttl": ttl,
+				})
+			}
+		}
+	}
+	if !found {
+		logging.Fatalf("no records found for %q", name)
+	}
+	if !db.Dirty() {
+		logging.Infof("no TTLs changed")
+		return
+	}
+	if err := commit(cfg, db, nil); err != nil {
+		logging.Fatal(err)
+	}
+}
+
+// checkTTL reports an error if ttl is outside min_ttl and max_ttl; see
+// nameConfig for those of a zone.
+func (c *config) checkTTL(ttl uint32) error {
+	if int64(ttl) < int64(c.MinTTL) {
+		return fmt.Errorf("TTL %d is below min_ttl %d", ttl, c.MinTTL)
+	}
+	if c.MaxTTL > 0 && int64(ttl) > int64(c.MaxTTL) {
+		return fmt.Errorf("TTL %d is above max_ttl %d", ttl, c.MaxTTL)
+	}
+	return nil
+}
+
+// clampTTL raises or lowers the TTL of the records of name and type into
+// the min_ttl and max_ttl of their zone, so that the records dnsup manages
+// keep within them however they were written.
+func clampTTL(cfg *config, db *zonedb.DB, name string, rrtype uint16) error {
+	cfg = cfg.nameConfig(db, name)
+	rrs := db.Lookup(name, rrtype)
+	if len(rrs) == 0 || cfg.MinTTL == 0 && cfg.MaxTTL == 0 {
+		return nil
+	}
+	ttl := rrs[0].Header().Ttl
+	switch {
+	case int64(ttl) < int64(cfg.MinTTL):
+		ttl = uint32(cfg.MinTTL)
+	case cfg.MaxTTL > 0 && int64(ttl) > int64(cfg.MaxTTL):
+		ttl = uint32(cfg.MaxTTL)
+	}
+	return db.SetTTL(name, rrtype, ttl)
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// runTUI implements "dnsup tui [zonefile...]", a terminal front end for
+// the zones: it lists them and their records, edits the data of A, AAAA
+// and TXT records in place, shows the pending changes as a diff and
+// writes them with the serials advanced, as other updates are.
+func runTUI(args []string) {
+	cfg, err := parseConfig("tui", args)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	cfg.zoneArgs(cfg.Args)
+	if len(cfg.Zones) < 1 {
+		logging.Fatal("missing master file name")
+	}
+	t := &tui{cfg: cfg, in: bufio.NewReader(os.Stdin), out: bufio.NewWriter(os.Stdout)}
+	if err := t.load(); err != nil {
+		logging.Fatal(err)
+	}
+	if err := t.raw(); err != nil {
+		logging.Fatalf("dnsup tui needs a terminal: %v", err)
+	}
+	err = t.run()
+	t.restore()
+	fmt.Print("\x1b[H\x1b[2J")
+	flushEvents()
+	if err != nil {
+		logging.Fatal(err)
+	}
+}
+
+// The views of the TUI.
+const (
+	viewZones = iota
+	viewRecords
+	viewDiff
+)
+
+// tui is the state of "dnsup tui".
+type tui struct {
+	cfg  *config
+	db   *zonedb.DB
+	in   *bufio.Reader
+	out  *bufio.Writer
+	tty  string // the terminal settings to restore
+	rows int
+	cols int
+
+	view    int
+	zones   []*zonedb.Authority
+	zone    *zonedb.Authority
+	records []dns.RR
+	diff    []string
+	cursor  int
+	top     int // the first line shown
+	back    int // the cursor to return to in the zone list
+	status  string
+	quit    bool // q was pressed once with changes pending
+
+	// edited holds the data of each edited record set before its first
+	// edit, for recordChanged once written.
+	edited map[rrsetKey][]string
+	order  []rrsetKey
+}
+
+// load loads the zones, discarding any edits.
+func (t *tui) load() error {
+	db, err := t.cfg.newDB()
+	if err != nil {
+		return err
+	}
+	if _, err := loadAll(db, t.cfg.zoneFiles()); err != nil {
+		return err
+	}
+	t.db, t.zones, t.zone = db, nil, nil
+	for _, mf := range db.Files() {
+		t.zones = append(t.zones, mf.Authorities()...)
+	}
+	t.edited, t.order = map[rrsetKey][]string{}, nil
+	t.view, t.cursor, t.top = viewZones, 0, 0
+	return nil
+}
+
+// stty runs stty on the terminal with args, returning its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// raw puts the terminal in raw mode, noting its size and the settings
+// that restore puts back.
+func (t *tui) raw() error {
+	tty, err := stty("-g")
+	if err != nil {
+		return err
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return err
+	}
+	t.tty, t.rows, t.cols = tty, 24, 80
+	if size, err := stty("size"); err == nil {
+		if fs := strings.Fields(size); len(fs) == 2 {
+			if rows, err := strconv.Atoi(fs[0]); err == nil && rows > 3 {
+				t.rows = rows
+			}
+			if cols, err := strconv.Atoi(fs[1]); err == nil && cols > 20 {
+				t.cols = cols
+			}
+		}
+	}
+	fmt.Print("\x1b[?25l")
+	return nil
+}
+
+func (t *tui) restore() {
+	fmt.Print("\x1b[?25h")
+	stty(t.tty)
+}
+
+// Keys other than printable characters, as read by key.
+const (
+	keyUp = -1 - iota
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEsc
+	keyEnter     = '\r'
+	keyBackspace = 0x7f
+)
+
+// key reads a key press. An escape sequence arrives in one read, so an
+// escape with nothing buffered after it is the Esc key.
+func (t *tui) key() (rune, error) {
+	r, _, err := t.in.ReadRune()
+	if err != nil || r != 0x1b {
+		return r, err
+	}
+	if t.in.Buffered() == 0 {
+		return keyEsc, nil
+	}
+	b, _ := t.in.ReadByte()
+	if b != '[' && b != 'O' {
+		return keyEsc, nil
+	}
+	var seq []byte
+	for t.in.Buffered() > 0 {
+		c, _ := t.in.ReadByte()
+		seq = append(seq, c)
+		if c >= 0x40 && c <= 0x7e {
+			break
+		}
+	}
+	switch string(seq) {
+	case "A":
+		return keyUp, nil
+	case "B":
+		return keyDown, nil
+	case "C":
+		return keyRight, nil
+	case "D":
+		return keyLeft, nil
+	case "5~":
+		return keyPageUp, nil
+	case "6~":
+		return keyPageDown, nil
+	case "H", "1~":
+		return keyHome, nil
+	case "F", "4~":
+		return keyEnd, nil
+	}
+	return keyEsc, nil
+}
+
+func (t *tui) run() error {
+	for {
+		t.draw()
+		k, err := t.key()
+		if err != nil {
+			return err
+		}
+		status := ""
+		switch k {
+		case 'q', 3: // Ctrl-C
+			if !t.db.Dirty() || t.quit {
+				return nil
+			}
+			t.quit = true
+			status = "Changes not written: press q again to discard them, w to write them"
+		case keyUp, 'k':
+			t.move(-1)
+		case keyDown, 'j':
+			t.move(1)
+		case keyPageUp:
+			t.move(-t.page())
+		case keyPageDown:
+			t.move(t.page())
+		case keyHome, 'g':
+			t.move(-t.cursor)
+		case keyEnd, 'G':
+			t.move(t.lines())
+		case keyEnter, keyRight, 'l', 'e':
+			status = t.enter()
+		case keyEsc, keyLeft, 'h':
+			t.leave()
+		case 'd':
+			status = t.showDiff()
+		case 'w':
+			status = t.write()
+		case 'r':
+			if err := t.load(); err != nil {
+				return err
+			}
+			status = "Reloaded the zones, discarding any changes"
+		}
+		if k != 'q' && k != 3 {
+			t.quit = false
+		}
+		t.status = status
+	}
+}
+
+// page is the number of lines shown between the title and status lines.
+func (t *tui) page() int {
+	return t.rows - 2
+}
+
+// lines returns the number of lines of the view.
+func (t *tui) lines() int {
+	switch t.view {
+	case viewZones:
+		return len(t.zones)
+	case viewRecords:
+		return len(t.records)
+	}
+	return len(t.diff)
+}
+
+// move moves the cursor by n lines, scrolling to keep it shown; the diff
+// view only scrolls.
+func (t *tui) move(n int) {
+	last := t.lines() - 1
+	if t.view == viewDiff {
+		last = t.lines() - t.page()
+	}
+	t.cursor += n
+	if t.cursor > last {
+		t.cursor = last
+	}
+	if t.cursor < 0 {
+		t.cursor = 0
+	}
+	if t.view == viewDiff {
+		t.top = t.cursor
+		return
+	}
+	if t.cursor < t.top {
+		t.top = t.cursor
+	}
+	if t.cursor >= t.top+t.page() {
+		t.top = t.cursor - t.page() + 1
+	}
+}
+
+// enter opens the selected zone, or edits the selected record.
+func (t *tui) enter() string {
+	switch t.view {
+	case viewZones:
+		if len(t.zones) == 0 {
+			return ""
+		}
+		t.back = t.cursor
+		t.zone = t.zones[t.cursor]
+		t.showRecords(0)
+	case viewRecords:
+		if len(t.records) > 0 {
+			return t.edit(t.records[t.cursor])
+		}
+	}
+	return ""
+}
+
+// leave goes back to the zone list, or from the diff to where it was
+// shown from.
+func (t *tui) leave() {
+	switch {
+	case t.view == viewDiff && t.zone != nil:
+		t.showRecords(0)
+	case t.view != viewZones:
+		t.view, t.zone, t.cursor, t.top = viewZones, nil, 0, 0
+		t.move(t.back)
+	}
+}
+
+// showRecords shows the records of the open zone with the cursor at line.
+func (t *tui) showRecords(line int) {
+	t.view, t.records, t.cursor, t.top = viewRecords, t.zone.Records(), 0, 0
+	t.move(line)
+}
+
+func (t *tui) showDiff() string {
+	var buf bytes.Buffer
+	if err := t.db.Diff(&buf); err != nil {
+		return err.Error()
+	}
+	if buf.Len() == 0 {
+		return "No pending changes"
+	}
+	t.diff = strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	t.view, t.cursor, t.top = viewDiff, 0, 0
+	return ""
+}
+
+// editable reports whether the TUI edits the data of records of rrtype.
+func editable(rrtype uint16) bool {
+	return rrtype == dns.TypeA || rrtype == dns.TypeAAAA || rrtype == dns.TypeTXT
+}
+
+// edit prompts for the new data of rr and applies it.
+func (t *tui) edit(rr dns.RR) string {
+	hdr := rr.Header()
+	if !editable(hdr.Rrtype) {
+		return dns.TypeToString[hdr.Rrtype] + " records cannot be edited here"
+	}
+	old := rdataOf(rr)
+	value, ok := t.prompt(fmt.Sprintf("%s %s: ", shortName(hdr.Name, t.zone.Domain()), dns.TypeToString[hdr.Rrtype]), old)
+	if !ok || value == old {
+		return ""
+	}
+	if hdr.Rrtype != dns.TypeTXT {
+		ip := net.ParseIP(value)
+		if ip == nil || (ip.To4() != nil) != (hdr.Rrtype == dns.TypeA) {
+			return fmt.Sprintf("%q is not an address for %s records", value, dns.TypeToString[hdr.Rrtype])
+		}
+	}
+	key := rrsetKey{hdr.Name, hdr.Rrtype}
+	before := values(t.db.Lookup(hdr.Name, hdr.Rrtype))
+	if err := t.db.ReplaceRecord(hdr.Name, hdr.Rrtype, old, value); err != nil {
+		return err.Error()
+	}
+	if _, ok := t.edited[key]; !ok {
+		t.edited[key] = before
+		t.order = append(t.order, key)
+	}
+	t.showRecords(t.cursor)
+	return fmt.Sprintf("%s %s: %s -> %s (press d for the diff, w to write)", hdr.Name, dns.TypeToString[hdr.Rrtype], old, value)
+}
+
+// prompt reads a line on the status line, starting from value, returning
+// false if it is cancelled with Esc.
+func (t *tui) prompt(label, value string) (string, bool) {
+	line := []rune(value)
+	for {
+		t.draw()
+		text := label + string(line)
+		if w := t.cols - 1; len([]rune(text)) > w {
+			text = string([]rune(text)[len([]rune(text))-w:])
+		}
+		fmt.Fprintf(t.out, "\x1b[%d;1H\x1b[2K%s\x1b[?25h", t.rows, text)
+		t.out.Flush()
+		k, err := t.key()
+		fmt.Print("\x1b[?25l")
+		if err != nil {
+			return "", false
+		}
+		switch {
+		case k == keyEnter:
+			return strings.TrimSpace(string(line)), true
+		case k == keyEsc || k == 3:
+			return "", false
+		case k == keyBackspace || k == 8:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		case k == 0x15: // Ctrl-U
+			line = nil
+		case k >= ' ':
+			line = append(line, k)
+		}
+	}
+}
+
+// write writes the pending changes as other updates do, with the
+// terminal restored so the output of hooks and any errors can be read,
+// then reloads the zones.
+func (t *tui) write() string {
+	if !t.db.Dirty() {
+		return "No pending changes"
+	}
+	t.restore()
+	fmt.Print("\x1b[H\x1b[2J")
+	status, written := "Changes written", false
+	if err := t.db.Write(); err != nil {
+		status = "Writing failed: " + err.Error()
+		if _, ok := err.(*zonedb.ChangedError); ok {
+			status = err.Error() + "; press r to reload and edit again"
+		}
+	} else {
+		written = true
+		if err := announce(t.cfg, t.db, nil); err != nil {
+			status = "Changes written, but " + err.Error()
+		}
+		for _, key := range t.order {
+			recordChanged(t.cfg, t.db, cliActor(), key.name, key.rrtype, t.edited[key], strings.Join(values(t.db.Lookup(key.name, key.rrtype)), ","))
+		}
+	}
+	fmt.Print("Press Enter to continue")
+	t.in.ReadString('\n')
+	if err := t.raw(); err != nil {
+		return err.Error()
+	}
+	if written {
+		if err := t.load(); err != nil {
+			return err.Error()
+		}
+	}
+	return status
+}
+
+func (t *tui) draw() {
+	w := t.out
+	fmt.Fprint(w, "\x1b[H\x1b[2J")
+	var title, help string
+	switch t.view {
+	case viewZones:
+		title = fmt.Sprintf("dnsup: %d zones", len(t.zones))
+		help = "Enter open  d diff  w write  r reload  q quit"
+	case viewRecords:
+		title = t.zone.Domain()
+		if soa := t.zone.SOA(); soa != nil {
+			title += fmt.Sprintf("  serial %d", soa.Serial)
+		}
+		help = "Enter edit A/AAAA/TXT  Esc back  d diff  w write  q quit"
+	case viewDiff:
+		title = "Pending changes"
+		help = "Esc back  w write  q quit"
+	}
+	if t.db.Dirty() {
+		title += "  [modified]"
+	}
+	t.line(1, "\x1b[7m", title)
+	for i := 0; i < t.page() && t.top+i < t.lines(); i++ {
+		n := t.top + i
+		text, attr := t.text(n), ""
+		switch {
+		case t.view != viewDiff && n == t.cursor:
+			attr = "\x1b[7m"
+		case t.view == viewDiff && strings.HasPrefix(text, "+") && !strings.HasPrefix(text, "+++"):
+			attr = "\x1b[32m"
+		case t.view == viewDiff && strings.HasPrefix(text, "-") && !strings.HasPrefix(text, "---"):
+			attr = "\x1b[31m"
+		case t.view == viewDiff && strings.HasPrefix(text, "@@"):
+			attr = "\x1b[36m"
+		}
+		t.line(i+2, attr, text)
+	}
+	status := t.status
+	if status == "" {
+		status = help
+	}
+	t.line(t.rows, "", status)
+	w.Flush()
+}
+
+// text returns line n of the view.
+func (t *tui) text(n int) string {
+	switch t.view {
+	case viewZones:
+		auth := t.zones[n]
+		mark := " "
+		if auth.Dirty() {
+			mark = "*"
+		}
+		serial := ""
+		if soa := auth.SOA(); soa != nil {
+			serial = fmt.Sprintf("serial %d", soa.Serial)
+		}
+		return fmt.Sprintf("%s %-32s %-18s %d records  %s", mark, auth.Domain(), serial, len(auth.Records()), t.fileOf(auth))
+	case viewRecords:
+		rr := t.records[n]
+		hdr := rr.Header()
+		mark := " "
+		if editable(hdr.Rrtype) {
+			mark = ">"
+		}
+		return fmt.Sprintf("%s %-24s %6d %-6s %s", mark, shortName(hdr.Name, t.zone.Domain()), hdr.Ttl, dns.TypeToString[hdr.Rrtype], rdataOf(rr))
+	}
+	return strings.Replace(t.diff[n], "\t", "    ", -1)
+}
+
+// shortName returns name relative to zone, or "@" for its apex.
+func shortName(name, zone string) string {
+	switch {
+	case strings.EqualFold(name, zone):
+		return "@"
+	case strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(zone)):
+		return name[:len(name)-len(zone)-1]
+	}
+	return name
+}
+
+// fileOf returns the name of the master file holding auth.
+func (t *tui) fileOf(auth *zonedb.Authority) string {
+	for _, mf := range t.db.Files() {
+		for _, a := range mf.Authorities() {
+			if a == auth {
+				return mf.Name()
+			}
+		}
+	}
+	return ""
+}
+
+// line writes text on screen line y, cut to the width of the terminal.
+func (t *tui) line(y int, attr, text string) {
+	if r := []rune(text); len(r) > t.cols {
+		text = string(r[:t.cols])
+	}
+	fmt.Fprintf(t.out, "\x1b[%d;1H%s%s\x1b[0m", y, attr, text)
+}
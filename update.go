@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/tsig"
+)
+
+const (
+	defaultUpdateTTL = 300
+	tsigFudge        = 300
+)
+
+// dynamicUpdater sends RFC 2136 UPDATE messages to an authoritative server
+// rather than rewriting master files on disk.
+type dynamicUpdater struct {
+	server  string
+	zone    string
+	ttl     uint32
+	keyName string
+	keyAlgo string
+	client  *dns.Client
+}
+
+// newDynamicUpdater returns an updater for server, signing with key if it
+// is not nil. If zone is empty it is discovered with a SOA query.
+func newDynamicUpdater(server, zone string, key *tsig.Key) (*dynamicUpdater, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	u := &dynamicUpdater{
+		server: server,
+		ttl:    defaultUpdateTTL,
+		client: &dns.Client{Net: "tcp"},
+	}
+	if zone != "" {
+		u.zone = dns.Fqdn(zone)
+	}
+	if key != nil {
+		u.keyName, u.keyAlgo = key.Name, key.Algorithm
+		u.client.TsigSecret = map[string]string{key.Name: key.Secret}
+	}
+	return u, nil
+}
+
+// UpdateRecord replaces the rrtype RRset of name with a single record
+// holding value in presentation format.
+func (u *dynamicUpdater) UpdateRecord(ctx context.Context, name string, rrtype uint16, value string) error {
+	m, err := u.recordMessage(ctx, name, rrtype, value)
+	if err != nil {
+		return err
+	}
+	return u.send(ctx, m, name)
+}
+
+func (u *dynamicUpdater) send(ctx context.Context, m *dns.Msg, name string) error {
+	r, err := u.exchange(ctx, m)
+	if err != nil {
+		return err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("update of %q rejected by %s: %s", name, u.server, dns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
+
+// Plan returns the UPDATE message UpdateRecord would send.
+func (u *dynamicUpdater) Plan(ctx context.Context, name string, rrtype uint16, value string) (string, error) {
+	m, err := u.recordMessage(ctx, name, rrtype, value)
+	if err != nil {
+		return "", err
+	}
+	return m.String(), nil
+}
+
+func (u *dynamicUpdater) recordMessage(ctx context.Context, name string, rrtype uint16, value string) (*dns.Msg, error) {
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(name), u.ttl, dns.TypeToString[rrtype], value))
+	if err != nil {
+		return nil, err
+	}
+	if rr == nil {
+		return nil, fmt.Errorf("empty %s value for %q", dns.TypeToString[rrtype], name)
+	}
+	return u.replace(ctx, rr)
+}
+
+// replace builds an UPDATE replacing the RRset of rr's name and type
+// with rr alone.
+func (u *dynamicUpdater) replace(ctx context.Context, rr dns.RR) (*dns.Msg, error) {
+	zone, err := u.findZone(ctx, rr.Header().Name)
+	if err != nil {
+		return nil, err
+	}
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	m.RemoveRRset([]dns.RR{rr})
+	m.Insert([]dns.RR{rr})
+	return m, nil
+}
+
+func (u *dynamicUpdater) exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	if u.keyName != "" {
+		m.SetTsig(u.keyName, u.keyAlgo, tsigFudge, time.Now().Unix())
+	}
+	r, _, err := u.client.ExchangeContext(ctx, m, u.server)
+	return r, err
+}
+
+func (u *dynamicUpdater) findZone(ctx context.Context, domain string) (string, error) {
+	if u.zone != "" {
+		return u.zone, nil
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(domain, dns.TypeSOA)
+	r, err := u.exchange(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	for _, rr := range append(r.Answer, r.Ns...) {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Hdr.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no SOA found for %q at %s", domain, u.server)
+}
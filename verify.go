@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+	"github.com/johnweldon/dnsup/pkg/retry"
+)
+
+// verifyInterval is how often verification queries are repeated.
+const verifyInterval = 5 * time.Second
+
+// expectation is a record that should be visible once an update is live.
+type expectation struct {
+	name   string
+	rrtype uint16
+	value  string
+}
+
+func (e expectation) String() string {
+	return fmt.Sprintf("%s %s %s", e.name, dns.TypeToString[e.rrtype], e.value)
+}
+
+// expectations returns the records the updates and sets should produce.
+func expectations(updates []update, sets []recordUpdate) ([]expectation, error) {
+	var exps []expectation
+	for _, up := range updates {
+		rrtype, err := addressType(up.ip)
+		if err != nil {
+			return nil, err
+		}
+		exps = append(exps, expectation{name: up.domain, rrtype: rrtype, value: up.ip})
+	}
+	for _, set := range sets {
+		exps = append(exps, expectation{name: set.name, rrtype: set.rrtype, value: set.value})
+	}
+	return exps, nil
+}
+
+// verify queries the zone's authoritative servers, or the configured
+// verify_resolvers, until every expectation is visible on all of them or
+// verify_timeout passes, logging the propagation status as it goes.
+func verify(cfg *config, exps []expectation) error {
+	type check struct {
+		server string
+		exp    expectation
+		rd     bool
+		last   string
+	}
+	var pending []*check
+	for _, exp := range exps {
+		servers, rd := cfg.VerifyResolvers, true
+		if len(servers) == 0 {
+			var err error
+			if servers, err = authServers(exp.name); err != nil {
+				return err
+			}
+			rd = false
+		}
+		for _, s := range servers {
+			if _, _, err := net.SplitHostPort(s); err != nil {
+				s = net.JoinHostPort(s, "53")
+			}
+			pending = append(pending, &check{server: s, exp: exp, rd: rd})
+		}
+	}
+
+	total := len(pending)
+	deadline := time.Now().Add(cfg.VerifyTimeout.Duration)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	policy := cfg.retryPolicy("verify")
+	for {
+		var left []*check
+		for _, c := range pending {
+			ok, got := visible(ctx, policy, c.server, c.exp, c.rd)
+			if ok {
+				logging.Infof("verified %s on %s", c.exp, c.server)
+				continue
+			}
+			c.last = got
+			left = append(left, c)
+		}
+		pending = left
+		if len(pending) == 0 {
+			logging.Infof("verified on %d of %d servers", total, total)
+			return nil
+		}
+		if time.Now().Add(verifyInterval).After(deadline) {
+			break
+		}
+		logging.Infof("visible on %d of %d servers; retrying in %s", total-len(pending), total, verifyInterval)
+		time.Sleep(verifyInterval)
+	}
+	for _, c := range pending {
+		logging.Warnf("%s not visible on %s: %s", c.exp, c.server, c.last)
+	}
+	return fmt.Errorf("verification failed on %d of %d servers after %s", len(pending), total, cfg.VerifyTimeout.Duration)
+}
+
+// visible reports whether server answers with the expected record, and
+// otherwise describes what it returned. Queries that fail are retried by
+// p until ctx is done; wrong answers are left to the caller to check again
+// later.
+func visible(ctx context.Context, p retry.Policy, server string, exp expectation, rd bool) (bool, string) {
+	want, err := dns.NewRR(fmt.Sprintf("%s 0 IN %s %s", exp.name, dns.TypeToString[exp.rrtype], exp.value))
+	if err != nil || want == nil {
+		return false, fmt.Sprintf("cannot parse expected value: %v", err)
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(exp.name), exp.rrtype)
+	m.RecursionDesired = rd
+	c := &dns.Client{Timeout: 5 * time.Second}
+	var r *dns.Msg
+	err = p.Do(ctx, "verify", func() (err error) {
+		r, _, err = c.ExchangeContext(ctx, m, server)
+		return err
+	})
+	if err != nil {
+		return false, err.Error()
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return false, dns.RcodeToString[r.Rcode]
+	}
+	var got []string
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype != exp.rrtype {
+			continue
+		}
+		if rdataOf(rr) == rdataOf(want) {
+			return true, ""
+		}
+		got = append(got, rdataOf(rr))
+	}
+	if len(got) == 0 {
+		return false, "no records"
+	}
+	return false, "have " + strings.Join(got, ", ")
+}
+
+// rdataOf returns the presentation format of rr's data.
+func rdataOf(rr dns.RR) string {
+	return strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Header().String()))
+}
+
+// authServers returns the name servers of the zone containing name, found
+// through the system resolver.
+func authServers(name string) ([]string, error) {
+	for n := dns.Fqdn(name); n != "."; n = parentName(n) {
+		ns, err := net.LookupNS(n)
+		if err != nil || len(ns) == 0 {
+			continue
+		}
+		var servers []string
+		for _, s := range ns {
+			servers = append(servers, s.Host)
+		}
+		return servers, nil
+	}
+	return nil, fmt.Errorf("cannot find the name servers for %s", name)
+}
+
+// liveSerial returns the highest serial of zone among its name servers,
+// warning of those that cannot be asked.
+func liveSerial(zone string) (uint32, bool) {
+	servers, err := authServers(zone)
+	if err != nil {
+		logging.Warnf("live serial of %s: %v", zone, err)
+		return 0, false
+	}
+	var serial uint32
+	found := false
+	for _, server := range servers {
+		s, err := primarySerial(server, zone, nil)
+		if err != nil {
+			logging.Warnf("live serial: %v", err)
+			continue
+		}
+		if !found || int32(s-serial) > 0 {
+			serial, found = s, true
+		}
+	}
+	if found {
+		logging.Debugf("live serial of %s is %d", zone, serial)
+	}
+	return serial, found
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/johnweldon/dnsup/pkg/logging"
+)
+
+// viewConfig is a view of split-horizon DNS, like a BIND view: its own
+// master files of the zones, updated in the same run as the other views
+// with its own addresses, such as the LAN address in an internal view and
+// the public one in the external view. Unset addresses, and the sources
+// to discover "auto" ones from, are those of the top level.
+type viewConfig struct {
+	Zones           []string `toml:"zones"`
+	ReverseZones    []string `toml:"reverse_zones"`
+	IP              string   `toml:"ip"`
+	IPv4            string   `toml:"ipv4"`
+	IPv6            string   `toml:"ipv6"`
+	Iface           string   `toml:"iface"`
+	IPSources       []string `toml:"ip_sources"`
+	VerifyResolvers []string `toml:"verify_resolvers"`
+}
+
+// viewConfig returns the configuration of the view name: c with the
+// view's zones, addresses and resolvers for verification.
+func (c *config) viewConfig(name string) *config {
+	v := c.Views[name]
+	vc := *c
+	vc.Zones, vc.ReverseZones = v.Zones, v.ReverseZones
+	if v.IP != "" || v.IPv4 != "" || v.IPv6 != "" {
+		vc.IP, vc.IPv4, vc.IPv6, vc.AutoIP = v.IP, v.IPv4, v.IPv6, false
+	}
+	if v.Iface != "" {
+		vc.Iface = v.Iface
+	}
+	if len(v.IPSources) > 0 {
+		vc.IPSources, vc.Iface = v.IPSources, ""
+	}
+	if len(v.VerifyResolvers) > 0 {
+		vc.VerifyResolvers = v.VerifyResolvers
+	}
+	return &vc
+}
+
+// updateViews updates the domains in the master files of every view, in
+// order of name, each with its own addresses. The record sets of -set
+// apply to every view.
+func updateViews(ctx context.Context, cfg *config) {
+	switch {
+	case len(cfg.Args) > 0:
+		logging.Fatal("master files are configured per view; not taking them as arguments")
+	case cfg.Stdin || cfg.Stream || len(cfg.IPSet) > 0:
+		logging.Fatal("-stdin, -stream and -ip-set cannot be used with views")
+	}
+	b, err := newBackend(cfg)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if b != nil {
+		logging.Fatal("views apply to master files, not to a provider")
+	}
+	sets, err := parseRecordUpdates(cfg.Set)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	var names []string
+	for name := range cfg.Views {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		vc := cfg.viewConfig(name)
+		if len(vc.Zones) < 1 {
+			logging.Fatalf("view %s: missing zones", name)
+		}
+		updates, err := collectUpdates(ctx, vc)
+		if err != nil {
+			logging.Fatalf("view %s: %v", name, err)
+		}
+		if len(updates) == 0 && len(sets) == 0 && len(vc.Templates) == 0 {
+			logging.Fatalf("view %s: no domains to update", name)
+		}
+		if len(vc.Templates) > 0 {
+			if vc.tmplData, err = renderData(ctx, vc, updates); err != nil {
+				logging.Fatalf("view %s: %v", name, err)
+			}
+		}
+		logging.Infof("updating view %s", name)
+		updateZones(vc, updates, sets)
+	}
+}
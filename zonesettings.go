@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/johnweldon/dnsup/pkg/zonedb"
+)
+
+// zoneSettings overrides settings of the top level for one zone, named by
+// its key under [zone_settings], so that an internal zone may have other
+// serials, TTL limits, secondaries and hooks than an external one. Unset
+// settings are those of the top level; hooks replace the global hooks,
+// and are followed by the zone's zone_hooks as those are. The soa_ fields
+// are set in the zone's SOA whenever the zone is written; see runSOA.
+type zoneSettings struct {
+	Serial      string   `toml:"serial"`
+	MinTTL      *int     `toml:"min_ttl"`
+	MaxTTL      *int     `toml:"max_ttl"`
+	Notify      []string `toml:"notify"`
+	NotifyNS    *bool    `toml:"notify_ns"`
+	Hooks       []string `toml:"hooks"`
+	ManagedOnly *bool    `toml:"managed_only"`
+
+	SOAMname   string `toml:"soa_mname"`
+	SOARname   string `toml:"soa_rname"`
+	SOARefresh string `toml:"soa_refresh"`
+	SOARetry   string `toml:"soa_retry"`
+	SOAExpire  string `toml:"soa_expire"`
+	SOAMinimum string `toml:"soa_minimum"`
+}
+
+// soa returns the SOA fields of z.
+func (z zoneSettings) soa() (zonedb.SOAFields, error) {
+	return soaFields(z.SOAMname, z.SOARname, z.SOARefresh, z.SOARetry, z.SOAExpire, z.SOAMinimum)
+}
+
+// zoneConfig returns the configuration of zone: c with the zone's own
+// settings, if it has any.
+func (c *config) zoneConfig(zone string) *config {
+	z, ok := c.ZoneSettings[strings.ToLower(zone)]
+	if !ok {
+		return c
+	}
+	zc := *c
+	if z.Serial != "" {
+		zc.Serial = z.Serial
+	}
+	if z.MinTTL != nil {
+		zc.MinTTL = *z.MinTTL
+	}
+	if z.MaxTTL != nil {
+		zc.MaxTTL = *z.MaxTTL
+	}
+	if z.Notify != nil {
+		zc.Notify = z.Notify
+	}
+	if z.NotifyNS != nil {
+		zc.NotifyNS = *z.NotifyNS
+	}
+	if z.Hooks != nil {
+		zc.Hooks = z.Hooks
+	}
+	if z.ManagedOnly != nil {
+		zc.ManagedOnly = *z.ManagedOnly
+	}
+	return &zc
+}
+
+// nameConfig returns the configuration of the zone in db holding name.
+func (c *config) nameConfig(db *zonedb.DB, name string) *config {
+	if auth := db.Zone(name); auth != nil {
+		return c.zoneConfig(auth.Domain())
+	}
+	return c
+}